@@ -8,6 +8,7 @@ import (
 
 	"github.com/ankouros/pterminal/internal/app"
 	"github.com/ankouros/pterminal/internal/buildinfo"
+	"github.com/ankouros/pterminal/internal/cli"
 )
 
 var (
@@ -15,6 +16,11 @@ var (
 )
 
 func main() {
+	// Subcommands run headless and never initialize GTK.
+	if len(os.Args) > 1 && cli.IsCommand(os.Args[1]) {
+		os.Exit(cli.Run(os.Args[1:]))
+	}
+
 	flag.Parse()
 	if *showVersion {
 		fmt.Println(buildinfo.String())
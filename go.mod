@@ -4,9 +4,11 @@ go 1.22
 
 require (
 	github.com/creack/pty v1.1.24
+	github.com/godbus/dbus/v5 v5.1.0
 	github.com/pkg/sftp v1.13.7
 	github.com/webview/webview_go v0.0.0-20240831120633-6173450d4dd6
 	golang.org/x/crypto v0.26.0
+	golang.org/x/term v0.23.0
 )
 
 require (
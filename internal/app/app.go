@@ -61,6 +61,7 @@ func Run() error {
 			}
 			w.ApplyConfig(cfg)
 		})
+		p2pSvc.SetOnRejected(w.NotifySyncRejected)
 	}
 
 	w.Run()
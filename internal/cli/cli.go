@@ -0,0 +1,470 @@
+package cli
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"text/tabwriter"
+
+	"github.com/ankouros/pterminal/internal/config"
+	"github.com/ankouros/pterminal/internal/hostkeys"
+	"github.com/ankouros/pterminal/internal/model"
+	"github.com/ankouros/pterminal/internal/sshclient"
+	"golang.org/x/term"
+)
+
+/*
+Headless CLI
+
+Subcommands that work on the same config as the desktop app without starting
+GTK/WebView, for shells and CI:
+
+	pterminal hosts list [--network NAME] [--json]
+	pterminal connect <host>
+	pterminal exec <host|network> [--concurrency N] [--timeout D] -- <command>
+	pterminal sftp get <host> <remote> [local]
+	pterminal sftp put <host> <local> <remote>
+	pterminal import samakia <file> [--network NAME] [--match MODE]
+	pterminal config validate [file]
+
+Hosts are named by name, "network/name", ID or UID. Passwords are read from
+the terminal, or from PTERMINAL_PASSWORD when there is none.
+*/
+
+// ExitUsage is returned for bad arguments.
+const ExitUsage = 2
+
+var commands = map[string]func(c *cli, args []string) error{
+	"hosts":   (*cli).hosts,
+	"connect": (*cli).connect,
+	"exec":    (*cli).exec,
+	"sftp":    (*cli).sftp,
+	"import":  (*cli).importCmd,
+	"config":  (*cli).configCmd,
+	"help":    (*cli).help,
+}
+
+// IsCommand reports whether name is a CLI subcommand; anything else starts
+// the desktop app.
+func IsCommand(name string) bool {
+	_, ok := commands[name]
+	return ok
+}
+
+// Run executes a subcommand (args[0]) and returns the process exit code.
+func Run(args []string) int {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	c := &cli{
+		stdin:  os.Stdin,
+		stdout: os.Stdout,
+		stderr: os.Stderr,
+		ctx:    ctx,
+	}
+	return c.run(args)
+}
+
+type cli struct {
+	stdin  io.Reader
+	stdout io.Writer
+	stderr io.Writer
+	ctx    context.Context
+
+	// cfg is loaded on first use; tests set it directly.
+	cfg    *model.AppConfig
+	prompt func(msg string, secret bool) (string, error)
+
+	exitCode int // set by commands that pass a remote exit status through
+}
+
+// usageError is printed with the usage text.
+type usageError string
+
+func (e usageError) Error() string { return string(e) }
+
+func (c *cli) run(args []string) int {
+	if len(args) == 0 {
+		c.usage()
+		return ExitUsage
+	}
+	cmd, ok := commands[args[0]]
+	if !ok {
+		fmt.Fprintf(c.stderr, "pterminal: unknown command %q\n", args[0])
+		c.usage()
+		return ExitUsage
+	}
+	if err := cmd(c, args[1:]); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		fmt.Fprintf(c.stderr, "pterminal %s: %v\n", args[0], err)
+		var ue usageError
+		if errors.As(err, &ue) {
+			c.usage()
+			return ExitUsage
+		}
+		return 1
+	}
+	return c.exitCode
+}
+
+func (c *cli) usage() {
+	fmt.Fprint(c.stderr, `usage:
+  pterminal                               start the desktop app
+  pterminal hosts list [--network NAME] [--json]
+  pterminal connect <host>
+  pterminal exec <host|network> [--concurrency N] [--timeout D] -- <command>
+  pterminal sftp get <host> <remote> [local]
+  pterminal sftp put <host> <local> <remote>
+  pterminal import samakia <file> [--network NAME] [--match hostname|host|uid]
+  pterminal config validate [file]
+
+Hosts are named by name, network/name, ID or UID.
+`)
+}
+
+func (c *cli) help(args []string) error {
+	c.usage()
+	return nil
+}
+
+func (c *cli) flags(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet("pterminal "+name, flag.ContinueOnError)
+	fs.SetOutput(c.stderr)
+	return fs
+}
+
+/*
+Config and hosts
+*/
+
+func (c *cli) loadConfig() (model.AppConfig, error) {
+	if c.cfg != nil {
+		return *c.cfg, nil
+	}
+	cfg, _, err := config.EnsureConfig()
+	if err != nil {
+		return model.AppConfig{}, err
+	}
+	c.cfg = &cfg
+
+	// Pins and replaced keys apply here just like in the app.
+	if path, err := config.HostKeysPath(); err == nil {
+		if s, err := hostkeys.Open(path); err == nil {
+			sshclient.SetHostKeyStore(s)
+		}
+	}
+	return cfg, nil
+}
+
+type hostRef struct {
+	host    model.Host
+	network model.Network
+}
+
+func liveHosts(cfg model.AppConfig) []hostRef {
+	var out []hostRef
+	for _, n := range cfg.Networks {
+		if n.Deleted {
+			continue
+		}
+		for _, h := range n.Hosts {
+			if !h.Deleted {
+				out = append(out, hostRef{host: h, network: n})
+			}
+		}
+	}
+	return out
+}
+
+// findHost resolves a host by UID, ID, "network/name" or name.
+func findHost(cfg model.AppConfig, ref string) (model.Host, error) {
+	ref = strings.TrimSpace(ref)
+	if ref == "" {
+		return model.Host{}, usageError("no host given")
+	}
+	hosts := liveHosts(cfg)
+	for _, r := range hosts {
+		if r.host.UID != "" && r.host.UID == ref {
+			return r.host, nil
+		}
+	}
+	if id, err := strconv.Atoi(ref); err == nil {
+		for _, r := range hosts {
+			if r.host.ID == id {
+				return r.host, nil
+			}
+		}
+	}
+
+	netName, name, scoped := strings.Cut(ref, "/")
+	if !scoped {
+		name, netName = ref, ""
+	}
+	var matches []hostRef
+	for _, r := range hosts {
+		if !strings.EqualFold(r.host.Name, name) {
+			continue
+		}
+		if scoped && !strings.EqualFold(r.network.Name, netName) {
+			continue
+		}
+		matches = append(matches, r)
+	}
+	switch len(matches) {
+	case 0:
+		return model.Host{}, fmt.Errorf("host %q not found", ref)
+	case 1:
+		return matches[0].host, nil
+	}
+	names := make([]string, len(matches))
+	for i, m := range matches {
+		names[i] = m.network.Name + "/" + m.host.Name
+	}
+	return model.Host{}, fmt.Errorf("host %q is ambiguous: %s", ref, strings.Join(names, ", "))
+}
+
+// findNetwork resolves a network by UID, ID or name; ok is false when none
+// matches.
+func findNetwork(cfg model.AppConfig, ref string) (model.Network, bool) {
+	ref = strings.TrimSpace(ref)
+	id, idErr := strconv.Atoi(ref)
+	for _, n := range cfg.Networks {
+		if n.Deleted {
+			continue
+		}
+		if (n.UID != "" && n.UID == ref) || (idErr == nil && n.ID == id) || strings.EqualFold(n.Name, ref) {
+			return n, true
+		}
+	}
+	return model.Network{}, false
+}
+
+func hostAddress(h model.Host) string {
+	addr := h.Host
+	if h.Port != 0 && h.Port != 22 {
+		addr = fmt.Sprintf("%s:%d", h.Host, h.Port)
+	}
+	if h.User != "" {
+		addr = h.User + "@" + addr
+	}
+	return addr
+}
+
+func (c *cli) hosts(args []string) error {
+	if len(args) == 0 || args[0] != "list" {
+		return usageError("expected: hosts list")
+	}
+	fs := c.flags("hosts list")
+	network := fs.String("network", "", "only list hosts of this network")
+	asJSON := fs.Bool("json", false, "print JSON")
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+	cfg, err := c.loadConfig()
+	if err != nil {
+		return err
+	}
+
+	type row struct {
+		ID      int    `json:"id"`
+		UID     string `json:"uid,omitempty"`
+		Name    string `json:"name"`
+		Network string `json:"network"`
+		Address string `json:"address"`
+		Driver  string `json:"driver"`
+		Auth    string `json:"auth,omitempty"`
+	}
+	rows := []row{}
+	for _, r := range liveHosts(cfg) {
+		if *network != "" && !strings.EqualFold(r.network.Name, *network) {
+			continue
+		}
+		driver := string(r.host.Driver)
+		if driver == "" {
+			driver = string(model.DriverSSH)
+		}
+		rows = append(rows, row{
+			ID:      r.host.ID,
+			UID:     r.host.UID,
+			Name:    r.host.Name,
+			Network: r.network.Name,
+			Address: hostAddress(r.host),
+			Driver:  driver,
+			Auth:    string(r.host.Auth.Method),
+		})
+	}
+	if *asJSON {
+		return writeJSON(c.stdout, rows)
+	}
+
+	tw := tabwriter.NewWriter(c.stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tNAME\tNETWORK\tADDRESS\tDRIVER\tAUTH")
+	for _, r := range rows {
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\t%s\n", r.ID, r.Name, r.Network, r.Address, r.Driver, r.Auth)
+	}
+	return tw.Flush()
+}
+
+func (c *cli) configCmd(args []string) error {
+	if len(args) == 0 || args[0] != "validate" {
+		return usageError("expected: config validate [file]")
+	}
+	path := ""
+	if len(args) > 1 {
+		path = args[1]
+	}
+	cfg, errs := config.ValidateFile(path)
+	for _, err := range errs {
+		fmt.Fprintln(c.stderr, err)
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%d problem(s) found", len(errs))
+	}
+	hosts := len(liveHosts(cfg))
+	fmt.Fprintf(c.stdout, "config is valid (%d networks, %d hosts)\n", len(cfg.Networks), hosts)
+	return nil
+}
+
+func (c *cli) importCmd(args []string) error {
+	if len(args) == 0 || args[0] != "samakia" {
+		return usageError("expected: import samakia <file>")
+	}
+	fs := c.flags("import samakia")
+	network := fs.String("network", "", "network to import into (default \"Samakia Inventory\")")
+	match := fs.String("match", "hostname", "match existing hosts by hostname, host or uid")
+	if err := fs.Parse(reorderFlags(args[1:])); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return usageError("expected one inventory file")
+	}
+	cfg, err := c.loadConfig()
+	if err != nil {
+		return err
+	}
+	next, summary, err := config.ImportSamakiaInventory(cfg, fs.Arg(0), *network, *match)
+	if err != nil {
+		return err
+	}
+	if err := config.Save(next); err != nil {
+		return err
+	}
+	c.cfg = &next
+	fmt.Fprintf(c.stdout, "imported into %q: %d added, %d updated, %d removed, %d skipped\n",
+		summary.NetworkName, summary.Added, summary.Updated, summary.Removed, summary.Skipped)
+	return nil
+}
+
+func writeJSON(w io.Writer, v any) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// reorderFlags moves flags in front of positional arguments so both
+// "import samakia f.json --network x" and "--network x f.json" work.
+func reorderFlags(args []string) []string {
+	var flags, rest []string
+	for i := 0; i < len(args); i++ {
+		a := args[i]
+		if a == "--" {
+			rest = append(rest, args[i+1:]...)
+			break
+		}
+		if strings.HasPrefix(a, "-") && len(a) > 1 {
+			flags = append(flags, a)
+			if !strings.Contains(a, "=") && i+1 < len(args) {
+				flags = append(flags, args[i+1])
+				i++
+			}
+			continue
+		}
+		rest = append(rest, a)
+	}
+	return append(flags, rest...)
+}
+
+/*
+Credentials and host keys
+*/
+
+// ask reads an answer from the controlling terminal.
+func (c *cli) ask(msg string, secret bool) (string, error) {
+	if c.prompt != nil {
+		return c.prompt(msg, secret)
+	}
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return "", errors.New("no terminal to ask on")
+	}
+	defer tty.Close()
+	fmt.Fprint(tty, msg)
+	if secret {
+		b, err := term.ReadPassword(int(tty.Fd()))
+		fmt.Fprintln(tty)
+		return string(b), err
+	}
+	line, err := bufio.NewReader(tty).ReadString('\n')
+	return strings.TrimSpace(line), err
+}
+
+// passwords returns a provider that asks once per host and remembers the
+// answer for the rest of the command.
+func (c *cli) passwords(cfg model.AppConfig) func(hostID int) (string, error) {
+	var mu sync.Mutex
+	cache := map[int]string{}
+	return func(hostID int) (string, error) {
+		// Hosts of an exec run dial concurrently; ask one at a time.
+		mu.Lock()
+		defer mu.Unlock()
+		if pw, ok := cache[hostID]; ok {
+			return pw, nil
+		}
+		if pw := os.Getenv("PTERMINAL_PASSWORD"); pw != "" {
+			return pw, nil
+		}
+		label := strconv.Itoa(hostID)
+		for _, r := range liveHosts(cfg) {
+			if r.host.ID == hostID {
+				label = hostAddress(r.host)
+			}
+		}
+		pw, err := c.ask(fmt.Sprintf("Password for %s: ", label), true)
+		if err != nil {
+			return "", fmt.Errorf("password required for %s: %w", label, err)
+		}
+		cache[hostID] = pw
+		return pw, nil
+	}
+}
+
+// trustPrompt asks whether to trust an unknown host key and records it.
+// It reports whether the caller should retry.
+func (c *cli) trustPrompt(err error) bool {
+	var unknown sshclient.ErrUnknownHostKey
+	if !errors.As(err, &unknown) {
+		return false
+	}
+	answer, aerr := c.ask(fmt.Sprintf(
+		"The authenticity of host %s can't be established.\nKey fingerprint is %s.\nTrust it and continue? [y/N] ",
+		unknown.HostPort, unknown.Fingerprint), false)
+	if aerr != nil || !strings.EqualFold(answer, "y") && !strings.EqualFold(answer, "yes") {
+		return false
+	}
+	if terr := sshclient.TrustHostKey(unknown.HostPort, unknown.Key); terr != nil {
+		fmt.Fprintf(c.stderr, "could not save the host key: %v\n", terr)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,118 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ankouros/pterminal/internal/model"
+)
+
+func testCLI(cfg model.AppConfig) (*cli, *bytes.Buffer, *bytes.Buffer) {
+	var out, errOut bytes.Buffer
+	return &cli{
+		stdin:  strings.NewReader(""),
+		stdout: &out,
+		stderr: &errOut,
+		ctx:    context.Background(),
+		cfg:    &cfg,
+		prompt: func(string, bool) (string, error) { return "", os.ErrNotExist },
+	}, &out, &errOut
+}
+
+func testConfig() model.AppConfig {
+	host := func(id int, uid, name string) model.Host {
+		return model.Host{ID: id, UID: uid, Name: name, Host: "10.0.0." + uid, Port: 22, User: "root",
+			Auth: model.AuthConfig{Method: model.AuthAgent}}
+	}
+	return model.AppConfig{
+		Version: 2,
+		Networks: []model.Network{
+			{ID: 1, Name: "prod", Hosts: []model.Host{host(1, "1", "web"), host(2, "2", "db")}},
+			{ID: 2, Name: "lab", Hosts: []model.Host{host(3, "3", "web"), {ID: 4, Name: "gone", Deleted: true}}},
+		},
+	}
+}
+
+func TestFindHost(t *testing.T) {
+	cfg := testConfig()
+	for ref, want := range map[string]int{"db": 2, "DB": 2, "prod/web": 1, "lab/web": 3, "3": 3} {
+		h, err := findHost(cfg, ref)
+		if err != nil || h.ID != want {
+			t.Fatalf("%q: got %d %v, want %d", ref, h.ID, err, want)
+		}
+	}
+	if _, err := findHost(cfg, "web"); err == nil || !strings.Contains(err.Error(), "ambiguous") {
+		t.Fatalf("expected an ambiguity error, got %v", err)
+	}
+	if _, err := findHost(cfg, "gone"); err == nil {
+		t.Fatal("deleted hosts must not resolve")
+	}
+
+	hosts, err := execTargets(cfg, "prod")
+	if err != nil || len(hosts) != 2 {
+		t.Fatalf("network target: %v %v", hosts, err)
+	}
+}
+
+func TestHostsListJSON(t *testing.T) {
+	c, out, _ := testCLI(testConfig())
+	if code := c.run([]string{"hosts", "list", "--network", "lab", "--json"}); code != 0 {
+		t.Fatalf("exit %d", code)
+	}
+	var rows []struct {
+		Name    string `json:"name"`
+		Address string `json:"address"`
+		Driver  string `json:"driver"`
+	}
+	if err := json.Unmarshal(out.Bytes(), &rows); err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 1 || rows[0].Name != "web" || rows[0].Address != "root@10.0.0.3" || rows[0].Driver != "ssh" {
+		t.Fatalf("unexpected rows %+v", rows)
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	dir := t.TempDir()
+	good := filepath.Join(dir, "good.json")
+	b, _ := json.Marshal(testConfig())
+	if err := os.WriteFile(good, b, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	c, out, _ := testCLI(model.AppConfig{})
+	if code := c.run([]string{"config", "validate", good}); code != 0 || !strings.Contains(out.String(), "valid") {
+		t.Fatalf("exit %d: %s", code, out.String())
+	}
+
+	bad := filepath.Join(dir, "bad.json")
+	if err := os.WriteFile(bad, []byte(`{"version":2,"networks":[],"bogus":1}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	c, _, errOut := testCLI(model.AppConfig{})
+	if code := c.run([]string{"config", "validate", bad}); code != 1 || !strings.Contains(errOut.String(), "bogus") {
+		t.Fatalf("exit %d: %s", code, errOut.String())
+	}
+}
+
+func TestUsageErrors(t *testing.T) {
+	for _, args := range [][]string{
+		{"nope"},
+		{"hosts"},
+		{"exec", "db"},
+		{"exec", "db", "web", "--", "uptime"},
+		{"sftp", "put", "db", "x"},
+	} {
+		c, _, _ := testCLI(testConfig())
+		if code := c.run(args); code != ExitUsage {
+			t.Fatalf("%v: exit %d, want %d", args, code, ExitUsage)
+		}
+	}
+	if got := reorderFlags([]string{"f.json", "--network", "x", "--match=uid"}); strings.Join(got, " ") != "--network x --match=uid f.json" {
+		t.Fatalf("unexpected order %v", got)
+	}
+}
@@ -0,0 +1,90 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/ankouros/pterminal/internal/model"
+	"github.com/ankouros/pterminal/internal/sshclient"
+	"golang.org/x/term"
+)
+
+// connect opens an interactive shell on a host, with the local terminal in
+// raw mode for the duration of the session.
+func (c *cli) connect(args []string) error {
+	if len(args) != 1 {
+		return usageError("expected: connect <host>")
+	}
+	cfg, err := c.loadConfig()
+	if err != nil {
+		return err
+	}
+	host, err := findHost(cfg, args[0])
+	if err != nil {
+		return err
+	}
+	if host.Driver != "" && host.Driver != model.DriverSSH {
+		return fmt.Errorf("%s is a %s host; only SSH hosts can be connected from the CLI", host.Name, host.Driver)
+	}
+
+	in, out := int(os.Stdin.Fd()), int(os.Stdout.Fd())
+	cols, rows := 80, 24
+	if term.IsTerminal(out) {
+		if w, h, err := term.GetSize(out); err == nil {
+			cols, rows = w, h
+		}
+	}
+
+	pw := c.passwords(cfg)
+	opts, err := sshclient.ResolveDialOptions(cfg, host, pw)
+	if err != nil {
+		return err
+	}
+	var sess *sshclient.NodeSession
+	for attempt := 0; ; attempt++ {
+		sess, err = sshclient.DialAndStart(c.ctx, host, cols, rows, func() (string, error) {
+			return pw(host.ID)
+		}, opts)
+		if err == nil || attempt > 0 || !c.trustPrompt(err) {
+			break
+		}
+	}
+	if err != nil {
+		return err
+	}
+	defer sess.Close()
+
+	if term.IsTerminal(in) {
+		state, err := term.MakeRaw(in)
+		if err != nil {
+			return err
+		}
+		defer term.Restore(in, state)
+	}
+	stopResize := watchResize(out, sess)
+	defer stopResize()
+
+	go func() {
+		buf := make([]byte, 4096)
+		for {
+			n, err := c.stdin.Read(buf)
+			if n > 0 {
+				if werr := sess.Write(buf[:n]); werr != nil {
+					return
+				}
+			}
+			if err != nil {
+				// EOF on a pipe ends the remote shell's input.
+				_ = sess.Write([]byte{4})
+				return
+			}
+		}
+	}()
+
+	for b := range sess.Output() {
+		if _, err := c.stdout.Write(b); err != nil {
+			return err
+		}
+	}
+	return sess.Err()
+}
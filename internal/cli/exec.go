@@ -0,0 +1,124 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ankouros/pterminal/internal/model"
+	"github.com/ankouros/pterminal/internal/scriptrun"
+)
+
+// exec runs a command on a host or on every SSH host of a network through the
+// script runner. With one host its output and exit code pass straight through;
+// with several each host's output gets a header and any failure exits 1.
+func (c *cli) exec(args []string) error {
+	fs := c.flags("exec")
+	concurrency := fs.Int("concurrency", scriptrun.DefaultConcurrency, "hosts to run on at once")
+	timeout := fs.Duration("timeout", scriptrun.DefaultTimeout, "per-host timeout")
+	asJSON := fs.Bool("json", false, "print the run as JSON")
+
+	var command []string
+	for i, a := range args {
+		if a == "--" {
+			args, command = args[:i], args[i+1:]
+			break
+		}
+	}
+	if err := fs.Parse(reorderFlags(args)); err != nil {
+		return err
+	}
+	rest := fs.Args()
+	if len(rest) == 0 {
+		return usageError("expected: exec <host|network> -- <command>")
+	}
+	if command == nil {
+		command = rest[1:]
+	} else if len(rest) > 1 {
+		return usageError("expected one host or network before --")
+	}
+	if len(command) == 0 {
+		return usageError("no command given")
+	}
+
+	cfg, err := c.loadConfig()
+	if err != nil {
+		return err
+	}
+	hosts, err := execTargets(cfg, rest[0])
+	if err != nil {
+		return err
+	}
+
+	runner := scriptrun.NewRunner(cfg, "")
+	script := model.TeamScript{Name: "pterminal exec", Command: strings.Join(command, " ")}
+	id, err := runner.Start(script, hosts, scriptrun.Options{Concurrency: *concurrency, Timeout: *timeout}, c.passwords(cfg))
+	if err != nil {
+		return err
+	}
+	run, err := runner.Wait(c.ctx, id)
+	if err != nil {
+		runner.Cancel(id)
+		run, err = runner.Wait(context.Background(), id)
+		if err != nil {
+			return err
+		}
+	}
+
+	if *asJSON {
+		if run.Failed > 0 {
+			c.exitCode = 1
+		}
+		return writeJSON(c.stdout, run)
+	}
+	if len(run.Results) == 1 {
+		res := run.Results[0]
+		fmt.Fprint(c.stdout, res.Stdout)
+		fmt.Fprint(c.stderr, res.Stderr)
+		switch res.Status {
+		case scriptrun.StatusPassed, scriptrun.StatusFailed:
+			c.exitCode = res.ExitCode
+			return nil
+		}
+		return fmt.Errorf("%s: %s", res.HostName, resultError(res))
+	}
+
+	for _, res := range run.Results {
+		status := res.Status
+		if res.Status == scriptrun.StatusFailed {
+			status = fmt.Sprintf("exit %d", res.ExitCode)
+		}
+		fmt.Fprintf(c.stdout, "== %s (%s): %s, %s\n", res.HostName, res.Address, status,
+			(time.Duration(res.DurationMs) * time.Millisecond).Round(time.Millisecond))
+		fmt.Fprint(c.stdout, res.Stdout)
+		fmt.Fprint(c.stderr, res.Stderr)
+		if res.Error != "" {
+			fmt.Fprintf(c.stderr, "%s: %s\n", res.HostName, resultError(res))
+		}
+	}
+	fmt.Fprintf(c.stdout, "== %d passed, %d failed\n", run.Passed, run.Failed)
+	if run.Failed > 0 {
+		c.exitCode = 1
+	}
+	return nil
+}
+
+func resultError(res scriptrun.HostResult) string {
+	if res.Error != "" {
+		return res.Error
+	}
+	return res.Status
+}
+
+// execTargets resolves a host, or else a network's SSH hosts.
+func execTargets(cfg model.AppConfig, ref string) ([]model.Host, error) {
+	host, hostErr := findHost(cfg, ref)
+	if hostErr == nil {
+		return []model.Host{host}, nil
+	}
+	if n, ok := findNetwork(cfg, ref); ok {
+		return scriptrun.ResolveHosts(cfg, nil, n.ID)
+	}
+	return nil, hostErr
+}
@@ -0,0 +1,36 @@
+package cli
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/ankouros/pterminal/internal/sshclient"
+	"golang.org/x/term"
+)
+
+// watchResize forwards local terminal size changes to sess until stopped.
+func watchResize(fd int, sess *sshclient.NodeSession) (stop func()) {
+	if !term.IsTerminal(fd) {
+		return func() {}
+	}
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGWINCH)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-ch:
+				if w, h, err := term.GetSize(fd); err == nil {
+					_ = sess.Resize(w, h)
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() {
+		signal.Stop(ch)
+		close(done)
+	}
+}
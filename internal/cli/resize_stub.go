@@ -0,0 +1,7 @@
+//go:build !linux
+
+package cli
+
+import "github.com/ankouros/pterminal/internal/sshclient"
+
+func watchResize(fd int, sess *sshclient.NodeSession) (stop func()) { return func() {} }
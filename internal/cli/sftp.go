@@ -0,0 +1,113 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/ankouros/pterminal/internal/model"
+	"github.com/ankouros/pterminal/internal/sftpclient"
+)
+
+// sftp copies a single file to or from a host. "-" stands for stdin/stdout.
+func (c *cli) sftp(args []string) error {
+	if len(args) == 0 || (args[0] != "get" && args[0] != "put") {
+		return usageError("expected: sftp get|put ...")
+	}
+	op, args := args[0], args[1:]
+	switch {
+	case op == "get" && (len(args) < 2 || len(args) > 3):
+		return usageError("expected: sftp get <host> <remote> [local]")
+	case op == "put" && len(args) != 3:
+		return usageError("expected: sftp put <host> <local> <remote>")
+	}
+
+	cfg, err := c.loadConfig()
+	if err != nil {
+		return err
+	}
+	host, err := findHost(cfg, args[0])
+	if err != nil {
+		return err
+	}
+
+	mgr := sftpclient.NewManager(cfg)
+	defer mgr.DisconnectAll()
+	pw := c.passwords(cfg)
+
+	var res sftpclient.FileResult
+	if op == "get" {
+		local := path.Base(args[1])
+		if len(args) == 3 {
+			local = args[2]
+		} else if local == "/" || local == "." {
+			return usageError("name the local file")
+		}
+		res, err = c.retry(mgr, host, func() (sftpclient.FileResult, error) { return c.get(mgr, host, args[1], local, pw) })
+	} else {
+		res, err = c.retry(mgr, host, func() (sftpclient.FileResult, error) { return c.put(mgr, host, args[1], args[2], pw) })
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(c.stderr, "%d bytes transferred", res.Bytes)
+	if res.Resumed > 0 {
+		fmt.Fprintf(c.stderr, " (resumed after %d bytes)", res.Resumed)
+	}
+	if res.Verify != "" {
+		fmt.Fprintf(c.stderr, ", %s verified (%s)", res.Algorithm, res.Verify)
+	}
+	fmt.Fprintln(c.stderr)
+	return nil
+}
+
+// retry runs fn again after the user trusts an unknown host key or supplies
+// custom SFTP credentials.
+func (c *cli) retry(mgr *sftpclient.Manager, host model.Host, fn func() (sftpclient.FileResult, error)) (sftpclient.FileResult, error) {
+	res, err := fn()
+	if err == nil {
+		return res, nil
+	}
+	switch {
+	case c.trustPrompt(err):
+	case err.Error() == "password_required" && host.SFTP != nil:
+		pw, perr := c.ask(fmt.Sprintf("SFTP password for %s@%s: ", host.SFTP.User, host.Host), true)
+		if perr != nil {
+			return res, err
+		}
+		mgr.SetCustomPassword(host.ID, pw)
+	default:
+		return res, err
+	}
+	return fn()
+}
+
+// get downloads remote to local. A file download that was interrupted
+// resumes; "-" streams to stdout instead.
+func (c *cli) get(mgr *sftpclient.Manager, host model.Host, remote, local string, pw func(int) (string, error)) (sftpclient.FileResult, error) {
+	if local == "-" {
+		n, err := mgr.Download(c.ctx, host.ID, remote, c.stdout, pw)
+		return sftpclient.FileResult{Bytes: n}, err
+	}
+	if fi, err := os.Stat(local); err == nil && fi.IsDir() {
+		local = filepath.Join(local, path.Base(remote))
+	}
+	return mgr.DownloadFile(c.ctx, host.ID, remote, local, pw)
+}
+
+// put uploads local to remote, resuming an interrupted upload; "-" reads
+// stdin instead.
+func (c *cli) put(mgr *sftpclient.Manager, host model.Host, local, remote string, pw func(int) (string, error)) (sftpclient.FileResult, error) {
+	if local == "-" {
+		n, err := mgr.Upload(c.ctx, host.ID, remote, c.stdin, pw)
+		return sftpclient.FileResult{Bytes: n}, err
+	}
+	if fi, err := os.Stat(local); err != nil {
+		return sftpclient.FileResult{}, err
+	} else if fi.IsDir() {
+		return sftpclient.FileResult{}, errors.New("local path is a directory")
+	}
+	return mgr.UploadFile(c.ctx, host.ID, local, remote, pw)
+}
@@ -75,6 +75,33 @@ func ConfigPath() (string, error) {
 	return filepath.Join(home, ".config", ConfigDirName, ConfigFileName), nil
 }
 
+// ScriptRunsPath is where the script runner keeps its run history.
+func ScriptRunsPath() (string, error) {
+	p, err := ConfigPath()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(filepath.Dir(p), "script-runs.json"), nil
+}
+
+// VaultPath is where the encrypted secret vault is kept.
+func VaultPath() (string, error) {
+	p, err := ConfigPath()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(filepath.Dir(p), "vault.json"), nil
+}
+
+// HostKeysPath is where pTerminal's host key store is kept.
+func HostKeysPath() (string, error) {
+	p, err := ConfigPath()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(filepath.Dir(p), "hostkeys.json"), nil
+}
+
 func ensureDir() (string, error) {
 	p, err := ConfigPath()
 	if err != nil {
@@ -190,6 +217,12 @@ func loadLocked() (model.AppConfig, error) {
 	if normalizeScopes(&cfg) {
 		changed = true
 	}
+	if normalizeJumpHosts(&cfg) {
+		changed = true
+	}
+	if normalizeForwards(&cfg) {
+		changed = true
+	}
 	if dedupePersonalNetworks(&cfg) {
 		changed = true
 	}
@@ -422,6 +455,68 @@ func normalizeScopes(cfg *model.AppConfig) bool {
 	return changed
 }
 
+// normalizeJumpHosts drops blank, duplicate and self-referencing jump host UIDs.
+func normalizeJumpHosts(cfg *model.AppConfig) bool {
+	changed := false
+	for ni := range cfg.Networks {
+		for hi := range cfg.Networks[ni].Hosts {
+			h := &cfg.Networks[ni].Hosts[hi]
+			if len(h.JumpHosts) == 0 {
+				if h.JumpHosts != nil {
+					h.JumpHosts = nil
+					changed = true
+				}
+				continue
+			}
+			seen := make(map[string]struct{}, len(h.JumpHosts))
+			out := make([]string, 0, len(h.JumpHosts))
+			for _, uid := range h.JumpHosts {
+				uid = strings.TrimSpace(uid)
+				if uid == "" || uid == h.UID {
+					continue
+				}
+				if _, ok := seen[uid]; ok {
+					continue
+				}
+				seen[uid] = struct{}{}
+				out = append(out, uid)
+			}
+			if len(out) == 0 {
+				out = nil
+			}
+			if !reflect.DeepEqual(out, h.JumpHosts) {
+				h.JumpHosts = out
+				changed = true
+			}
+		}
+	}
+	return changed
+}
+
+// normalizeForwards gives every port forwarding rule a unique ID and a kind.
+func normalizeForwards(cfg *model.AppConfig) bool {
+	changed := false
+	for ni := range cfg.Networks {
+		for hi := range cfg.Networks[ni].Hosts {
+			h := &cfg.Networks[ni].Hosts[hi]
+			seen := make(map[string]struct{}, len(h.Forwards))
+			for fi := range h.Forwards {
+				f := &h.Forwards[fi]
+				if _, dup := seen[f.ID]; f.ID == "" || dup {
+					f.ID = model.NewID()
+					changed = true
+				}
+				seen[f.ID] = struct{}{}
+				if f.Kind == "" {
+					f.Kind = model.ForwardLocal
+					changed = true
+				}
+			}
+		}
+	}
+	return changed
+}
+
 func dedupePersonalNetworks(cfg *model.AppConfig) bool {
 	changed := false
 	seen := map[string]struct{}{}
@@ -489,6 +584,8 @@ func hostFingerprint(h model.Host) string {
 	b.WriteString("|")
 	b.WriteString(string(h.HostKey.Mode))
 	b.WriteString("|")
+	b.WriteString(strings.Join(h.JumpHosts, ","))
+	b.WriteString("|")
 	if h.Telecom != nil {
 		b.WriteString(h.Telecom.Path)
 		b.WriteString("|")
@@ -74,3 +74,38 @@ func TestNormalizeSFTP(t *testing.T) {
 		t.Fatalf("unexpected credentials mode: %q", host.SFTP.Credentials)
 	}
 }
+
+func TestValidate(t *testing.T) {
+	valid := model.Host{
+		ID: 1, Name: "ok", UID: "u1", Host: "10.0.0.1", Port: 22, User: "root",
+		Auth: model.AuthConfig{Method: model.AuthAgent},
+	}
+	cfg := model.AppConfig{
+		Version:  ConfigVersionCurrent,
+		Networks: []model.Network{{ID: 1, Name: "n1", Hosts: []model.Host{valid}}},
+	}
+	if errs := Validate(cfg); len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+
+	bad := model.Host{
+		ID: 1, Name: "bad", Port: 70000, User: "root",
+		Auth:      model.AuthConfig{Method: "magic"},
+		JumpHosts: []string{"missing"},
+		Forwards:  []model.PortForward{{ID: "f1", Kind: model.ForwardLocal, BindPort: 8080}},
+	}
+	cfg.Networks[0].Hosts = append(cfg.Networks[0].Hosts, bad)
+	// duplicate id, no address, port, auth method, jump host, forward target
+	if errs := Validate(cfg); len(errs) != 6 {
+		t.Fatalf("expected 6 errors, got %d: %v", len(errs), errs)
+	}
+	cfg.Networks[0].Hosts = cfg.Networks[0].Hosts[:1]
+	cfg.Sync = &model.SyncSettings{Port: 43278, Peers: []string{"10.8.0.2:43278", "vpn.example.com:43278"}}
+	if errs := Validate(cfg); len(errs) != 0 {
+		t.Fatalf("unexpected sync errors: %v", errs)
+	}
+	cfg.Sync = &model.SyncSettings{Port: -1, Peers: []string{"10.8.0.2", "10.8.0.2:0"}}
+	if errs := Validate(cfg); len(errs) != 3 {
+		t.Fatalf("expected 3 sync errors, got %d: %v", len(errs), errs)
+	}
+}
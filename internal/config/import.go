@@ -49,6 +49,8 @@ func ImportFromFile(path string) (cfg model.AppConfig, backupPath string, err er
 	_ = normalizeTeamRequests(&cfg)
 	_ = normalizeUIDs(&cfg)
 	_ = normalizeScopes(&cfg)
+	_ = normalizeJumpHosts(&cfg)
+	_ = normalizeForwards(&cfg)
 	_ = dedupePersonalNetworks(&cfg)
 	_ = StripSecrets(&cfg)
 
@@ -0,0 +1,184 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ankouros/pterminal/internal/model"
+)
+
+// ExportNetworkSSHConfig writes a network's SSH hosts as an ssh_config fragment
+// into ~/Downloads and returns the file path.
+func ExportNetworkSSHConfig(cfg model.AppConfig, networkID int) (string, error) {
+	text, name, err := FormatNetworkSSHConfig(cfg, networkID)
+	if err != nil {
+		return "", err
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	downloads := filepath.Join(home, "Downloads")
+	_ = os.MkdirAll(downloads, 0o755)
+
+	filename := "pterminal-ssh-config-" + sshAlias(name) + "-" + time.Now().Format("20060102-150405") + ".conf"
+	out := filepath.Join(downloads, filename)
+	if err := os.WriteFile(out, []byte(text), 0o600); err != nil {
+		return "", err
+	}
+	return out, nil
+}
+
+// FormatNetworkSSHConfig renders the network's SSH hosts as Host blocks. Jump
+// hosts in the same network are referenced by alias, others as user@host:port.
+func FormatNetworkSSHConfig(cfg model.AppConfig, networkID int) (string, string, error) {
+	var netw *model.Network
+	for i := range cfg.Networks {
+		if cfg.Networks[i].ID == networkID && !cfg.Networks[i].Deleted {
+			netw = &cfg.Networks[i]
+			break
+		}
+	}
+	if netw == nil {
+		return "", "", fmt.Errorf("network %d not found", networkID)
+	}
+
+	byUID := map[string]model.Host{}
+	for _, n := range cfg.Networks {
+		for _, h := range n.Hosts {
+			if !h.Deleted && h.UID != "" {
+				byUID[h.UID] = h
+			}
+		}
+	}
+
+	hosts := make([]model.Host, 0, len(netw.Hosts))
+	hostAliases := make([]string, 0, len(netw.Hosts))
+	aliases := map[string]string{} // UID -> alias
+	used := map[string]struct{}{}
+	for _, h := range netw.Hosts {
+		if h.Deleted || (h.Driver != "" && h.Driver != model.DriverSSH) {
+			continue
+		}
+		alias := sshAlias(h.Name)
+		if alias == "" {
+			alias = sshAlias(h.Host)
+		}
+		base := alias
+		for i := 2; ; i++ {
+			if _, ok := used[alias]; !ok {
+				break
+			}
+			alias = base + "-" + strconv.Itoa(i)
+		}
+		used[alias] = struct{}{}
+		if h.UID != "" {
+			aliases[h.UID] = alias
+		}
+		hosts = append(hosts, h)
+		hostAliases = append(hostAliases, alias)
+	}
+	if len(hosts) == 0 {
+		return "", "", errors.New("network has no SSH hosts")
+	}
+
+	var b strings.Builder
+	b.WriteString("# pTerminal network \"")
+	b.WriteString(netw.Name)
+	b.WriteString("\" exported ")
+	b.WriteString(time.Now().Format(time.RFC3339))
+	b.WriteString("\n")
+
+	for i, h := range hosts {
+		b.WriteString("\nHost ")
+		b.WriteString(hostAliases[i])
+		b.WriteString("\n")
+		writeSSHOption(&b, "HostName", h.Host)
+		if h.Port > 0 && h.Port != 22 {
+			writeSSHOption(&b, "Port", strconv.Itoa(h.Port))
+		}
+		writeSSHOption(&b, "User", h.User)
+		if h.Auth.Method == model.AuthKey && strings.TrimSpace(h.Auth.KeyPath) != "" {
+			writeSSHOption(&b, "IdentityFile", h.Auth.KeyPath)
+		}
+		if h.Auth.Method == model.AuthPassword || h.Auth.Method == model.AuthKeyboardInteractive {
+			writeSSHOption(&b, "PreferredAuthentications", "keyboard-interactive,password")
+		}
+		if len(h.JumpHosts) > 0 {
+			specs := make([]string, 0, len(h.JumpHosts))
+			for _, uid := range h.JumpHosts {
+				if a, ok := aliases[uid]; ok {
+					specs = append(specs, a)
+					continue
+				}
+				if j, ok := byUID[uid]; ok {
+					spec := j.Host
+					if j.User != "" {
+						spec = j.User + "@" + spec
+					}
+					if j.Port > 0 && j.Port != 22 {
+						spec += ":" + strconv.Itoa(j.Port)
+					}
+					specs = append(specs, spec)
+				}
+			}
+			if len(specs) > 0 {
+				writeSSHOption(&b, "ProxyJump", strings.Join(specs, ","))
+			}
+		}
+		if ka := h.Keepalive; ka != nil && !ka.Disabled {
+			if ka.IntervalSec > 0 {
+				writeSSHOption(&b, "ServerAliveInterval", strconv.Itoa(ka.IntervalSec))
+			}
+			if ka.MaxMissed > 0 {
+				writeSSHOption(&b, "ServerAliveCountMax", strconv.Itoa(ka.MaxMissed))
+			}
+		}
+		if h.Auth.Method == model.AuthAgent && h.ForwardAgent != model.AgentForwardOff {
+			writeSSHOption(&b, "ForwardAgent", "yes")
+		}
+		if h.HostKey.Mode == model.HostKeyInsecure {
+			writeSSHOption(&b, "StrictHostKeyChecking", "no")
+			writeSSHOption(&b, "UserKnownHostsFile", "/dev/null")
+		}
+	}
+
+	return b.String(), netw.Name, nil
+}
+
+func writeSSHOption(b *strings.Builder, key, value string) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return
+	}
+	if strings.ContainsAny(value, " \t") {
+		value = strconv.Quote(value)
+	}
+	b.WriteString("    ")
+	b.WriteString(key)
+	b.WriteString(" ")
+	b.WriteString(value)
+	b.WriteString("\n")
+}
+
+// sshAlias turns a display name into a Host alias without pattern characters.
+func sshAlias(name string) string {
+	var b strings.Builder
+	for _, r := range strings.TrimSpace(name) {
+		switch {
+		case r == ' ' || r == '\t' || r == '/':
+			b.WriteRune('-')
+		case strings.ContainsRune("*?!,#\"'=", r):
+			continue
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return strings.Trim(b.String(), "-")
+}
@@ -0,0 +1,714 @@
+package config
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/ankouros/pterminal/internal/model"
+)
+
+const (
+	sshConfigManagedBy  = "ssh-config-import"
+	sshConfigMaxInclude = 16
+)
+
+// sshConfigHost is one concrete Host alias resolved against the whole config.
+type sshConfigHost struct {
+	Alias                 string
+	HostName              string
+	Port                  int
+	User                  string
+	IdentityFile          string
+	ProxyJump             []string
+	StrictHostKeyChecking string
+	ServerAliveInterval   int
+	ServerAliveCountMax   int
+}
+
+type sshConfigOption struct {
+	key  string // lower-cased keyword
+	args []string
+}
+
+type sshMatchCriterion struct {
+	keyword string
+	arg     string
+}
+
+// sshConfigBlock is a Host or Match section. Options before the first section
+// live in a block that always matches.
+type sshConfigBlock struct {
+	match    bool
+	patterns []string            // Host patterns
+	criteria []sshMatchCriterion // Match criteria
+	options  []sshConfigOption
+}
+
+// ImportSSHConfig imports the concrete Host entries of an OpenSSH client config
+// into networkName, reusing the Samakia import summary/report machinery.
+// Supported: Include (globs), Host patterns with wildcards/negation, and Match
+// all/host/originalhost/user. Other Match criteria never match.
+func ImportSSHConfig(
+	cfg model.AppConfig,
+	path string,
+	networkName string,
+	matchMode string,
+) (model.AppConfig, SamakiaImportSummary, error) {
+	if strings.TrimSpace(path) == "" {
+		path = "~/.ssh/config"
+	}
+	path = expandUserPath(path)
+	if strings.TrimSpace(networkName) == "" {
+		networkName = "SSH Config"
+	}
+	normalizedMatchMode, err := normalizeMatchMode(matchMode)
+	if err != nil {
+		return cfg, SamakiaImportSummary{}, err
+	}
+	if normalizedMatchMode == matchModeUID {
+		return cfg, SamakiaImportSummary{}, errors.New("uid match mode is not supported for ssh_config imports")
+	}
+
+	blocks, err := parseSSHConfig(path)
+	if err != nil {
+		return cfg, SamakiaImportSummary{}, err
+	}
+	hosts := resolveSSHConfigHosts(blocks)
+	if len(hosts) == 0 {
+		return cfg, SamakiaImportSummary{}, errors.New("no hosts found in ssh config")
+	}
+
+	net := findOrCreateNetwork(&cfg, networkName)
+	if net == nil {
+		return cfg, SamakiaImportSummary{}, errors.New("failed to create network")
+	}
+
+	usedNames := map[string]struct{}{}
+	for _, h := range net.Hosts {
+		if !h.Deleted && h.Name != "" {
+			usedNames[h.Name] = struct{}{}
+		}
+	}
+
+	summary := SamakiaImportSummary{
+		Source:      "ssh_config",
+		NetworkName: net.Name,
+		NetworkID:   net.ID,
+		MatchMode:   normalizedMatchMode,
+		RoleCounts:  map[string]int{},
+	}
+
+	seenKeys := map[string]struct{}{}
+	byAlias := map[string]int{} // alias -> index in net.Hosts
+	updated := map[int]bool{}   // existing host index -> changed
+	touched := map[int]bool{}   // every host index produced by this import
+
+	upsert := func(in sshConfigHost) (int, bool) {
+		key := importKey(normalizedMatchMode, in.Alias, in.HostName, "", model.HostRoleGeneric)
+		if key == "" {
+			return -1, false
+		}
+		if _, ok := seenKeys[key]; ok {
+			return -1, false
+		}
+		seenKeys[key] = struct{}{}
+
+		if idx := findSSHConfigHost(net.Hosts, in, normalizedMatchMode); idx >= 0 {
+			h := &net.Hosts[idx]
+			changed := false
+			set := func(dst *string, v string) {
+				if *dst != v {
+					*dst = v
+					changed = true
+				}
+			}
+			set(&h.Host, in.HostName)
+			set(&h.User, in.User)
+			if h.Port != in.Port {
+				h.Port = in.Port
+				changed = true
+			}
+			if in.IdentityFile != "" && h.Auth.Method == model.AuthKey {
+				set(&h.Auth.KeyPath, in.IdentityFile)
+			}
+			if mode := sshHostKeyMode(in.StrictHostKeyChecking); h.HostKey.Mode != mode {
+				h.HostKey.Mode = mode
+				changed = true
+			}
+			if ka := sshKeepalive(in); ka != nil && (h.Keepalive == nil || *h.Keepalive != *ka) {
+				h.Keepalive = ka
+				changed = true
+			}
+			if h.UID == "" {
+				h.UID = model.NewID()
+			}
+			updated[idx] = changed
+			touched[idx] = true
+			return idx, true
+		}
+
+		name := uniqueName(in.Alias, usedNames)
+		usedNames[name] = struct{}{}
+		net.Hosts = append(net.Hosts, model.Host{
+			Name:      name,
+			UID:       model.NewID(),
+			Host:      in.HostName,
+			Port:      in.Port,
+			User:      in.User,
+			Role:      model.HostRoleGeneric,
+			ManagedBy: sshConfigManagedBy,
+			Driver:    model.DriverSSH,
+			Auth: model.AuthConfig{
+				Method:  model.AuthKey,
+				KeyPath: in.IdentityFile,
+			},
+			HostKey:   model.HostKeyConfig{Mode: sshHostKeyMode(in.StrictHostKeyChecking)},
+			Keepalive: sshKeepalive(in),
+			Scope:     model.ScopePrivate,
+		})
+		idx := len(net.Hosts) - 1
+		touched[idx] = true
+		summary.Added++
+		summary.AddedHosts = append(summary.AddedHosts, buildImportSummary(&net.Hosts[idx]))
+		summary.RoleCounts[string(model.HostRoleGeneric)]++
+		return idx, true
+	}
+
+	for _, in := range hosts {
+		idx, ok := upsert(in)
+		if !ok {
+			summary.Skipped++
+			continue
+		}
+		byAlias[strings.ToLower(in.Alias)] = idx
+	}
+
+	// Second pass: link ProxyJump specs to hosts by UID, creating hosts for
+	// bastions that are not defined as aliases.
+	for _, in := range hosts {
+		idx, ok := byAlias[strings.ToLower(in.Alias)]
+		if !ok {
+			continue
+		}
+		var jumps []string
+		for _, spec := range in.ProxyJump {
+			jidx := -1
+			jump := parseJumpSpec(spec)
+			if i, ok := byAlias[strings.ToLower(jump.Alias)]; ok {
+				jidx = i
+			} else if uid := findHostUIDByAddr(cfg, jump.HostName, jump.Port); uid != "" {
+				jumps = append(jumps, uid)
+				continue
+			} else if i, ok := upsert(jump); ok {
+				byAlias[strings.ToLower(jump.Alias)] = i
+				jidx = i
+			}
+			if jidx >= 0 && jidx != idx {
+				jumps = append(jumps, net.Hosts[jidx].UID)
+			}
+		}
+		if !stringSlicesEqual(net.Hosts[idx].JumpHosts, jumps) {
+			net.Hosts[idx].JumpHosts = jumps
+			if _, existed := updated[idx]; existed {
+				updated[idx] = true
+			}
+		}
+	}
+
+	for idx := range net.Hosts {
+		changed, ok := updated[idx]
+		if !ok {
+			continue
+		}
+		if changed {
+			summary.Updated++
+			summary.UpdatedHosts = append(summary.UpdatedHosts, buildImportSummary(&net.Hosts[idx]))
+			summary.RoleCounts[string(net.Hosts[idx].Role)]++
+		} else {
+			summary.Skipped++
+		}
+	}
+
+	for i := range net.Hosts {
+		h := &net.Hosts[i]
+		if h.Deleted || h.ManagedBy != sshConfigManagedBy || touched[i] {
+			continue
+		}
+		h.Deleted = true
+		summary.Removed++
+		summary.RemovedHosts = append(summary.RemovedHosts, buildImportSummary(h))
+	}
+
+	_ = normalizeIDs(&cfg)
+	_ = normalizeUIDs(&cfg)
+	_ = normalizeScopes(&cfg)
+	_ = normalizeJumpHosts(&cfg)
+	_ = StripSecrets(&cfg)
+
+	return cfg, summary, nil
+}
+
+func findSSHConfigHost(hosts []model.Host, in sshConfigHost, matchMode string) int {
+	match := strings.TrimSpace(matchValue(matchMode, in.Alias, in.HostName, ""))
+	if match == "" {
+		return -1
+	}
+	for i := range hosts {
+		h := &hosts[i]
+		if h.Deleted || h.ManagedBy != sshConfigManagedBy {
+			continue
+		}
+		candidate := strings.TrimSpace(matchValue(matchMode, h.Name, h.Host, ""))
+		if candidate != "" && strings.EqualFold(candidate, match) {
+			return i
+		}
+	}
+	return -1
+}
+
+func findHostUIDByAddr(cfg model.AppConfig, host string, port int) string {
+	for _, netw := range cfg.Networks {
+		if netw.Deleted {
+			continue
+		}
+		for _, h := range netw.Hosts {
+			if h.Deleted || h.UID == "" || h.ManagedBy == sshConfigManagedBy {
+				continue
+			}
+			if strings.EqualFold(h.Host, host) && h.Port == port {
+				return h.UID
+			}
+		}
+	}
+	return ""
+}
+
+func sshHostKeyMode(strict string) model.HostKeyMode {
+	switch strings.ToLower(strings.TrimSpace(strict)) {
+	case "no", "off":
+		return model.HostKeyInsecure
+	default:
+		return model.HostKeyKnownHosts
+	}
+}
+
+// parseJumpSpec turns a ProxyJump element ([user@]host[:port] or an ssh:// URI)
+// into a host entry named after the original spec host.
+func parseJumpSpec(spec string) sshConfigHost {
+	spec = strings.TrimPrefix(strings.TrimSpace(spec), "ssh://")
+	out := sshConfigHost{Port: 22}
+	if at := strings.LastIndex(spec, "@"); at >= 0 {
+		out.User = spec[:at]
+		spec = spec[at+1:]
+	}
+	host := spec
+	if h, p, err := splitHostPortLoose(spec); err == nil {
+		host = h
+		out.Port = p
+	}
+	if out.User == "" {
+		out.User = os.Getenv("USER")
+	}
+	out.Alias = host
+	out.HostName = host
+	return out
+}
+
+func splitHostPortLoose(s string) (string, int, error) {
+	i := strings.LastIndex(s, ":")
+	if i < 0 || strings.Count(s, ":") > 1 && !strings.HasPrefix(s, "[") {
+		return "", 0, errors.New("no port")
+	}
+	port, err := strconv.Atoi(s[i+1:])
+	if err != nil {
+		return "", 0, err
+	}
+	return strings.Trim(s[:i], "[]"), port, nil
+}
+
+/*
+Parsing
+*/
+
+func parseSSHConfig(path string) ([]sshConfigBlock, error) {
+	blocks := []sshConfigBlock{{}}
+	if err := parseSSHConfigFile(path, filepath.Dir(path), 0, &blocks); err != nil {
+		return nil, err
+	}
+	return blocks, nil
+}
+
+func parseSSHConfigFile(path, baseDir string, depth int, blocks *[]sshConfigBlock) error {
+	if depth > sshConfigMaxInclude {
+		return fmt.Errorf("ssh config include depth exceeded at %s", path)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		key, args, err := splitSSHConfigLine(scanner.Text())
+		if err != nil {
+			return fmt.Errorf("%s:%d: %w", path, lineNo, err)
+		}
+		if key == "" {
+			continue
+		}
+
+		switch key {
+		case "host":
+			*blocks = append(*blocks, sshConfigBlock{patterns: args})
+		case "match":
+			criteria, err := parseMatchCriteria(args)
+			if err != nil {
+				return fmt.Errorf("%s:%d: %w", path, lineNo, err)
+			}
+			*blocks = append(*blocks, sshConfigBlock{match: true, criteria: criteria})
+		case "include":
+			// Included options inherit the enclosing section; afterwards the
+			// enclosing section continues in a fresh block to keep ordering.
+			enclosing := (*blocks)[len(*blocks)-1]
+			for _, pattern := range args {
+				pattern = expandUserPath(pattern)
+				if !filepath.IsAbs(pattern) {
+					pattern = filepath.Join(baseDir, pattern)
+				}
+				matches, err := filepath.Glob(pattern)
+				if err != nil {
+					return fmt.Errorf("%s:%d: %w", path, lineNo, err)
+				}
+				for _, inc := range matches {
+					if info, err := os.Stat(inc); err != nil || info.IsDir() {
+						continue
+					}
+					*blocks = append(*blocks, sectionOf(enclosing))
+					if err := parseSSHConfigFile(inc, baseDir, depth+1, blocks); err != nil {
+						return err
+					}
+				}
+			}
+			*blocks = append(*blocks, sectionOf(enclosing))
+		default:
+			cur := &(*blocks)[len(*blocks)-1]
+			cur.options = append(cur.options, sshConfigOption{key: key, args: args})
+		}
+	}
+	return scanner.Err()
+}
+
+func sectionOf(b sshConfigBlock) sshConfigBlock {
+	return sshConfigBlock{match: b.match, patterns: b.patterns, criteria: b.criteria}
+}
+
+// splitSSHConfigLine returns the lower-cased keyword and its arguments.
+// Keywords may be separated from arguments by whitespace and/or one "=".
+func splitSSHConfigLine(line string) (string, []string, error) {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return "", nil, nil
+	}
+
+	end := strings.IndexAny(line, " \t=")
+	if end < 0 {
+		return strings.ToLower(line), nil, nil
+	}
+	key := strings.ToLower(line[:end])
+	rest := strings.TrimLeft(line[end:], " \t")
+	if strings.HasPrefix(rest, "=") {
+		rest = strings.TrimLeft(rest[1:], " \t")
+	}
+
+	var (
+		args    []string
+		cur     strings.Builder
+		inQuote bool
+		hasTok  bool
+	)
+	for _, r := range rest {
+		switch {
+		case r == '"':
+			inQuote = !inQuote
+			hasTok = true
+		case !inQuote && (r == ' ' || r == '\t'):
+			if hasTok {
+				args = append(args, cur.String())
+				cur.Reset()
+				hasTok = false
+			}
+		case !inQuote && r == '#' && !hasTok:
+			return key, args, nil
+		default:
+			cur.WriteRune(r)
+			hasTok = true
+		}
+	}
+	if inQuote {
+		return "", nil, errors.New("unterminated quote")
+	}
+	if hasTok {
+		args = append(args, cur.String())
+	}
+	return key, args, nil
+}
+
+func parseMatchCriteria(args []string) ([]sshMatchCriterion, error) {
+	out := []sshMatchCriterion{}
+	for i := 0; i < len(args); i++ {
+		kw := strings.ToLower(args[i])
+		switch kw {
+		case "all", "canonical", "final":
+			out = append(out, sshMatchCriterion{keyword: kw})
+		default:
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("match %s requires an argument", kw)
+			}
+			out = append(out, sshMatchCriterion{keyword: kw, arg: args[i+1]})
+			i++
+		}
+	}
+	return out, nil
+}
+
+/*
+Resolution
+*/
+
+// resolveSSHConfigHosts evaluates every concrete Host alias the way ssh(1)
+// does: blocks are walked in order and the first value of each option wins.
+func resolveSSHConfigHosts(blocks []sshConfigBlock) []sshConfigHost {
+	aliases := []string{}
+	seen := map[string]struct{}{}
+	for _, b := range blocks {
+		if b.match {
+			continue
+		}
+		for _, p := range b.patterns {
+			if strings.ContainsAny(p, "*?!") {
+				continue
+			}
+			key := strings.ToLower(p)
+			if _, ok := seen[key]; ok {
+				continue
+			}
+			seen[key] = struct{}{}
+			aliases = append(aliases, p)
+		}
+	}
+
+	out := make([]sshConfigHost, 0, len(aliases))
+	for _, alias := range aliases {
+		vals := map[string][]string{}
+		for _, b := range blocks {
+			if !b.matches(alias, vals) {
+				continue
+			}
+			for _, opt := range b.options {
+				if len(opt.args) == 0 {
+					continue
+				}
+				if opt.key == "identityfile" {
+					vals[opt.key] = append(vals[opt.key], opt.args[0])
+					continue
+				}
+				if _, ok := vals[opt.key]; !ok {
+					vals[opt.key] = opt.args
+				}
+			}
+		}
+		out = append(out, buildSSHConfigHost(alias, vals))
+	}
+	return out
+}
+
+func (b sshConfigBlock) matches(alias string, vals map[string][]string) bool {
+	if !b.match {
+		if len(b.patterns) == 0 {
+			return true // options before the first Host/Match
+		}
+		return matchPatternList(alias, b.patterns)
+	}
+
+	for _, c := range b.criteria {
+		negate := false
+		kw := c.keyword
+		if strings.HasPrefix(kw, "!") {
+			negate = true
+			kw = kw[1:]
+		}
+		var ok bool
+		switch kw {
+		case "all":
+			ok = true
+		case "host":
+			ok = matchPatternList(resolvedHostName(alias, vals), strings.Split(c.arg, ","))
+		case "originalhost":
+			ok = matchPatternList(alias, strings.Split(c.arg, ","))
+		case "user":
+			user := firstArg(vals["user"])
+			ok = user != "" && matchPatternList(user, strings.Split(c.arg, ","))
+		default:
+			// exec, localuser, localnetwork, canonical, final, tagged: not
+			// evaluated offline, so the block is skipped.
+			return false
+		}
+		if ok == negate {
+			return false
+		}
+	}
+	return true
+}
+
+// matchPatternList applies ssh_config pattern-list rules: any negated match
+// rejects, otherwise any positive match accepts.
+func matchPatternList(value string, patterns []string) bool {
+	value = strings.ToLower(value)
+	matched := false
+	for _, p := range patterns {
+		p = strings.ToLower(strings.TrimSpace(p))
+		if p == "" {
+			continue
+		}
+		if strings.HasPrefix(p, "!") {
+			if wildcardMatch(p[1:], value) {
+				return false
+			}
+			continue
+		}
+		if wildcardMatch(p, value) {
+			matched = true
+		}
+	}
+	return matched
+}
+
+// wildcardMatch implements the "*" and "?" globbing used by ssh_config.
+func wildcardMatch(pattern, s string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for len(pattern) > 0 && pattern[0] == '*' {
+				pattern = pattern[1:]
+			}
+			if pattern == "" {
+				return true
+			}
+			for i := 0; i <= len(s); i++ {
+				if wildcardMatch(pattern, s[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if s == "" {
+				return false
+			}
+		default:
+			if s == "" || s[0] != pattern[0] {
+				return false
+			}
+		}
+		pattern = pattern[1:]
+		s = s[1:]
+	}
+	return s == ""
+}
+
+func resolvedHostName(alias string, vals map[string][]string) string {
+	name := firstArg(vals["hostname"])
+	if name == "" {
+		return alias
+	}
+	return strings.ReplaceAll(name, "%h", alias)
+}
+
+func buildSSHConfigHost(alias string, vals map[string][]string) sshConfigHost {
+	out := sshConfigHost{
+		Alias:                 alias,
+		HostName:              resolvedHostName(alias, vals),
+		Port:                  22,
+		User:                  firstArg(vals["user"]),
+		StrictHostKeyChecking: firstArg(vals["stricthostkeychecking"]),
+	}
+	if out.User == "" {
+		// ssh(1) falls back to the local user name.
+		out.User = os.Getenv("USER")
+	}
+	if p, err := strconv.Atoi(firstArg(vals["port"])); err == nil && p > 0 && p <= 65535 {
+		out.Port = p
+	}
+	if id := firstArg(vals["identityfile"]); id != "" {
+		id = strings.ReplaceAll(id, "%d", "~")
+		id = strings.ReplaceAll(id, "%h", out.HostName)
+		id = strings.ReplaceAll(id, "%r", out.User)
+		out.IdentityFile = id
+	}
+	if v, err := strconv.Atoi(firstArg(vals["serveraliveinterval"])); err == nil && v > 0 {
+		out.ServerAliveInterval = v
+	}
+	if v, err := strconv.Atoi(firstArg(vals["serveralivecountmax"])); err == nil && v > 0 {
+		out.ServerAliveCountMax = v
+	}
+	if jump := firstArg(vals["proxyjump"]); jump != "" && !strings.EqualFold(jump, "none") {
+		for _, spec := range strings.Split(jump, ",") {
+			if spec = strings.TrimSpace(spec); spec != "" {
+				out.ProxyJump = append(out.ProxyJump, spec)
+			}
+		}
+	}
+	return out
+}
+
+// sshKeepalive maps ServerAliveInterval/CountMax onto a keepalive config. An
+// unset (or 0) interval keeps pTerminal's defaults rather than disabling them.
+func sshKeepalive(in sshConfigHost) *model.KeepaliveConfig {
+	if in.ServerAliveInterval <= 0 && in.ServerAliveCountMax <= 0 {
+		return nil
+	}
+	return &model.KeepaliveConfig{
+		IntervalSec: in.ServerAliveInterval,
+		MaxMissed:   in.ServerAliveCountMax,
+	}
+}
+
+/*
+Utils
+*/
+
+func firstArg(args []string) string {
+	if len(args) == 0 {
+		return ""
+	}
+	return strings.TrimSpace(args[0])
+}
+
+func expandUserPath(p string) string {
+	p = strings.TrimSpace(p)
+	if p == "~" || strings.HasPrefix(p, "~/") {
+		if home, err := os.UserHomeDir(); err == nil {
+			return filepath.Join(home, strings.TrimPrefix(p, "~"))
+		}
+	}
+	return p
+}
+
+func stringSlicesEqual(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
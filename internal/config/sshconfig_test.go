@@ -0,0 +1,193 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ankouros/pterminal/internal/model"
+)
+
+func writeSSHConfigFixture(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+
+	main := `# top-level defaults
+User deploy
+
+Include conf.d/*
+
+Host bastion
+    HostName bastion.example.com
+    Port 2200
+
+Host web-1 web-2
+    ProxyJump bastion
+
+Host web-2
+    HostName 10.0.0.2
+    IdentityFile ~/.ssh/web_ed25519
+
+Match originalhost db-*
+    User dba
+    ProxyJump ops@jump.example.com:2222,bastion
+
+Host *.lab !skip.lab
+    StrictHostKeyChecking no
+
+Host *
+    HostName %h.internal
+    Port=22
+`
+	extra := `Host db-1
+    HostName 10.0.1.1
+
+Host skip.lab router.lab
+`
+	if err := os.MkdirAll(filepath.Join(dir, "conf.d"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "conf.d", "db.conf"), []byte(extra), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "config")
+	if err := os.WriteFile(path, []byte(main), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestImportSSHConfig(t *testing.T) {
+	path := writeSSHConfigFixture(t)
+
+	cfg := model.AppConfig{Version: ConfigVersionCurrent}
+	updated, summary, err := ImportSSHConfig(cfg, path, "Lab", matchModeHostname)
+	if err != nil {
+		t.Fatalf("import failed: %v", err)
+	}
+
+	hosts := map[string]model.Host{}
+	for _, h := range updated.Networks[0].Hosts {
+		hosts[h.Name] = h
+	}
+
+	// db-1, skip.lab, router.lab, bastion, web-1, web-2 + jump.example.com
+	if summary.Added != 7 || summary.Source != "ssh_config" {
+		t.Fatalf("unexpected summary: %+v", summary)
+	}
+
+	bastion := hosts["bastion"]
+	if bastion.Host != "bastion.example.com" || bastion.Port != 2200 || bastion.User != "deploy" {
+		t.Fatalf("unexpected bastion: %+v", bastion)
+	}
+
+	web1 := hosts["web-1"]
+	if web1.Host != "web-1.internal" || len(web1.JumpHosts) != 1 || web1.JumpHosts[0] != bastion.UID {
+		t.Fatalf("unexpected web-1: %+v", web1)
+	}
+
+	web2 := hosts["web-2"]
+	if web2.Host != "10.0.0.2" || web2.Auth.Method != model.AuthKey || web2.Auth.KeyPath != "~/.ssh/web_ed25519" {
+		t.Fatalf("unexpected web-2: %+v", web2)
+	}
+
+	db := hosts["db-1"]
+	jump := hosts["jump.example.com"]
+	if db.User != "deploy" {
+		// Top-level User comes first, so the Match block's User does not override it.
+		t.Fatalf("expected first-match-wins user, got %q", db.User)
+	}
+	if jump.User != "ops" || jump.Port != 2222 {
+		t.Fatalf("unexpected jump host: %+v", jump)
+	}
+	if len(db.JumpHosts) != 2 || db.JumpHosts[0] != jump.UID || db.JumpHosts[1] != bastion.UID {
+		t.Fatalf("unexpected db-1 jump chain: %+v", db.JumpHosts)
+	}
+
+	if hosts["router.lab"].HostKey.Mode != model.HostKeyInsecure {
+		t.Fatalf("expected router.lab to be insecure")
+	}
+	if hosts["skip.lab"].HostKey.Mode != model.HostKeyKnownHosts {
+		t.Fatalf("expected negated pattern to keep known_hosts for skip.lab")
+	}
+
+	// A second import of the same file is a no-op.
+	again, summary2, err := ImportSSHConfig(updated, path, "Lab", matchModeHostname)
+	if err != nil {
+		t.Fatalf("re-import failed: %v", err)
+	}
+	if summary2.Added != 0 || summary2.Updated != 0 || summary2.Removed != 0 {
+		t.Fatalf("expected idempotent re-import, got %+v", summary2)
+	}
+	if len(again.Networks[0].Hosts) != len(updated.Networks[0].Hosts) {
+		t.Fatalf("re-import changed host count")
+	}
+}
+
+func TestImportSSHConfigRejectsUIDMatchMode(t *testing.T) {
+	path := writeSSHConfigFixture(t)
+	if _, _, err := ImportSSHConfig(model.AppConfig{}, path, "Lab", matchModeUID); err == nil {
+		t.Fatal("expected uid match mode error")
+	}
+}
+
+func TestFormatNetworkSSHConfig(t *testing.T) {
+	cfg := model.AppConfig{
+		Networks: []model.Network{{
+			ID:   1,
+			Name: "Prod",
+			Hosts: []model.Host{
+				{ID: 1, UID: "b", Name: "bastion host", Host: "bastion.example.com", Port: 2200, User: "ops"},
+				{
+					ID: 2, UID: "w", Name: "web", Host: "10.0.0.5", Port: 22, User: "deploy",
+					Auth:      model.AuthConfig{Method: model.AuthKey, KeyPath: "~/.ssh/id_web"},
+					HostKey:   model.HostKeyConfig{Mode: model.HostKeyInsecure},
+					JumpHosts: []string{"b"},
+					Keepalive: &model.KeepaliveConfig{IntervalSec: 15, MaxMissed: 4},
+				},
+				{ID: 3, UID: "t", Name: "console", Driver: model.DriverTelecom},
+			},
+		}},
+	}
+
+	text, name, err := FormatNetworkSSHConfig(cfg, 1)
+	if err != nil {
+		t.Fatalf("format failed: %v", err)
+	}
+	if name != "Prod" {
+		t.Fatalf("unexpected network name %q", name)
+	}
+	for _, want := range []string{
+		"Host bastion-host\n    HostName bastion.example.com\n    Port 2200\n    User ops\n",
+		"Host web\n",
+		"    IdentityFile ~/.ssh/id_web\n",
+		"    ProxyJump bastion-host\n",
+		"    StrictHostKeyChecking no\n",
+		"    ServerAliveInterval 15\n    ServerAliveCountMax 4\n",
+	} {
+		if !strings.Contains(text, want) {
+			t.Fatalf("missing %q in:\n%s", want, text)
+		}
+	}
+	if strings.Contains(text, "console") {
+		t.Fatalf("telecom hosts must not be exported:\n%s", text)
+	}
+
+	// The fragment parses back into the same hosts.
+	path := filepath.Join(t.TempDir(), "config")
+	if err := os.WriteFile(path, []byte(text), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	blocks, err := parseSSHConfig(path)
+	if err != nil {
+		t.Fatalf("parse exported config: %v", err)
+	}
+	parsed := resolveSSHConfigHosts(blocks)
+	if len(parsed) != 2 || parsed[1].ProxyJump[0] != "bastion-host" || parsed[0].Port != 2200 {
+		t.Fatalf("unexpected round trip: %+v", parsed)
+	}
+	if ka := sshKeepalive(parsed[1]); ka == nil || ka.IntervalSec != 15 || ka.MaxMissed != 4 {
+		t.Fatalf("keepalive not round-tripped: %+v", ka)
+	}
+}
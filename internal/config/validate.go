@@ -0,0 +1,192 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/ankouros/pterminal/internal/forward"
+	"github.com/ankouros/pterminal/internal/model"
+)
+
+/*
+Validation
+
+Validate reports problems that would make hosts unusable (missing addresses,
+unknown auth or host key modes, dangling jump hosts, bad forwards) without
+normalizing or saving anything, so it is safe to run against a file in CI.
+*/
+
+// ValidateFile reads the config at path (the active config when empty) and
+// validates it. Unknown fields are reported as errors.
+func ValidateFile(path string) (model.AppConfig, []error) {
+	if path == "" {
+		p, err := ConfigPath()
+		if err != nil {
+			return model.AppConfig{}, []error{err}
+		}
+		path = p
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return model.AppConfig{}, []error{err}
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.DisallowUnknownFields()
+	var cfg model.AppConfig
+	if err := dec.Decode(&cfg); err != nil {
+		return model.AppConfig{}, []error{fmt.Errorf("invalid config JSON: %w", err)}
+	}
+	return cfg, Validate(cfg)
+}
+
+// Validate checks cfg for problems; nil means it is valid.
+func Validate(cfg model.AppConfig) []error {
+	var errs []error
+	add := func(format string, args ...any) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	if cfg.Version != 0 && cfg.Version != 1 && cfg.Version != ConfigVersionCurrent {
+		add("unsupported config version %d (expected %d)", cfg.Version, ConfigVersionCurrent)
+	}
+
+	netIDs := map[int]struct{}{}
+	hostIDs := map[int]string{}
+	uids := map[string]struct{}{}
+	for _, n := range cfg.Networks {
+		if n.Deleted {
+			continue
+		}
+		for _, h := range n.Hosts {
+			if !h.Deleted && h.UID != "" {
+				uids[h.UID] = struct{}{}
+			}
+		}
+	}
+
+	for _, n := range cfg.Networks {
+		if n.Deleted {
+			continue
+		}
+		netName := n.Name
+		if strings.TrimSpace(netName) == "" {
+			add("network %d has no name", n.ID)
+			netName = fmt.Sprintf("#%d", n.ID)
+		}
+		if _, dup := netIDs[n.ID]; dup && n.ID != 0 {
+			add("network %q: duplicate id %d", netName, n.ID)
+		}
+		netIDs[n.ID] = struct{}{}
+
+		for _, h := range n.Hosts {
+			if h.Deleted {
+				continue
+			}
+			name := h.Name
+			if strings.TrimSpace(name) == "" {
+				name = fmt.Sprintf("#%d", h.ID)
+				add("network %q: host %s has no name", netName, name)
+			}
+			where := fmt.Sprintf("host %q (network %q)", name, netName)
+			if other, dup := hostIDs[h.ID]; dup && h.ID != 0 {
+				add("%s: id %d is also used by %q", where, h.ID, other)
+			}
+			hostIDs[h.ID] = name
+			errs = append(errs, validateHost(where, h, uids)...)
+		}
+	}
+
+	if cfg.Sync != nil {
+		if cfg.Sync.Port < 0 || cfg.Sync.Port > 65535 {
+			add("sync port %d out of range", cfg.Sync.Port)
+		}
+		for _, peer := range cfg.Sync.Peers {
+			host, port, err := net.SplitHostPort(strings.TrimSpace(peer))
+			if err != nil || host == "" {
+				add("sync peer %q is not host:port", peer)
+				continue
+			}
+			if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+				add("sync peer %q: bad port", peer)
+			}
+		}
+	}
+	return errs
+}
+
+func validateHost(where string, h model.Host, uids map[string]struct{}) []error {
+	var errs []error
+	add := func(format string, args ...any) {
+		errs = append(errs, fmt.Errorf("%s: %s", where, fmt.Sprintf(format, args...)))
+	}
+
+	switch h.Driver {
+	case "", model.DriverSSH:
+	case model.DriverTelecom, model.DriverIOShell:
+		return nil
+	default:
+		add("unknown driver %q", h.Driver)
+		return errs
+	}
+
+	if strings.TrimSpace(h.Host) == "" {
+		add("no address")
+	}
+	if h.Port < 0 || h.Port > 65535 {
+		add("port %d out of range", h.Port)
+	}
+	if strings.TrimSpace(h.User) == "" {
+		add("no user")
+	}
+
+	switch h.Auth.Method {
+	case model.AuthPassword, model.AuthKey, model.AuthAgent, model.AuthKeyboardInteractive:
+	case "":
+		add("no auth method")
+	default:
+		add("unknown auth method %q", h.Auth.Method)
+	}
+
+	switch h.HostKey.Mode {
+	case "", model.HostKeyKnownHosts, model.HostKeyInsecure:
+	default:
+		add("unknown host key mode %q", h.HostKey.Mode)
+	}
+	for _, pin := range h.HostKey.Pins {
+		if !strings.HasPrefix(pin, "SHA256:") {
+			add("host key pin %q is not a SHA256 fingerprint", pin)
+		}
+	}
+
+	switch h.ForwardAgent {
+	case model.AgentForwardOff:
+	case model.AgentForwardOn, model.AgentForwardConfirm:
+		if h.Auth.Method != model.AuthAgent {
+			add("agent forwarding needs agent auth")
+		}
+	default:
+		add("unknown agent forwarding mode %q", h.ForwardAgent)
+	}
+
+	for _, uid := range h.JumpHosts {
+		if _, ok := uids[strings.TrimSpace(uid)]; !ok {
+			add("jump host %s not found", uid)
+		}
+	}
+
+	for _, f := range h.Forwards {
+		if f.Kind == "" {
+			f.Kind = model.ForwardLocal
+		}
+		if err := forward.Validate(f); err != nil {
+			add("forward %s: %v", f.ID, err)
+		}
+	}
+	return errs
+}
@@ -0,0 +1,406 @@
+package control
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ankouros/pterminal/internal/config"
+	"github.com/ankouros/pterminal/internal/model"
+)
+
+/*
+Control socket
+
+A JSON-RPC 2.0 server on a unix socket that lets editors and scripts drive a
+running pTerminal. The socket is created with mode 0600 in a 0700 directory
+under $XDG_RUNTIME_DIR, so only the user running the app can connect.
+
+Messages are JSON objects, one per line. Methods are passed to the Handler;
+"subscribe" and "unsubscribe" are handled here and turn the connection into
+an event stream as well: matching events arrive as "event" notifications.
+*/
+
+// JSON-RPC error codes.
+const (
+	CodeParseError     = -32700
+	CodeInvalidRequest = -32600
+	CodeMethodNotFound = -32601
+	CodeInvalidParams  = -32602
+	CodeInternal       = -32603
+	CodeAppError       = -32000 // the app rejected the request; Data has details
+	CodeDenied         = -32001 // not available over the socket
+)
+
+// Event kinds.
+const (
+	EventState    = "state"
+	EventOutput   = "output"
+	EventTransfer = "transfer"
+)
+
+// eventBuffer is how many events a slow client may fall behind before
+// events are dropped.
+const eventBuffer = 1024
+
+// Error is a JSON-RPC error object.
+type Error struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+	Data    any    `json:"data,omitempty"`
+}
+
+func (e *Error) Error() string { return e.Message }
+
+// Handler serves a method call. A returned *Error is sent as is; other errors
+// become CodeInternal.
+type Handler func(method string, params json.RawMessage) (any, error)
+
+// Event is pushed to subscribers.
+type Event struct {
+	Type    string `json:"type"`
+	HostID  int    `json:"hostId"`
+	TabID   int    `json:"tabId"`
+	State   string `json:"state,omitempty"`
+	Error   string `json:"error,omitempty"`
+	DataB64 string `json:"dataB64,omitempty"`
+
+	// Transfer is the SFTP transfer a transfer event reports on.
+	Transfer any `json:"transfer,omitempty"`
+
+	// Dropped counts events this client missed just before this one because
+	// it did not read fast enough.
+	Dropped int `json:"dropped,omitempty"`
+}
+
+type request struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+type response struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  any             `json:"result,omitempty"`
+	Error   *Error          `json:"error,omitempty"`
+}
+
+type notification struct {
+	JSONRPC string `json:"jsonrpc"`
+	Method  string `json:"method"`
+	Params  Event  `json:"params"`
+}
+
+// subscription filters events; zero IDs match every host/tab.
+type subscription struct {
+	kinds  map[string]bool
+	hostID int
+	tabID  int
+}
+
+func (s *subscription) matches(ev Event) bool {
+	return s != nil && s.kinds[ev.Type] &&
+		(s.hostID == 0 || s.hostID == ev.HostID) &&
+		(s.tabID == 0 || s.tabID == ev.TabID)
+}
+
+// Server is safe for concurrent use.
+type Server struct {
+	handler Handler
+
+	mu      sync.Mutex
+	ln      net.Listener
+	socket  string
+	sockErr error
+	conns   map[*conn]struct{}
+}
+
+// NewServer returns a server that is not listening yet (see Configure).
+func NewServer(h Handler) *Server {
+	return &Server{handler: h, conns: map[*conn]struct{}{}}
+}
+
+// SocketPath resolves the socket location from the settings.
+func SocketPath(settings *model.ControlSettings) (string, error) {
+	if settings != nil && strings.TrimSpace(settings.SocketPath) != "" {
+		p := strings.TrimSpace(settings.SocketPath)
+		if p == "~" || strings.HasPrefix(p, "~/") {
+			home, err := os.UserHomeDir()
+			if err != nil {
+				return "", err
+			}
+			p = filepath.Join(home, strings.TrimPrefix(p, "~"))
+		}
+		return p, nil
+	}
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		return filepath.Join(dir, config.ConfigDirName, "control.sock"), nil
+	}
+	p, err := config.ConfigPath()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(filepath.Dir(p), "control.sock"), nil
+}
+
+// Configure opens or closes the socket to match the settings. The error is
+// also kept for Socket.
+func (s *Server) Configure(settings *model.ControlSettings) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	err := s.configureLocked(settings)
+	s.sockErr = err
+	return err
+}
+
+func (s *Server) configureLocked(settings *model.ControlSettings) error {
+	want := ""
+	if settings != nil && settings.Enabled {
+		p, err := SocketPath(settings)
+		if err != nil {
+			return err
+		}
+		want = p
+	}
+	if want == s.socket {
+		return nil
+	}
+	s.closeLocked()
+	if want == "" {
+		return nil
+	}
+	return s.listenLocked(want)
+}
+
+// Socket returns the path being served ("" when off) and the error of the
+// last attempt to serve it.
+func (s *Server) Socket() (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.socket, s.sockErr
+}
+
+func (s *Server) listenLocked(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	// Only replace a socket nothing answers on (left by a crashed instance).
+	if c, err := net.DialTimeout("unix", path, time.Second); err == nil {
+		_ = c.Close()
+		return fmt.Errorf("control socket %s is in use", path)
+	}
+	_ = os.Remove(path)
+
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return err
+	}
+	if err := os.Chmod(path, 0o600); err != nil {
+		_ = ln.Close()
+		return err
+	}
+	s.ln, s.socket = ln, path
+	go func() {
+		for {
+			nc, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			c := &conn{srv: s, nc: nc, enc: json.NewEncoder(nc), events: make(chan Event, eventBuffer), done: make(chan struct{})}
+			s.mu.Lock()
+			s.conns[c] = struct{}{}
+			s.mu.Unlock()
+			go c.serve()
+		}
+	}()
+	return nil
+}
+
+func (s *Server) closeLocked() {
+	if s.ln != nil {
+		_ = s.ln.Close()
+		_ = os.Remove(s.socket)
+	}
+	for c := range s.conns {
+		_ = c.nc.Close()
+	}
+	s.ln, s.socket = nil, ""
+}
+
+// Close stops serving and drops every client.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closeLocked()
+	return nil
+}
+
+// Subscribed reports whether any client wants events of kind, so callers
+// can skip building events nobody reads.
+func (s *Server) Subscribed(kind string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for c := range s.conns {
+		if sub := c.subscription(); sub != nil && sub.kinds[kind] {
+			return true
+		}
+	}
+	return false
+}
+
+// Publish sends ev to every matching subscriber without blocking; clients
+// that fall behind lose events and are told how many with the next one.
+func (s *Server) Publish(ev Event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for c := range s.conns {
+		if !c.subscription().matches(ev) {
+			continue
+		}
+		select {
+		case c.events <- ev:
+		default:
+			c.mu.Lock()
+			c.dropped++
+			c.mu.Unlock()
+		}
+	}
+}
+
+type conn struct {
+	srv *Server
+	nc  net.Conn
+
+	wmu sync.Mutex // serializes writes of responses and events
+	enc *json.Encoder
+
+	mu      sync.Mutex
+	sub     *subscription
+	dropped int
+
+	events chan Event
+	done   chan struct{}
+}
+
+func (c *conn) subscription() *subscription {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.sub
+}
+
+func (c *conn) write(v any) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	return c.enc.Encode(v)
+}
+
+func (c *conn) serve() {
+	defer func() {
+		c.srv.mu.Lock()
+		delete(c.srv.conns, c)
+		c.srv.mu.Unlock()
+		close(c.done)
+		_ = c.nc.Close()
+	}()
+	go c.pushEvents()
+
+	dec := json.NewDecoder(c.nc)
+	for {
+		var req request
+		if err := dec.Decode(&req); err != nil {
+			var syntax *json.SyntaxError
+			var typ *json.UnmarshalTypeError
+			if errors.As(err, &syntax) || errors.As(err, &typ) {
+				// The stream cannot be resynchronized after bad JSON.
+				_ = c.write(response{JSONRPC: "2.0", ID: json.RawMessage("null"),
+					Error: &Error{Code: CodeParseError, Message: err.Error()}})
+			}
+			return
+		}
+		resp := c.handle(req)
+		if len(req.ID) == 0 {
+			continue // notification
+		}
+		resp.JSONRPC, resp.ID = "2.0", req.ID
+		if err := c.write(resp); err != nil {
+			return
+		}
+	}
+}
+
+func (c *conn) pushEvents() {
+	for {
+		select {
+		case ev := <-c.events:
+			c.mu.Lock()
+			ev.Dropped, c.dropped = c.dropped, 0
+			c.mu.Unlock()
+			if err := c.write(notification{JSONRPC: "2.0", Method: "event", Params: ev}); err != nil {
+				_ = c.nc.Close()
+				return
+			}
+		case <-c.done:
+			return
+		}
+	}
+}
+
+func (c *conn) handle(req request) response {
+	if req.JSONRPC != "2.0" || req.Method == "" {
+		return response{Error: &Error{Code: CodeInvalidRequest, Message: "invalid request"}}
+	}
+	switch req.Method {
+	case "subscribe":
+		var p struct {
+			Events []string `json:"events"`
+			HostID int      `json:"hostId"`
+			TabID  int      `json:"tabId"`
+		}
+		if len(req.Params) > 0 {
+			if err := json.Unmarshal(req.Params, &p); err != nil {
+				return response{Error: &Error{Code: CodeInvalidParams, Message: err.Error()}}
+			}
+		}
+		if len(p.Events) == 0 {
+			p.Events = []string{EventState, EventOutput}
+		}
+		sub := &subscription{kinds: map[string]bool{}, hostID: p.HostID, tabID: p.TabID}
+		for _, k := range p.Events {
+			if k != EventState && k != EventOutput && k != EventTransfer {
+				return response{Error: &Error{Code: CodeInvalidParams, Message: "unknown event " + k}}
+			}
+			sub.kinds[k] = true
+		}
+		c.mu.Lock()
+		c.sub = sub
+		c.mu.Unlock()
+		return response{Result: map[string]any{"events": p.Events}}
+
+	case "unsubscribe":
+		c.mu.Lock()
+		c.sub = nil
+		c.mu.Unlock()
+		return response{Result: map[string]any{}}
+	}
+
+	result, err := c.srv.handler(req.Method, req.Params)
+	if err != nil {
+		var rpcErr *Error
+		if !errors.As(err, &rpcErr) {
+			rpcErr = &Error{Code: CodeInternal, Message: err.Error()}
+		}
+		return response{Error: rpcErr}
+	}
+	if result == nil {
+		result = map[string]any{}
+	}
+	return response{Result: result}
+}
@@ -0,0 +1,147 @@
+package control
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ankouros/pterminal/internal/model"
+)
+
+type testClient struct {
+	t  *testing.T
+	nc net.Conn
+	rd *bufio.Reader
+}
+
+func dialTest(t *testing.T, path string) *testClient {
+	t.Helper()
+	nc, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = nc.Close() })
+	return &testClient{t: t, nc: nc, rd: bufio.NewReader(nc)}
+}
+
+func (c *testClient) send(line string) {
+	c.t.Helper()
+	if _, err := c.nc.Write([]byte(line + "\n")); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+func (c *testClient) recv() map[string]any {
+	c.t.Helper()
+	_ = c.nc.SetReadDeadline(time.Now().Add(5 * time.Second))
+	line, err := c.rd.ReadBytes('\n')
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	var m map[string]any
+	if err := json.Unmarshal(line, &m); err != nil {
+		c.t.Fatal(err)
+	}
+	return m
+}
+
+func TestServer(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ctl", "control.sock")
+	srv := NewServer(func(method string, params json.RawMessage) (any, error) {
+		switch method {
+		case "echo":
+			var p map[string]any
+			_ = json.Unmarshal(params, &p)
+			return p, nil
+		case "fail":
+			return nil, &Error{Code: CodeAppError, Message: "nope"}
+		}
+		return nil, errors.New("boom")
+	})
+	t.Cleanup(func() { _ = srv.Close() })
+
+	if err := srv.Configure(&model.ControlSettings{Enabled: true, SocketPath: path}); err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0o600 {
+		t.Fatalf("socket mode %v", fi.Mode().Perm())
+	}
+
+	// A second server must not take over a live socket.
+	other := NewServer(nil)
+	if err := other.Configure(&model.ControlSettings{Enabled: true, SocketPath: path}); err == nil {
+		_ = other.Close()
+		t.Fatal("expected the socket to be in use")
+	}
+
+	c := dialTest(t, path)
+	c.send(`{"jsonrpc":"2.0","id":1,"method":"echo","params":{"a":"b"}}`)
+	if m := c.recv(); m["id"] != 1.0 || m["result"].(map[string]any)["a"] != "b" {
+		t.Fatalf("echo: %v", m)
+	}
+	c.send(`{"jsonrpc":"2.0","id":2,"method":"fail"}`)
+	if m := c.recv(); m["error"].(map[string]any)["code"] != float64(CodeAppError) {
+		t.Fatalf("fail: %v", m)
+	}
+	c.send(`{"jsonrpc":"2.0","id":3,"method":"other"}`)
+	if m := c.recv(); m["error"].(map[string]any)["code"] != float64(CodeInternal) {
+		t.Fatalf("other: %v", m)
+	}
+	c.send(`{"id":4,"method":"echo"}`)
+	if m := c.recv(); m["error"].(map[string]any)["code"] != float64(CodeInvalidRequest) {
+		t.Fatalf("invalid: %v", m)
+	}
+
+	// Events reach matching subscribers only.
+	if srv.Subscribed(EventState) {
+		t.Fatal("no subscribers yet")
+	}
+	c.send(`{"jsonrpc":"2.0","id":5,"method":"subscribe","params":{"events":["state"],"hostId":7}}`)
+	c.recv()
+	if !srv.Subscribed(EventState) || srv.Subscribed(EventOutput) {
+		t.Fatal("unexpected subscriptions")
+	}
+	srv.Publish(Event{Type: EventOutput, HostID: 7, TabID: 1, DataB64: "aGk="})
+	srv.Publish(Event{Type: EventState, HostID: 8, TabID: 1, State: "connected"})
+	srv.Publish(Event{Type: EventState, HostID: 7, TabID: 2, State: "connected"})
+	m := c.recv()
+	ev := m["params"].(map[string]any)
+	if m["method"] != "event" || ev["hostId"] != 7.0 || ev["tabId"] != 2.0 || ev["state"] != "connected" {
+		t.Fatalf("event: %v", m)
+	}
+
+	c.send(`{"jsonrpc":"2.0","id":6,"method":"subscribe","params":{"events":["transfer"]}}`)
+	if m := c.recv(); m["error"] != nil {
+		t.Fatalf("subscribe transfer: %v", m)
+	}
+	if !srv.Subscribed(EventTransfer) {
+		t.Fatal("not subscribed to transfers")
+	}
+	srv.Publish(Event{Type: EventTransfer, HostID: 7, Transfer: map[string]any{"id": "t1"}})
+	m = c.recv()
+	ev = m["params"].(map[string]any)
+	if ev["type"] != EventTransfer || ev["transfer"].(map[string]any)["id"] != "t1" {
+		t.Fatalf("transfer event: %v", m)
+	}
+
+	c.send(`not json`)
+	if m := c.recv(); m["error"].(map[string]any)["code"] != float64(CodeParseError) {
+		t.Fatalf("parse error: %v", m)
+	}
+
+	if err := srv.Configure(&model.ControlSettings{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("socket left behind: %v", err)
+	}
+}
@@ -0,0 +1,96 @@
+package credstore
+
+import (
+	"errors"
+
+	"github.com/ankouros/pterminal/internal/model"
+)
+
+/*
+Credential stores
+
+A Store keeps host secrets by kind and host UID. The UI's in-memory password
+cache sits in front of a Chain of stores (the desktop keyring, the vault):
+lookups try every available store in order, and a newly entered secret is
+saved to the first available one.
+*/
+
+// Secret kinds.
+const (
+	KindPassword   = "ssh"        // SSH password (password / keyboard-interactive auth)
+	KindPassphrase = "passphrase" // private key passphrase
+	KindSFTP       = "sftp"       // custom SFTP password
+)
+
+// ErrNoStore is returned by Chain.Save when no store is available.
+var ErrNoStore = errors.New("no credential store available")
+
+type Store interface {
+	// Name identifies the store in the UI ("keyring", "vault").
+	Name() string
+
+	// Available reports whether the store can be read and written now
+	// (reachable, unlocked).
+	Available() bool
+
+	Get(kind, hostUID string) (string, bool)
+
+	// Set stores a secret; an empty secret removes it.
+	Set(kind, hostUID, secret string) error
+
+	// Forget removes every secret stored for a host.
+	Forget(hostUID string) error
+}
+
+// KindFor returns the kind of secret a host's SSH connection asks for.
+func KindFor(host model.Host) string {
+	if host.Auth.Method == model.AuthKey {
+		return KindPassphrase
+	}
+	return KindPassword
+}
+
+// Chain is an ordered list of stores.
+type Chain []Store
+
+// Get returns the secret from the first available store that has it.
+func (c Chain) Get(kind, hostUID string) (string, bool) {
+	if hostUID == "" {
+		return "", false
+	}
+	for _, s := range c {
+		if !s.Available() {
+			continue
+		}
+		if secret, ok := s.Get(kind, hostUID); ok {
+			return secret, true
+		}
+	}
+	return "", false
+}
+
+// Save writes secret to the first available store.
+func (c Chain) Save(kind, hostUID, secret string) error {
+	if hostUID == "" {
+		return errors.New("host uid is required")
+	}
+	for _, s := range c {
+		if s.Available() {
+			return s.Set(kind, hostUID, secret)
+		}
+	}
+	return ErrNoStore
+}
+
+// Forget removes a host's secrets from every available store.
+func (c Chain) Forget(hostUID string) error {
+	var errs []error
+	for _, s := range c {
+		if s.Available() {
+			if err := s.Forget(hostUID); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
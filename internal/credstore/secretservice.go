@@ -0,0 +1,307 @@
+package credstore
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+)
+
+/*
+Secret Service backend
+
+Speaks the freedesktop.org Secret Service API (org.freedesktop.secrets, as
+served by GNOME Keyring and KWallet) on the session bus. Items live in the
+default collection with the attributes
+
+	application=pterminal  kind=<kind>  host-uid=<uid>
+
+and are transferred over a "plain" session, which is fine for a local bus.
+Lookups never prompt; saving into a locked collection asks the desktop to
+unlock it.
+*/
+
+const (
+	ssName           = "org.freedesktop.secrets"
+	ssPath           = dbus.ObjectPath("/org/freedesktop/secrets")
+	ssIface          = "org.freedesktop.Secret.Service"
+	ssCollectionFace = "org.freedesktop.Secret.Collection"
+	ssItemIface      = "org.freedesktop.Secret.Item"
+	ssPromptIface    = "org.freedesktop.Secret.Prompt"
+
+	ssAppAttr = "pterminal"
+
+	promptTimeout = 2 * time.Minute
+)
+
+// ssSecret is the Secret struct of the API: (oayays).
+type ssSecret struct {
+	Session     dbus.ObjectPath
+	Parameters  []byte
+	Value       []byte
+	ContentType string
+}
+
+type SecretService struct {
+	dial func() (*dbus.Conn, error)
+
+	mu      sync.Mutex
+	conn    *dbus.Conn
+	session dbus.ObjectPath
+}
+
+// NewSecretService returns a store on the user's session bus. It connects on
+// first use.
+func NewSecretService() *SecretService {
+	return NewSecretServiceWith(dbus.SessionBusPrivate)
+}
+
+// NewSecretServiceWith uses dial for the bus connection; the connection it
+// returns must not be authenticated yet.
+func NewSecretServiceWith(dial func(opts ...dbus.ConnOption) (*dbus.Conn, error)) *SecretService {
+	return &SecretService{dial: func() (*dbus.Conn, error) {
+		conn, err := dial()
+		if err != nil {
+			return nil, err
+		}
+		if err := conn.Auth(nil); err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+		if err := conn.Hello(); err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+		return conn, nil
+	}}
+}
+
+func (s *SecretService) Name() string { return "keyring" }
+
+// Available reports whether a secret service is running on the bus.
+func (s *SecretService) Available() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	conn, _, err := s.connLocked()
+	if err != nil {
+		return false
+	}
+	var has bool
+	if err := conn.BusObject().Call("org.freedesktop.DBus.NameHasOwner", 0, ssName).Store(&has); err != nil {
+		s.resetLocked()
+		return false
+	}
+	return has
+}
+
+// Close drops the bus connection.
+func (s *SecretService) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.resetLocked()
+	return nil
+}
+
+func (s *SecretService) resetLocked() {
+	if s.conn != nil {
+		_ = s.conn.Close()
+	}
+	s.conn = nil
+	s.session = ""
+}
+
+// connLocked returns the bus connection and an open plain session.
+func (s *SecretService) connLocked() (*dbus.Conn, dbus.ObjectPath, error) {
+	if s.conn != nil && s.conn.Connected() && s.session != "" {
+		return s.conn, s.session, nil
+	}
+	s.resetLocked()
+	conn, err := s.dial()
+	if err != nil {
+		return nil, "", err
+	}
+	var out dbus.Variant
+	var session dbus.ObjectPath
+	err = conn.Object(ssName, ssPath).
+		Call(ssIface+".OpenSession", 0, "plain", dbus.MakeVariant("")).
+		Store(&out, &session)
+	if err != nil {
+		_ = conn.Close()
+		return nil, "", fmt.Errorf("secret service: %w", err)
+	}
+	s.conn, s.session = conn, session
+	return conn, session, nil
+}
+
+func attrs(kind, hostUID string) map[string]string {
+	a := map[string]string{"application": ssAppAttr, "host-uid": hostUID}
+	if kind != "" {
+		a["kind"] = kind
+	}
+	return a
+}
+
+// search returns the unlocked and locked items matching a.
+func (s *SecretService) search(conn *dbus.Conn, a map[string]string) ([]dbus.ObjectPath, []dbus.ObjectPath, error) {
+	var unlocked, locked []dbus.ObjectPath
+	err := conn.Object(ssName, ssPath).Call(ssIface+".SearchItems", 0, a).Store(&unlocked, &locked)
+	return unlocked, locked, err
+}
+
+// Get returns a secret from an unlocked item. It never prompts.
+func (s *SecretService) Get(kind, hostUID string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	conn, session, err := s.connLocked()
+	if err != nil {
+		return "", false
+	}
+	unlocked, _, err := s.search(conn, attrs(kind, hostUID))
+	if err != nil || len(unlocked) == 0 {
+		return "", false
+	}
+	var secret ssSecret
+	if err := conn.Object(ssName, unlocked[0]).Call(ssItemIface+".GetSecret", 0, session).Store(&secret); err != nil {
+		return "", false
+	}
+	return string(secret.Value), true
+}
+
+// Set creates or replaces the item for kind and host; an empty secret deletes it.
+func (s *SecretService) Set(kind, hostUID, secret string) error {
+	if secret == "" {
+		return s.remove(attrs(kind, hostUID))
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	conn, session, err := s.connLocked()
+	if err != nil {
+		return err
+	}
+	collection, err := s.defaultCollection(conn)
+	if err != nil {
+		return err
+	}
+	if err := s.unlock(conn, collection); err != nil {
+		return err
+	}
+
+	props := map[string]dbus.Variant{
+		ssItemIface + ".Label":      dbus.MakeVariant(fmt.Sprintf("pTerminal %s for host %s", kind, hostUID)),
+		ssItemIface + ".Attributes": dbus.MakeVariant(attrs(kind, hostUID)),
+	}
+	value := ssSecret{Session: session, Value: []byte(secret), ContentType: "text/plain; charset=utf8"}
+	var item, prompt dbus.ObjectPath
+	err = conn.Object(ssName, collection).
+		Call(ssCollectionFace+".CreateItem", 0, props, value, true).
+		Store(&item, &prompt)
+	if err != nil {
+		return fmt.Errorf("secret service: %w", err)
+	}
+	_, err = s.prompt(conn, prompt)
+	return err
+}
+
+// Forget deletes every item stored for a host.
+func (s *SecretService) Forget(hostUID string) error {
+	return s.remove(attrs("", hostUID))
+}
+
+func (s *SecretService) remove(a map[string]string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	conn, _, err := s.connLocked()
+	if err != nil {
+		return err
+	}
+	unlocked, locked, err := s.search(conn, a)
+	if err != nil {
+		return fmt.Errorf("secret service: %w", err)
+	}
+	if len(locked) > 0 {
+		if err := s.unlock(conn, locked...); err != nil {
+			return err
+		}
+		unlocked = append(unlocked, locked...)
+	}
+	for _, item := range unlocked {
+		var prompt dbus.ObjectPath
+		if err := conn.Object(ssName, item).Call(ssItemIface+".Delete", 0).Store(&prompt); err != nil {
+			return fmt.Errorf("secret service: %w", err)
+		}
+		if _, err := s.prompt(conn, prompt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *SecretService) defaultCollection(conn *dbus.Conn) (dbus.ObjectPath, error) {
+	var path dbus.ObjectPath
+	if err := conn.Object(ssName, ssPath).Call(ssIface+".ReadAlias", 0, "default").Store(&path); err != nil {
+		return "", fmt.Errorf("secret service: %w", err)
+	}
+	if path == "/" || path == "" {
+		return "", errors.New("secret service has no default collection")
+	}
+	return path, nil
+}
+
+func (s *SecretService) unlock(conn *dbus.Conn, objects ...dbus.ObjectPath) error {
+	var unlocked []dbus.ObjectPath
+	var prompt dbus.ObjectPath
+	if err := conn.Object(ssName, ssPath).Call(ssIface+".Unlock", 0, objects).Store(&unlocked, &prompt); err != nil {
+		return fmt.Errorf("secret service: %w", err)
+	}
+	dismissed, err := s.prompt(conn, prompt)
+	if err != nil {
+		return err
+	}
+	if dismissed {
+		return errors.New("keyring unlock was dismissed")
+	}
+	return nil
+}
+
+// prompt runs a Secret Service prompt ("/" means none is needed) and waits
+// for it to complete.
+func (s *SecretService) prompt(conn *dbus.Conn, path dbus.ObjectPath) (dismissed bool, err error) {
+	if path == "/" || path == "" {
+		return false, nil
+	}
+	match := []dbus.MatchOption{
+		dbus.WithMatchObjectPath(path),
+		dbus.WithMatchInterface(ssPromptIface),
+		dbus.WithMatchMember("Completed"),
+	}
+	if err := conn.AddMatchSignal(match...); err != nil {
+		return false, err
+	}
+	defer conn.RemoveMatchSignal(match...)
+	signals := make(chan *dbus.Signal, 4)
+	conn.Signal(signals)
+	defer conn.RemoveSignal(signals)
+
+	if err := conn.Object(ssName, path).Call(ssPromptIface+".Prompt", 0, "").Err; err != nil {
+		return false, fmt.Errorf("secret service prompt: %w", err)
+	}
+	timeout := time.NewTimer(promptTimeout)
+	defer timeout.Stop()
+	for {
+		select {
+		case sig := <-signals:
+			if sig == nil || sig.Path != path || sig.Name != ssPromptIface+".Completed" {
+				continue
+			}
+			if len(sig.Body) > 0 {
+				dismissed, _ = sig.Body[0].(bool)
+			}
+			return dismissed, nil
+		case <-timeout.C:
+			return false, errors.New("secret service prompt timed out")
+		}
+	}
+}
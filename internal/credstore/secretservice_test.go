@@ -0,0 +1,353 @@
+package credstore
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+)
+
+func TestSecretServiceStore(t *testing.T) {
+	addr := startTestBus(t)
+	mock := startMockSecretService(t, addr)
+
+	ss := NewSecretServiceWith(func(opts ...dbus.ConnOption) (*dbus.Conn, error) {
+		return dbus.Dial(addr, opts...)
+	})
+	t.Cleanup(func() { _ = ss.Close() })
+
+	if !ss.Available() {
+		t.Fatal("secret service not available")
+	}
+
+	// The collection starts locked; saving unlocks it through a prompt.
+	if err := (Chain{ss}).Save(KindPassword, "host-1", "pw-1"); err != nil {
+		t.Fatal(err)
+	}
+	if mock.prompts() != 1 {
+		t.Fatalf("expected one unlock prompt, got %d", mock.prompts())
+	}
+	if err := ss.Set(KindSFTP, "host-1", "sftp-1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ss.Set(KindPassword, "host-1", "pw-2"); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, ok := ss.Get(KindPassword, "host-1"); !ok || got != "pw-2" {
+		t.Fatalf("unexpected password %q %v", got, ok)
+	}
+	if got, ok := ss.Get(KindSFTP, "host-1"); !ok || got != "sftp-1" {
+		t.Fatalf("unexpected sftp password %q %v", got, ok)
+	}
+	if _, ok := ss.Get(KindPassphrase, "host-1"); ok {
+		t.Fatal("unexpected passphrase")
+	}
+	if n := mock.count(); n != 2 {
+		t.Fatalf("expected 2 items after replace, got %d", n)
+	}
+
+	if err := ss.Set(KindSFTP, "host-1", ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := ss.Get(KindSFTP, "host-1"); ok {
+		t.Fatal("sftp password not removed")
+	}
+	if err := (Chain{ss}).Forget("host-1"); err != nil {
+		t.Fatal(err)
+	}
+	if mock.count() != 0 {
+		t.Fatalf("items left after forget: %d", mock.count())
+	}
+}
+
+type memStore struct {
+	name      string
+	available bool
+	secrets   map[string]string
+}
+
+func (m *memStore) Name() string    { return m.name }
+func (m *memStore) Available() bool { return m.available }
+func (m *memStore) Get(kind, uid string) (string, bool) {
+	s, ok := m.secrets[kind+"/"+uid]
+	return s, ok
+}
+func (m *memStore) Set(kind, uid, secret string) error {
+	m.secrets[kind+"/"+uid] = secret
+	return nil
+}
+func (m *memStore) Forget(uid string) error {
+	for k := range m.secrets {
+		if strings.HasSuffix(k, "/"+uid) {
+			delete(m.secrets, k)
+		}
+	}
+	return nil
+}
+
+func TestChainOrder(t *testing.T) {
+	locked := &memStore{name: "vault", secrets: map[string]string{"ssh/h": "old"}}
+	keyring := &memStore{name: "keyring", available: true, secrets: map[string]string{}}
+	chain := Chain{locked, keyring}
+
+	if _, ok := chain.Get(KindPassword, "h"); ok {
+		t.Fatal("unavailable store was read")
+	}
+	if err := chain.Save(KindPassword, "h", "new"); err != nil {
+		t.Fatal(err)
+	}
+	if keyring.secrets["ssh/h"] != "new" || locked.secrets["ssh/h"] != "old" {
+		t.Fatalf("saved to the wrong store: %v %v", keyring.secrets, locked.secrets)
+	}
+	locked.available = true
+	if got, _ := chain.Get(KindPassword, "h"); got != "old" {
+		t.Fatalf("expected the first store to win, got %q", got)
+	}
+	if err := (Chain{&memStore{}}).Save(KindPassword, "h", "x"); err != ErrNoStore {
+		t.Fatalf("expected ErrNoStore, got %v", err)
+	}
+}
+
+// startTestBus runs a private dbus-daemon and returns its address.
+func startTestBus(t *testing.T) string {
+	t.Helper()
+	daemon, err := exec.LookPath("dbus-daemon")
+	if err != nil {
+		t.Skip("dbus-daemon not installed")
+	}
+	dir := t.TempDir()
+	conf := filepath.Join(dir, "bus.conf")
+	err = os.WriteFile(conf, []byte(`<!DOCTYPE busconfig PUBLIC "-//freedesktop//DTD D-Bus Bus Configuration 1.0//EN"
+ "http://www.freedesktop.org/standards/dbus/1.0/busconfig.dtd">
+<busconfig>
+  <type>session</type>
+  <listen>unix:path=`+filepath.Join(dir, "bus")+`</listen>
+  <auth>EXTERNAL</auth>
+  <policy context="default">
+    <allow send_destination="*" eavesdrop="true"/>
+    <allow eavesdrop="true"/>
+    <allow own="*"/>
+  </policy>
+</busconfig>
+`), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := exec.Command(daemon, "--config-file="+conf, "--nofork", "--print-address")
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Skipf("dbus-daemon: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+	})
+
+	line := make(chan string, 1)
+	go func() {
+		s, _ := bufio.NewReader(out).ReadString('\n')
+		line <- strings.TrimSpace(s)
+	}()
+	select {
+	case addr := <-line:
+		if addr == "" {
+			t.Skip("dbus-daemon printed no address")
+		}
+		return addr
+	case <-time.After(5 * time.Second):
+		t.Skip("dbus-daemon did not start")
+	}
+	return ""
+}
+
+/*
+Mock secret service
+
+Implements the subset of org.freedesktop.Secret.* the store uses: a single
+"login" collection (the default alias) that starts locked and is unlocked by
+a prompt, and items with attributes and a plain secret.
+*/
+
+const mockCollection = dbus.ObjectPath("/org/freedesktop/secrets/collection/login")
+
+type mockSecretService struct {
+	conn *dbus.Conn
+
+	mu        sync.Mutex
+	locked    bool
+	items     map[dbus.ObjectPath]*mockItem
+	next      int
+	promptCnt int
+}
+
+type mockItem struct {
+	svc   *mockSecretService
+	path  dbus.ObjectPath
+	attrs map[string]string
+	value []byte
+}
+
+type mockPrompt struct {
+	svc  *mockSecretService
+	path dbus.ObjectPath
+}
+
+type mockCollectionObj struct{ svc *mockSecretService }
+
+func startMockSecretService(t *testing.T, addr string) *mockSecretService {
+	t.Helper()
+	conn, err := dbus.Dial(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.Auth(nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.Hello(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+
+	m := &mockSecretService{conn: conn, locked: true, items: map[dbus.ObjectPath]*mockItem{}}
+	if err := conn.Export(m, ssPath, ssIface); err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.Export(&mockCollectionObj{svc: m}, mockCollection, ssCollectionFace); err != nil {
+		t.Fatal(err)
+	}
+	reply, err := conn.RequestName(ssName, dbus.NameFlagDoNotQueue)
+	if err != nil || reply != dbus.RequestNameReplyPrimaryOwner {
+		t.Fatalf("request name: %v %v", reply, err)
+	}
+	return m
+}
+
+func (m *mockSecretService) prompts() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.promptCnt
+}
+
+func (m *mockSecretService) count() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.items)
+}
+
+func (m *mockSecretService) OpenSession(alg string, in dbus.Variant) (dbus.Variant, dbus.ObjectPath, *dbus.Error) {
+	if alg != "plain" {
+		return dbus.MakeVariant(""), "/", dbus.MakeFailedError(fmt.Errorf("unsupported algorithm %s", alg))
+	}
+	return dbus.MakeVariant(""), "/org/freedesktop/secrets/session/1", nil
+}
+
+func (m *mockSecretService) ReadAlias(name string) (dbus.ObjectPath, *dbus.Error) {
+	if name == "default" {
+		return mockCollection, nil
+	}
+	return "/", nil
+}
+
+func (m *mockSecretService) SearchItems(attrs map[string]string) ([]dbus.ObjectPath, []dbus.ObjectPath, *dbus.Error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	unlocked, locked := []dbus.ObjectPath{}, []dbus.ObjectPath{}
+	for path, item := range m.items {
+		match := true
+		for k, v := range attrs {
+			if item.attrs[k] != v {
+				match = false
+			}
+		}
+		if !match {
+			continue
+		}
+		if m.locked {
+			locked = append(locked, path)
+		} else {
+			unlocked = append(unlocked, path)
+		}
+	}
+	return unlocked, locked, nil
+}
+
+func (m *mockSecretService) Unlock(objects []dbus.ObjectPath) ([]dbus.ObjectPath, dbus.ObjectPath, *dbus.Error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.locked {
+		return objects, "/", nil
+	}
+	m.next++
+	path := dbus.ObjectPath(fmt.Sprintf("/org/freedesktop/secrets/prompt/p%d", m.next))
+	if err := m.conn.Export(&mockPrompt{svc: m, path: path}, path, ssPromptIface); err != nil {
+		return nil, "/", dbus.MakeFailedError(err)
+	}
+	return []dbus.ObjectPath{}, path, nil
+}
+
+// Prompt unlocks the collection, as if the user typed the keyring password.
+func (p *mockPrompt) Prompt(windowID string) *dbus.Error {
+	p.svc.mu.Lock()
+	p.svc.locked = false
+	p.svc.promptCnt++
+	p.svc.mu.Unlock()
+	go func() {
+		_ = p.svc.conn.Emit(p.path, ssPromptIface+".Completed", false, dbus.MakeVariant([]dbus.ObjectPath{mockCollection}))
+	}()
+	return nil
+}
+
+func (c *mockCollectionObj) CreateItem(props map[string]dbus.Variant, secret ssSecret, replace bool) (dbus.ObjectPath, dbus.ObjectPath, *dbus.Error) {
+	m := c.svc
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.locked {
+		return "/", "/", &dbus.Error{Name: "org.freedesktop.Secret.Error.IsLocked"}
+	}
+	attrs, _ := props[ssItemIface+".Attributes"].Value().(map[string]string)
+	if replace {
+		for path, item := range m.items {
+			if fmt.Sprint(item.attrs) == fmt.Sprint(attrs) {
+				item.value = secret.Value
+				return path, "/", nil
+			}
+		}
+	}
+	m.next++
+	path := dbus.ObjectPath(fmt.Sprintf("%s/i%d", mockCollection, m.next))
+	item := &mockItem{svc: m, path: path, attrs: attrs, value: secret.Value}
+	if err := m.conn.Export(item, path, ssItemIface); err != nil {
+		return "/", "/", dbus.MakeFailedError(err)
+	}
+	m.items[path] = item
+	return path, "/", nil
+}
+
+func (i *mockItem) GetSecret(session dbus.ObjectPath) (ssSecret, *dbus.Error) {
+	i.svc.mu.Lock()
+	defer i.svc.mu.Unlock()
+	if i.svc.locked {
+		return ssSecret{}, &dbus.Error{Name: "org.freedesktop.Secret.Error.IsLocked"}
+	}
+	return ssSecret{Session: session, Value: i.value, ContentType: "text/plain"}, nil
+}
+
+func (i *mockItem) Delete() (dbus.ObjectPath, *dbus.Error) {
+	i.svc.mu.Lock()
+	delete(i.svc.items, i.path)
+	i.svc.mu.Unlock()
+	_ = i.svc.conn.Export(nil, i.path, ssItemIface)
+	return "/", nil
+}
@@ -0,0 +1,274 @@
+package forward
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/ankouros/pterminal/internal/model"
+	"golang.org/x/crypto/ssh"
+)
+
+type State string
+
+const (
+	StateStopped State = "stopped"
+	StateActive  State = "active"
+	StateWaiting State = "waiting" // wanted, but no SSH connection to run on
+	StateError   State = "error"
+)
+
+// Stats is a snapshot of one forwarding rule for the UI.
+type Stats struct {
+	ID     string            `json:"id"`
+	Kind   model.ForwardKind `json:"kind"`
+	Bind   string            `json:"bind"`
+	Target string            `json:"target,omitempty"`
+	State  State             `json:"state"`
+	Error  string            `json:"error,omitempty"`
+
+	// BytesIn counts bytes received from the target side, BytesOut bytes sent to it.
+	BytesIn  int64 `json:"bytesIn"`
+	BytesOut int64 `json:"bytesOut"`
+	Active   int64 `json:"active"`
+	Total    int64 `json:"total"`
+}
+
+// Counters accumulate traffic for a rule; they outlive a single Forward so
+// totals survive reconnects.
+type Counters struct {
+	BytesIn  atomic.Int64
+	BytesOut atomic.Int64
+	Active   atomic.Int64
+	Total    atomic.Int64
+}
+
+/*
+Validation
+*/
+
+func Validate(rule model.PortForward) error {
+	if rule.BindPort < 0 || rule.BindPort > 65535 {
+		return fmt.Errorf("invalid bind port %d", rule.BindPort)
+	}
+	switch rule.Kind {
+	case model.ForwardLocal, model.ForwardRemote:
+		if strings.TrimSpace(rule.TargetHost) == "" {
+			return errors.New("target host is required")
+		}
+		if rule.TargetPort <= 0 || rule.TargetPort > 65535 {
+			return fmt.Errorf("invalid target port %d", rule.TargetPort)
+		}
+	case model.ForwardDynamic:
+	default:
+		return fmt.Errorf("unknown forward kind: %s", rule.Kind)
+	}
+	return nil
+}
+
+func bindAddr(rule model.PortForward) string {
+	host := strings.TrimSpace(rule.BindAddr)
+	if host == "" {
+		if rule.Kind == model.ForwardRemote {
+			host = "localhost"
+		} else {
+			host = "127.0.0.1"
+		}
+	}
+	return net.JoinHostPort(host, fmt.Sprint(rule.BindPort))
+}
+
+func targetAddr(rule model.PortForward) string {
+	if rule.Kind == model.ForwardDynamic {
+		return ""
+	}
+	return net.JoinHostPort(strings.TrimSpace(rule.TargetHost), fmt.Sprint(rule.TargetPort))
+}
+
+/*
+Forward
+*/
+
+// Forward runs a single rule on one ssh.Client until closed or until the
+// listener fails (e.g. the SSH connection dropped).
+type Forward struct {
+	rule     model.PortForward
+	client   *ssh.Client
+	ln       net.Listener
+	counters *Counters
+
+	mu    sync.Mutex
+	conns map[net.Conn]struct{}
+
+	done chan struct{}
+	once sync.Once
+}
+
+// Start binds the listener for rule and begins accepting connections.
+func Start(client *ssh.Client, rule model.PortForward, counters *Counters) (*Forward, error) {
+	if client == nil {
+		return nil, errors.New("ssh client is nil")
+	}
+	if err := Validate(rule); err != nil {
+		return nil, err
+	}
+	if counters == nil {
+		counters = &Counters{}
+	}
+
+	var (
+		ln  net.Listener
+		err error
+	)
+	if rule.Kind == model.ForwardRemote {
+		ln, err = client.Listen("tcp", bindAddr(rule))
+	} else {
+		ln, err = net.Listen("tcp", bindAddr(rule))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	f := &Forward{
+		rule:     rule,
+		client:   client,
+		ln:       ln,
+		counters: counters,
+		conns:    make(map[net.Conn]struct{}),
+		done:     make(chan struct{}),
+	}
+	go f.acceptLoop()
+	return f, nil
+}
+
+// Addr returns the bound listener address (useful when BindPort is 0).
+func (f *Forward) Addr() net.Addr { return f.ln.Addr() }
+
+func (f *Forward) Done() <-chan struct{} { return f.done }
+
+func (f *Forward) Close() error {
+	var ret error
+	f.once.Do(func() {
+		ret = f.ln.Close()
+		// done is closed under mu so track cannot add a connection after
+		// the ones below are closed.
+		f.mu.Lock()
+		close(f.done)
+		for c := range f.conns {
+			_ = c.Close()
+		}
+		f.mu.Unlock()
+	})
+	return ret
+}
+
+func (f *Forward) acceptLoop() {
+	defer f.Close()
+	for {
+		conn, err := f.ln.Accept()
+		if err != nil {
+			return
+		}
+		go f.handle(conn)
+	}
+}
+
+func (f *Forward) track(c net.Conn) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	select {
+	case <-f.done:
+		return false
+	default:
+	}
+	f.conns[c] = struct{}{}
+	return true
+}
+
+func (f *Forward) untrack(c net.Conn) {
+	f.mu.Lock()
+	delete(f.conns, c)
+	f.mu.Unlock()
+}
+
+func (f *Forward) handle(conn net.Conn) {
+	if !f.track(conn) {
+		_ = conn.Close()
+		return
+	}
+	defer f.untrack(conn)
+	defer conn.Close()
+
+	var (
+		upstream net.Conn
+		err      error
+	)
+	switch f.rule.Kind {
+	case model.ForwardLocal:
+		upstream, err = f.client.Dial("tcp", targetAddr(f.rule))
+	case model.ForwardRemote:
+		upstream, err = net.Dial("tcp", targetAddr(f.rule))
+	case model.ForwardDynamic:
+		var target string
+		target, err = socksHandshake(conn)
+		if err != nil {
+			return
+		}
+		upstream, err = f.client.Dial("tcp", target)
+		if err != nil {
+			_ = socksReply(conn, socksReplyFailure)
+			return
+		}
+		err = socksReply(conn, socksReplySuccess)
+	}
+	if err != nil {
+		if upstream != nil {
+			_ = upstream.Close()
+		}
+		return
+	}
+	if !f.track(upstream) {
+		_ = upstream.Close()
+		return
+	}
+	defer f.untrack(upstream)
+	defer upstream.Close()
+
+	f.counters.Active.Add(1)
+	f.counters.Total.Add(1)
+	defer f.counters.Active.Add(-1)
+
+	pipe(conn, upstream, &f.counters.BytesOut, &f.counters.BytesIn)
+}
+
+// pipe copies both directions until either side closes.
+func pipe(local, upstream net.Conn, out, in *atomic.Int64) {
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		_, _ = io.Copy(countingWriter{w: upstream, n: out}, local)
+		_ = upstream.Close()
+	}()
+	go func() {
+		defer wg.Done()
+		_, _ = io.Copy(countingWriter{w: local, n: in}, upstream)
+		_ = local.Close()
+	}()
+	wg.Wait()
+}
+
+type countingWriter struct {
+	w io.Writer
+	n *atomic.Int64
+}
+
+func (c countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n.Add(int64(n))
+	return n, err
+}
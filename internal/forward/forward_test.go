@@ -0,0 +1,396 @@
+package forward
+
+import (
+	"bufio"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ankouros/pterminal/internal/model"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestLocalForward(t *testing.T) {
+	client := startTestServer(t)
+	echo := startEcho(t)
+
+	counters := &Counters{}
+	f, err := Start(client, model.PortForward{
+		ID:         "l",
+		Kind:       model.ForwardLocal,
+		BindPort:   0,
+		TargetHost: "127.0.0.1",
+		TargetPort: echo.Port,
+	}, counters)
+	if err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	defer f.Close()
+
+	conn, err := net.Dial("tcp", f.Addr().String())
+	if err != nil {
+		t.Fatalf("dial forward: %v", err)
+	}
+	roundTrip(t, conn, "hello")
+	_ = conn.Close()
+
+	waitFor(t, func() bool { return counters.Active.Load() == 0 })
+	if counters.Total.Load() != 1 || counters.BytesOut.Load() != 6 || counters.BytesIn.Load() != 6 {
+		t.Fatalf("unexpected counters: total=%d out=%d in=%d",
+			counters.Total.Load(), counters.BytesOut.Load(), counters.BytesIn.Load())
+	}
+}
+
+func TestRemoteForward(t *testing.T) {
+	client := startTestServer(t)
+	echo := startEcho(t)
+
+	f, err := Start(client, model.PortForward{
+		ID:         "r",
+		Kind:       model.ForwardRemote,
+		BindAddr:   "127.0.0.1",
+		BindPort:   0,
+		TargetHost: "127.0.0.1",
+		TargetPort: echo.Port,
+	}, nil)
+	if err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	defer f.Close()
+
+	// The test server binds the "remote" listener on this machine.
+	conn, err := net.Dial("tcp", f.Addr().String())
+	if err != nil {
+		t.Fatalf("dial remote listener: %v", err)
+	}
+	defer conn.Close()
+	roundTrip(t, conn, "remote")
+}
+
+func TestDynamicForward(t *testing.T) {
+	client := startTestServer(t)
+	echo := startEcho(t)
+
+	f, err := Start(client, model.PortForward{ID: "d", Kind: model.ForwardDynamic}, nil)
+	if err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	defer f.Close()
+
+	conn, err := net.Dial("tcp", f.Addr().String())
+	if err != nil {
+		t.Fatalf("dial socks: %v", err)
+	}
+	defer conn.Close()
+
+	// Greeting: version 5, one method (no auth).
+	if _, err := conn.Write([]byte{5, 1, 0}); err != nil {
+		t.Fatal(err)
+	}
+	var sel [2]byte
+	if _, err := io.ReadFull(conn, sel[:]); err != nil || sel != [2]byte{5, 0} {
+		t.Fatalf("method selection: %v %v", sel, err)
+	}
+
+	host := "localhost"
+	req := []byte{5, 1, 0, 3, byte(len(host))}
+	req = append(req, host...)
+	req = binary.BigEndian.AppendUint16(req, uint16(echo.Port))
+	if _, err := conn.Write(req); err != nil {
+		t.Fatal(err)
+	}
+	var reply [10]byte
+	if _, err := io.ReadFull(conn, reply[:]); err != nil || reply[1] != socksReplySuccess {
+		t.Fatalf("connect reply: %v %v", reply, err)
+	}
+	roundTrip(t, conn, "socks")
+}
+
+func TestSetRestartsOnAttach(t *testing.T) {
+	echo := startEcho(t)
+	rule := model.PortForward{
+		ID:         "l",
+		Kind:       model.ForwardLocal,
+		BindPort:   freePort(t),
+		TargetHost: "127.0.0.1",
+		TargetPort: echo.Port,
+	}
+
+	set := NewSet()
+	if err := set.Start(rule); err != nil {
+		t.Fatalf("start without client: %v", err)
+	}
+	if st := set.Stats([]model.PortForward{rule}); st[0].State != StateWaiting {
+		t.Fatalf("expected waiting, got %s", st[0].State)
+	}
+
+	first := startTestServer(t)
+	set.Attach(first, nil)
+	if st := set.Stats([]model.PortForward{rule}); st[0].State != StateActive {
+		t.Fatalf("expected active, got %+v", st[0])
+	}
+
+	// Simulate a dropped connection followed by a reconnect.
+	set.Detach(first)
+	_ = first.Close()
+	if st := set.Stats([]model.PortForward{rule}); st[0].State != StateWaiting {
+		t.Fatalf("expected waiting after detach, got %s", st[0].State)
+	}
+
+	second := startTestServer(t)
+	set.Attach(second, nil)
+	conn, err := net.Dial("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(rule.BindPort)))
+	if err != nil {
+		t.Fatalf("dial restored forward: %v", err)
+	}
+	roundTrip(t, conn, "again")
+	_ = conn.Close()
+
+	set.Stop(rule.ID)
+	if st := set.Stats([]model.PortForward{rule}); st[0].State != StateStopped {
+		t.Fatalf("expected stopped, got %s", st[0].State)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	cases := []struct {
+		rule model.PortForward
+		ok   bool
+	}{
+		{model.PortForward{Kind: model.ForwardLocal, BindPort: 80, TargetHost: "h", TargetPort: 80}, true},
+		{model.PortForward{Kind: model.ForwardLocal, BindPort: 80}, false},
+		{model.PortForward{Kind: model.ForwardDynamic, BindPort: 1080}, true},
+		{model.PortForward{Kind: "bogus", BindPort: 1}, false},
+		{model.PortForward{Kind: model.ForwardRemote, BindPort: 70000, TargetHost: "h", TargetPort: 1}, false},
+	}
+	for i, tc := range cases {
+		if err := Validate(tc.rule); (err == nil) != tc.ok {
+			t.Fatalf("case %d: got err=%v, want ok=%v", i, err, tc.ok)
+		}
+	}
+}
+
+/*
+Helpers
+*/
+
+func roundTrip(t *testing.T, conn net.Conn, msg string) {
+	t.Helper()
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := fmt.Fprintf(conn, "%s\n", msg); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if strings.TrimSpace(line) != msg {
+		t.Fatalf("echo mismatch: %q", line)
+	}
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func freePort(t *testing.T) int {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	return ln.Addr().(*net.TCPAddr).Port
+}
+
+func startEcho(t *testing.T) *net.TCPAddr {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = ln.Close() })
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				_, _ = io.Copy(c, c)
+			}()
+		}
+	}()
+	return ln.Addr().(*net.TCPAddr)
+}
+
+// startTestServer starts an SSH server that supports direct-tcpip and
+// tcpip-forward, and returns a client connected to it.
+func startTestServer(t *testing.T) *ssh.Client {
+	t.Helper()
+
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &ssh.ServerConfig{NoClientAuth: true}
+	cfg.AddHostKey(signer)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = ln.Close() })
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go serveConn(c, cfg)
+		}
+	}()
+
+	client, err := ssh.Dial("tcp", ln.Addr().String(), &ssh.ClientConfig{
+		User:            "test",
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		Timeout:         5 * time.Second,
+	})
+	if err != nil {
+		t.Fatalf("ssh dial: %v", err)
+	}
+	t.Cleanup(func() { _ = client.Close() })
+	return client
+}
+
+func serveConn(c net.Conn, cfg *ssh.ServerConfig) {
+	defer c.Close()
+	sc, chans, reqs, err := ssh.NewServerConn(c, cfg)
+	if err != nil {
+		return
+	}
+	defer sc.Close()
+
+	var (
+		mu        sync.Mutex
+		listeners []net.Listener
+	)
+	defer func() {
+		mu.Lock()
+		for _, l := range listeners {
+			_ = l.Close()
+		}
+		mu.Unlock()
+	}()
+
+	go func() {
+		for req := range reqs {
+			if req.Type != "tcpip-forward" {
+				if req.WantReply {
+					_ = req.Reply(false, nil)
+				}
+				continue
+			}
+			var p struct {
+				Addr string
+				Port uint32
+			}
+			if err := ssh.Unmarshal(req.Payload, &p); err != nil {
+				_ = req.Reply(false, nil)
+				continue
+			}
+			l, err := net.Listen("tcp", net.JoinHostPort(p.Addr, strconv.Itoa(int(p.Port))))
+			if err != nil {
+				_ = req.Reply(false, nil)
+				continue
+			}
+			mu.Lock()
+			listeners = append(listeners, l)
+			mu.Unlock()
+			port := uint32(l.Addr().(*net.TCPAddr).Port)
+			_ = req.Reply(true, ssh.Marshal(struct{ Port uint32 }{port}))
+
+			go func(addr string) {
+				for {
+					in, err := l.Accept()
+					if err != nil {
+						return
+					}
+					origin := in.RemoteAddr().(*net.TCPAddr)
+					payload := ssh.Marshal(struct {
+						Addr       string
+						Port       uint32
+						OriginAddr string
+						OriginPort uint32
+					}{addr, port, origin.IP.String(), uint32(origin.Port)})
+					ch, chReqs, err := sc.OpenChannel("forwarded-tcpip", payload)
+					if err != nil {
+						_ = in.Close()
+						continue
+					}
+					go ssh.DiscardRequests(chReqs)
+					go proxy(ch, in)
+				}
+			}(p.Addr)
+		}
+	}()
+
+	for nc := range chans {
+		if nc.ChannelType() != "direct-tcpip" {
+			_ = nc.Reject(ssh.UnknownChannelType, "unsupported")
+			continue
+		}
+		var p struct {
+			Host       string
+			Port       uint32
+			OriginHost string
+			OriginPort uint32
+		}
+		if err := ssh.Unmarshal(nc.ExtraData(), &p); err != nil {
+			_ = nc.Reject(ssh.ConnectionFailed, err.Error())
+			continue
+		}
+		up, err := net.Dial("tcp", net.JoinHostPort(p.Host, strconv.Itoa(int(p.Port))))
+		if err != nil {
+			_ = nc.Reject(ssh.ConnectionFailed, err.Error())
+			continue
+		}
+		ch, chReqs, err := nc.Accept()
+		if err != nil {
+			_ = up.Close()
+			continue
+		}
+		go ssh.DiscardRequests(chReqs)
+		go proxy(ch, up)
+	}
+}
+
+func proxy(ch ssh.Channel, c net.Conn) {
+	defer ch.Close()
+	defer c.Close()
+	go func() {
+		_, _ = io.Copy(c, ch)
+		_ = c.Close()
+	}()
+	_, _ = io.Copy(ch, c)
+}
@@ -0,0 +1,194 @@
+package forward
+
+import (
+	"sync"
+
+	"github.com/ankouros/pterminal/internal/model"
+	"golang.org/x/crypto/ssh"
+)
+
+// Set tracks the forwards wanted for one host. Forwards run on whichever SSH
+// client is attached; when it detaches they wait and are restarted on the next
+// Attach (e.g. after session.Manager reconnects).
+type Set struct {
+	mu      sync.Mutex
+	client  *ssh.Client
+	entries map[string]*entry
+}
+
+type entry struct {
+	rule     model.PortForward
+	fwd      *Forward
+	err      error
+	counters *Counters
+}
+
+func NewSet() *Set {
+	return &Set{entries: make(map[string]*entry)}
+}
+
+// Client returns the SSH client the forwards currently run on (nil if none).
+func (s *Set) Client() *ssh.Client {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.client
+}
+
+// Attach runs all wanted forwards on client. autoStart rules are added to the
+// wanted set first.
+func (s *Set) Attach(client *ssh.Client, autoStart []model.PortForward) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.client = client
+	for _, rule := range autoStart {
+		if _, ok := s.entries[rule.ID]; !ok {
+			s.entries[rule.ID] = &entry{rule: rule, counters: &Counters{}}
+		}
+	}
+	for _, e := range s.entries {
+		s.runLocked(e)
+	}
+}
+
+// Detach stops the running forwards if they run on client; they stay wanted.
+func (s *Set) Detach(client *ssh.Client) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.client == nil || s.client != client {
+		return
+	}
+	s.client = nil
+	for _, e := range s.entries {
+		if e.fwd != nil {
+			_ = e.fwd.Close()
+			e.fwd = nil
+		}
+	}
+}
+
+// Start marks rule as wanted (replacing an older copy) and runs it if a client
+// is attached.
+func (s *Set) Start(rule model.PortForward) error {
+	if err := Validate(rule); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e := s.entries[rule.ID]
+	if e == nil {
+		e = &entry{counters: &Counters{}}
+		s.entries[rule.ID] = e
+	}
+	if e.fwd != nil {
+		_ = e.fwd.Close()
+		e.fwd = nil
+	}
+	e.rule = rule
+	s.runLocked(e)
+	return e.err
+}
+
+// Stop closes the forward and forgets it.
+func (s *Set) Stop(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if e := s.entries[id]; e != nil {
+		if e.fwd != nil {
+			_ = e.fwd.Close()
+		}
+		delete(s.entries, id)
+	}
+}
+
+// StopAll closes every forward and clears the wanted set.
+func (s *Set) StopAll() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id, e := range s.entries {
+		if e.fwd != nil {
+			_ = e.fwd.Close()
+		}
+		delete(s.entries, id)
+	}
+}
+
+// Stats reports every configured rule plus any running rule that has since
+// been removed from the config.
+func (s *Set) Stats(rules []model.PortForward) []Stats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	out := make([]Stats, 0, len(rules))
+	seen := make(map[string]struct{}, len(rules))
+	for _, rule := range rules {
+		seen[rule.ID] = struct{}{}
+		if e := s.entries[rule.ID]; e != nil {
+			out = append(out, s.statsLocked(e))
+			continue
+		}
+		out = append(out, Stats{
+			ID:     rule.ID,
+			Kind:   rule.Kind,
+			Bind:   bindAddr(rule),
+			Target: targetAddr(rule),
+			State:  StateStopped,
+		})
+	}
+	for id, e := range s.entries {
+		if _, ok := seen[id]; !ok {
+			out = append(out, s.statsLocked(e))
+		}
+	}
+	return out
+}
+
+func (s *Set) runLocked(e *entry) {
+	if s.client == nil {
+		e.err = nil
+		return
+	}
+	if e.fwd != nil {
+		select {
+		case <-e.fwd.Done():
+			e.fwd = nil
+		default:
+			return
+		}
+	}
+	e.fwd, e.err = Start(s.client, e.rule, e.counters)
+}
+
+func (s *Set) statsLocked(e *entry) Stats {
+	st := Stats{
+		ID:       e.rule.ID,
+		Kind:     e.rule.Kind,
+		Bind:     bindAddr(e.rule),
+		Target:   targetAddr(e.rule),
+		BytesIn:  e.counters.BytesIn.Load(),
+		BytesOut: e.counters.BytesOut.Load(),
+		Active:   e.counters.Active.Load(),
+		Total:    e.counters.Total.Load(),
+	}
+	switch {
+	case e.err != nil:
+		st.State = StateError
+		st.Error = e.err.Error()
+	case e.fwd == nil:
+		st.State = StateWaiting
+	default:
+		select {
+		case <-e.fwd.Done():
+			st.State = StateWaiting
+		default:
+			st.State = StateActive
+			st.Bind = e.fwd.Addr().String()
+		}
+	}
+	return st
+}
@@ -0,0 +1,114 @@
+package forward
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+)
+
+// Minimal SOCKS5 server side (RFC 1928): no authentication, CONNECT only.
+
+const (
+	socksVersion5 = 0x05
+
+	socksAuthNone         = 0x00
+	socksAuthNoAcceptable = 0xff
+
+	socksCmdConnect = 0x01
+
+	socksAtypIPv4   = 0x01
+	socksAtypDomain = 0x03
+	socksAtypIPv6   = 0x04
+
+	socksReplySuccess         = 0x00
+	socksReplyFailure         = 0x01
+	socksReplyCmdUnsupported  = 0x07
+	socksReplyAtypUnsupported = 0x08
+)
+
+// socksHandshake negotiates auth and reads the CONNECT request, returning the
+// requested host:port. On protocol errors an error reply is sent when possible.
+func socksHandshake(conn net.Conn) (string, error) {
+	var hdr [2]byte
+	if _, err := io.ReadFull(conn, hdr[:]); err != nil {
+		return "", err
+	}
+	if hdr[0] != socksVersion5 {
+		return "", fmt.Errorf("unsupported socks version %d", hdr[0])
+	}
+	methods := make([]byte, int(hdr[1]))
+	if _, err := io.ReadFull(conn, methods); err != nil {
+		return "", err
+	}
+	noAuth := false
+	for _, m := range methods {
+		if m == socksAuthNone {
+			noAuth = true
+			break
+		}
+	}
+	if !noAuth {
+		_, _ = conn.Write([]byte{socksVersion5, socksAuthNoAcceptable})
+		return "", errors.New("socks client requires authentication")
+	}
+	if _, err := conn.Write([]byte{socksVersion5, socksAuthNone}); err != nil {
+		return "", err
+	}
+
+	var req [4]byte
+	if _, err := io.ReadFull(conn, req[:]); err != nil {
+		return "", err
+	}
+	if req[0] != socksVersion5 {
+		return "", fmt.Errorf("unsupported socks version %d", req[0])
+	}
+	if req[1] != socksCmdConnect {
+		_ = socksReply(conn, socksReplyCmdUnsupported)
+		return "", fmt.Errorf("unsupported socks command %d", req[1])
+	}
+
+	var host string
+	switch req[3] {
+	case socksAtypIPv4:
+		var ip [4]byte
+		if _, err := io.ReadFull(conn, ip[:]); err != nil {
+			return "", err
+		}
+		host = net.IP(ip[:]).String()
+	case socksAtypIPv6:
+		var ip [16]byte
+		if _, err := io.ReadFull(conn, ip[:]); err != nil {
+			return "", err
+		}
+		host = net.IP(ip[:]).String()
+	case socksAtypDomain:
+		var n [1]byte
+		if _, err := io.ReadFull(conn, n[:]); err != nil {
+			return "", err
+		}
+		name := make([]byte, int(n[0]))
+		if _, err := io.ReadFull(conn, name); err != nil {
+			return "", err
+		}
+		host = string(name)
+	default:
+		_ = socksReply(conn, socksReplyAtypUnsupported)
+		return "", fmt.Errorf("unsupported socks address type %d", req[3])
+	}
+
+	var port [2]byte
+	if _, err := io.ReadFull(conn, port[:]); err != nil {
+		return "", err
+	}
+	return net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port[:])))), nil
+}
+
+// socksReply sends a reply with an all-zero IPv4 bind address; clients do not
+// need the real one for CONNECT.
+func socksReply(conn net.Conn, code byte) error {
+	_, err := conn.Write([]byte{socksVersion5, code, 0x00, socksAtypIPv4, 0, 0, 0, 0, 0, 0})
+	return err
+}
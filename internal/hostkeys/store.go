@@ -0,0 +1,335 @@
+package hostkeys
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+/*
+Host key store
+
+pTerminal's own record of host keys, keyed by host UID and kept next to the
+config (hostkeys.json). Every key a host presents is recorded with first and
+last seen times. Keys can be pinned: once a host has pins, only a pinned key
+is accepted, whatever known_hosts says. Replacing a key (after a mismatch)
+retires the host's other keys, so they are rejected from then on, and is
+written to the host's audit log together with who did it.
+*/
+
+// Audit actions.
+const (
+	ActionTrust   = "trust"
+	ActionPin     = "pin"
+	ActionUnpin   = "unpin"
+	ActionReplace = "replace"
+)
+
+// maxAudit caps the audit log per host.
+const maxAudit = 100
+
+// ErrUnknownKey is returned for a fingerprint the store has not seen.
+var ErrUnknownKey = errors.New("host key not recorded")
+
+// Verdict is the store's opinion of a presented key.
+type Verdict int
+
+const (
+	// Unknown leaves the decision to known_hosts.
+	Unknown Verdict = iota
+	// Trusted keys were trusted or pinned for the host.
+	Trusted
+	// Rejected keys were replaced, or the host has pins and this is not one.
+	Rejected
+)
+
+// Key is one key a host presented.
+type Key struct {
+	Fingerprint string `json:"fingerprint"` // SHA256
+	Type        string `json:"type"`
+	Key         string `json:"key"` // authorized_keys format
+	FirstSeen   int64  `json:"firstSeen"`
+	LastSeen    int64  `json:"lastSeen"`
+	Trusted     bool   `json:"trusted,omitempty"` // accepted here, not only via known_hosts
+	Pinned      bool   `json:"pinned,omitempty"`
+	ReplacedAt  int64  `json:"replacedAt,omitempty"`
+}
+
+// Event is an audit log entry.
+type Event struct {
+	At          int64    `json:"at"`
+	Action      string   `json:"action"`
+	Fingerprint string   `json:"fingerprint"`
+	Previous    []string `json:"previous,omitempty"` // replaced fingerprints
+	By          string   `json:"by,omitempty"`
+}
+
+// Record is everything known about one host.
+type Record struct {
+	HostPort string  `json:"hostPort,omitempty"`
+	Keys     []Key   `json:"keys"`
+	Audit    []Event `json:"audit,omitempty"`
+}
+
+type file struct {
+	Version int                `json:"version"`
+	Hosts   map[string]*Record `json:"hosts"`
+}
+
+// Store is safe for concurrent use.
+type Store struct {
+	path string
+
+	mu    sync.Mutex
+	hosts map[string]*Record
+}
+
+// Open loads the store at path; a missing file is an empty store.
+func Open(path string) (*Store, error) {
+	s := &Store{path: path, hosts: map[string]*Record{}}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return s, nil
+		}
+		return nil, err
+	}
+	var f file
+	if err := json.Unmarshal(b, &f); err != nil {
+		return nil, err
+	}
+	if f.Hosts != nil {
+		s.hosts = f.Hosts
+	}
+	return s, nil
+}
+
+// Check judges key for a host. extraPins are fingerprints pinned outside the
+// store (shared team pins from the config).
+func (s *Store) Check(hostUID string, key ssh.PublicKey, extraPins []string) Verdict {
+	fp := ssh.FingerprintSHA256(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rec := s.hosts[hostUID]
+
+	pins := append([]string(nil), extraPins...)
+	var known *Key
+	if rec != nil {
+		for i := range rec.Keys {
+			k := &rec.Keys[i]
+			if k.Pinned && k.ReplacedAt == 0 {
+				pins = append(pins, k.Fingerprint)
+			}
+			if k.Fingerprint == fp {
+				known = k
+			}
+		}
+	}
+	if len(pins) > 0 {
+		for _, p := range pins {
+			if p == fp {
+				return Trusted
+			}
+		}
+		return Rejected
+	}
+	switch {
+	case known == nil:
+		return Unknown
+	case known.ReplacedAt != 0:
+		return Rejected
+	case known.Trusted:
+		return Trusted
+	}
+	return Unknown
+}
+
+// Seen records that host presented key and it was accepted.
+func (s *Store) Seen(hostUID, hostPort string, key ssh.PublicKey) error {
+	if hostUID == "" {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.recordLocked(hostUID, hostPort, key)
+	return s.saveLocked()
+}
+
+// Trust accepts key for a host from now on.
+func (s *Store) Trust(hostUID, hostPort string, key ssh.PublicKey, by string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	k := s.recordLocked(hostUID, hostPort, key)
+	k.Trusted = true
+	s.auditLocked(hostUID, Event{Action: ActionTrust, Fingerprint: k.Fingerprint, By: by})
+	return s.saveLocked()
+}
+
+// Replace accepts key for a host and retires every other key it had; the new
+// key is pinned when a retired one was.
+func (s *Store) Replace(hostUID, hostPort string, key ssh.PublicKey, by string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fp := ssh.FingerprintSHA256(key)
+	now := time.Now().Unix()
+	rec := s.hosts[hostUID]
+
+	var previous []string
+	pinned := false
+	if rec != nil {
+		for i := range rec.Keys {
+			k := &rec.Keys[i]
+			if k.Fingerprint == fp || k.ReplacedAt != 0 {
+				continue
+			}
+			previous = append(previous, k.Fingerprint)
+			pinned = pinned || k.Pinned
+			k.Pinned = false
+			k.ReplacedAt = now
+		}
+	}
+	k := s.recordLocked(hostUID, hostPort, key)
+	k.Trusted = true
+	k.ReplacedAt = 0
+	k.Pinned = k.Pinned || pinned
+	s.auditLocked(hostUID, Event{Action: ActionReplace, Fingerprint: fp, Previous: previous, By: by})
+	return s.saveLocked()
+}
+
+// SetPinned pins or unpins a recorded key.
+func (s *Store) SetPinned(hostUID, fingerprint string, pinned bool, by string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rec := s.hosts[hostUID]
+	if rec == nil {
+		return ErrUnknownKey
+	}
+	for i := range rec.Keys {
+		k := &rec.Keys[i]
+		if k.Fingerprint != fingerprint {
+			continue
+		}
+		if k.ReplacedAt != 0 && pinned {
+			return errors.New("cannot pin a replaced key")
+		}
+		if k.Pinned == pinned {
+			return nil
+		}
+		k.Pinned = pinned
+		if pinned {
+			k.Trusted = true
+		}
+		action := ActionUnpin
+		if pinned {
+			action = ActionPin
+		}
+		s.auditLocked(hostUID, Event{Action: action, Fingerprint: fingerprint, By: by})
+		return s.saveLocked()
+	}
+	return ErrUnknownKey
+}
+
+// Get returns a copy of a host's record, newest keys first.
+func (s *Store) Get(hostUID string) (Record, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rec := s.hosts[hostUID]
+	if rec == nil {
+		return Record{Keys: []Key{}}, false
+	}
+	out := Record{
+		HostPort: rec.HostPort,
+		Keys:     append([]Key(nil), rec.Keys...),
+		Audit:    append([]Event(nil), rec.Audit...),
+	}
+	sort.SliceStable(out.Keys, func(i, j int) bool { return out.Keys[i].LastSeen > out.Keys[j].LastSeen })
+	return out, true
+}
+
+// Pinned returns the fingerprints pinned for a host.
+func (s *Store) Pinned(hostUID string) []string {
+	rec, _ := s.Get(hostUID)
+	var out []string
+	for _, k := range rec.Keys {
+		if k.Pinned && k.ReplacedAt == 0 {
+			out = append(out, k.Fingerprint)
+		}
+	}
+	return out
+}
+
+// Forget drops everything recorded for a host.
+func (s *Store) Forget(hostUID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.hosts[hostUID]; !ok {
+		return nil
+	}
+	delete(s.hosts, hostUID)
+	return s.saveLocked()
+}
+
+func (s *Store) recordLocked(hostUID, hostPort string, key ssh.PublicKey) *Key {
+	rec := s.hosts[hostUID]
+	if rec == nil {
+		rec = &Record{}
+		s.hosts[hostUID] = rec
+	}
+	if hostPort != "" {
+		rec.HostPort = hostPort
+	}
+	now := time.Now().Unix()
+	fp := ssh.FingerprintSHA256(key)
+	for i := range rec.Keys {
+		if rec.Keys[i].Fingerprint == fp {
+			rec.Keys[i].LastSeen = now
+			return &rec.Keys[i]
+		}
+	}
+	rec.Keys = append(rec.Keys, Key{
+		Fingerprint: fp,
+		Type:        key.Type(),
+		Key:         strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key))),
+		FirstSeen:   now,
+		LastSeen:    now,
+	})
+	return &rec.Keys[len(rec.Keys)-1]
+}
+
+func (s *Store) auditLocked(hostUID string, ev Event) {
+	rec := s.hosts[hostUID]
+	if rec == nil {
+		return
+	}
+	ev.At = time.Now().Unix()
+	rec.Audit = append(rec.Audit, ev)
+	if len(rec.Audit) > maxAudit {
+		rec.Audit = rec.Audit[len(rec.Audit)-maxAudit:]
+	}
+}
+
+func (s *Store) saveLocked() error {
+	b, err := json.MarshalIndent(file{Version: 1, Hosts: s.hosts}, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0o700); err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	return nil
+}
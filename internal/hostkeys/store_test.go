@@ -0,0 +1,110 @@
+package hostkeys
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func testKey(t *testing.T) ssh.PublicKey {
+	t.Helper()
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestStoreTrustPinReplace(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "hostkeys.json")
+	s, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldKey, newKey := testKey(t), testKey(t)
+
+	if v := s.Check("h1", oldKey, nil); v != Unknown {
+		t.Fatalf("expected Unknown, got %v", v)
+	}
+	if err := s.Seen("h1", "example:22", oldKey); err != nil {
+		t.Fatal(err)
+	}
+	if v := s.Check("h1", oldKey, nil); v != Unknown {
+		t.Fatalf("seen keys are left to known_hosts, got %v", v)
+	}
+	if err := s.Trust("h1", "example:22", oldKey, "a@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if v := s.Check("h1", oldKey, nil); v != Trusted {
+		t.Fatalf("expected Trusted, got %v", v)
+	}
+
+	oldFP := ssh.FingerprintSHA256(oldKey)
+	if err := s.SetPinned("h1", oldFP, true, "a@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if v := s.Check("h1", newKey, nil); v != Rejected {
+		t.Fatalf("unpinned key on a pinned host: %v", v)
+	}
+
+	if err := s.Replace("h1", "example:22", newKey, "b@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if v := s.Check("h1", oldKey, nil); v != Rejected {
+		t.Fatalf("replaced key: %v", v)
+	}
+	if v := s.Check("h1", newKey, nil); v != Trusted {
+		t.Fatalf("replacement key: %v", v)
+	}
+	newFP := ssh.FingerprintSHA256(newKey)
+	if pins := s.Pinned("h1"); len(pins) != 1 || pins[0] != newFP {
+		t.Fatalf("pin not carried over: %v", pins)
+	}
+	if err := s.SetPinned("h1", oldFP, true, ""); err == nil {
+		t.Fatal("expected an error pinning a replaced key")
+	}
+
+	// The store survives a reopen, audit log included.
+	s, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec, ok := s.Get("h1")
+	if !ok || rec.HostPort != "example:22" || len(rec.Keys) != 2 {
+		t.Fatalf("unexpected record %+v", rec)
+	}
+	last := rec.Audit[len(rec.Audit)-1]
+	if last.Action != ActionReplace || last.By != "b@example.com" || len(last.Previous) != 1 || last.Previous[0] != oldFP {
+		t.Fatalf("unexpected audit event %+v", last)
+	}
+	if fi, err := os.Stat(path); err != nil || fi.Mode().Perm() != 0o600 {
+		t.Fatalf("unexpected file mode %v %v", fi, err)
+	}
+}
+
+func TestStoreExtraPins(t *testing.T) {
+	s, err := Open(filepath.Join(t.TempDir(), "hostkeys.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pinned, other := testKey(t), testKey(t)
+	pins := []string{ssh.FingerprintSHA256(pinned)}
+
+	if v := s.Check("h1", pinned, pins); v != Trusted {
+		t.Fatalf("shared pin: %v", v)
+	}
+	if v := s.Check("h1", other, pins); v != Rejected {
+		t.Fatalf("key outside the shared pins: %v", v)
+	}
+	if err := s.SetPinned("h1", pins[0], true, ""); err != ErrUnknownKey {
+		t.Fatalf("expected ErrUnknownKey, got %v", err)
+	}
+}
@@ -16,6 +16,15 @@ const (
 	HostKeyInsecure   HostKeyMode = "insecure"
 )
 
+// AgentForwardMode controls SSH agent forwarding for agent-auth hosts.
+type AgentForwardMode string
+
+const (
+	AgentForwardOff     AgentForwardMode = ""
+	AgentForwardOn      AgentForwardMode = "on"
+	AgentForwardConfirm AgentForwardMode = "confirm" // ask before each remote signing request
+)
+
 type HostRole string
 
 const (
@@ -27,11 +36,16 @@ const (
 type AuthConfig struct {
 	Method   AuthMethod `json:"method"`
 	KeyPath  string     `json:"keyPath,omitempty"`  // when method=key
+	CertPath string     `json:"certPath,omitempty"` // when method=key; default <key>-cert.pub
 	Password string     `json:"password,omitempty"` // when method=password (stored in config)
 }
 
 type HostKeyConfig struct {
 	Mode HostKeyMode `json:"mode,omitempty"` // known_hosts / insecure
+
+	// Pins are SHA256 fingerprints shared with the team; when set, only these
+	// keys are accepted.
+	Pins []string `json:"pins,omitempty"`
 }
 
 type ConnectionDriver string
@@ -88,6 +102,147 @@ type SFTPConfig struct {
 	Password string `json:"password,omitempty"`
 }
 
+type ForwardKind string
+
+const (
+	ForwardLocal   ForwardKind = "local"   // ssh -L: local listener, dialed from the remote host
+	ForwardRemote  ForwardKind = "remote"  // ssh -R: remote listener, dialed from this machine
+	ForwardDynamic ForwardKind = "dynamic" // ssh -D: local SOCKS5 proxy, dialed from the remote host
+)
+
+// PortForward is a per-host forwarding rule. Bind is where the listener lives
+// (local for local/dynamic, remote for remote); Target is where connections go.
+type PortForward struct {
+	// ID is stable per host and used by the UI to start/stop the rule.
+	ID   string      `json:"id"`
+	Kind ForwardKind `json:"kind"`
+
+	BindAddr string `json:"bindAddr,omitempty"` // defaults to 127.0.0.1 (local) / localhost (remote)
+	BindPort int    `json:"bindPort"`
+
+	// Target is unused for dynamic forwards.
+	TargetHost string `json:"targetHost,omitempty"`
+	TargetPort int    `json:"targetPort,omitempty"`
+
+	// AutoStart starts the forward whenever an SSH session to the host connects.
+	AutoStart bool `json:"autoStart,omitempty"`
+}
+
+// KeepaliveConfig tunes SSH keepalives (keepalive@openssh.com). A nil config
+// uses the defaults; after MaxMissed unanswered probes the connection is
+// treated as dead and reconnected.
+type KeepaliveConfig struct {
+	Disabled    bool `json:"disabled,omitempty"`
+	IntervalSec int  `json:"intervalSec,omitempty"` // default 30
+	MaxMissed   int  `json:"maxMissed,omitempty"`   // default 3
+}
+
+// RecordingConfig turns on asciicast recording for every terminal tab of a host.
+type RecordingConfig struct {
+	Enabled bool `json:"enabled,omitempty"`
+
+	// Input also records keystrokes. Off by default: typed passwords end up in the file.
+	Input bool `json:"input,omitempty"`
+
+	// Resize records terminal size changes.
+	Resize bool `json:"resize,omitempty"`
+}
+
+// RecordingSettings are the app-wide storage settings for recordings.
+type RecordingSettings struct {
+	// Dir overrides the storage directory (default: ~/.config/pterminal/recordings).
+	Dir string `json:"dir,omitempty"`
+
+	// MaxFileMB starts a new part file once a recording reaches this size (default 64).
+	MaxFileMB int `json:"maxFileMB,omitempty"`
+}
+
+// ScrollbackSettings persist terminal output to disk so tabs can be restored
+// after a restart.
+type ScrollbackSettings struct {
+	Enabled bool `json:"enabled,omitempty"`
+
+	// Dir overrides the storage directory (default: ~/.config/pterminal/scrollback).
+	Dir string `json:"dir,omitempty"`
+
+	// MaxKB caps the stored output per tab (default 1024).
+	MaxKB int `json:"maxKB,omitempty"`
+
+	// MaxAgeHours drops scrollback not written for this long (default 168).
+	MaxAgeHours int `json:"maxAgeHours,omitempty"`
+}
+
+// AgentSettings configure the built-in SSH agent.
+type AgentSettings struct {
+	// AddUnlockedKeys loads a key into the agent once its passphrase was
+	// entered, so later connects do not ask again.
+	AddUnlockedKeys bool `json:"addUnlockedKeys,omitempty"`
+
+	// LifetimeMin unloads added keys after this many minutes (0 = until quit).
+	LifetimeMin int `json:"lifetimeMin,omitempty"`
+
+	// Socket serves the agent on a unix socket for other local tools.
+	Socket bool `json:"socket,omitempty"`
+
+	// SocketPath overrides the socket location
+	// (default: $XDG_RUNTIME_DIR/pterminal/agent.sock).
+	SocketPath string `json:"socketPath,omitempty"`
+}
+
+// ControlSettings exposes the app's RPC on a unix socket so editors and
+// scripts can drive a running instance.
+type ControlSettings struct {
+	Enabled bool `json:"enabled,omitempty"`
+
+	// SocketPath overrides the socket location
+	// (default: $XDG_RUNTIME_DIR/pterminal/control.sock).
+	SocketPath string `json:"socketPath,omitempty"`
+}
+
+// TransferSettings configure SFTP file and directory transfers.
+type TransferSettings struct {
+	// DownloadDir is where downloads are saved (default: ~/Downloads).
+	DownloadDir string `json:"downloadDir,omitempty"`
+
+	// Concurrency is how many transfers run at once (default 2).
+	Concurrency int `json:"concurrency,omitempty"`
+
+	// Conflict is the default policy for existing files: overwrite, skip,
+	// rename or newer (default rename).
+	Conflict string `json:"conflict,omitempty"`
+}
+
+// EditorSettings configure how the inline editor saves remote files.
+type EditorSettings struct {
+	// AtomicSave writes a temporary file and renames it over the original
+	// instead of rewriting the file in place.
+	AtomicSave bool `json:"atomicSave,omitempty"`
+
+	// Backup keeps the previous content as <file>.bak.
+	Backup bool `json:"backup,omitempty"`
+}
+
+// SyncSettings configure how LAN sync finds peers beyond UDP broadcast.
+// Changes to Port and MDNS apply on the next start.
+type SyncSettings struct {
+	// Port pins the TCP sync listener (default: a random port).
+	Port int `json:"port,omitempty"`
+
+	// Peers are host:port addresses of other devices' sync ports, for
+	// teammates on another subnet or behind a VPN.
+	Peers []string `json:"peers,omitempty"`
+
+	// MDNS announces and browses _pterminal._tcp over multicast DNS.
+	MDNS bool `json:"mdns,omitempty"`
+}
+
+// CredentialSettings choose where entered passwords are remembered.
+type CredentialSettings struct {
+	// SecretService stores secrets in the desktop keyring (GNOME Keyring,
+	// KWallet) over the freedesktop.org Secret Service API.
+	SecretService bool `json:"secretService,omitempty"`
+}
+
 type Host struct {
 	ID   int    `json:"id"`
 	Name string `json:"name"`
@@ -111,6 +266,23 @@ type Host struct {
 	Auth    AuthConfig    `json:"auth"`
 	HostKey HostKeyConfig `json:"hostKey"`
 
+	// JumpHosts lists the UIDs of hosts to tunnel through (ProxyJump), outermost first.
+	// Each hop authenticates and verifies its host key with its own settings.
+	JumpHosts []string `json:"jumpHosts,omitempty"`
+
+	// ForwardAgent forwards the local SSH agent to terminal sessions. Only
+	// used with agent authentication.
+	ForwardAgent AgentForwardMode `json:"forwardAgent,omitempty"`
+
+	// Forwards are port forwarding rules that run on the host's SSH connection.
+	Forwards []PortForward `json:"forwards,omitempty"`
+
+	// Recording enables session recording for all tabs of this host.
+	Recording *RecordingConfig `json:"recording,omitempty"`
+
+	// Keepalive controls dead-connection detection for SSH sessions.
+	Keepalive *KeepaliveConfig `json:"keepalive,omitempty"`
+
 	Telecom *TelecomConfig `json:"telecom,omitempty"`
 
 	// IOShell is a legacy field kept for backward compatibility with older exported configs.
@@ -134,6 +306,7 @@ type Host struct {
 	Version   map[string]int `json:"version,omitempty"`
 	Conflict  bool           `json:"conflict,omitempty"`
 	Deleted   bool           `json:"deleted,omitempty"`
+	Sig       *Signature     `json:"sig,omitempty"`
 }
 
 type Network struct {
@@ -153,6 +326,7 @@ type Network struct {
 	Version   map[string]int `json:"version,omitempty"`
 	Conflict  bool           `json:"conflict,omitempty"`
 	Deleted   bool           `json:"deleted,omitempty"`
+	Sig       *Signature     `json:"sig,omitempty"`
 }
 
 type AppConfig struct {
@@ -161,4 +335,13 @@ type AppConfig struct {
 	Teams    []Team       `json:"teams,omitempty"`
 	Scripts  []TeamScript `json:"scripts,omitempty"`
 	Networks []Network    `json:"networks"`
+
+	Recording   *RecordingSettings  `json:"recording,omitempty"`
+	Scrollback  *ScrollbackSettings `json:"scrollback,omitempty"`
+	Credentials *CredentialSettings `json:"credentials,omitempty"`
+	Agent       *AgentSettings      `json:"agent,omitempty"`
+	Control     *ControlSettings    `json:"control,omitempty"`
+	Transfers   *TransferSettings   `json:"transfers,omitempty"`
+	Editor      *EditorSettings     `json:"editor,omitempty"`
+	Sync        *SyncSettings       `json:"sync,omitempty"`
 }
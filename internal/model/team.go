@@ -24,10 +24,21 @@ type UserProfile struct {
 	DeviceID string `json:"deviceId,omitempty"`
 }
 
+// Signature is the identity key signature of the device that last changed a
+// synced object.
+type Signature struct {
+	Key []byte `json:"key"`
+	Sig []byte `json:"sig"`
+}
+
 type TeamMember struct {
 	Email string `json:"email,omitempty"`
 	Name  string `json:"name,omitempty"`
 	Role  string `json:"role,omitempty"`
+
+	// Devices lists the identity key fingerprints of the member's devices
+	// that may hold the team key.
+	Devices []string `json:"devices,omitempty"`
 }
 
 type TeamJoinRequest struct {
@@ -38,18 +49,26 @@ type TeamJoinRequest struct {
 	RequestedAt int64  `json:"requestedAt,omitempty"`
 	ResolvedAt  int64  `json:"resolvedAt,omitempty"`
 	ResolvedBy  string `json:"resolvedBy,omitempty"`
+	// DeviceKey is the fingerprint of the requesting device.
+	DeviceKey string `json:"deviceKey,omitempty"`
 }
 
 type Team struct {
-	ID        string            `json:"id,omitempty"`
-	Name      string            `json:"name,omitempty"`
-	Members   []TeamMember      `json:"members,omitempty"`
-	Requests  []TeamJoinRequest `json:"requests,omitempty"`
-	UpdatedAt int64             `json:"updatedAt,omitempty"`
-	UpdatedBy string            `json:"updatedBy,omitempty"`
-	Version   map[string]int    `json:"version,omitempty"`
-	Conflict  bool              `json:"conflict,omitempty"`
-	Deleted   bool              `json:"deleted,omitempty"`
+	ID       string            `json:"id,omitempty"`
+	Name     string            `json:"name,omitempty"`
+	Members  []TeamMember      `json:"members,omitempty"`
+	Requests []TeamJoinRequest `json:"requests,omitempty"`
+
+	// HostCAs lists CA public keys (authorized_keys format) trusted to sign
+	// host certificates for the team's hosts.
+	HostCAs []string `json:"hostCAs,omitempty"`
+
+	UpdatedAt int64          `json:"updatedAt,omitempty"`
+	UpdatedBy string         `json:"updatedBy,omitempty"`
+	Version   map[string]int `json:"version,omitempty"`
+	Conflict  bool           `json:"conflict,omitempty"`
+	Deleted   bool           `json:"deleted,omitempty"`
+	Sig       *Signature     `json:"sig,omitempty"`
 }
 
 type TeamScript struct {
@@ -64,4 +83,5 @@ type TeamScript struct {
 	Version     map[string]int `json:"version,omitempty"`
 	Conflict    bool           `json:"conflict,omitempty"`
 	Deleted     bool           `json:"deleted,omitempty"`
+	Sig         *Signature     `json:"sig,omitempty"`
 }
@@ -0,0 +1,398 @@
+package p2p
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/argon2"
+
+	"github.com/ankouros/pterminal/internal/model"
+	"github.com/ankouros/pterminal/internal/teamrepo"
+	"github.com/ankouros/pterminal/internal/vault"
+)
+
+/*
+Team bundles
+
+A team bundle moves one team's state between devices that have no network
+path to each other. It holds what a sync with the exporting device would
+send: the team itself in the clear, the team's networks, scripts and repo
+manifest sealed with the team key, and every repo file sealed on its own.
+The exporting device signs the whole bundle with its identity key.
+
+Importing runs the bundle through the same steps as a sync from that device:
+the team change is checked against its signature and roles, the signer must
+be listed under a team member for its data to be opened, the result goes
+through MergeRemote, and files are applied like synced files (conflicts are
+written next to the local copy). The merge is done on a copy and applied
+only once the signer and this device are found in the team and the team data
+opens.
+
+A device can only open a bundle sealed with a key generation it holds. For a
+device that never synced with a member, the exporter can include the team
+key wrapped with a passphrase (Argon2id); the importing device takes it only
+when it is itself listed in the team.
+*/
+
+const (
+	bundleFormat  = "pterminal-team-bundle"
+	bundleVersion = 1
+)
+
+var (
+	// ErrBundlePassphrase means the bundle carries the team key and this
+	// device needs it, but no passphrase was given.
+	ErrBundlePassphrase = errors.New("team bundle needs its passphrase")
+	errBundleSignature  = errors.New("team bundle signature is invalid")
+	errBadPassphrase    = errors.New("wrong passphrase")
+)
+
+type bundleFile struct {
+	Format    string            `json:"format"`
+	Version   int               `json:"version"`
+	DeviceID  string            `json:"deviceId"`
+	CreatedAt int64             `json:"createdAt"`
+	Config    model.AppConfig   `json:"config"` // user and the team, no networks or scripts
+	Payload   sealedTeam        `json:"payload"`
+	Files     []bundleEntry     `json:"files,omitempty"`
+	Key       *wrappedTeamKey   `json:"key,omitempty"`
+	PublicKey ed25519.PublicKey `json:"publicKey"`
+	Signature []byte            `json:"sig,omitempty"`
+}
+
+// bundleEntry is a repo file sealed with the payload's key generation.
+// Entries follow the files of the sealed manifest, deleted ones left out, so
+// paths are not readable without the key.
+type bundleEntry struct {
+	Nonce   []byte `json:"nonce"`
+	DataB64 string `json:"dataB64"`
+}
+
+type wrappedTeamKey struct {
+	Salt    []byte `json:"salt"`
+	Time    uint32 `json:"time"`
+	Memory  uint32 `json:"memory"` // KiB
+	Threads uint8  `json:"threads"`
+	Nonce   []byte `json:"nonce"`
+	Sealed  []byte `json:"sealed"`
+}
+
+// BundleResult describes an imported team bundle.
+type BundleResult struct {
+	TeamID   string `json:"teamId"`
+	TeamName string `json:"teamName"`
+	From     string `json:"from,omitempty"` // email of the exporting user
+	Signer   string `json:"signer"`
+	Files    int    `json:"files"`
+}
+
+// ExportTeamBundle writes the team's synced state to path (a timestamped
+// file in ~/Downloads when empty) and returns the path. With a passphrase
+// the team key is included, wrapped with it.
+func (s *Service) ExportTeamBundle(teamID, path, passphrase string) (string, error) {
+	cfg := s.configSnapshot()
+	team, ok := findTeam(cfg, teamID)
+	if !ok || team.Deleted {
+		return "", errors.New("team not found")
+	}
+	if !deviceAuthorized(cfg, teamID, s.identity.Fingerprint()) {
+		return "", errors.New("this device is not listed in the team")
+	}
+	key, ok := s.teamKeys.current(teamID)
+	if !ok {
+		return "", errNoTeamKey
+	}
+
+	scoped := s.teamScopedConfig(cfg)
+	var payload teamPayload
+	for _, netw := range scoped.Networks {
+		if netw.TeamID == teamID {
+			payload.Networks = append(payload.Networks, netw)
+		}
+	}
+	for _, script := range scoped.Scripts {
+		if script.TeamID == teamID {
+			payload.Scripts = append(payload.Scripts, script)
+		}
+	}
+	teamDir, err := teamrepo.EnsureTeamDir(s.baseDir, teamID)
+	if err != nil {
+		return "", err
+	}
+	manifest, err := teamrepo.BuildManifest(teamDir, teamID)
+	if err != nil {
+		return "", err
+	}
+	payload.Manifest = &manifest
+
+	// Everything is sealed with the key read above; a rotation meanwhile
+	// would mix generations.
+	seal := func(aad string, plain []byte) ([]byte, []byte, error) {
+		gen, nonce, data, err := s.teamKeys.seal(teamID, aad, plain)
+		if err == nil && gen != key.Generation {
+			err = errors.New("team key rotated during export")
+		}
+		return nonce, data, err
+	}
+	plain, err := json.Marshal(payload)
+	if err != nil {
+		return "", err
+	}
+	nonce, data, err := seal(payloadAAD(teamID), plain)
+	if err != nil {
+		return "", err
+	}
+
+	b := bundleFile{
+		Format:    bundleFormat,
+		Version:   bundleVersion,
+		DeviceID:  s.deviceID,
+		CreatedAt: time.Now().Unix(),
+		Config:    model.AppConfig{Version: cfg.Version, User: cfg.User, Teams: []model.Team{team}},
+		Payload:   sealedTeam{TeamID: teamID, Generation: key.Generation, Nonce: nonce, Data: data},
+		PublicKey: s.identity.PublicKey(),
+	}
+	for _, entry := range manifest.Files {
+		if entry.Deleted {
+			continue
+		}
+		plain, err := s.readTeamFile(teamID, entry.Path)
+		if err != nil {
+			return "", fmt.Errorf("%s: %w", entry.Path, err)
+		}
+		nonce, data, err := seal(fileAAD(teamID, entry.Path, entry.Hash), plain)
+		if err != nil {
+			return "", err
+		}
+		b.Files = append(b.Files, bundleEntry{Nonce: nonce, DataB64: base64.StdEncoding.EncodeToString(data)})
+	}
+	if passphrase != "" {
+		grant := teamKeyGrant{TeamID: teamID, Generation: key.Generation, Key: key.Key}
+		if b.Key, err = wrapTeamKey(passphrase, grant); err != nil {
+			return "", err
+		}
+	}
+	b.Signature = s.identity.sign(bundleSigned(b))
+
+	if path == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		name := strings.Map(func(r rune) rune {
+			if r == '/' || r == os.PathSeparator || r < 0x20 || r == ' ' {
+				return '-'
+			}
+			return r
+		}, team.Name)
+		path = filepath.Join(home, "Downloads", "pterminal-team-"+name+"-"+time.Now().Format("20060102-150405")+".json")
+	}
+	out, err := json.Marshal(b)
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return "", err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, out, 0o600); err != nil {
+		return "", err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		_ = os.Remove(tmp)
+		return "", err
+	}
+	return path, nil
+}
+
+// ImportTeamBundle merges a bundle written by ExportTeamBundle. passphrase is
+// only needed when this device lacks the key the bundle is sealed with.
+func (s *Service) ImportTeamBundle(path, passphrase string) (BundleResult, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return BundleResult{}, err
+	}
+	var b bundleFile
+	if err := json.Unmarshal(raw, &b); err != nil {
+		return BundleResult{}, fmt.Errorf("invalid team bundle: %w", err)
+	}
+	if b.Format != bundleFormat || b.Version != bundleVersion {
+		return BundleResult{}, fmt.Errorf("unsupported team bundle (%q version %d)", b.Format, b.Version)
+	}
+	pub, ok := validPublicKey(b.PublicKey)
+	if !ok || !ed25519.Verify(pub, bundleSigned(b), b.Signature) {
+		return BundleResult{}, errBundleSignature
+	}
+	if len(b.Config.Teams) != 1 || b.Config.Teams[0].ID == "" || b.Payload.TeamID != b.Config.Teams[0].ID {
+		return BundleResult{}, errors.New("invalid team bundle: no team")
+	}
+	// Blocked devices and keys claiming a pinned device ID are refused as in
+	// a handshake; unknown devices are fine, the team decides about them.
+	if err := s.devices.check(b.DeviceID, pub, true); err != nil {
+		return BundleResult{}, err
+	}
+	team := b.Config.Teams[0]
+	fp := Fingerprint(pub)
+	result := BundleResult{TeamID: team.ID, TeamName: team.Name, From: b.Config.User.Email, Signer: fp}
+
+	// Everything is checked against a merged copy of the config first: an
+	// approval in the bundle is what lets this device take the key, but
+	// nothing is applied unless the signer and this device are in the team
+	// and the team data opens.
+	cur := s.configSnapshot()
+	meta := b.Config
+	meta.Networks, meta.Scripts = nil, nil
+	check, rejected, _ := s.mergeConfig(cur, meta)
+	if !deviceAuthorized(check, team.ID, fp) {
+		s.recordRejections(rejected, b.DeviceID)
+		return result, errors.New("the bundle's signer is not listed in the team")
+	}
+	if !deviceAuthorized(check, team.ID, s.identity.Fingerprint()) {
+		s.recordRejections(rejected, b.DeviceID)
+		return result, errors.New("this device is not listed in the team")
+	}
+	if !s.teamKeys.has(team.ID, b.Payload.Generation) {
+		if b.Key == nil {
+			return result, fmt.Errorf("%w (generation %d); export the bundle with a passphrase", errNoTeamKey, b.Payload.Generation)
+		}
+		if passphrase == "" {
+			return result, ErrBundlePassphrase
+		}
+		grant, err := unwrapTeamKey(b.Key, passphrase)
+		if err != nil {
+			return result, err
+		}
+		if grant.TeamID != team.ID || grant.Generation != b.Payload.Generation || !opensPayload(grant.Key, b.Payload) {
+			return result, errors.New("invalid team bundle: key does not match")
+		}
+		if _, err := s.teamKeys.add(grant.TeamID, teamKey{Generation: grant.Generation, Key: grant.Key}); err != nil {
+			return result, err
+		}
+	}
+	networks, scripts, manifests := s.openTeamData(check, []sealedTeam{b.Payload}, fp)
+	if len(manifests) != 1 {
+		return result, errors.New("team data failed to decrypt")
+	}
+
+	incoming := b.Config
+	incoming.Networks = networks
+	incoming.Scripts = scripts
+	merged, rejected, changed := s.mergeConfig(cur, incoming)
+	s.recordRejections(rejected, b.DeviceID)
+	if changed {
+		s.commitConfig(cur, merged)
+	}
+
+	teamDir, err := teamrepo.EnsureTeamDir(s.baseDir, team.ID)
+	if err != nil {
+		return result, err
+	}
+	local, err := teamrepo.BuildManifest(teamDir, team.ID)
+	if err != nil {
+		return result, err
+	}
+	remote := manifests[0]
+	_ = s.applyRemoteDeletions(local, remote)
+	wants := map[string]struct{}{}
+	for _, p := range computeWants(local, remote) {
+		wants[p] = struct{}{}
+	}
+	i := 0
+	for _, entry := range remote.Files {
+		if entry.Deleted {
+			continue
+		}
+		if i >= len(b.Files) {
+			return result, errors.New("invalid team bundle: files missing")
+		}
+		e := b.Files[i]
+		i++
+		if _, ok := wants[entry.Path]; !ok {
+			continue
+		}
+		err := s.applyTeamFile(wireMessage{
+			Type:       "file",
+			TeamID:     team.ID,
+			Path:       entry.Path,
+			Hash:       entry.Hash,
+			ModTime:    entry.ModTime,
+			Generation: b.Payload.Generation,
+			Nonce:      e.Nonce,
+			DataB64:    e.DataB64,
+		}, b.DeviceID, fp)
+		if err != nil {
+			return result, fmt.Errorf("%s: %w", entry.Path, err)
+		}
+		result.Files++
+	}
+	return result, nil
+}
+
+// bundleSigned returns the bytes a bundle's signature covers.
+func bundleSigned(b bundleFile) []byte {
+	b.Signature = nil
+	out, _ := json.Marshal(b)
+	return append([]byte("pterminal team bundle\x00"), out...)
+}
+
+func wrapTeamKey(passphrase string, grant teamKeyGrant) (*wrappedTeamKey, error) {
+	w := &wrappedTeamKey{Salt: make([]byte, 16), Time: 3, Memory: 64 * 1024, Threads: 4}
+	if _, err := rand.Read(w.Salt); err != nil {
+		return nil, err
+	}
+	aead, err := newGCM(argon2.IDKey([]byte(passphrase), w.Salt, w.Time, w.Memory, w.Threads, teamKeySize))
+	if err != nil {
+		return nil, err
+	}
+	plain, err := json.Marshal(grant)
+	if err != nil {
+		return nil, err
+	}
+	w.Nonce = make([]byte, aead.NonceSize())
+	if _, err := rand.Read(w.Nonce); err != nil {
+		return nil, err
+	}
+	w.Sealed = aead.Seal(nil, w.Nonce, plain, []byte("pterminal team bundle key"))
+	return w, nil
+}
+
+// opensPayload reports whether key decrypts st, so a bundle cannot leave a
+// wrong key behind for its team.
+func opensPayload(key []byte, st sealedTeam) bool {
+	aead, err := newGCM(key)
+	if err != nil || len(st.Nonce) != aead.NonceSize() {
+		return false
+	}
+	_, err = aead.Open(nil, st.Nonce, st.Data, []byte(payloadAAD(st.TeamID)))
+	return err == nil
+}
+
+func unwrapTeamKey(w *wrappedTeamKey, passphrase string) (teamKeyGrant, error) {
+	if !vault.ValidKDFParams(w.Salt, w.Time, w.Memory, w.Threads) {
+		return teamKeyGrant{}, errors.New("invalid team bundle: key parameters")
+	}
+	aead, err := newGCM(argon2.IDKey([]byte(passphrase), w.Salt, w.Time, w.Memory, w.Threads, teamKeySize))
+	if err != nil {
+		return teamKeyGrant{}, err
+	}
+	if len(w.Nonce) != aead.NonceSize() {
+		return teamKeyGrant{}, errors.New("invalid team bundle: key nonce")
+	}
+	plain, err := aead.Open(nil, w.Nonce, w.Sealed, []byte("pterminal team bundle key"))
+	if err != nil {
+		return teamKeyGrant{}, errBadPassphrase
+	}
+	var grant teamKeyGrant
+	if err := json.Unmarshal(plain, &grant); err != nil {
+		return teamKeyGrant{}, err
+	}
+	return grant, nil
+}
@@ -0,0 +1,105 @@
+package p2p
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ankouros/pterminal/internal/model"
+	"github.com/ankouros/pterminal/internal/teamrepo"
+)
+
+func TestTeamBundleRoundTrip(t *testing.T) {
+	admin := newTeamService(t, "admin@example.com")
+	member := newTeamService(t, "member@example.com")
+	members := []model.TeamMember{
+		{Email: "admin@example.com", Role: model.TeamRoleAdmin, Devices: []string{admin.identity.Fingerprint()}},
+		{Email: "member@example.com", Role: model.TeamRoleUser, Devices: []string{member.identity.Fingerprint()}},
+	}
+	cfg := teamConfig(admin.user, members...)
+	cfg.Scripts = []model.TeamScript{{ID: "s1", TeamID: "team-1", Scope: model.ScopeTeam, Name: "deploy", Version: map[string]int{"x": 1}}}
+	admin.SetConfig(admin.SignConfig(cfg))
+	teamDir, err := teamrepo.EnsureTeamDir(admin.baseDir, "team-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(teamDir, "runbook.md"), []byte("# runbook\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	// The member's device is listed but has never synced, so it has no key.
+	member.SetConfig(member.SignConfig(teamConfig(member.user, members...)))
+	path := filepath.Join(t.TempDir(), "bundle.json")
+	if _, err := admin.ExportTeamBundle("team-1", path, "usb-stick"); err != nil {
+		t.Fatal(err)
+	}
+	// A device outside the team is refused, and keeps its config as it was.
+	outsider := newTeamService(t, "outsider@example.com")
+	if _, err := outsider.ImportTeamBundle(path, "usb-stick"); err == nil {
+		t.Fatal("bundle imported on a device outside the team")
+	}
+	if got := outsider.configSnapshot().Teams; len(got) != 0 {
+		t.Fatalf("refused bundle merged the team: %+v", got)
+	}
+	if _, err := member.ImportTeamBundle(path, ""); !errors.Is(err, ErrBundlePassphrase) {
+		t.Fatalf("expected the passphrase to be asked for, got %v", err)
+	}
+	if _, err := member.ImportTeamBundle(path, "wrong"); err == nil {
+		t.Fatal("wrong passphrase accepted")
+	}
+	res, err := member.ImportTeamBundle(path, "usb-stick")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Files != 1 || res.Signer != admin.identity.Fingerprint() {
+		t.Fatalf("unexpected result: %+v", res)
+	}
+	if got := member.configSnapshot().Scripts; len(got) != 1 || got[0].Name != "deploy" {
+		t.Fatalf("team scripts not merged: %+v", got)
+	}
+	b, err := os.ReadFile(filepath.Join(teamrepo.TeamDir(member.baseDir, "team-1"), "runbook.md"))
+	if err != nil || string(b) != "# runbook\n" {
+		t.Fatalf("team file not applied: %q %v", b, err)
+	}
+
+	// Importing again changes nothing; the key is now held.
+	if res, err := member.ImportTeamBundle(path, ""); err != nil || res.Files != 0 {
+		t.Fatalf("re-import: %+v %v", res, err)
+	}
+
+	// Any edit breaks the signature.
+	raw, _ := os.ReadFile(path)
+	var f map[string]any
+	if err := json.Unmarshal(raw, &f); err != nil {
+		t.Fatal(err)
+	}
+	f["deviceId"] = "someone-else"
+	raw, _ = json.Marshal(f)
+	if err := os.WriteFile(path, raw, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := member.ImportTeamBundle(path, ""); !errors.Is(err, errBundleSignature) {
+		t.Fatalf("tampered bundle: %v", err)
+	}
+}
+
+func TestUnwrapTeamKeyChecksParams(t *testing.T) {
+	grant := teamKeyGrant{TeamID: "team-1", Generation: 1, Key: make([]byte, teamKeySize)}
+	for name, edit := range map[string]func(w *wrappedTeamKey){
+		"short salt":  func(w *wrappedTeamKey) { w.Salt = w.Salt[:8] },
+		"huge memory": func(w *wrappedTeamKey) { w.Memory = 4 << 20 },
+		"tiny memory": func(w *wrappedTeamKey) { w.Memory = 1 },
+		"many passes": func(w *wrappedTeamKey) { w.Time = 16 },
+	} {
+		w, err := wrapTeamKey("pw", grant)
+		if err != nil {
+			t.Fatal(err)
+		}
+		edit(w)
+		if _, err := unwrapTeamKey(w, "pw"); err == nil {
+			t.Fatalf("%s: accepted", name)
+		}
+	}
+}
@@ -0,0 +1,194 @@
+package p2p
+
+import (
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+/*
+Pinned devices
+
+devices.json records every peer this device has completed a handshake with,
+keyed by identity key fingerprint. A known key is accepted on its own; the
+shared secret (PTERMINAL_P2P_SECRET) is only needed to enroll a key seen for
+the first time, so rotating the secret does not lock out pinned devices. A
+device ID stays bound to the key it was first seen with: another key claiming
+it is rejected until the old device is forgotten.
+*/
+
+const devicesFile = "devices.json"
+
+// touchSaveInterval limits how often last-seen updates are written.
+const touchSaveInterval = time.Minute
+
+var (
+	errDeviceBlocked  = errors.New("device is blocked")
+	errDeviceIDTaken  = errors.New("device id is pinned to another key")
+	errDeviceUnknown  = errors.New("unknown device key and no valid shared secret")
+	errDeviceNotFound = errors.New("device not found")
+)
+
+// Device is a peer pinned by its identity key.
+type Device struct {
+	Fingerprint string `json:"fingerprint"`
+	PublicKey   []byte `json:"publicKey"`
+	DeviceID    string `json:"deviceId"`
+	Name        string `json:"name,omitempty"`
+	Email       string `json:"email,omitempty"`
+	Host        string `json:"host,omitempty"`
+	FirstSeen   int64  `json:"firstSeen"`
+	LastSeen    int64  `json:"lastSeen"`
+	Blocked     bool   `json:"blocked,omitempty"`
+}
+
+type devicesFileData struct {
+	Version int                `json:"version"`
+	Devices map[string]*Device `json:"devices"`
+}
+
+type deviceStore struct {
+	path string
+
+	mu      sync.Mutex
+	devices map[string]*Device
+	savedAt time.Time
+}
+
+// openDevices loads the store at path; a missing file is an empty store.
+func openDevices(path string) (*deviceStore, error) {
+	s := &deviceStore{path: path, devices: map[string]*Device{}}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return s, nil
+		}
+		return nil, err
+	}
+	var f devicesFileData
+	if err := json.Unmarshal(b, &f); err != nil {
+		return nil, err
+	}
+	if f.Devices != nil {
+		s.devices = f.Devices
+	}
+	return s, nil
+}
+
+// check reports whether deviceID may present pub. enroll says the peer
+// proved the shared secret, which admits a key not seen before.
+func (s *deviceStore) check(deviceID string, pub ed25519.PublicKey, enroll bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err := s.checkLocked(deviceID, Fingerprint(pub), enroll)
+	return err
+}
+
+func (s *deviceStore) checkLocked(deviceID, fp string, enroll bool) (*Device, error) {
+	if d := s.devices[fp]; d != nil {
+		if d.Blocked {
+			return nil, errDeviceBlocked
+		}
+		if d.DeviceID != deviceID {
+			return nil, errDeviceIDTaken
+		}
+		return d, nil
+	}
+	for _, d := range s.devices {
+		if d.DeviceID == deviceID {
+			return nil, errDeviceIDTaken
+		}
+	}
+	if !enroll {
+		return nil, errDeviceUnknown
+	}
+	return nil, nil
+}
+
+// admit checks a peer that completed a handshake and pins its key when it is
+// new.
+func (s *deviceStore) admit(deviceID string, pub ed25519.PublicKey, enroll bool, info PeerInfo) (Device, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fp := Fingerprint(pub)
+	d, err := s.checkLocked(deviceID, fp, enroll)
+	if err != nil {
+		return Device{}, err
+	}
+	now := time.Now().Unix()
+	save := d == nil || time.Since(s.savedAt) > touchSaveInterval
+	if d == nil {
+		d = &Device{Fingerprint: fp, PublicKey: pub, DeviceID: deviceID, FirstSeen: now}
+		s.devices[fp] = d
+	}
+	d.LastSeen = now
+	if info.Name != "" || info.Email != "" {
+		d.Name, d.Email = info.Name, info.Email
+	}
+	if info.Host != "" {
+		d.Host = info.Host
+	}
+	if save {
+		if err := s.saveLocked(); err != nil {
+			return Device{}, err
+		}
+	}
+	return *d, nil
+}
+
+func (s *deviceStore) list() []Device {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([]Device, 0, len(s.devices))
+	for _, d := range s.devices {
+		out = append(out, *d)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].LastSeen > out[j].LastSeen })
+	return out
+}
+
+func (s *deviceStore) forget(fp string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.devices[fp] == nil {
+		return errDeviceNotFound
+	}
+	delete(s.devices, fp)
+	return s.saveLocked()
+}
+
+func (s *deviceStore) setBlocked(fp string, blocked bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d := s.devices[fp]
+	if d == nil {
+		return errDeviceNotFound
+	}
+	d.Blocked = blocked
+	return s.saveLocked()
+}
+
+func (s *deviceStore) saveLocked() error {
+	b, err := json.MarshalIndent(devicesFileData{Version: 1, Devices: s.devices}, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0o700); err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	s.savedAt = time.Now()
+	return nil
+}
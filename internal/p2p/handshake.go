@@ -0,0 +1,252 @@
+package p2p
+
+import (
+	"bufio"
+	"crypto/ecdh"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+/*
+Sync handshake
+
+Every sync connection starts with a mutually authenticated key exchange
+(SIGMA-style, X25519 + Ed25519), before any config or file is exchanged:
+
+	initiator -> responder: deviceId, identity key, ephemeral X25519 key
+	responder -> initiator: deviceId, identity key, ephemeral key,
+	                        signature over the transcript, secret MAC
+	initiator -> responder: signature over the transcript, secret MAC
+
+Each side signs a hash of both hellos (including both ephemeral keys, so
+nothing can be replayed) with a role label, and checks the other's signature
+against the identity key it sent. The MAC proves the shared secret; it only
+matters for keys that are not pinned yet. The session keys, one per
+direction, are derived from the X25519 secret with HKDF over the transcript.
+*/
+
+const (
+	handshakeVersion  = 1
+	maxHandshakeFrame = 16 * 1024
+
+	roleInitiator = "initiator"
+	roleResponder = "responder"
+)
+
+var errBadSignature = errors.New("p2p handshake: bad signature")
+
+type handshakeMsg struct {
+	Version   int    `json:"v,omitempty"`
+	DeviceID  string `json:"deviceId,omitempty"`
+	Identity  []byte `json:"identity,omitempty"`
+	Ephemeral []byte `json:"ephemeral,omitempty"`
+	Signature []byte `json:"sig,omitempty"`
+	Auth      []byte `json:"auth,omitempty"`
+}
+
+// remotePeer is the authenticated other side of a handshake.
+type remotePeer struct {
+	DeviceID string
+	Key      ed25519.PublicKey
+	// Enroll is set when the peer proved the shared secret (or the secret
+	// is not required), which allows pinning a new key.
+	Enroll bool
+}
+
+type handshakeConfig struct {
+	id       *Identity
+	deviceID string
+	secret   []byte
+	insecure bool
+	// admit decides on the verified peer before the handshake completes.
+	admit func(remotePeer) error
+}
+
+func (hc handshakeConfig) mac(th []byte) []byte {
+	if len(hc.secret) == 0 {
+		return nil
+	}
+	m := hmac.New(sha256.New, hc.secret)
+	m.Write(th)
+	return m.Sum(nil)
+}
+
+func (hc handshakeConfig) enrolls(th, auth []byte) bool {
+	if len(hc.secret) == 0 {
+		return hc.insecure
+	}
+	return len(auth) > 0 && hmac.Equal(auth, hc.mac(th))
+}
+
+// clientHandshake runs the initiator's side on a new connection.
+func clientHandshake(rw io.ReadWriter, hc handshakeConfig) (codec, remotePeer, error) {
+	br := bufio.NewReader(rw)
+	eph, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, remotePeer{}, err
+	}
+	hello := handshakeMsg{
+		Version:   handshakeVersion,
+		DeviceID:  hc.deviceID,
+		Identity:  hc.id.PublicKey(),
+		Ephemeral: eph.PublicKey().Bytes(),
+	}
+	if err := writeFrame(rw, hello); err != nil {
+		return nil, remotePeer{}, err
+	}
+
+	var reply handshakeMsg
+	if err := readFrame(br, &reply); err != nil {
+		return nil, remotePeer{}, err
+	}
+	peer, peerEph, err := parseHello(reply)
+	if err != nil {
+		return nil, remotePeer{}, err
+	}
+	th := transcript(hello, reply, roleResponder)
+	if !ed25519.Verify(peer.Key, th, reply.Signature) {
+		return nil, remotePeer{}, errBadSignature
+	}
+	peer.Enroll = hc.enrolls(th, reply.Auth)
+	if err := hc.admit(peer); err != nil {
+		return nil, remotePeer{}, err
+	}
+
+	th = transcript(hello, reply, roleInitiator)
+	if err := writeFrame(rw, handshakeMsg{Signature: hc.id.sign(th), Auth: hc.mac(th)}); err != nil {
+		return nil, remotePeer{}, err
+	}
+	c, err := sessionCodec(br, rw, eph, peerEph, hello, reply, true)
+	return c, peer, err
+}
+
+// serverHandshake runs the responder's side on an accepted connection.
+func serverHandshake(rw io.ReadWriter, hc handshakeConfig) (codec, remotePeer, error) {
+	br := bufio.NewReader(rw)
+	var hello handshakeMsg
+	if err := readFrame(br, &hello); err != nil {
+		return nil, remotePeer{}, err
+	}
+	peer, peerEph, err := parseHello(hello)
+	if err != nil {
+		return nil, remotePeer{}, err
+	}
+	eph, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, remotePeer{}, err
+	}
+	reply := handshakeMsg{
+		Version:   handshakeVersion,
+		DeviceID:  hc.deviceID,
+		Identity:  hc.id.PublicKey(),
+		Ephemeral: eph.PublicKey().Bytes(),
+	}
+	th := transcript(hello, reply, roleResponder)
+	reply.Signature = hc.id.sign(th)
+	reply.Auth = hc.mac(th)
+	if err := writeFrame(rw, reply); err != nil {
+		return nil, remotePeer{}, err
+	}
+
+	var finish handshakeMsg
+	if err := readFrame(br, &finish); err != nil {
+		return nil, remotePeer{}, err
+	}
+	th = transcript(hello, reply, roleInitiator)
+	if !ed25519.Verify(peer.Key, th, finish.Signature) {
+		return nil, remotePeer{}, errBadSignature
+	}
+	peer.Enroll = hc.enrolls(th, finish.Auth)
+	if err := hc.admit(peer); err != nil {
+		return nil, remotePeer{}, err
+	}
+	c, err := sessionCodec(br, rw, eph, peerEph, hello, reply, false)
+	return c, peer, err
+}
+
+func parseHello(m handshakeMsg) (remotePeer, *ecdh.PublicKey, error) {
+	if m.Version != handshakeVersion {
+		return remotePeer{}, nil, fmt.Errorf("p2p handshake: unsupported version %d", m.Version)
+	}
+	if m.DeviceID == "" {
+		return remotePeer{}, nil, errors.New("p2p handshake: missing device id")
+	}
+	key, ok := validPublicKey(m.Identity)
+	if !ok {
+		return remotePeer{}, nil, errors.New("p2p handshake: invalid identity key")
+	}
+	eph, err := ecdh.X25519().NewPublicKey(m.Ephemeral)
+	if err != nil {
+		return remotePeer{}, nil, fmt.Errorf("p2p handshake: %w", err)
+	}
+	return remotePeer{DeviceID: m.DeviceID, Key: key}, eph, nil
+}
+
+// transcript hashes both hellos for the given purpose.
+func transcript(hello, reply handshakeMsg, label string) []byte {
+	h := sha256.New()
+	for _, b := range [][]byte{
+		[]byte("pterminal p2p handshake v1"),
+		[]byte(hello.DeviceID), hello.Identity, hello.Ephemeral,
+		[]byte(reply.DeviceID), reply.Identity, reply.Ephemeral,
+		[]byte(label),
+	} {
+		var n [4]byte
+		binary.BigEndian.PutUint32(n[:], uint32(len(b)))
+		h.Write(n[:])
+		h.Write(b)
+	}
+	return h.Sum(nil)
+}
+
+func sessionCodec(br *bufio.Reader, w io.Writer, eph *ecdh.PrivateKey, peerEph *ecdh.PublicKey, hello, reply handshakeMsg, initiator bool) (codec, error) {
+	shared, err := eph.ECDH(peerEph)
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]byte, 64)
+	kdf := hkdf.New(sha256.New, shared, transcript(hello, reply, "keys"), []byte("pterminal p2p session keys"))
+	if _, err := io.ReadFull(kdf, keys); err != nil {
+		return nil, err
+	}
+	toResponder, toInitiator := keys[:32], keys[32:]
+	if initiator {
+		return newSecureCodec(br, w, toResponder, toInitiator)
+	}
+	return newSecureCodec(br, w, toInitiator, toResponder)
+}
+
+func writeFrame(w io.Writer, v any) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	frame := binary.BigEndian.AppendUint32(make([]byte, 0, 4+len(b)), uint32(len(b)))
+	_, err = w.Write(append(frame, b...))
+	return err
+}
+
+func readFrame(r io.Reader, v any) error {
+	var hdr [4]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return err
+	}
+	size := binary.BigEndian.Uint32(hdr[:])
+	if size == 0 || size > maxHandshakeFrame {
+		return fmt.Errorf("invalid p2p handshake frame size: %d", size)
+	}
+	b := make([]byte, size)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
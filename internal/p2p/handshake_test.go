@@ -0,0 +1,127 @@
+package p2p
+
+import (
+	"errors"
+	"net"
+	"path/filepath"
+	"testing"
+)
+
+type testDevice struct {
+	id      string
+	ident   *Identity
+	devices *deviceStore
+	secret  []byte
+}
+
+func newTestDevice(t *testing.T, id string, secret []byte) *testDevice {
+	t.Helper()
+	dir := t.TempDir()
+	ident, err := LoadIdentity(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	devices, err := openDevices(filepath.Join(dir, devicesFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testDevice{id: id, ident: ident, devices: devices, secret: secret}
+}
+
+func (d *testDevice) config() handshakeConfig {
+	return handshakeConfig{
+		id:       d.ident,
+		deviceID: d.id,
+		secret:   d.secret,
+		admit: func(p remotePeer) error {
+			_, err := d.devices.admit(p.DeviceID, p.Key, p.Enroll, PeerInfo{})
+			return err
+		},
+	}
+}
+
+// pair runs a handshake between a and b and exchanges one message.
+func pair(a, b *testDevice) (clientErr, serverErr error) {
+	ca, cb := net.Pipe()
+	defer ca.Close()
+	defer cb.Close()
+	done := make(chan error, 1)
+	go func() {
+		c, peer, err := serverHandshake(cb, b.config())
+		if err == nil && peer.DeviceID != a.id {
+			err = errors.New("server saw the wrong device id")
+		}
+		if err == nil {
+			var msg wireMessage
+			if err = c.Decode(&msg); err == nil && msg.DeviceID != a.id {
+				err = errors.New("message garbled")
+			}
+		}
+		if err != nil {
+			_ = cb.Close()
+		}
+		done <- err
+	}()
+	c, peer, err := clientHandshake(ca, a.config())
+	if err == nil && peer.DeviceID != b.id {
+		err = errors.New("client saw the wrong device id")
+	}
+	if err == nil {
+		err = c.Encode(wireMessage{Type: "sync", DeviceID: a.id})
+	}
+	if err != nil {
+		_ = ca.Close()
+	}
+	return err, <-done
+}
+
+func TestHandshakePinsAndRejects(t *testing.T) {
+	a := newTestDevice(t, "dev-a", []byte("0123456789abcdef0123456789abcdef"))
+	b := newTestDevice(t, "dev-b", []byte("0123456789abcdef0123456789abcdef"))
+	if cerr, serr := pair(a, b); cerr != nil || serr != nil {
+		t.Fatalf("first sync: %v / %v", cerr, serr)
+	}
+	if len(a.devices.list()) != 1 || len(b.devices.list()) != 1 {
+		t.Fatal("peers were not pinned")
+	}
+
+	// Pinned devices keep syncing after the secret is rotated.
+	a.secret, b.secret = []byte("fedcba9876543210fedcba9876543210"), []byte("another secret entirely, 32 byte")
+	if cerr, serr := pair(a, b); cerr != nil || serr != nil {
+		t.Fatalf("pinned sync: %v / %v", cerr, serr)
+	}
+
+	// A new key without the secret is not enrolled.
+	c := newTestDevice(t, "dev-c", []byte("wrong secret, also thirty two by"))
+	if cerr, serr := pair(c, b); !errors.Is(cerr, errDeviceUnknown) || serr == nil {
+		t.Fatalf("unknown device without the secret was accepted: %v / %v", cerr, serr)
+	}
+
+	// Knowing the secret does not allow taking over a pinned device ID.
+	impostor := newTestDevice(t, "dev-a", b.secret)
+	if _, serr := pair(impostor, b); !errors.Is(serr, errDeviceIDTaken) {
+		t.Fatalf("expected the device id to be taken, got %v", serr)
+	}
+
+	if err := b.devices.setBlocked(Fingerprint(a.ident.PublicKey()), true); err != nil {
+		t.Fatal(err)
+	}
+	if _, serr := pair(a, b); !errors.Is(serr, errDeviceBlocked) {
+		t.Fatalf("expected the device to be blocked, got %v", serr)
+	}
+}
+
+func TestIdentityPersists(t *testing.T) {
+	dir := t.TempDir()
+	first, err := LoadIdentity(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := LoadIdentity(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.Fingerprint() != again.Fingerprint() || first.Fingerprint() == "" {
+		t.Fatalf("identity changed: %s vs %s", first.Fingerprint(), again.Fingerprint())
+	}
+}
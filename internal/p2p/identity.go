@@ -0,0 +1,112 @@
+package p2p
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/crypto/ssh"
+)
+
+/*
+Device identity
+
+Every device has an Ed25519 keypair, created on first start and kept next to
+the config (identity.key, PKCS#8 PEM, mode 0600). Hellos and sync handshakes
+are signed with it, and peers are pinned by its public key (devices.json), so
+knowing the shared secret is no longer enough to pass as another device.
+*/
+
+const identityFile = "identity.key"
+
+// Identity is this device's signing key.
+type Identity struct {
+	priv ed25519.PrivateKey
+	pub  ed25519.PublicKey
+}
+
+// LoadIdentity reads the identity in dir, creating one when there is none.
+func LoadIdentity(dir string) (*Identity, error) {
+	path := filepath.Join(dir, identityFile)
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return createIdentity(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, fmt.Errorf("%s: no PEM key", path)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	priv, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%s: not an Ed25519 key", path)
+	}
+	return &Identity{priv: priv, pub: priv.Public().(ed25519.PublicKey)}, nil
+}
+
+func createIdentity(path string) (*Identity, error) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, err
+	}
+	// O_EXCL: a second instance starting at the same time must not replace
+	// the key the first one is already using.
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+	if errors.Is(err, os.ErrExist) {
+		return LoadIdentity(filepath.Dir(path))
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = pem.Encode(f, &pem.Block{Type: "PRIVATE KEY", Bytes: der})
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		_ = os.Remove(path)
+		return nil, err
+	}
+	return &Identity{priv: priv, pub: pub}, nil
+}
+
+// PublicKey returns the identity's public key.
+func (id *Identity) PublicKey() ed25519.PublicKey { return id.pub }
+
+// Fingerprint returns the public key's SHA256 fingerprint, as ssh prints it.
+func (id *Identity) Fingerprint() string { return Fingerprint(id.pub) }
+
+func (id *Identity) sign(msg []byte) []byte { return ed25519.Sign(id.priv, msg) }
+
+// Fingerprint formats an Ed25519 public key like "SHA256:…".
+func Fingerprint(pub ed25519.PublicKey) string {
+	key, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		return ""
+	}
+	return ssh.FingerprintSHA256(key)
+}
+
+func validPublicKey(b []byte) (ed25519.PublicKey, bool) {
+	if len(b) != ed25519.PublicKeySize {
+		return nil, false
+	}
+	return ed25519.PublicKey(b), true
+}
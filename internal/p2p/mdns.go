@@ -0,0 +1,390 @@
+package p2p
+
+import (
+	"encoding/binary"
+	"errors"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+mDNS / DNS-SD
+
+With sync.mdns enabled, each device answers multicast DNS queries for
+_pterminal._tcp.local. with a service instance named after its device ID
+(PTR, SRV with the sync port, TXT with id= and fp=, and A records), and
+queries for the service itself. Answers are dial candidates: the address is
+the sender of the answer, the port comes from SRV, and who is there is only
+trusted after the sync handshake, exactly as for static peers. Only the
+parts of RFC 6762/6763 needed for that are implemented.
+*/
+
+const (
+	mdnsService  = "_pterminal._tcp.local."
+	mdnsInterval = 20 * time.Second
+	mdnsTTL      = 120
+
+	dnsTypeA   = 1
+	dnsTypePTR = 12
+	dnsTypeTXT = 16
+	dnsTypeSRV = 33
+	dnsTypeANY = 255
+
+	dnsClassIN    = 1
+	dnsCacheFlush = 0x8000
+)
+
+var mdnsGroup = &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: 5353}
+
+// mdnsRecord is a resource record, with only the fields pTerminal uses.
+type mdnsRecord struct {
+	Name   string
+	Type   uint16
+	Target string // PTR, SRV
+	Port   uint16 // SRV
+	Text   []string
+	IP     net.IP // A
+}
+
+type mdnsMessage struct {
+	Response  bool
+	Questions []mdnsRecord // Name and Type only
+	Records   []mdnsRecord // answers and additional records
+}
+
+// mdnsAnnounce describes this device's sync service.
+type mdnsAnnounce struct {
+	DeviceID    string
+	Fingerprint string
+	Host        string
+	Port        int
+	IPs         []net.IP
+}
+
+// dnsLabel makes s usable as a single DNS label.
+func dnsLabel(s string) string {
+	label := strings.Map(func(r rune) rune {
+		if r == '.' || r < 0x20 {
+			return '-'
+		}
+		return r
+	}, s)
+	if len(label) > 63 {
+		label = label[:63]
+	}
+	if label == "" {
+		label = "pterminal"
+	}
+	return label
+}
+
+func (a mdnsAnnounce) records() []mdnsRecord {
+	instance := dnsLabel(a.DeviceID) + "." + mdnsService
+	host := dnsLabel(a.Host) + ".local."
+	out := []mdnsRecord{
+		{Name: mdnsService, Type: dnsTypePTR, Target: instance},
+		{Name: instance, Type: dnsTypeSRV, Target: host, Port: uint16(a.Port)},
+		{Name: instance, Type: dnsTypeTXT, Text: []string{"id=" + a.DeviceID, "fp=" + a.Fingerprint}},
+	}
+	for _, ip := range a.IPs {
+		out = append(out, mdnsRecord{Name: host, Type: dnsTypeA, IP: ip})
+	}
+	return out
+}
+
+// mdnsFound is a peer found in an mDNS answer.
+type mdnsFound struct {
+	DeviceID string
+	Port     int
+}
+
+// parseAnnounce extracts the pTerminal services from an answer.
+func parseAnnounce(msg mdnsMessage) []mdnsFound {
+	if !msg.Response {
+		return nil
+	}
+	var out []mdnsFound
+	for _, ptr := range msg.Records {
+		if ptr.Type != dnsTypePTR || !strings.EqualFold(ptr.Name, mdnsService) {
+			continue
+		}
+		found := mdnsFound{}
+		for _, r := range msg.Records {
+			if !strings.EqualFold(r.Name, ptr.Target) {
+				continue
+			}
+			switch r.Type {
+			case dnsTypeSRV:
+				found.Port = int(r.Port)
+			case dnsTypeTXT:
+				for _, kv := range r.Text {
+					if v, ok := strings.CutPrefix(kv, "id="); ok {
+						found.DeviceID = v
+					}
+				}
+			}
+		}
+		if found.Port > 0 && found.DeviceID != "" {
+			out = append(out, found)
+		}
+	}
+	return out
+}
+
+// asksForService reports whether a query asks for the pTerminal service.
+func asksForService(msg mdnsMessage) bool {
+	if msg.Response {
+		return false
+	}
+	for _, q := range msg.Questions {
+		if strings.EqualFold(q.Name, mdnsService) && (q.Type == dnsTypePTR || q.Type == dnsTypeANY) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Service) startMDNS() error {
+	conn, err := net.ListenMulticastUDP("udp4", nil, mdnsGroup)
+	if err != nil {
+		return err
+	}
+	s.mdnsConn = conn
+	go s.mdnsReadLoop()
+	go s.mdnsQueryLoop()
+	return nil
+}
+
+func (s *Service) mdnsAnnounce() mdnsAnnounce {
+	hostname, _ := os.Hostname()
+	return mdnsAnnounce{
+		DeviceID:    s.deviceID,
+		Fingerprint: s.identity.Fingerprint(),
+		Host:        hostname,
+		Port:        s.tcpPort,
+		IPs:         localIPv4s(),
+	}
+}
+
+func (s *Service) mdnsQueryLoop() {
+	ticker := time.NewTicker(mdnsInterval)
+	defer ticker.Stop()
+
+	query := encodeMDNS(mdnsMessage{Questions: []mdnsRecord{{Name: mdnsService, Type: dnsTypePTR}}})
+	// Announce once, then keep asking; peers answer with their records.
+	_, _ = s.mdnsConn.WriteToUDP(encodeMDNS(mdnsMessage{Response: true, Records: s.mdnsAnnounce().records()}), mdnsGroup)
+	for {
+		_, _ = s.mdnsConn.WriteToUDP(query, mdnsGroup)
+		select {
+		case <-s.stopCh:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *Service) mdnsReadLoop() {
+	buf := make([]byte, 9000)
+	for {
+		n, addr, err := s.mdnsConn.ReadFromUDP(buf)
+		if err != nil {
+			select {
+			case <-s.stopCh:
+				return
+			default:
+			}
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+		msg, err := decodeMDNS(buf[:n])
+		if err != nil {
+			continue
+		}
+		if asksForService(msg) {
+			answer := encodeMDNS(mdnsMessage{Response: true, Records: s.mdnsAnnounce().records()})
+			if _, err := s.mdnsConn.WriteToUDP(answer, mdnsGroup); err != nil {
+				log.Printf("p2p: mdns answer: %v", err)
+			}
+			continue
+		}
+		for _, f := range parseAnnounce(msg) {
+			if f.DeviceID == s.deviceID {
+				continue
+			}
+			s.addCandidate(net.JoinHostPort(addr.IP.String(), strconv.Itoa(f.Port)), sourceMDNS)
+		}
+	}
+}
+
+func localIPv4s() []net.IP {
+	var out []net.IP
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return nil
+	}
+	for _, a := range addrs {
+		if ipNet, ok := a.(*net.IPNet); ok && !ipNet.IP.IsLoopback() {
+			if ip := ipNet.IP.To4(); ip != nil {
+				out = append(out, ip)
+			}
+		}
+	}
+	return out
+}
+
+func encodeMDNS(msg mdnsMessage) []byte {
+	b := make([]byte, 12, 512)
+	if msg.Response {
+		binary.BigEndian.PutUint16(b[2:], 0x8400) // response, authoritative
+	}
+	binary.BigEndian.PutUint16(b[4:], uint16(len(msg.Questions)))
+	binary.BigEndian.PutUint16(b[6:], uint16(len(msg.Records)))
+	for _, q := range msg.Questions {
+		b = appendName(b, q.Name)
+		b = binary.BigEndian.AppendUint16(b, q.Type)
+		b = binary.BigEndian.AppendUint16(b, dnsClassIN)
+	}
+	for _, r := range msg.Records {
+		var rdata []byte
+		class := uint16(dnsClassIN | dnsCacheFlush)
+		switch r.Type {
+		case dnsTypePTR:
+			rdata = appendName(nil, r.Target)
+			class = dnsClassIN // shared record
+		case dnsTypeSRV:
+			rdata = binary.BigEndian.AppendUint16(rdata, 0) // priority
+			rdata = binary.BigEndian.AppendUint16(rdata, 0) // weight
+			rdata = binary.BigEndian.AppendUint16(rdata, r.Port)
+			rdata = appendName(rdata, r.Target)
+		case dnsTypeTXT:
+			for _, t := range r.Text {
+				if len(t) > 255 {
+					t = t[:255]
+				}
+				rdata = append(append(rdata, byte(len(t))), t...)
+			}
+		case dnsTypeA:
+			rdata = r.IP.To4()
+		}
+		b = appendName(b, r.Name)
+		b = binary.BigEndian.AppendUint16(b, r.Type)
+		b = binary.BigEndian.AppendUint16(b, class)
+		b = binary.BigEndian.AppendUint32(b, mdnsTTL)
+		b = binary.BigEndian.AppendUint16(b, uint16(len(rdata)))
+		b = append(b, rdata...)
+	}
+	return b
+}
+
+func appendName(b []byte, name string) []byte {
+	for _, label := range strings.Split(strings.TrimSuffix(name, "."), ".") {
+		if label == "" {
+			continue
+		}
+		b = append(append(b, byte(len(label))), label...)
+	}
+	return append(b, 0)
+}
+
+var errShortDNS = errors.New("mdns: short message")
+
+func decodeMDNS(b []byte) (mdnsMessage, error) {
+	if len(b) < 12 {
+		return mdnsMessage{}, errShortDNS
+	}
+	msg := mdnsMessage{Response: b[2]&0x80 != 0}
+	qd := int(binary.BigEndian.Uint16(b[4:]))
+	rr := int(binary.BigEndian.Uint16(b[6:])) + int(binary.BigEndian.Uint16(b[8:])) + int(binary.BigEndian.Uint16(b[10:]))
+	off := 12
+	for i := 0; i < qd; i++ {
+		name, next, err := readName(b, off)
+		if err != nil || next+4 > len(b) {
+			return mdnsMessage{}, errShortDNS
+		}
+		msg.Questions = append(msg.Questions, mdnsRecord{Name: name, Type: binary.BigEndian.Uint16(b[next:])})
+		off = next + 4
+	}
+	for i := 0; i < rr; i++ {
+		name, next, err := readName(b, off)
+		if err != nil || next+10 > len(b) {
+			return mdnsMessage{}, errShortDNS
+		}
+		r := mdnsRecord{Name: name, Type: binary.BigEndian.Uint16(b[next:])}
+		size := int(binary.BigEndian.Uint16(b[next+8:]))
+		start := next + 10
+		if start+size > len(b) {
+			return mdnsMessage{}, errShortDNS
+		}
+		rdata := b[start : start+size]
+		switch r.Type {
+		case dnsTypePTR:
+			r.Target, _, err = readName(b, start)
+		case dnsTypeSRV:
+			if size < 7 {
+				return mdnsMessage{}, errShortDNS
+			}
+			r.Port = binary.BigEndian.Uint16(rdata[4:])
+			r.Target, _, err = readName(b, start+6)
+		case dnsTypeTXT:
+			for j := 0; j < len(rdata); {
+				n := int(rdata[j])
+				if j+1+n > len(rdata) {
+					break
+				}
+				r.Text = append(r.Text, string(rdata[j+1:j+1+n]))
+				j += 1 + n
+			}
+		case dnsTypeA:
+			if size == 4 {
+				r.IP = net.IP(append([]byte(nil), rdata...))
+			}
+		}
+		if err != nil {
+			return mdnsMessage{}, err
+		}
+		msg.Records = append(msg.Records, r)
+		off = start + size
+	}
+	return msg, nil
+}
+
+// readName reads a possibly compressed name at off and returns it with the
+// offset after it.
+func readName(b []byte, off int) (string, int, error) {
+	var labels []string
+	end := -1
+	for jumps := 0; ; {
+		if off >= len(b) {
+			return "", 0, errShortDNS
+		}
+		n := int(b[off])
+		switch {
+		case n == 0:
+			if end < 0 {
+				end = off + 1
+			}
+			return strings.Join(labels, ".") + ".", end, nil
+		case n&0xC0 == 0xC0:
+			if off+1 >= len(b) || jumps > 16 {
+				return "", 0, errShortDNS
+			}
+			if end < 0 {
+				end = off + 2
+			}
+			off = int(binary.BigEndian.Uint16(b[off:]) & 0x3FFF)
+			jumps++
+		default:
+			if off+1+n > len(b) {
+				return "", 0, errShortDNS
+			}
+			labels = append(labels, string(b[off+1:off+1+n]))
+			off += 1 + n
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package p2p
+
+import (
+	"net"
+	"testing"
+)
+
+func TestMDNSAnnounceRoundTrip(t *testing.T) {
+	a := mdnsAnnounce{
+		DeviceID:    "dev-1",
+		Fingerprint: "SHA256:abc",
+		Host:        "laptop.lan",
+		Port:        43278,
+		IPs:         []net.IP{net.IPv4(10, 8, 0, 2)},
+	}
+	msg, err := decodeMDNS(encodeMDNS(mdnsMessage{Response: true, Records: a.records()}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := parseAnnounce(msg)
+	if len(found) != 1 || found[0].DeviceID != "dev-1" || found[0].Port != 43278 {
+		t.Fatalf("unexpected announce: %+v", found)
+	}
+
+	query, err := decodeMDNS(encodeMDNS(mdnsMessage{Questions: []mdnsRecord{{Name: mdnsService, Type: dnsTypePTR}}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !asksForService(query) || parseAnnounce(query) != nil {
+		t.Fatalf("query not recognized: %+v", query)
+	}
+
+	// Other responders compress names; the PTR target here points back
+	// into the question.
+	b := []byte{0x84, 0, 0x84, 0, 0, 1, 0, 1, 0, 0, 0, 0}
+	b = appendName(b, mdnsService)
+	b = append(b, 0, dnsTypePTR, 0, dnsClassIN)
+	b = append(b, 0xC0, 12, 0, dnsTypePTR, 0, dnsClassIN, 0, 0, 0, 120, 0, 2, 0xC0, 12)
+	msg, err = decodeMDNS(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msg.Records) != 1 || msg.Records[0].Name != mdnsService || msg.Records[0].Target != mdnsService {
+		t.Fatalf("compressed names not followed: %+v", msg.Records)
+	}
+
+	// Truncated messages are rejected, not read past their end.
+	if _, err := decodeMDNS(b[:len(b)-3]); err == nil {
+		t.Fatal("truncated message decoded")
+	}
+}
@@ -2,6 +2,7 @@ package p2p
 
 import (
 	"reflect"
+	"slices"
 	"sort"
 	"strings"
 	"time"
@@ -488,6 +489,7 @@ func mergeTeams(local, remote []model.Team, changed bool) ([]model.Team, bool) {
 			case versionConcurrent:
 				merged := mergeTeamMembers(l, r)
 				l.Members = merged
+				l.HostCAs = mergeStrings(l.HostCAs, r.HostCAs)
 				l.Conflict = true
 				changed = true
 			}
@@ -599,6 +601,7 @@ func mergeNetworks(local, remote []model.Network, changed bool) ([]model.Network
 				l.UpdatedBy = r.UpdatedBy
 				l.Version = r.Version
 				l.Conflict = r.Conflict
+				l.Sig = r.Sig
 				changed = true
 			case versionConcurrent:
 				l.Conflict = true
@@ -713,7 +716,8 @@ func teamCoreEqual(a, b model.Team) bool {
 	bm := normalizeMembers(b.Members)
 	ar := normalizeRequests(a.Requests)
 	br := normalizeRequests(b.Requests)
-	return reflect.DeepEqual(am, bm) && reflect.DeepEqual(ar, br)
+	return reflect.DeepEqual(am, bm) && reflect.DeepEqual(ar, br) &&
+		stringsEqual(a.HostCAs, b.HostCAs)
 }
 
 func normalizeMembers(members []model.TeamMember) []model.TeamMember {
@@ -728,7 +732,7 @@ func normalizeMembers(members []model.TeamMember) []model.TeamMember {
 		if role != model.TeamRoleAdmin && role != model.TeamRoleUser {
 			role = model.TeamRoleUser
 		}
-		out = append(out, model.TeamMember{Email: email, Name: name, Role: role})
+		out = append(out, model.TeamMember{Email: email, Name: name, Role: role, Devices: mergeStrings(nil, m.Devices)})
 	}
 	sort.Slice(out, func(i, j int) bool {
 		if out[i].Email == out[j].Email {
@@ -739,6 +743,9 @@ func normalizeMembers(members []model.TeamMember) []model.TeamMember {
 	return out
 }
 
+// mergeTeamMembers joins the members of two concurrent versions of a team.
+// A member's devices come from the newer version (both agreeing on a tie),
+// so a device removed there does not come back from a stale copy.
 func mergeTeamMembers(a, b model.Team) []model.TeamMember {
 	byEmail := map[string]model.TeamMember{}
 	for _, m := range normalizeMembers(a.Members) {
@@ -757,6 +764,12 @@ func mergeTeamMembers(a, b model.Team) []model.TeamMember {
 			if existing.Name == "" && m.Name != "" {
 				existing.Name = m.Name
 			}
+			switch {
+			case b.UpdatedAt > a.UpdatedAt:
+				existing.Devices = m.Devices
+			case b.UpdatedAt == a.UpdatedAt:
+				existing.Devices = commonStrings(existing.Devices, m.Devices)
+			}
 			byEmail[m.Email] = existing
 			continue
 		}
@@ -886,13 +899,72 @@ func hostCoreEqual(a, b model.Host) bool {
 		a.User == b.User &&
 		a.Driver == b.Driver &&
 		a.Auth == b.Auth &&
-		a.HostKey == b.HostKey &&
+		a.HostKey.Mode == b.HostKey.Mode &&
+		stringsEqual(a.HostKey.Pins, b.HostKey.Pins) &&
+		a.ForwardAgent == b.ForwardAgent &&
+		stringsEqual(a.JumpHosts, b.JumpHosts) &&
+		forwardsEqual(a.Forwards, b.Forwards) &&
 		a.Scope == b.Scope &&
 		a.TeamID == b.TeamID &&
+		reflect.DeepEqual(a.Recording, b.Recording) &&
+		reflect.DeepEqual(a.Keepalive, b.Keepalive) &&
 		reflect.DeepEqual(a.Telecom, b.Telecom) &&
 		reflect.DeepEqual(a.SFTP, b.SFTP)
 }
 
+// stringsEqual treats nil and empty slices as equal so UI round-trips do not
+// register as edits.
+func stringsEqual(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// mergeStrings returns a followed by the entries of b it does not contain.
+// commonStrings returns the strings of a that are also in b.
+func commonStrings(a, b []string) []string {
+	out := []string{}
+	for _, s := range a {
+		if slices.Contains(b, s) {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+func mergeStrings(a, b []string) []string {
+	seen := make(map[string]struct{}, len(a)+len(b))
+	out := make([]string, 0, len(a)+len(b))
+	for _, list := range [][]string{a, b} {
+		for _, s := range list {
+			if _, ok := seen[s]; ok {
+				continue
+			}
+			seen[s] = struct{}{}
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+func forwardsEqual(a, b []model.PortForward) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
 func inferTeamID(hosts []model.Host) string {
 	for _, h := range hosts {
 		if h.Scope == model.ScopeTeam && h.TeamID != "" {
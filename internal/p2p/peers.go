@@ -0,0 +1,163 @@
+package p2p
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+Static and mDNS peers
+
+UDP broadcast only reaches the local subnet. Devices elsewhere (another
+subnet, a VPN) are reached by dialing addresses directly: the host:port list
+in sync.peers and the answers to mDNS queries. These are candidates, not
+peers: nothing is known about them until the sync handshake proves who holds
+the identity key at that address, after which they are tracked in s.peers
+like any broadcast peer. Addresses that keep failing are retried with a
+growing backoff.
+*/
+
+const (
+	sourceBroadcast = "broadcast"
+	sourceStatic    = "static"
+	sourceMDNS      = "mdns"
+
+	// mDNS answers are dropped when not repeated for this long.
+	candidateTTL = 3 * mdnsInterval
+	maxBackoff   = 5 * time.Minute
+)
+
+type candidate struct {
+	source string
+	seen   time.Time // last mDNS answer
+	fails  int
+	next   time.Time // no dial before this
+}
+
+// addCandidate records an address found by mDNS.
+func (s *Service) addCandidate(addr, source string) {
+	s.peersMu.Lock()
+	defer s.peersMu.Unlock()
+	c := s.candidates[addr]
+	if c == nil {
+		c = &candidate{}
+		s.candidates[addr] = c
+	}
+	c.source = source
+	c.seen = time.Now()
+}
+
+// dueCandidates returns the static and mDNS addresses to dial now, leaving
+// out those of peers already synced through another address.
+func (s *Service) dueCandidates() map[string]string {
+	cfg := s.configSnapshot()
+	now := time.Now()
+
+	s.peersMu.Lock()
+	defer s.peersMu.Unlock()
+
+	static := map[string]struct{}{}
+	if cfg.Sync != nil {
+		for _, addr := range cfg.Sync.Peers {
+			addr = strings.TrimSpace(addr)
+			if addr == "" {
+				continue
+			}
+			static[addr] = struct{}{}
+			if s.candidates[addr] == nil {
+				s.candidates[addr] = &candidate{}
+			}
+			s.candidates[addr].source = sourceStatic
+		}
+	}
+
+	known := map[string]struct{}{}
+	for _, peer := range s.peers {
+		if now.Sub(peer.lastSeen) <= peerTTL && peer.info.TCPPort != 0 {
+			known[net.JoinHostPort(peer.info.Addr, strconv.Itoa(peer.info.TCPPort))] = struct{}{}
+		}
+	}
+
+	due := map[string]string{}
+	for addr, c := range s.candidates {
+		_, isStatic := static[addr]
+		if !isStatic && (c.source == sourceStatic || now.Sub(c.seen) > candidateTTL) {
+			// Removed from the settings, or no longer announced.
+			delete(s.candidates, addr)
+			continue
+		}
+		if _, ok := known[addr]; ok || now.Before(c.next) {
+			continue
+		}
+		due[addr] = c.source
+	}
+	return due
+}
+
+// syncCandidates dials the due static and mDNS addresses. A device that
+// answers becomes a peer, found through that source.
+func (s *Service) syncCandidates() {
+	for addr, source := range s.dueCandidates() {
+		verified, remote, err := s.syncAddr(addr, nil)
+		s.candidateResult(addr, err == nil)
+		if err != nil {
+			continue
+		}
+		host, port, _ := net.SplitHostPort(addr)
+		tcpPort, _ := strconv.Atoi(port)
+
+		s.peersMu.Lock()
+		fp := Fingerprint(verified.Key)
+		peer := s.peers[fp]
+		if peer == nil {
+			peer = &peerState{}
+			s.peers[fp] = peer
+		}
+		peer.key = verified.Key
+		peer.info = PeerInfo{
+			DeviceID:    verified.DeviceID,
+			Fingerprint: fp,
+			Name:        remote.User.Name,
+			Email:       remote.User.Email,
+			Addr:        host,
+			TCPPort:     tcpPort,
+			Teams:       remote.Teams,
+			Source:      source,
+		}
+		peer.lastSeen = time.Now()
+		peer.lastSync = time.Now()
+		s.peersMu.Unlock()
+	}
+}
+
+func (s *Service) candidateResult(addr string, ok bool) {
+	s.peersMu.Lock()
+	defer s.peersMu.Unlock()
+	c := s.candidates[addr]
+	if c == nil {
+		return
+	}
+	if ok {
+		c.fails = 0
+		c.next = time.Time{}
+		return
+	}
+	c.fails++
+	wait := maxBackoff
+	if c.fails < 10 {
+		wait = min(syncInterval<<c.fails, maxBackoff)
+	}
+	c.next = time.Now().Add(wait)
+}
+
+// touchPeer keeps a peer fresh after a sync; peers found without broadcast
+// send no hellos.
+func (s *Service) touchPeer(fingerprint string) {
+	s.peersMu.Lock()
+	defer s.peersMu.Unlock()
+	if peer := s.peers[fingerprint]; peer != nil {
+		peer.lastSeen = time.Now()
+	}
+}
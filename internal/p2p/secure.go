@@ -4,6 +4,7 @@ import (
 	"bufio"
 	"crypto/aes"
 	"crypto/cipher"
+	"crypto/ed25519"
 	"crypto/hmac"
 	"crypto/rand"
 	"crypto/sha256"
@@ -34,18 +35,13 @@ type codec interface {
 	Decode(v any) error
 }
 
-type jsonCodec struct {
-	enc *json.Encoder
-	dec *json.Decoder
-}
-
-func (c *jsonCodec) Encode(v any) error { return c.enc.Encode(v) }
-func (c *jsonCodec) Decode(v any) error { return c.dec.Decode(v) }
-
+// secureCodec seals JSON messages with a session's AES-256-GCM keys, one per
+// direction.
 type secureCodec struct {
 	reader *bufio.Reader
 	writer io.Writer
-	gcm    cipher.AEAD
+	send   cipher.AEAD
+	recv   cipher.AEAD
 	mu     sync.Mutex
 }
 
@@ -58,7 +54,7 @@ func (c *secureCodec) Encode(v any) error {
 	if _, err := rand.Read(nonce); err != nil {
 		return err
 	}
-	ciphertext := c.gcm.Seal(nil, nonce, payload, nil)
+	ciphertext := c.send.Seal(nil, nonce, payload, nil)
 	frame := append(nonce, ciphertext...)
 	if len(frame) > maxFrameBytes {
 		return fmt.Errorf("p2p frame too large: %d bytes", len(frame))
@@ -96,7 +92,7 @@ func (c *secureCodec) Decode(v any) error {
 
 	nonce := frame[:nonceSize]
 	ciphertext := frame[nonceSize:]
-	plaintext, err := c.gcm.Open(nil, nonce, ciphertext, nil)
+	plaintext, err := c.recv.Open(nil, nonce, ciphertext, nil)
 	if err != nil {
 		return err
 	}
@@ -104,29 +100,24 @@ func (c *secureCodec) Decode(v any) error {
 	return json.Unmarshal(plaintext, v)
 }
 
-func newCodec(conn io.ReadWriter, secret []byte) (codec, error) {
-	if len(secret) == 0 {
-		br := bufio.NewReader(conn)
-		return &jsonCodec{
-			enc: json.NewEncoder(conn),
-			dec: json.NewDecoder(br),
-		}, nil
-	}
-
-	block, err := aes.NewCipher(secret)
+func newSecureCodec(br *bufio.Reader, w io.Writer, sendKey, recvKey []byte) (codec, error) {
+	send, err := newGCM(sendKey)
 	if err != nil {
 		return nil, err
 	}
-	gcm, err := cipher.NewGCM(block)
+	recv, err := newGCM(recvKey)
 	if err != nil {
 		return nil, err
 	}
+	return &secureCodec{reader: br, writer: w, send: send, recv: recv}, nil
+}
 
-	return &secureCodec{
-		reader: bufio.NewReader(conn),
-		writer: conn,
-		gcm:    gcm,
-	}, nil
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
 }
 
 func loadSecret() (secret []byte, insecure bool, err error) {
@@ -141,34 +132,46 @@ func loadSecret() (secret []byte, insecure bool, err error) {
 	return sum[:], false, nil
 }
 
-func helloAuth(secret []byte, deviceID string, tcpPort int, ts int64) string {
+// helloPayload is what a hello's signature and secret MAC cover.
+func helloPayload(deviceID string, tcpPort int, ts int64, pub []byte) []byte {
+	return []byte(fmt.Sprintf("pterminal-hello|%s|%d|%d|%s", deviceID, tcpPort, ts, hex.EncodeToString(pub)))
+}
+
+func helloAuth(secret, payload []byte) string {
 	if len(secret) == 0 {
 		return ""
 	}
 	mac := hmac.New(sha256.New, secret)
-	fmt.Fprintf(mac, "%s|%d|%d", deviceID, tcpPort, ts)
+	mac.Write(payload)
 	return hex.EncodeToString(mac.Sum(nil))
 }
 
-func verifyHello(secret []byte, deviceID string, tcpPort int, ts int64, auth string, now time.Time) bool {
-	if len(secret) == 0 {
-		return true
+// verifyHello checks a hello's timestamp and identity signature. enroll
+// reports whether it also proved the shared secret (always, in insecure
+// mode), which is needed for a key that is not pinned yet.
+func verifyHello(secret []byte, insecure bool, msg helloMsg, now time.Time) (pub ed25519.PublicKey, enroll, ok bool) {
+	if msg.Timestamp == 0 {
+		return nil, false, false
 	}
-	if auth == "" || ts == 0 {
-		return false
-	}
-	delta := now.Sub(time.Unix(ts, 0))
+	delta := now.Sub(time.Unix(msg.Timestamp, 0))
 	if delta < -helloAuthWindow || delta > helloAuthWindow {
-		return false
+		return nil, false, false
 	}
-	expected := helloAuth(secret, deviceID, tcpPort, ts)
-	expectedBytes, err := hex.DecodeString(expected)
-	if err != nil {
-		return false
+	pub, valid := validPublicKey(msg.PublicKey)
+	if !valid {
+		return nil, false, false
 	}
-	incomingBytes, err := hex.DecodeString(auth)
-	if err != nil {
-		return false
+	payload := helloPayload(msg.DeviceID, msg.TCPPort, msg.Timestamp, pub)
+	if !ed25519.Verify(pub, payload, msg.Signature) {
+		return nil, false, false
+	}
+	if len(secret) == 0 {
+		return pub, insecure, true
+	}
+	incoming, err := hex.DecodeString(msg.Auth)
+	if err != nil || len(incoming) == 0 {
+		return pub, false, true
 	}
-	return hmac.Equal(expectedBytes, incomingBytes)
+	expected, _ := hex.DecodeString(helloAuth(secret, payload))
+	return pub, hmac.Equal(expected, incoming), true
 }
@@ -1,6 +1,7 @@
 package p2p
 
 import (
+	"crypto/ed25519"
 	"crypto/sha256"
 	"encoding/base64"
 	"encoding/json"
@@ -33,20 +34,31 @@ type Service struct {
 	baseDir  string
 	secret   []byte
 	insecure bool
-
-	peersMu sync.Mutex
-	peers   map[string]*peerState
-
-	udpConn  *net.UDPConn
-	udpAddrs []*net.UDPAddr
-	tcpLn    net.Listener
-	tcpPort  int
-	stopCh   chan struct{}
-	onMerged func(model.AppConfig)
+	identity *Identity
+	devices  *deviceStore
+	teamKeys *teamKeyring
+
+	peersMu    sync.Mutex
+	peers      map[string]*peerState // by identity key fingerprint
+	candidates map[string]*candidate // static and mDNS addresses by host:port
+	rejected   map[string]time.Time  // last logged handshake failure per address
+
+	rejectMu   sync.Mutex
+	rejections []Rejection // most recent last
+
+	udpConn    *net.UDPConn
+	udpAddrs   []*net.UDPAddr
+	mdnsConn   *net.UDPConn
+	tcpLn      net.Listener
+	tcpPort    int
+	stopCh     chan struct{}
+	onMerged   func(model.AppConfig)
+	onRejected func(Rejection)
 }
 
 type peerState struct {
 	info     PeerInfo
+	key      ed25519.PublicKey
 	lastSeen time.Time
 	lastSync time.Time
 }
@@ -61,22 +73,28 @@ type helloMsg struct {
 	TCPPort   int           `json:"tcpPort"`
 	Teams     []TeamSummary `json:"teams,omitempty"`
 	Timestamp int64         `json:"ts,omitempty"`
+	PublicKey []byte        `json:"publicKey,omitempty"`
+	Signature []byte        `json:"sig,omitempty"`
 	Auth      string        `json:"auth,omitempty"`
 }
 
 type wireMessage struct {
-	Type      string              `json:"type"`
-	DeviceID  string              `json:"deviceId,omitempty"`
-	User      model.UserProfile   `json:"user,omitempty"`
-	Config    model.AppConfig     `json:"config,omitempty"`
-	Teams     []TeamSummary       `json:"teams,omitempty"`
-	Manifests []teamrepo.Manifest `json:"manifests,omitempty"`
-	TeamID    string              `json:"teamId,omitempty"`
-	Path      string              `json:"path,omitempty"`
-	Paths     []string            `json:"paths,omitempty"`
-	Hash      string              `json:"hash,omitempty"`
-	ModTime   int64               `json:"modTime,omitempty"`
-	DataB64   string              `json:"dataB64,omitempty"`
+	Type     string            `json:"type"`
+	DeviceID string            `json:"deviceId,omitempty"`
+	User     model.UserProfile `json:"user,omitempty"`
+	Config   model.AppConfig   `json:"config,omitempty"`
+	Teams    []TeamSummary     `json:"teams,omitempty"`
+	Sealed   []sealedTeam      `json:"sealed,omitempty"`
+	TeamKeys []teamKeyGrant    `json:"teamKeys,omitempty"`
+	TeamID   string            `json:"teamId,omitempty"`
+	Path     string            `json:"path,omitempty"`
+	Paths    []string          `json:"paths,omitempty"`
+	Hash     string            `json:"hash,omitempty"`
+	ModTime  int64             `json:"modTime,omitempty"`
+	// Files are sealed with generation Generation of the team key.
+	Generation int    `json:"generation,omitempty"`
+	Nonce      []byte `json:"nonce,omitempty"`
+	DataB64    string `json:"dataB64,omitempty"`
 }
 
 func NewService(cfg model.AppConfig, baseDir string) (*Service, error) {
@@ -92,19 +110,38 @@ func NewService(cfg model.AppConfig, baseDir string) (*Service, error) {
 		return nil, err
 	}
 	if insecure {
-		log.Printf("p2p: insecure mode enabled via %s", envP2PInsecure)
+		log.Printf("p2p: insecure mode enabled via %s: unknown devices are pinned without the shared secret", envP2PInsecure)
+	}
+	identity, err := LoadIdentity(baseDir)
+	if err != nil {
+		return nil, fmt.Errorf("device identity: %w", err)
+	}
+	devices, err := openDevices(filepath.Join(baseDir, devicesFile))
+	if err != nil {
+		return nil, fmt.Errorf("pinned devices: %w", err)
+	}
+	teamKeys, err := openTeamKeys(filepath.Join(baseDir, teamKeysFile))
+	if err != nil {
+		return nil, fmt.Errorf("team keys: %w", err)
 	}
 
 	s := &Service{
-		cfg:      cfg,
-		deviceID: cfg.User.DeviceID,
-		user:     cfg.User,
-		baseDir:  baseDir,
-		secret:   secret,
-		insecure: insecure,
-		peers:    make(map[string]*peerState),
-		stopCh:   make(chan struct{}),
-	}
+		cfg:        cfg,
+		deviceID:   cfg.User.DeviceID,
+		user:       cfg.User,
+		baseDir:    baseDir,
+		secret:     secret,
+		insecure:   insecure,
+		identity:   identity,
+		devices:    devices,
+		teamKeys:   teamKeys,
+		peers:      make(map[string]*peerState),
+		candidates: make(map[string]*candidate),
+		rejected:   make(map[string]time.Time),
+		stopCh:     make(chan struct{}),
+	}
+
+	s.updateTeamKeys(cfg, cfg, true)
 
 	if err := s.start(); err != nil {
 		return nil, err
@@ -132,15 +169,27 @@ func (s *Service) start() error {
 	}
 	s.udpConn = udpConn
 
-	ln, err := net.Listen("tcp", ":0")
+	settings := model.SyncSettings{}
+	if s.cfg.Sync != nil {
+		settings = *s.cfg.Sync
+	}
+	ln, err := net.Listen("tcp", fmt.Sprintf(":%d", settings.Port))
 	if err != nil {
-		return err
+		_ = udpConn.Close()
+		return fmt.Errorf("sync port: %w", err)
 	}
 	s.tcpLn = ln
 	if addr, ok := ln.Addr().(*net.TCPAddr); ok {
 		s.tcpPort = addr.Port
 	}
 
+	if settings.MDNS {
+		// Broadcast and static peers still work without multicast.
+		if err := s.startMDNS(); err != nil {
+			log.Printf("p2p: mdns: %v", err)
+		}
+	}
+
 	go s.announceLoop()
 	go s.listenLoop()
 	go s.syncLoop()
@@ -157,19 +206,51 @@ func (s *Service) Close() {
 	if s.tcpLn != nil {
 		_ = s.tcpLn.Close()
 	}
+	if s.mdnsConn != nil {
+		_ = s.mdnsConn.Close()
+	}
 }
 
+// SetConfig applies a config saved on this device. Team keys are created or
+// rotated to match its membership changes. Signing is left to SignConfig,
+// which callers apply to local edits before saving them.
 func (s *Service) SetConfig(cfg model.AppConfig) {
+	prev := s.setConfig(cfg)
+	s.updateTeamKeys(prev, cfg, true)
+}
+
+// RegisterDevice lists this device under the local user in the teams they
+// administer that have no admin device yet, so changes to those teams can be
+// checked from then on. It is applied to configs edited on this device,
+// before their versions are bumped.
+func (s *Service) RegisterDevice(cfg model.AppConfig) model.AppConfig {
+	return registerAdminDevice(cfg, cfg.User.Email, s.identity.Fingerprint())
+}
+
+// SignConfig signs the team objects changed on this device, so the config
+// can be saved as it is synced.
+func (s *Service) SignConfig(cfg model.AppConfig) model.AppConfig {
+	return s.identity.signConfig(s.configSnapshot(), cfg)
+}
+
+func (s *Service) setConfig(cfg model.AppConfig) model.AppConfig {
 	s.cfgMu.Lock()
+	defer s.cfgMu.Unlock()
+	prev := s.cfg
 	s.cfg = cfg
 	s.user = cfg.User
-	s.cfgMu.Unlock()
+	return prev
 }
 
 func (s *Service) SetOnMerged(fn func(model.AppConfig)) {
 	s.onMerged = fn
 }
 
+// SetOnRejected sets a callback for remote changes that were not merged.
+func (s *Service) SetOnRejected(fn func(Rejection)) {
+	s.onRejected = fn
+}
+
 func (s *Service) Peers() []PeerInfo {
 	s.peersMu.Lock()
 	defer s.peersMu.Unlock()
@@ -184,7 +265,43 @@ func (s *Service) Peers() []PeerInfo {
 }
 
 func (s *Service) Presence() PresenceSnapshot {
-	return PresenceSnapshot{Peers: s.Peers(), User: s.user}
+	return PresenceSnapshot{
+		Peers:       s.Peers(),
+		User:        s.user,
+		Fingerprint: s.identity.Fingerprint(),
+		TeamKeys:    s.teamKeys.generations(),
+	}
+}
+
+// Devices lists the pinned peer devices.
+func (s *Service) Devices() []Device {
+	return s.devices.list()
+}
+
+// ForgetDevice unpins a device; it has to prove the shared secret again.
+func (s *Service) ForgetDevice(fingerprint string) error {
+	if err := s.devices.forget(fingerprint); err != nil {
+		return err
+	}
+	s.dropPeer(fingerprint)
+	return nil
+}
+
+// BlockDevice rejects (or accepts again) a pinned device.
+func (s *Service) BlockDevice(fingerprint string, blocked bool) error {
+	if err := s.devices.setBlocked(fingerprint, blocked); err != nil {
+		return err
+	}
+	if blocked {
+		s.dropPeer(fingerprint)
+	}
+	return nil
+}
+
+func (s *Service) dropPeer(fingerprint string) {
+	s.peersMu.Lock()
+	delete(s.peers, fingerprint)
+	s.peersMu.Unlock()
 }
 
 func (s *Service) SyncNow() {
@@ -213,12 +330,9 @@ func (s *Service) sendHello() {
 	hostname, _ := os.Hostname()
 	teams := s.teamSummaries()
 
-	ts := int64(0)
-	auth := ""
-	if len(s.secret) > 0 {
-		ts = time.Now().Unix()
-		auth = helloAuth(s.secret, s.deviceID, s.tcpPort, ts)
-	}
+	ts := time.Now().Unix()
+	pub := s.identity.PublicKey()
+	payload := helloPayload(s.deviceID, s.tcpPort, ts, pub)
 
 	msg := helloMsg{
 		App:       "pterminal",
@@ -230,7 +344,9 @@ func (s *Service) sendHello() {
 		TCPPort:   s.tcpPort,
 		Teams:     teams,
 		Timestamp: ts,
-		Auth:      auth,
+		PublicKey: pub,
+		Signature: s.identity.sign(payload),
+		Auth:      helloAuth(s.secret, payload),
 	}
 	b, err := json.Marshal(msg)
 	if err != nil {
@@ -264,31 +380,40 @@ func (s *Service) listenLoop() {
 		if msg.DeviceID == "" || msg.DeviceID == s.deviceID {
 			continue
 		}
-		if !verifyHello(s.secret, msg.DeviceID, msg.TCPPort, msg.Timestamp, msg.Auth, time.Now()) {
+		pub, enroll, ok := verifyHello(s.secret, s.insecure, msg, time.Now())
+		if !ok {
+			continue
+		}
+		// Peers are pinned by key; an unknown key needs the shared secret.
+		if err := s.devices.check(msg.DeviceID, pub, enroll); err != nil {
 			continue
 		}
-		s.upsertPeer(msg, addr)
+		s.upsertPeer(msg, pub, addr)
 	}
 }
 
-func (s *Service) upsertPeer(msg helloMsg, addr *net.UDPAddr) {
+func (s *Service) upsertPeer(msg helloMsg, pub ed25519.PublicKey, addr *net.UDPAddr) {
 	s.peersMu.Lock()
 	defer s.peersMu.Unlock()
 
-	peer := s.peers[msg.DeviceID]
+	fp := Fingerprint(pub)
+	peer := s.peers[fp]
 	if peer == nil {
 		peer = &peerState{}
-		s.peers[msg.DeviceID] = peer
+		s.peers[fp] = peer
 	}
 
+	peer.key = pub
 	peer.info = PeerInfo{
-		DeviceID: msg.DeviceID,
-		Name:     msg.Name,
-		Email:    msg.Email,
-		Host:     msg.Host,
-		Addr:     addr.IP.String(),
-		TCPPort:  msg.TCPPort,
-		Teams:    msg.Teams,
+		DeviceID:    msg.DeviceID,
+		Fingerprint: fp,
+		Name:        msg.Name,
+		Email:       msg.Email,
+		Host:        msg.Host,
+		Addr:        addr.IP.String(),
+		TCPPort:     msg.TCPPort,
+		Teams:       msg.Teams,
+		Source:      sourceBroadcast,
 	}
 	peer.lastSeen = time.Now()
 }
@@ -321,6 +446,7 @@ func (s *Service) syncPeers() {
 		}
 		s.syncPeer(peer)
 	}
+	s.syncCandidates()
 }
 
 func (s *Service) snapshotPeers() []peerState {
@@ -337,61 +463,136 @@ func (s *Service) snapshotPeers() []peerState {
 
 func (s *Service) syncPeer(peer peerState) {
 	addr := net.JoinHostPort(peer.info.Addr, fmt.Sprintf("%d", peer.info.TCPPort))
+	if _, _, err := s.syncAddr(addr, peer.key); err == nil {
+		s.touchPeer(peer.info.Fingerprint)
+	}
+}
+
+// syncAddr runs a sync with the device listening on addr. When expect is
+// set, whoever answers must hold that identity key.
+func (s *Service) syncAddr(addr string, expect ed25519.PublicKey) (remotePeer, wireMessage, error) {
 	conn, err := net.DialTimeout("tcp", addr, 4*time.Second)
 	if err != nil {
-		return
+		return remotePeer{}, wireMessage{}, err
 	}
 	defer conn.Close()
 	_ = conn.SetDeadline(time.Now().Add(syncTimeout))
 
-	codec, err := newCodec(conn, s.secret)
+	codec, verified, err := clientHandshake(conn, s.handshakeConfig(func(p remotePeer) error {
+		// Whoever answers must be the device whose hello we saw.
+		if expect != nil && !p.Key.Equal(expect) {
+			return errors.New("p2p: peer answered with another identity key")
+		}
+		return nil
+	}))
 	if err != nil {
-		return
+		s.logRejected(addr, err)
+		return remotePeer{}, wireMessage{}, err
 	}
 
+	fp := Fingerprint(verified.Key)
 	cfg := s.configSnapshot()
 	manifests := s.buildManifests(cfg)
-
-	localMsg := wireMessage{
-		Type:      "sync",
-		DeviceID:  s.deviceID,
-		User:      s.user,
-		Config:    s.teamScopedConfig(cfg),
-		Teams:     s.teamSummariesFromConfig(cfg),
-		Manifests: manifests,
-	}
-	if err := codec.Encode(localMsg); err != nil {
-		return
+	if err := codec.Encode(s.syncMessage(cfg, manifests, fp)); err != nil {
+		return verified, wireMessage{}, err
 	}
 
 	var remote wireMessage
 	if err := codec.Decode(&remote); err != nil {
-		return
+		return verified, wireMessage{}, err
 	}
 	if remote.Type == "sync" {
-		s.applyRemote(remote)
-		s.syncFiles(codec, manifests, remote.Manifests, remote.DeviceID)
+		remoteManifests := s.applyRemote(remote, fp)
+		s.syncFiles(codec, manifests, remoteManifests, verified.DeviceID, fp)
 	}
+	return verified, remote, nil
 }
 
-func (s *Service) applyRemote(remote wireMessage) {
-	if remote.Config.Version == 0 {
+// handshakeConfig sets up a sync handshake; check adds conditions on the
+// peer on top of its pinned key.
+func (s *Service) handshakeConfig(check func(remotePeer) error) handshakeConfig {
+	return handshakeConfig{
+		id:       s.identity,
+		deviceID: s.deviceID,
+		secret:   s.secret,
+		insecure: s.insecure,
+		admit: func(p remotePeer) error {
+			if p.DeviceID == s.deviceID {
+				return errors.New("p2p: peer claims our device id")
+			}
+			if check != nil {
+				if err := check(p); err != nil {
+					return err
+				}
+			}
+			info := s.peerInfo(Fingerprint(p.Key))
+			_, err := s.devices.admit(p.DeviceID, p.Key, p.Enroll, info)
+			return err
+		},
+	}
+}
+
+func (s *Service) peerInfo(fingerprint string) PeerInfo {
+	s.peersMu.Lock()
+	defer s.peersMu.Unlock()
+	if peer := s.peers[fingerprint]; peer != nil {
+		return peer.info
+	}
+	return PeerInfo{}
+}
+
+// logRejected logs a failed handshake, at most once a minute per address.
+func (s *Service) logRejected(addr string, err error) {
+	s.peersMu.Lock()
+	last := s.rejected[addr]
+	if time.Since(last) < time.Minute {
+		s.peersMu.Unlock()
 		return
 	}
+	s.rejected[addr] = time.Now()
+	s.peersMu.Unlock()
+	log.Printf("p2p: handshake with %s failed: %v", addr, err)
+}
 
+// applyRemote takes the team keys a peer handed over, merges its config and
+// the team data it could open, and returns the repo manifests of those teams.
+func (s *Service) applyRemote(remote wireMessage, peerFP string) []teamrepo.Manifest {
 	local := s.configSnapshot()
-	merged, changed := MergeRemote(local, s.teamScopedConfig(remote.Config))
-	if !changed {
-		return
+	s.acceptTeamKeys(local, remote.TeamKeys, peerFP)
+	networks, scripts, manifests := s.openTeamData(local, remote.Sealed, peerFP)
+	if remote.Config.Version == 0 {
+		return manifests
 	}
 
-	s.SetConfig(merged)
+	incoming := remote.Config
+	incoming.Networks = networks
+	incoming.Scripts = scripts
+	merged, rejected, changed := s.mergeConfig(local, incoming)
+	s.recordRejections(rejected, remote.DeviceID)
+	if changed {
+		s.commitConfig(local, merged)
+	}
+	return manifests
+}
+
+// mergeConfig merges the authorized part of a remote config into a copy of
+// local; nothing is applied.
+func (s *Service) mergeConfig(local, remote model.AppConfig) (model.AppConfig, []Rejection, bool) {
+	incoming, rejected := authorizeRemote(local, s.teamScopedConfig(remote))
+	merged, changed := MergeRemote(local, incoming)
+	return merged, rejected, changed
+}
+
+// commitConfig applies a config merged from prev.
+func (s *Service) commitConfig(prev, merged model.AppConfig) {
+	s.setConfig(merged)
+	s.updateTeamKeys(prev, merged, false)
 	if s.onMerged != nil {
 		s.onMerged(merged)
 	}
 }
 
-func (s *Service) syncFiles(codec codec, local, remote []teamrepo.Manifest, remoteDevice string) {
+func (s *Service) syncFiles(codec codec, local, remote []teamrepo.Manifest, remoteDevice, peerFP string) {
 	localMap := manifestMap(local)
 	remoteMap := manifestMap(remote)
 	localFileMaps := manifestFileMaps(local)
@@ -440,7 +641,7 @@ func (s *Service) syncFiles(codec codec, local, remote []teamrepo.Manifest, remo
 					wantClosed = true
 				}
 			case "file":
-				_ = s.applyTeamFile(msg, remoteDevice)
+				_ = s.applyTeamFile(msg, remoteDevice, peerFP)
 			case "file_done":
 				return
 			}
@@ -469,17 +670,26 @@ func (s *Service) syncFiles(codec codec, local, remote []teamrepo.Manifest, remo
 			if !ok || entry.Deleted {
 				continue
 			}
+			if !deviceAuthorized(s.configSnapshot(), req.teamID, peerFP) {
+				continue
+			}
 			data, err := s.readTeamFile(req.teamID, req.path)
 			if err != nil {
 				continue
 			}
+			gen, nonce, sealed, err := s.teamKeys.seal(req.teamID, fileAAD(req.teamID, req.path, entry.Hash), data)
+			if err != nil {
+				continue
+			}
 			msg := wireMessage{
-				Type:    "file",
-				TeamID:  req.teamID,
-				Path:    req.path,
-				Hash:    entry.Hash,
-				ModTime: entry.ModTime,
-				DataB64: base64.StdEncoding.EncodeToString(data),
+				Type:       "file",
+				TeamID:     req.teamID,
+				Path:       req.path,
+				Hash:       entry.Hash,
+				ModTime:    entry.ModTime,
+				Generation: gen,
+				Nonce:      nonce,
+				DataB64:    base64.StdEncoding.EncodeToString(sealed),
 			}
 			send(msg)
 		}
@@ -490,10 +700,21 @@ func (s *Service) syncFiles(codec codec, local, remote []teamrepo.Manifest, remo
 	<-fileDone
 }
 
-func (s *Service) applyTeamFile(msg wireMessage, remoteDevice string) error {
+func (s *Service) applyTeamFile(msg wireMessage, remoteDevice, peerFP string) error {
 	if msg.TeamID == "" {
 		return errors.New("missing team id")
 	}
+	if !deviceAuthorized(s.configSnapshot(), msg.TeamID, peerFP) {
+		return errors.New("peer is not a member of the team")
+	}
+	sealed, err := base64.StdEncoding.DecodeString(msg.DataB64)
+	if err != nil {
+		return err
+	}
+	data, err := s.teamKeys.open(msg.TeamID, msg.Generation, fileAAD(msg.TeamID, msg.Path, msg.Hash), msg.Nonce, sealed)
+	if err != nil {
+		return err
+	}
 	fullPath, err := s.teamFilePath(msg.TeamID, msg.Path)
 	if err != nil {
 		return err
@@ -512,11 +733,6 @@ func (s *Service) applyTeamFile(msg wireMessage, remoteDevice string) error {
 		fullPath = fullPath + ".conflict-" + remoteDevice + "-" + suffix
 	}
 
-	data, err := base64.StdEncoding.DecodeString(msg.DataB64)
-	if err != nil {
-		return err
-	}
-
 	if err := os.MkdirAll(filepath.Dir(fullPath), 0o700); err != nil {
 		return err
 	}
@@ -574,6 +790,8 @@ func (s *Service) buildManifests(cfg model.AppConfig) []teamrepo.Manifest {
 
 func (s *Service) teamScopedConfig(cfg model.AppConfig) model.AppConfig {
 	out := cfg
+	// Peer addresses are this device's own and stay off the wire.
+	out.Sync = nil
 	out.Networks = nil
 	for _, netw := range cfg.Networks {
 		if netw.TeamID == "" || netw.Deleted {
@@ -786,22 +1004,16 @@ func (s *Service) handleConn(conn net.Conn) {
 	defer conn.Close()
 	_ = conn.SetDeadline(time.Now().Add(syncTimeout))
 
-	codec, err := newCodec(conn, s.secret)
+	codec, verified, err := serverHandshake(conn, s.handshakeConfig(nil))
 	if err != nil {
+		s.logRejected(conn.RemoteAddr().String(), err)
 		return
 	}
 
+	fp := Fingerprint(verified.Key)
 	cfg := s.configSnapshot()
 	manifests := s.buildManifests(cfg)
-	localMsg := wireMessage{
-		Type:      "sync",
-		DeviceID:  s.deviceID,
-		User:      s.user,
-		Config:    s.teamScopedConfig(cfg),
-		Teams:     s.teamSummariesFromConfig(cfg),
-		Manifests: manifests,
-	}
-	if err := codec.Encode(localMsg); err != nil {
+	if err := codec.Encode(s.syncMessage(cfg, manifests, fp)); err != nil {
 		return
 	}
 
@@ -813,6 +1025,6 @@ func (s *Service) handleConn(conn net.Conn) {
 		return
 	}
 
-	s.applyRemote(remote)
-	s.syncFiles(codec, manifests, remote.Manifests, remote.DeviceID)
+	remoteManifests := s.applyRemote(remote, fp)
+	s.syncFiles(codec, manifests, remoteManifests, verified.DeviceID, fp)
 }
@@ -0,0 +1,505 @@
+package p2p
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/json"
+	"log"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/ankouros/pterminal/internal/model"
+)
+
+/*
+Signed changes
+
+Every synced team object (teams, team scripts, team networks and their hosts)
+carries the signature of the device that last changed it, made with its
+identity key over the object's synced fields. Before a remote change is
+merged, the signature is checked and the signing device is looked up in the
+team's member list (TeamMember.Devices), as this device knows it:
+
+  - members, roles, name, host CAs, resolving join requests and deleting the
+    team need an admin; a member may remove themselves, and any device may
+    file a pending join request for itself;
+  - team scripts, networks and hosts need a member, and deleting a team
+    network needs an admin;
+  - UpdatedBy has to be the email of the member the signing device belongs
+    to.
+
+Changes that fail are left out of the merge, logged, and listed in Teams.
+Teams where no admin has a device listed yet (created before devices were
+recorded) cannot be judged by signer: their members, roles and devices only
+change on this device once an admin lists one, which happens when an admin
+saves the config on their own device. Other changes to such teams are taken
+as before.
+*/
+
+const (
+	kindTeam    = "team"
+	kindScript  = "script"
+	kindNetwork = "network"
+	kindHost    = "host"
+)
+
+// Rejection is a remote change that was left out of a merge.
+type Rejection struct {
+	At     int64  `json:"at"`
+	Peer   string `json:"peer,omitempty"`
+	Kind   string `json:"kind"`
+	ID     string `json:"id"`
+	Name   string `json:"name,omitempty"`
+	TeamID string `json:"teamId,omitempty"`
+	Signer string `json:"signer,omitempty"`
+	Reason string `json:"reason"`
+}
+
+func changeBytes(kind string, v any) []byte {
+	b, _ := json.Marshal(v)
+	return append([]byte("pterminal change v1\x00"+kind+"\x00"), b...)
+}
+
+// The signed form of each object leaves out what is local to a device:
+// numeric IDs, conflict flags, and the order of members and requests.
+
+func teamSigned(t model.Team) []byte {
+	t.Members = normalizeMembers(t.Members)
+	t.Requests = normalizeRequests(t.Requests)
+	t.Conflict, t.Sig = false, nil
+	return changeBytes(kindTeam, t)
+}
+
+func scriptSigned(s model.TeamScript) []byte {
+	s.Conflict, s.Sig = false, nil
+	return changeBytes(kindScript, s)
+}
+
+func networkSigned(n model.Network) []byte {
+	n.ID, n.Hosts = 0, nil
+	n.Conflict, n.Sig = false, nil
+	return changeBytes(kindNetwork, n)
+}
+
+func hostSigned(h model.Host) []byte {
+	h.ID = 0
+	h.Conflict, h.Sig = false, nil
+	return changeBytes(kindHost, h)
+}
+
+// changeSigner returns the fingerprint of the key that signed msg.
+func changeSigner(sig *model.Signature, msg []byte) (string, bool) {
+	if sig == nil {
+		return "", false
+	}
+	pub, ok := validPublicKey(sig.Key)
+	if !ok || !ed25519.Verify(pub, msg, sig.Sig) {
+		return "", false
+	}
+	return Fingerprint(pub), true
+}
+
+func (id *Identity) signChange(sig *model.Signature, msg []byte) *model.Signature {
+	if _, ok := changeSigner(sig, msg); ok {
+		return sig
+	}
+	return &model.Signature{Key: id.pub, Sig: id.sign(msg)}
+}
+
+// signConfig signs the team objects in cfg that were changed on this device:
+// those that differ from prev and carry no valid signature. Objects as they
+// arrived from peers keep their signatures, or lack of one. Signatures are
+// deterministic, so signing the same config twice gives the same result.
+func (id *Identity) signConfig(prev, cfg model.AppConfig) model.AppConfig {
+	before := map[string][]byte{}
+	for _, t := range prev.Teams {
+		before[kindTeam+t.ID] = teamSigned(t)
+	}
+	for _, s := range prev.Scripts {
+		before[kindScript+s.ID] = scriptSigned(s)
+	}
+	for _, n := range prev.Networks {
+		before[kindNetwork+n.UID] = networkSigned(n)
+		for _, h := range n.Hosts {
+			before[kindHost+h.UID] = hostSigned(h)
+		}
+	}
+	sign := func(sig *model.Signature, key string, msg []byte) *model.Signature {
+		if b, ok := before[key]; ok && bytes.Equal(b, msg) {
+			return sig
+		}
+		return id.signChange(sig, msg)
+	}
+
+	teams := make([]model.Team, len(cfg.Teams))
+	for i, t := range cfg.Teams {
+		t.Sig = sign(t.Sig, kindTeam+t.ID, teamSigned(t))
+		teams[i] = t
+	}
+	cfg.Teams = teams
+
+	scripts := make([]model.TeamScript, len(cfg.Scripts))
+	for i, s := range cfg.Scripts {
+		if s.Scope == model.ScopeTeam && s.TeamID != "" {
+			s.Sig = sign(s.Sig, kindScript+s.ID, scriptSigned(s))
+		}
+		scripts[i] = s
+	}
+	cfg.Scripts = scripts
+
+	networks := make([]model.Network, len(cfg.Networks))
+	for i, n := range cfg.Networks {
+		if n.TeamID != "" {
+			n.Sig = sign(n.Sig, kindNetwork+n.UID, networkSigned(n))
+		}
+		hosts := make([]model.Host, len(n.Hosts))
+		for j, h := range n.Hosts {
+			if h.Scope == model.ScopeTeam && h.TeamID != "" {
+				h.Sig = sign(h.Sig, kindHost+h.UID, hostSigned(h))
+			}
+			hosts[j] = h
+		}
+		n.Hosts = hosts
+		networks[i] = n
+	}
+	cfg.Networks = networks
+	return cfg
+}
+
+// teamEnforced reports whether changes to t are checked: some admin has a
+// device listed.
+func teamEnforced(t model.Team) bool {
+	for _, m := range t.Members {
+		if m.Role == model.TeamRoleAdmin && len(m.Devices) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// registerAdminDevice lists fp under the member email in the teams where
+// that member is an admin and no admin has a device listed yet.
+func registerAdminDevice(cfg model.AppConfig, email, fp string) model.AppConfig {
+	email = strings.ToLower(strings.TrimSpace(email))
+	if email == "" || fp == "" {
+		return cfg
+	}
+	teams := make([]model.Team, len(cfg.Teams))
+	for i, t := range cfg.Teams {
+		if !t.Deleted && !teamEnforced(t) {
+			members := make([]model.TeamMember, len(t.Members))
+			for j, m := range t.Members {
+				if m.Role == model.TeamRoleAdmin && strings.EqualFold(strings.TrimSpace(m.Email), email) {
+					m.Devices = mergeStrings(m.Devices, []string{fp})
+				}
+				members[j] = m
+			}
+			t.Members = members
+		}
+		teams[i] = t
+	}
+	cfg.Teams = teams
+	return cfg
+}
+
+func memberByDevice(t model.Team, fp string) (model.TeamMember, bool) {
+	for _, m := range t.Members {
+		for _, d := range m.Devices {
+			if d == fp {
+				return m, true
+			}
+		}
+	}
+	return model.TeamMember{}, false
+}
+
+// remoteChanges reports whether merging the remote version can change the
+// local one.
+func remoteChanges(local, remote map[string]int, localAt, remoteAt int64) bool {
+	switch compareVersion(local, remote, localAt, remoteAt) {
+	case versionLess, versionConcurrent:
+		return true
+	}
+	return false
+}
+
+// authorizeRemote leaves out the changes in remote that are not signed by a
+// device allowed to make them, judged by the local config.
+func authorizeRemote(local, remote model.AppConfig) (model.AppConfig, []Rejection) {
+	var rejected []Rejection
+	reject := func(kind, id, name, teamID, signer, reason string) {
+		rejected = append(rejected, Rejection{Kind: kind, ID: id, Name: name, TeamID: teamID, Signer: signer, Reason: reason})
+	}
+
+	// authority holds the team each change is judged by: the local one, or
+	// a new team as its creator signed it.
+	authority := map[string]model.Team{}
+	for _, t := range local.Teams {
+		authority[t.ID] = t
+	}
+
+	teams := make([]model.Team, 0, len(remote.Teams))
+	for _, r := range remote.Teams {
+		l, ok := authority[r.ID]
+		if ok && !remoteChanges(l.Version, r.Version, l.UpdatedAt, r.UpdatedAt) {
+			// Nothing to take from it; join requests only travel with a
+			// newer, signed team.
+			r.Requests = l.Requests
+			teams = append(teams, r)
+			continue
+		}
+		if signer, reason := checkTeam(l, ok, r); reason != "" {
+			reject(kindTeam, r.ID, r.Name, r.ID, signer, reason)
+			continue
+		}
+		if !ok {
+			authority[r.ID] = r
+		}
+		teams = append(teams, r)
+	}
+	remote.Teams = teams
+
+	localScripts := map[string]model.TeamScript{}
+	for _, s := range local.Scripts {
+		localScripts[s.ID] = s
+	}
+	scripts := make([]model.TeamScript, 0, len(remote.Scripts))
+	for _, r := range remote.Scripts {
+		l, ok := localScripts[r.ID]
+		if r.TeamID != "" && (!ok || remoteChanges(l.Version, r.Version, l.UpdatedAt, r.UpdatedAt)) {
+			if signer, reason := checkMember(authority, teamIDs(l.TeamID, r.TeamID), r.Sig, scriptSigned(r), r.UpdatedBy, false); reason != "" {
+				reject(kindScript, r.ID, r.Name, r.TeamID, signer, reason)
+				continue
+			}
+		}
+		scripts = append(scripts, r)
+	}
+	remote.Scripts = scripts
+
+	localNetworks := map[string]model.Network{}
+	for _, n := range local.Networks {
+		localNetworks[n.UID] = n
+	}
+	networks := make([]model.Network, 0, len(remote.Networks))
+	for _, r := range remote.Networks {
+		l, ok := localNetworks[r.UID]
+		if r.TeamID != "" && (!ok || remoteChanges(l.Version, r.Version, l.UpdatedAt, r.UpdatedAt)) {
+			deleting := r.Deleted && (!ok || !l.Deleted)
+			if signer, reason := checkMember(authority, teamIDs(l.TeamID, r.TeamID), r.Sig, networkSigned(r), r.UpdatedBy, deleting); reason != "" {
+				reject(kindNetwork, r.UID, r.Name, r.TeamID, signer, reason)
+				if !ok {
+					continue
+				}
+				// Keep the local network, but still look at its hosts.
+				hosts := r.Hosts
+				r = l
+				r.Hosts = hosts
+			}
+		}
+
+		localHosts := map[string]model.Host{}
+		for _, h := range l.Hosts {
+			localHosts[h.UID] = h
+		}
+		hosts := make([]model.Host, 0, len(r.Hosts))
+		for _, h := range r.Hosts {
+			lh, ok := localHosts[h.UID]
+			if !ok || remoteChanges(lh.Version, h.Version, lh.UpdatedAt, h.UpdatedAt) {
+				teamID := h.TeamID
+				if teamID == "" {
+					teamID = r.TeamID
+				}
+				if signer, reason := checkMember(authority, teamIDs(lh.TeamID, teamID), h.Sig, hostSigned(h), h.UpdatedBy, false); reason != "" {
+					reject(kindHost, h.UID, h.Name, teamID, signer, reason)
+					continue
+				}
+			}
+			hosts = append(hosts, h)
+		}
+		r.Hosts = hosts
+		networks = append(networks, r)
+	}
+	remote.Networks = networks
+
+	return remote, rejected
+}
+
+func teamIDs(ids ...string) []string {
+	out := []string{}
+	for _, id := range ids {
+		if id != "" && (len(out) == 0 || out[0] != id) {
+			out = append(out, id)
+		}
+	}
+	return out
+}
+
+// checkTeam judges a remote team change against the local team l (exists
+// is false for a team not known yet).
+func checkTeam(l model.Team, exists bool, r model.Team) (signer, reason string) {
+	auth := l
+	if !exists {
+		auth = r
+	}
+	if !teamEnforced(auth) {
+		if exists && !reflect.DeepEqual(normalizeMembers(l.Members), normalizeMembers(r.Members)) {
+			// The one member change allowed is an admin listing the device
+			// that signed it (registerAdminDevice).
+			fp, ok := changeSigner(r.Sig, teamSigned(r))
+			if !ok || !registersAdminDevice(l, r, fp) {
+				return "", "no admin device is listed for the team yet"
+			}
+			return fp, ""
+		}
+		return "", ""
+	}
+	fp, ok := changeSigner(r.Sig, teamSigned(r))
+	if !ok {
+		return "", "missing or invalid signature"
+	}
+	m, member := memberByDevice(auth, fp)
+	if member && r.UpdatedBy != "" && !strings.EqualFold(r.UpdatedBy, m.Email) {
+		return fp, "updatedBy does not match the signing device"
+	}
+	if member && m.Role == model.TeamRoleAdmin {
+		return fp, ""
+	}
+	if !exists {
+		return fp, "team not created by one of its admins"
+	}
+
+	if strings.TrimSpace(l.Name) != strings.TrimSpace(r.Name) || l.Deleted != r.Deleted ||
+		!stringsEqual(l.HostCAs, r.HostCAs) {
+		return fp, "only a team admin can change the team"
+	}
+	members := normalizeMembers(r.Members)
+	if !reflect.DeepEqual(members, normalizeMembers(l.Members)) {
+		if !member || !reflect.DeepEqual(members, withoutMember(l.Members, m.Email)) {
+			return fp, "only a team admin can change members"
+		}
+	}
+
+	before := map[string]model.TeamJoinRequest{}
+	for _, req := range normalizeRequests(l.Requests) {
+		before[req.Email] = req
+	}
+	for _, req := range normalizeRequests(r.Requests) {
+		if prev, ok := before[req.Email]; ok && reflect.DeepEqual(prev, req) {
+			delete(before, req.Email)
+			continue
+		}
+		delete(before, req.Email)
+		if req.Status != model.TeamJoinPending || req.DeviceKey != fp {
+			return fp, "only a team admin can resolve join requests"
+		}
+	}
+	for email := range before {
+		// Requests only go away when their member leaves.
+		if !member || !strings.EqualFold(email, m.Email) {
+			return fp, "only a team admin can resolve join requests"
+		}
+	}
+	return fp, ""
+}
+
+// registersAdminDevice reports whether the only member difference between l
+// and r is fp added to the devices of one of l's admins.
+func registersAdminDevice(l, r model.Team, fp string) bool {
+	want := normalizeMembers(r.Members)
+	for _, m := range normalizeMembers(l.Members) {
+		if m.Role != model.TeamRoleAdmin || (r.UpdatedBy != "" && !strings.EqualFold(r.UpdatedBy, m.Email)) {
+			continue
+		}
+		t := registerAdminDevice(model.AppConfig{Teams: []model.Team{l}}, m.Email, fp).Teams[0]
+		if reflect.DeepEqual(normalizeMembers(t.Members), want) {
+			return true
+		}
+	}
+	return false
+}
+
+func withoutMember(members []model.TeamMember, email string) []model.TeamMember {
+	out := []model.TeamMember{}
+	for _, m := range normalizeMembers(members) {
+		if !strings.EqualFold(m.Email, email) {
+			out = append(out, m)
+		}
+	}
+	return out
+}
+
+// checkMember requires a change to be signed by a member (an admin when
+// admin is set) of each of the teams.
+func checkMember(authority map[string]model.Team, teams []string, sig *model.Signature, msg []byte, updatedBy string, admin bool) (signer, reason string) {
+	for _, teamID := range teams {
+		t, ok := authority[teamID]
+		if !ok {
+			return "", "unknown team"
+		}
+		if !teamEnforced(t) {
+			continue
+		}
+		fp, valid := changeSigner(sig, msg)
+		if !valid {
+			return "", "missing or invalid signature"
+		}
+		m, member := memberByDevice(t, fp)
+		switch {
+		case !member:
+			return fp, "signed by a device that is not a team member"
+		case updatedBy != "" && !strings.EqualFold(updatedBy, m.Email):
+			return fp, "updatedBy does not match the signing device"
+		case admin && m.Role != model.TeamRoleAdmin:
+			return fp, "only a team admin can delete team networks"
+		}
+	}
+	return "", ""
+}
+
+// maxRejections bounds the rejected changes kept for display.
+const maxRejections = 100
+
+// recordRejections logs changes that were left out of a merge. The same
+// change sent again by the same peer is only logged and reported once.
+func (s *Service) recordRejections(list []Rejection, peer string) {
+	if len(list) == 0 {
+		return
+	}
+	now := time.Now().Unix()
+	var fresh []Rejection
+	s.rejectMu.Lock()
+next:
+	for _, r := range list {
+		r.Peer, r.At = peer, now
+		for i, have := range s.rejections {
+			if have.Peer == r.Peer && have.Kind == r.Kind && have.ID == r.ID && have.Reason == r.Reason {
+				s.rejections = append(append(s.rejections[:i:i], s.rejections[i+1:]...), r)
+				continue next
+			}
+		}
+		log.Printf("p2p: rejected %s %q from %s: %s", r.Kind, r.Name, peer, r.Reason)
+		fresh = append(fresh, r)
+		s.rejections = append(s.rejections, r)
+		if len(s.rejections) > maxRejections {
+			s.rejections = s.rejections[len(s.rejections)-maxRejections:]
+		}
+	}
+	s.rejectMu.Unlock()
+	if s.onRejected != nil {
+		for _, r := range fresh {
+			s.onRejected(r)
+		}
+	}
+}
+
+// Rejections lists the remote changes that were not merged, newest first.
+func (s *Service) Rejections() []Rejection {
+	s.rejectMu.Lock()
+	defer s.rejectMu.Unlock()
+	out := make([]Rejection, len(s.rejections))
+	for i, r := range s.rejections {
+		out[len(out)-1-i] = r
+	}
+	return out
+}
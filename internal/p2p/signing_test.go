@@ -0,0 +1,168 @@
+package p2p
+
+import (
+	"testing"
+
+	"github.com/ankouros/pterminal/internal/model"
+)
+
+func TestAuthorizeRemoteEnforcesRoles(t *testing.T) {
+	newID := func() *Identity {
+		id, err := LoadIdentity(t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return id
+	}
+	admin, member, outsider := newID(), newID(), newID()
+
+	team := model.Team{
+		ID:   "team-1",
+		Name: "ops",
+		Members: []model.TeamMember{
+			{Email: "admin@example.com", Role: model.TeamRoleAdmin, Devices: []string{admin.Fingerprint()}},
+			{Email: "member@example.com", Role: model.TeamRoleUser, Devices: []string{member.Fingerprint()}},
+		},
+		Version: map[string]int{"a": 1},
+	}
+	script := model.TeamScript{ID: "s1", TeamID: "team-1", Scope: model.ScopeTeam, Name: "deploy", Version: map[string]int{"a": 1}}
+	netw := model.Network{UID: "n1", Name: "prod", TeamID: "team-1", Version: map[string]int{"a": 1}}
+	local := admin.signConfig(model.AppConfig{}, model.AppConfig{
+		Teams:    []model.Team{team},
+		Scripts:  []model.TeamScript{script},
+		Networks: []model.Network{netw},
+	})
+
+	// edit returns remote with one object changed and signed by id.
+	edit := func(id *Identity, fn func(*model.AppConfig)) model.AppConfig {
+		remote := model.AppConfig{
+			Teams:    append([]model.Team(nil), local.Teams...),
+			Scripts:  append([]model.TeamScript(nil), local.Scripts...),
+			Networks: append([]model.Network(nil), local.Networks...),
+		}
+		fn(&remote)
+		return id.signConfig(local, remote)
+	}
+	bump := map[string]int{"a": 1, "b": 1}
+
+	cases := []struct {
+		name   string
+		remote model.AppConfig
+		ok     bool
+	}{
+		{"member edits a script", edit(member, func(c *model.AppConfig) {
+			c.Scripts[0].Command, c.Scripts[0].Version, c.Scripts[0].UpdatedBy, c.Scripts[0].Sig = "make", bump, "member@example.com", nil
+		}), true},
+		{"member forges updatedBy", edit(member, func(c *model.AppConfig) {
+			c.Scripts[0].Command, c.Scripts[0].Version, c.Scripts[0].UpdatedBy, c.Scripts[0].Sig = "make", bump, "admin@example.com", nil
+		}), false},
+		{"outsider edits a script", edit(outsider, func(c *model.AppConfig) {
+			c.Scripts[0].Command, c.Scripts[0].Version, c.Scripts[0].Sig = "rm -rf /", bump, nil
+		}), false},
+		{"member renames the team", edit(member, func(c *model.AppConfig) {
+			c.Teams[0].Name, c.Teams[0].Version, c.Teams[0].Sig = "mine", bump, nil
+		}), false},
+		{"member promotes themselves", edit(member, func(c *model.AppConfig) {
+			m := append([]model.TeamMember(nil), c.Teams[0].Members...)
+			m[1].Role = model.TeamRoleAdmin
+			c.Teams[0].Members, c.Teams[0].Version, c.Teams[0].Sig = m, bump, nil
+		}), false},
+		{"member leaves", edit(member, func(c *model.AppConfig) {
+			c.Teams[0].Members, c.Teams[0].Version, c.Teams[0].Sig = c.Teams[0].Members[:1], bump, nil
+		}), true},
+		{"outsider asks to join", edit(outsider, func(c *model.AppConfig) {
+			c.Teams[0].Requests = []model.TeamJoinRequest{{ID: "r1", Email: "new@example.com", Status: model.TeamJoinPending, DeviceKey: outsider.Fingerprint()}}
+			c.Teams[0].Version, c.Teams[0].Sig = bump, nil
+		}), true},
+		{"outsider approves itself", edit(outsider, func(c *model.AppConfig) {
+			c.Teams[0].Requests = []model.TeamJoinRequest{{ID: "r1", Email: "new@example.com", Status: model.TeamJoinApproved, DeviceKey: outsider.Fingerprint()}}
+			c.Teams[0].Version, c.Teams[0].Sig = bump, nil
+		}), false},
+		{"member deletes a team network", edit(member, func(c *model.AppConfig) {
+			c.Networks[0].Deleted, c.Networks[0].Version, c.Networks[0].Sig = true, bump, nil
+		}), false},
+		{"admin deletes a team network", edit(admin, func(c *model.AppConfig) {
+			c.Networks[0].Deleted, c.Networks[0].Version, c.Networks[0].Sig = true, bump, nil
+		}), true},
+		{"tampered after signing", func() model.AppConfig {
+			c := edit(admin, func(c *model.AppConfig) {
+				c.Networks[0].Name, c.Networks[0].Version, c.Networks[0].Sig = "staging", bump, nil
+			})
+			c.Networks[0].Name = "evil"
+			return c
+		}(), false},
+	}
+	for _, tc := range cases {
+		_, rejected := authorizeRemote(local, tc.remote)
+		if tc.ok && len(rejected) != 0 {
+			t.Errorf("%s: rejected: %+v", tc.name, rejected)
+		}
+		if !tc.ok && len(rejected) != 1 {
+			t.Errorf("%s: expected one rejection, got %+v", tc.name, rejected)
+		}
+	}
+
+	// Unchanged objects pass whatever signs them.
+	if _, rejected := authorizeRemote(local, local); len(rejected) != 0 {
+		t.Fatalf("unchanged config rejected: %+v", rejected)
+	}
+}
+
+func TestUnenforcedTeamKeepsMembers(t *testing.T) {
+	admin, err := LoadIdentity(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	outsider, err := LoadIdentity(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A team from before devices were recorded.
+	local := model.AppConfig{
+		User: model.UserProfile{Email: "admin@example.com"},
+		Teams: []model.Team{{
+			ID:      "team-1",
+			Name:    "ops",
+			Members: []model.TeamMember{{Email: "admin@example.com", Role: model.TeamRoleAdmin}},
+			Version: map[string]int{"a": 1},
+		}},
+	}
+	remote := local
+	remote.Teams = []model.Team{local.Teams[0]}
+	remote.Teams[0].Members = []model.TeamMember{
+		{Email: "admin@example.com", Role: model.TeamRoleAdmin},
+		{Email: "evil@example.com", Role: model.TeamRoleAdmin, Devices: []string{outsider.Fingerprint()}},
+	}
+	remote.Teams[0].Version = map[string]int{"a": 1, "b": 1}
+	remote = outsider.signConfig(local, remote)
+	if _, rejected := authorizeRemote(local, remote); len(rejected) != 1 {
+		t.Fatalf("member change to an unenforced team accepted: %+v", rejected)
+	}
+
+	// Saving on the admin's device lists it, and then only it can sign.
+	registered := registerAdminDevice(local, local.User.Email, admin.Fingerprint())
+	if !teamEnforced(registered.Teams[0]) || teamEnforced(local.Teams[0]) {
+		t.Fatal("admin device not registered on a copy")
+	}
+	registered.Teams[0].Version = map[string]int{"a": 2}
+	registered = admin.signConfig(local, registered)
+	if got, rejected := authorizeRemote(local, registered); len(rejected) != 0 || !teamEnforced(got.Teams[0]) {
+		t.Fatalf("admin device registration rejected by a peer: %+v", rejected)
+	}
+	// Another device cannot list itself under the admin the same way.
+	hijack := registerAdminDevice(local, local.User.Email, outsider.Fingerprint())
+	hijack.Teams[0].Version = map[string]int{"a": 2}
+	hijack.Teams[0].UpdatedBy = "evil@example.com"
+	hijack = outsider.signConfig(local, hijack)
+	if _, rejected := authorizeRemote(local, hijack); len(rejected) != 1 {
+		t.Fatalf("registration for another member accepted: %+v", rejected)
+	}
+
+	// Objects that arrived from a peer keep their signatures when the local
+	// config is signed.
+	signed := admin.signConfig(remote, remote)
+	if Fingerprint(signed.Teams[0].Sig.Key) == admin.Fingerprint() {
+		t.Fatal("remote team re-signed with the local key")
+	}
+}
@@ -0,0 +1,305 @@
+package p2p
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ankouros/pterminal/internal/model"
+	"github.com/ankouros/pterminal/internal/teamrepo"
+)
+
+/*
+Team keys
+
+Every team has a symmetric key that seals its networks, scripts, repo
+manifest and repo files on the wire (AES-256-GCM). Keys are kept next to the
+config in teamkeys.json (mode 0600), never in the config itself, and are only
+handed over an authenticated sync session to devices listed under a member
+of the team (TeamMember.Devices, filled in when a join request is approved).
+
+An admin's device creates the first key. When a local edit takes a device
+out of a team (a member is removed or leaves), that device's key is rotated
+to a new generation, so the removed device cannot read anything synced
+afterwards. Older generations are kept to open data still sealed with them.
+*/
+
+const teamKeysFile = "teamkeys.json"
+
+const teamKeySize = 32
+
+var errNoTeamKey = errors.New("no key for team")
+
+type teamKey struct {
+	Generation int    `json:"generation"`
+	Key        []byte `json:"key"`
+	CreatedAt  int64  `json:"createdAt"`
+}
+
+// teamKeyGrant hands a team key to a member device.
+type teamKeyGrant struct {
+	TeamID     string `json:"teamId"`
+	Generation int    `json:"generation"`
+	Key        []byte `json:"key"`
+}
+
+// sealedTeam carries one team's data, encrypted with its key.
+type sealedTeam struct {
+	TeamID     string `json:"teamId"`
+	Generation int    `json:"generation"`
+	Nonce      []byte `json:"nonce"`
+	Data       []byte `json:"data"`
+}
+
+type teamPayload struct {
+	Networks []model.Network    `json:"networks,omitempty"`
+	Scripts  []model.TeamScript `json:"scripts,omitempty"`
+	Manifest *teamrepo.Manifest `json:"manifest,omitempty"`
+}
+
+type teamKeysFileData struct {
+	Version int                  `json:"version"`
+	Teams   map[string][]teamKey `json:"teams"`
+}
+
+type teamKeyring struct {
+	path string
+
+	mu    sync.Mutex
+	teams map[string][]teamKey // by team ID
+}
+
+// openTeamKeys loads the keyring at path; a missing file is an empty one.
+func openTeamKeys(path string) (*teamKeyring, error) {
+	k := &teamKeyring{path: path, teams: map[string][]teamKey{}}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return k, nil
+		}
+		return nil, err
+	}
+	var f teamKeysFileData
+	if err := json.Unmarshal(b, &f); err != nil {
+		return nil, err
+	}
+	if f.Teams != nil {
+		k.teams = f.Teams
+	}
+	return k, nil
+}
+
+// current returns the key used to seal new data: the highest generation,
+// and the larger key when two devices created the same generation.
+func (k *teamKeyring) current(teamID string) (teamKey, bool) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return k.currentLocked(teamID)
+}
+
+func (k *teamKeyring) currentLocked(teamID string) (teamKey, bool) {
+	var best teamKey
+	found := false
+	for _, key := range k.teams[teamID] {
+		if !found || key.Generation > best.Generation ||
+			(key.Generation == best.Generation && bytes.Compare(key.Key, best.Key) > 0) {
+			best, found = key, true
+		}
+	}
+	return best, found
+}
+
+// generations maps team IDs to the generation of their current key.
+func (k *teamKeyring) generations() map[string]int {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	out := make(map[string]int, len(k.teams))
+	for id := range k.teams {
+		if key, ok := k.currentLocked(id); ok {
+			out[id] = key.Generation
+		}
+	}
+	return out
+}
+
+// has reports whether the keyring holds generation gen of the team's key.
+func (k *teamKeyring) has(teamID string, gen int) bool {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	for _, key := range k.teams[teamID] {
+		if key.Generation == gen {
+			return true
+		}
+	}
+	return false
+}
+
+// rotate creates the team's next key generation.
+func (k *teamKeyring) rotate(teamID string) (teamKey, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	cur, _ := k.currentLocked(teamID)
+	key := teamKey{Generation: cur.Generation + 1, Key: make([]byte, teamKeySize), CreatedAt: time.Now().Unix()}
+	if _, err := rand.Read(key.Key); err != nil {
+		return teamKey{}, err
+	}
+	k.teams[teamID] = append(k.teams[teamID], key)
+	if err := k.saveLocked(); err != nil {
+		k.teams[teamID] = k.teams[teamID][:len(k.teams[teamID])-1]
+		return teamKey{}, err
+	}
+	return key, nil
+}
+
+// add stores a key received from another member; it reports whether the
+// key was new.
+func (k *teamKeyring) add(teamID string, key teamKey) (bool, error) {
+	if teamID == "" || key.Generation < 1 || len(key.Key) != teamKeySize {
+		return false, errors.New("invalid team key")
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	for _, have := range k.teams[teamID] {
+		if have.Generation == key.Generation && bytes.Equal(have.Key, key.Key) {
+			return false, nil
+		}
+	}
+	k.teams[teamID] = append(k.teams[teamID], key)
+	return true, k.saveLocked()
+}
+
+// seal encrypts plain with the team's current key.
+func (k *teamKeyring) seal(teamID, aad string, plain []byte) (gen int, nonce, data []byte, err error) {
+	key, ok := k.current(teamID)
+	if !ok {
+		return 0, nil, nil, errNoTeamKey
+	}
+	aead, err := newGCM(key.Key)
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	nonce = make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return 0, nil, nil, err
+	}
+	return key.Generation, nonce, aead.Seal(nil, nonce, plain, []byte(aad)), nil
+}
+
+// open decrypts data sealed with generation gen of the team's key.
+func (k *teamKeyring) open(teamID string, gen int, aad string, nonce, data []byte) ([]byte, error) {
+	k.mu.Lock()
+	keys := append([]teamKey(nil), k.teams[teamID]...)
+	k.mu.Unlock()
+	found := false
+	for _, key := range keys {
+		if key.Generation != gen {
+			continue
+		}
+		found = true
+		aead, err := newGCM(key.Key)
+		if err != nil || len(nonce) != aead.NonceSize() {
+			continue
+		}
+		if plain, err := aead.Open(nil, nonce, data, []byte(aad)); err == nil {
+			return plain, nil
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("%w (generation %d)", errNoTeamKey, gen)
+	}
+	return nil, errors.New("team data failed to decrypt")
+}
+
+func (k *teamKeyring) saveLocked() error {
+	b, err := json.MarshalIndent(teamKeysFileData{Version: 1, Teams: k.teams}, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(k.path), 0o700); err != nil {
+		return err
+	}
+	tmp := k.path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, k.path); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+func payloadAAD(teamID string) string {
+	return "pterminal team payload\x00" + teamID
+}
+
+func fileAAD(teamID, relPath, hash string) string {
+	return "pterminal team file\x00" + teamID + "\x00" + relPath + "\x00" + hash
+}
+
+// teamDevices returns the fingerprints of the devices allowed to hold the
+// team's key.
+func teamDevices(t model.Team) map[string]struct{} {
+	out := map[string]struct{}{}
+	if t.Deleted {
+		return out
+	}
+	for _, m := range t.Members {
+		for _, fp := range m.Devices {
+			if fp = strings.TrimSpace(fp); fp != "" {
+				out[fp] = struct{}{}
+			}
+		}
+	}
+	return out
+}
+
+func findTeam(cfg model.AppConfig, teamID string) (model.Team, bool) {
+	for _, t := range cfg.Teams {
+		if t.ID == teamID && teamID != "" {
+			return t, true
+		}
+	}
+	return model.Team{}, false
+}
+
+// deviceAuthorized reports whether the device with fingerprint fp is listed
+// under a member of the team.
+func deviceAuthorized(cfg model.AppConfig, teamID, fp string) bool {
+	t, ok := findTeam(cfg, teamID)
+	if !ok || fp == "" {
+		return false
+	}
+	_, ok = teamDevices(t)[fp]
+	return ok
+}
+
+func isTeamAdmin(t model.Team, email string) bool {
+	email = strings.ToLower(strings.TrimSpace(email))
+	if email == "" {
+		return false
+	}
+	for _, m := range t.Members {
+		if strings.ToLower(strings.TrimSpace(m.Email)) == email && m.Role == model.TeamRoleAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+// lostDevices reports whether a device allowed in prev is no longer allowed.
+func lostDevices(prev model.Team, now map[string]struct{}) bool {
+	for fp := range teamDevices(prev) {
+		if _, ok := now[fp]; !ok {
+			return true
+		}
+	}
+	return false
+}
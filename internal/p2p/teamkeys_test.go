@@ -0,0 +1,140 @@
+package p2p
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/ankouros/pterminal/internal/model"
+)
+
+func newTeamService(t *testing.T, email string) *Service {
+	t.Helper()
+	dir := t.TempDir()
+	ident, err := LoadIdentity(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, err := openTeamKeys(filepath.Join(dir, teamKeysFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	devices, err := openDevices(filepath.Join(dir, devicesFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := model.UserProfile{Email: email, DeviceID: "dev-" + email}
+	return &Service{
+		cfg:      model.AppConfig{Version: 1, User: user},
+		deviceID: user.DeviceID,
+		user:     user,
+		baseDir:  dir,
+		identity: ident,
+		devices:  devices,
+		teamKeys: keys,
+	}
+}
+
+func teamConfig(user model.UserProfile, members ...model.TeamMember) model.AppConfig {
+	return model.AppConfig{
+		Version: 1,
+		User:    user,
+		Teams:   []model.Team{{ID: "team-1", Name: "ops", Members: members, Version: map[string]int{"x": 1}}},
+	}
+}
+
+func TestTeamKeysFollowMembership(t *testing.T) {
+	admin := newTeamService(t, "admin@example.com")
+	member := newTeamService(t, "member@example.com")
+	outsider := newTeamService(t, "outsider@example.com")
+	adminFP, memberFP := admin.identity.Fingerprint(), member.identity.Fingerprint()
+
+	members := []model.TeamMember{
+		{Email: "admin@example.com", Role: model.TeamRoleAdmin, Devices: []string{adminFP}},
+		{Email: "member@example.com", Role: model.TeamRoleUser, Devices: []string{memberFP}},
+	}
+	cfg := teamConfig(admin.user, members...)
+	cfg.Scripts = []model.TeamScript{{ID: "s1", TeamID: "team-1", Scope: model.ScopeTeam, Name: "deploy", Version: map[string]int{"x": 1}}}
+	admin.SetConfig(admin.SignConfig(cfg))
+	if gen := admin.teamKeys.generations()["team-1"]; gen != 1 {
+		t.Fatalf("admin should create the first key, got generation %d", gen)
+	}
+	member.SetConfig(member.SignConfig(teamConfig(member.user, members...)))
+
+	// Outsiders get the team list but neither the key nor the team's data.
+	msg := admin.syncMessage(admin.configSnapshot(), nil, outsider.identity.Fingerprint())
+	if len(msg.TeamKeys) != 0 || len(msg.Sealed) != 0 || len(msg.Config.Scripts) != 0 {
+		t.Fatalf("team data sent to an outsider: %+v", msg)
+	}
+	if len(msg.Config.Teams) != 1 {
+		t.Fatal("team metadata should stay visible")
+	}
+
+	msg = admin.syncMessage(admin.configSnapshot(), nil, memberFP)
+	if len(msg.Config.Scripts) != 0 || len(msg.Sealed) != 1 {
+		t.Fatalf("team data should only travel sealed: %+v", msg)
+	}
+	member.applyRemote(msg, adminFP)
+	if got := member.configSnapshot().Scripts; len(got) != 1 || got[0].Name != "deploy" {
+		t.Fatalf("member did not receive the team scripts: %+v", got)
+	}
+
+	// Removing the member rotates the key; the old key opens nothing new.
+	removed := teamConfig(admin.user, members[0])
+	removed.Scripts = cfg.Scripts
+	admin.SetConfig(admin.SignConfig(removed))
+	if gen := admin.teamKeys.generations()["team-1"]; gen != 2 {
+		t.Fatalf("expected the key to rotate, got generation %d", gen)
+	}
+	if msg := admin.syncMessage(admin.configSnapshot(), nil, memberFP); len(msg.TeamKeys) != 0 || len(msg.Sealed) != 0 {
+		t.Fatal("removed member still receives team data")
+	}
+	gen, nonce, data, err := admin.teamKeys.seal("team-1", payloadAAD("team-1"), []byte("{}"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := member.teamKeys.open("team-1", gen, payloadAAD("team-1"), nonce, data); !errors.Is(err, errNoTeamKey) {
+		t.Fatalf("removed member opened data sealed after rotation: %v", err)
+	}
+
+	// Keys persist across restarts.
+	reloaded, err := openTeamKeys(filepath.Join(admin.baseDir, teamKeysFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reloaded.generations()["team-1"] != 2 {
+		t.Fatal("team keys were not saved")
+	}
+}
+
+func TestMergeKeepsDeviceRemovals(t *testing.T) {
+	base := model.Team{
+		ID: "team-1",
+		Members: []model.TeamMember{
+			{Email: "admin@example.com", Role: model.TeamRoleAdmin, Devices: []string{"fp-admin"}},
+			{Email: "member@example.com", Role: model.TeamRoleUser, Devices: []string{"fp-laptop", "fp-lost"}},
+		},
+	}
+	// The admin removes a lost device while another device renames the
+	// team from the old member list.
+	removed := base
+	removed.Members = []model.TeamMember{base.Members[0], {Email: "member@example.com", Role: model.TeamRoleUser, Devices: []string{"fp-laptop"}}}
+	removed.Version, removed.UpdatedAt = map[string]int{"a": 2, "b": 1}, 200
+	stale := base
+	stale.Name = "ops"
+	stale.Version, stale.UpdatedAt = map[string]int{"a": 1, "b": 2}, 100
+
+	for _, tc := range []struct{ local, remote model.Team }{{removed, stale}, {stale, removed}} {
+		merged, _ := mergeTeams([]model.Team{tc.local}, []model.Team{tc.remote}, false)
+		if _, ok := teamDevices(merged[0])["fp-lost"]; ok {
+			t.Fatalf("removed device came back: %+v", merged[0].Members)
+		}
+	}
+
+	// On a tie only the devices both versions list are kept.
+	stale.UpdatedAt = removed.UpdatedAt
+	merged, _ := mergeTeams([]model.Team{stale}, []model.Team{removed}, false)
+	if _, ok := teamDevices(merged[0])["fp-lost"]; ok {
+		t.Fatalf("removed device came back on a tie: %+v", merged[0].Members)
+	}
+}
@@ -0,0 +1,153 @@
+package p2p
+
+import (
+	"encoding/json"
+	"log"
+
+	"github.com/ankouros/pterminal/internal/model"
+	"github.com/ankouros/pterminal/internal/teamrepo"
+)
+
+// syncMessage builds the sync message for the peer with fingerprint peerFP:
+// team metadata in the clear, and the networks, scripts and repo manifest of
+// every team the peer's device belongs to sealed with that team's key, along
+// with the keys themselves.
+func (s *Service) syncMessage(cfg model.AppConfig, manifests []teamrepo.Manifest, peerFP string) wireMessage {
+	scoped := s.teamScopedConfig(cfg)
+	payloads := map[string]*teamPayload{}
+	payload := func(teamID string) *teamPayload {
+		if !deviceAuthorized(cfg, teamID, peerFP) {
+			return nil
+		}
+		if payloads[teamID] == nil {
+			payloads[teamID] = &teamPayload{}
+		}
+		return payloads[teamID]
+	}
+	for _, netw := range scoped.Networks {
+		if p := payload(netw.TeamID); p != nil {
+			p.Networks = append(p.Networks, netw)
+		}
+	}
+	for _, script := range scoped.Scripts {
+		if p := payload(script.TeamID); p != nil {
+			p.Scripts = append(p.Scripts, script)
+		}
+	}
+	for i := range manifests {
+		if p := payload(manifests[i].TeamID); p != nil {
+			p.Manifest = &manifests[i]
+		}
+	}
+
+	msg := wireMessage{
+		Type:     "sync",
+		DeviceID: s.deviceID,
+		User:     s.user,
+		Teams:    s.teamSummariesFromConfig(cfg),
+	}
+	msg.Config = scoped
+	msg.Config.Networks = nil
+	msg.Config.Scripts = nil
+
+	for teamID, p := range payloads {
+		plain, err := json.Marshal(p)
+		if err != nil {
+			continue
+		}
+		gen, nonce, data, err := s.teamKeys.seal(teamID, payloadAAD(teamID), plain)
+		if err != nil {
+			continue
+		}
+		msg.Sealed = append(msg.Sealed, sealedTeam{TeamID: teamID, Generation: gen, Nonce: nonce, Data: data})
+	}
+	for _, t := range cfg.Teams {
+		if !deviceAuthorized(cfg, t.ID, peerFP) {
+			continue
+		}
+		if key, ok := s.teamKeys.current(t.ID); ok {
+			msg.TeamKeys = append(msg.TeamKeys, teamKeyGrant{TeamID: t.ID, Generation: key.Generation, Key: key.Key})
+		}
+	}
+	return msg
+}
+
+// acceptTeamKeys stores the keys a peer handed over, for teams this device
+// and the peer's device both belong to.
+func (s *Service) acceptTeamKeys(cfg model.AppConfig, grants []teamKeyGrant, peerFP string) {
+	self := s.identity.Fingerprint()
+	for _, g := range grants {
+		if !deviceAuthorized(cfg, g.TeamID, self) || !deviceAuthorized(cfg, g.TeamID, peerFP) {
+			continue
+		}
+		if _, err := s.teamKeys.add(g.TeamID, teamKey{Generation: g.Generation, Key: g.Key}); err != nil {
+			log.Printf("p2p: team %s key: %v", g.TeamID, err)
+		}
+	}
+}
+
+// openTeamData decrypts the team payloads of a peer allowed in those teams.
+// Anything a payload holds for another team is dropped.
+func (s *Service) openTeamData(cfg model.AppConfig, sealed []sealedTeam, peerFP string) ([]model.Network, []model.TeamScript, []teamrepo.Manifest) {
+	var (
+		networks  []model.Network
+		scripts   []model.TeamScript
+		manifests []teamrepo.Manifest
+	)
+	for _, st := range sealed {
+		if !deviceAuthorized(cfg, st.TeamID, peerFP) {
+			continue
+		}
+		plain, err := s.teamKeys.open(st.TeamID, st.Generation, payloadAAD(st.TeamID), st.Nonce, st.Data)
+		if err != nil {
+			continue
+		}
+		var p teamPayload
+		if err := json.Unmarshal(plain, &p); err != nil {
+			continue
+		}
+		for _, netw := range p.Networks {
+			if netw.TeamID == st.TeamID {
+				networks = append(networks, netw)
+			}
+		}
+		for _, script := range p.Scripts {
+			if script.TeamID == st.TeamID {
+				scripts = append(scripts, script)
+			}
+		}
+		if p.Manifest != nil && p.Manifest.TeamID == st.TeamID {
+			manifests = append(manifests, *p.Manifest)
+		}
+	}
+	return networks, scripts, manifests
+}
+
+// updateTeamKeys creates the first key of teams this device administers and
+// rotates the key of a team that lost a device: for local edits, and for
+// merged ones (a member leaving on their own device) on admins' devices.
+func (s *Service) updateTeamKeys(prev, cfg model.AppConfig, local bool) {
+	self := s.identity.Fingerprint()
+	for _, t := range cfg.Teams {
+		if t.ID == "" || t.Deleted {
+			continue
+		}
+		allowed := teamDevices(t)
+		if _, ok := allowed[self]; !ok {
+			continue
+		}
+		_, have := s.teamKeys.current(t.ID)
+		admin := isTeamAdmin(t, cfg.User.Email)
+		before, _ := findTeam(prev, t.ID)
+		switch {
+		case !have && admin:
+		case have && (local || admin) && lostDevices(before, allowed):
+			log.Printf("p2p: team %s lost a device, rotating its key", t.ID)
+		default:
+			continue
+		}
+		if _, err := s.teamKeys.rotate(t.ID); err != nil {
+			log.Printf("p2p: team %s key: %v", t.ID, err)
+		}
+	}
+}
@@ -8,17 +8,24 @@ type TeamSummary struct {
 }
 
 type PeerInfo struct {
-	DeviceID string        `json:"deviceId"`
-	Name     string        `json:"name,omitempty"`
-	Email    string        `json:"email,omitempty"`
-	Host     string        `json:"host,omitempty"`
-	Addr     string        `json:"addr,omitempty"`
-	TCPPort  int           `json:"tcpPort,omitempty"`
-	LastSeen int64         `json:"lastSeen,omitempty"`
-	Teams    []TeamSummary `json:"teams,omitempty"`
+	DeviceID    string        `json:"deviceId"`
+	Fingerprint string        `json:"fingerprint,omitempty"`
+	Name        string        `json:"name,omitempty"`
+	Email       string        `json:"email,omitempty"`
+	Host        string        `json:"host,omitempty"`
+	Addr        string        `json:"addr,omitempty"`
+	TCPPort     int           `json:"tcpPort,omitempty"`
+	LastSeen    int64         `json:"lastSeen,omitempty"`
+	Teams       []TeamSummary `json:"teams,omitempty"`
+	// Source is how the peer was found: broadcast, static or mdns.
+	Source string `json:"source,omitempty"`
 }
 
 type PresenceSnapshot struct {
 	Peers []PeerInfo        `json:"peers"`
 	User  model.UserProfile `json:"user"`
+	// Fingerprint is this device's identity key.
+	Fingerprint string `json:"fingerprint"`
+	// TeamKeys maps the teams this device holds a key for to its generation.
+	TeamKeys map[string]int `json:"teamKeys,omitempty"`
 }
@@ -0,0 +1,305 @@
+package recording
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+/*
+Asciicast v2 recorder
+
+A recording is a header line followed by one JSON array per event:
+[seconds, "o"|"i"|"r", data]. Files rotate into numbered parts once they
+reach MaxBytes; each part has its own header and clock so it plays alone.
+*/
+
+const (
+	Ext = ".cast"
+
+	DefaultMaxBytes = 64 << 20
+)
+
+// Options control what a Recorder captures and where it writes.
+type Options struct {
+	Dir      string
+	MaxBytes int64
+
+	Input  bool
+	Resize bool
+}
+
+// Meta describes the recorded terminal.
+type Meta struct {
+	HostID   int
+	TabID    int
+	HostName string
+	Cols     int
+	Rows     int
+}
+
+type header struct {
+	Version   int               `json:"version"`
+	Width     int               `json:"width"`
+	Height    int               `json:"height"`
+	Timestamp int64             `json:"timestamp"`
+	Title     string            `json:"title,omitempty"`
+	Env       map[string]string `json:"env,omitempty"`
+}
+
+type Recorder struct {
+	opts Options
+	meta Meta
+	base string
+
+	mu      sync.Mutex
+	f       *os.File
+	w       *bufio.Writer
+	path    string
+	part    int
+	size    int64
+	start   time.Time
+	pending map[string][]byte // incomplete UTF-8 tails per event type
+	err     error
+	closed  bool
+}
+
+// Start creates the recording directory and the first part file.
+func Start(opts Options, meta Meta) (*Recorder, error) {
+	if strings.TrimSpace(opts.Dir) == "" {
+		return nil, errors.New("recording dir is required")
+	}
+	if opts.MaxBytes <= 0 {
+		opts.MaxBytes = DefaultMaxBytes
+	}
+	if meta.TabID <= 0 {
+		meta.TabID = 1
+	}
+	if err := os.MkdirAll(opts.Dir, 0o700); err != nil {
+		return nil, err
+	}
+
+	r := &Recorder{
+		opts:    opts,
+		meta:    meta,
+		base:    fileBase(meta, time.Now()),
+		pending: make(map[string][]byte),
+	}
+	if err := r.openPart(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func fileBase(meta Meta, t time.Time) string {
+	name := slug(meta.HostName)
+	if name == "" {
+		name = "host"
+	}
+	return fmt.Sprintf("%s-h%d-t%d-%s", name, meta.HostID, meta.TabID, t.Format("20060102-150405"))
+}
+
+// slug keeps file names portable.
+func slug(s string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(strings.TrimSpace(s)) {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '.', r == '_':
+			b.WriteRune(r)
+		default:
+			b.WriteRune('-')
+		}
+	}
+	return strings.Trim(b.String(), "-")
+}
+
+func (r *Recorder) openPart() error {
+	r.part++
+	name := r.base + Ext
+	if r.part > 1 {
+		name = r.base + ".part" + strconv.Itoa(r.part) + Ext
+	}
+	path := filepath.Join(r.opts.Dir, name)
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0o600)
+	if err != nil {
+		return err
+	}
+
+	r.f = f
+	r.w = bufio.NewWriterSize(f, 32*1024)
+	r.path = path
+	r.size = 0
+	r.start = time.Now()
+
+	title := r.meta.HostName
+	if title == "" {
+		title = "host " + strconv.Itoa(r.meta.HostID)
+	}
+	title += " (tab " + strconv.Itoa(r.meta.TabID) + ")"
+	if r.part > 1 {
+		title += " part " + strconv.Itoa(r.part)
+	}
+
+	line, err := json.Marshal(header{
+		Version:   2,
+		Width:     r.meta.Cols,
+		Height:    r.meta.Rows,
+		Timestamp: r.start.Unix(),
+		Title:     title,
+		Env:       map[string]string{"TERM": "xterm-256color"},
+	})
+	if err != nil {
+		return err
+	}
+	return r.writeLine(line)
+}
+
+func (r *Recorder) writeLine(line []byte) error {
+	n, err := r.w.Write(append(line, '\n'))
+	r.size += int64(n)
+	return err
+}
+
+// Path returns the file currently being written.
+func (r *Recorder) Path() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.path
+}
+
+// Output records terminal output.
+func (r *Recorder) Output(p []byte) { r.event("o", p) }
+
+// Input records keystrokes when Options.Input is set.
+func (r *Recorder) Input(p []byte) {
+	if r.opts.Input {
+		r.event("i", p)
+	}
+}
+
+// Resize records a size change when Options.Resize is set. The latest size is
+// always kept for the header of the next part.
+func (r *Recorder) Resize(cols, rows int) {
+	r.mu.Lock()
+	changed := cols != r.meta.Cols || rows != r.meta.Rows
+	r.meta.Cols, r.meta.Rows = cols, rows
+	r.mu.Unlock()
+
+	if r.opts.Resize && changed {
+		r.event("r", []byte(strconv.Itoa(cols)+"x"+strconv.Itoa(rows)))
+	}
+}
+
+func (r *Recorder) event(kind string, p []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed || r.err != nil || len(p) == 0 {
+		return
+	}
+
+	// Chunks may split multi-byte characters; hold the tail until it completes
+	// so the JSON string does not get replacement characters.
+	data := append(r.pending[kind], p...)
+	data, r.pending[kind] = splitUTF8(data)
+	if len(data) == 0 {
+		return
+	}
+
+	elapsed := time.Since(r.start).Seconds()
+	text, err := json.Marshal(string(data))
+	if err != nil {
+		r.err = err
+		return
+	}
+	line := make([]byte, 0, len(text)+24)
+	line = append(line, '[')
+	line = strconv.AppendFloat(line, elapsed, 'f', 6, 64)
+	line = append(line, `, "`...)
+	line = append(line, kind...)
+	line = append(line, `", `...)
+	line = append(line, text...)
+	line = append(line, ']')
+	if err := r.writeLine(line); err != nil {
+		r.err = err
+		return
+	}
+
+	if r.size >= r.opts.MaxBytes {
+		if err := r.rotate(); err != nil {
+			r.err = err
+		}
+	}
+}
+
+func (r *Recorder) rotate() error {
+	if err := r.closeFile(); err != nil {
+		return err
+	}
+	return r.openPart()
+}
+
+func (r *Recorder) closeFile() error {
+	if r.f == nil {
+		return nil
+	}
+	err := r.w.Flush()
+	if cerr := r.f.Close(); err == nil {
+		err = cerr
+	}
+	r.f = nil
+	r.w = nil
+	return err
+}
+
+// Flush writes buffered events to disk.
+func (r *Recorder) Flush() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.w == nil {
+		return r.err
+	}
+	if err := r.w.Flush(); err != nil {
+		return err
+	}
+	return r.err
+}
+
+// Close flushes and closes the current part. Further events are dropped.
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return nil
+	}
+	r.closed = true
+	err := r.closeFile()
+	if err == nil {
+		err = r.err
+	}
+	return err
+}
+
+// splitUTF8 returns the longest prefix that does not end in the middle of a
+// multi-byte sequence, and the remaining tail.
+func splitUTF8(p []byte) ([]byte, []byte) {
+	for i := len(p) - 1; i >= 0 && i >= len(p)-utf8.UTFMax; i-- {
+		if !utf8.RuneStart(p[i]) {
+			continue
+		}
+		if utf8.FullRune(p[i:]) {
+			return p, nil
+		}
+		tail := append([]byte(nil), p[i:]...)
+		return p[:i], tail
+	}
+	return p, nil
+}
@@ -0,0 +1,178 @@
+package recording
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func readCast(t *testing.T, path string) (header, [][]any) {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	sc := bufio.NewScanner(f)
+	if !sc.Scan() {
+		t.Fatalf("%s: empty file", path)
+	}
+	var h header
+	if err := json.Unmarshal(sc.Bytes(), &h); err != nil {
+		t.Fatalf("header: %v", err)
+	}
+	var events [][]any
+	for sc.Scan() {
+		var ev []any
+		if err := json.Unmarshal(sc.Bytes(), &ev); err != nil {
+			t.Fatalf("event %q: %v", sc.Text(), err)
+		}
+		events = append(events, ev)
+	}
+	return h, events
+}
+
+func TestRecorderWritesAsciicast(t *testing.T) {
+	dir := t.TempDir()
+	rec, err := Start(Options{Dir: dir, Input: true, Resize: true}, Meta{
+		HostID: 3, TabID: 2, HostName: "Web 1", Cols: 80, Rows: 24,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	euro := []byte("€") // 3 bytes, split across two chunks
+	rec.Output([]byte("hello "))
+	rec.Output(euro[:1])
+	rec.Output(euro[1:])
+	rec.Input([]byte("ls\r"))
+	rec.Resize(120, 40)
+	path := rec.Path()
+	if err := rec.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(filepath.Base(path), "web-1-h3-t2-") {
+		t.Fatalf("unexpected file name %s", path)
+	}
+	h, events := readCast(t, path)
+	if h.Version != 2 || h.Width != 80 || h.Height != 24 || h.Title != "Web 1 (tab 2)" {
+		t.Fatalf("unexpected header: %+v", h)
+	}
+
+	want := [][2]string{{"o", "hello "}, {"o", "€"}, {"i", "ls\r"}, {"r", "120x40"}}
+	if len(events) != len(want) {
+		t.Fatalf("got %d events: %v", len(events), events)
+	}
+	for i, ev := range events {
+		if ev[1] != want[i][0] || ev[2] != want[i][1] {
+			t.Fatalf("event %d: got %v, want %v", i, ev, want[i])
+		}
+	}
+}
+
+func TestRecorderSkipsInputByDefault(t *testing.T) {
+	dir := t.TempDir()
+	rec, err := Start(Options{Dir: dir}, Meta{HostID: 1, Cols: 80, Rows: 24})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec.Input([]byte("secret\r"))
+	rec.Resize(100, 30)
+	rec.Output([]byte("$ "))
+	path := rec.Path()
+	_ = rec.Close()
+
+	_, events := readCast(t, path)
+	if len(events) != 1 || events[0][1] != "o" {
+		t.Fatalf("expected only output, got %v", events)
+	}
+}
+
+func TestRecorderRotates(t *testing.T) {
+	dir := t.TempDir()
+	rec, err := Start(Options{Dir: dir, MaxBytes: 200}, Meta{HostID: 1, HostName: "h", Cols: 80, Rows: 24})
+	if err != nil {
+		t.Fatal(err)
+	}
+	chunk := strings.Repeat("x", 100)
+	for i := 0; i < 5; i++ {
+		rec.Output([]byte(chunk))
+	}
+	_ = rec.Close()
+
+	list, err := List(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) < 2 {
+		t.Fatalf("expected rotated parts, got %+v", list)
+	}
+	parts := 0
+	for _, info := range list {
+		if strings.Contains(info.Name, ".part") {
+			parts++
+			if !strings.Contains(info.Title, "part") {
+				t.Fatalf("part header missing part number: %+v", info)
+			}
+		}
+		if info.Width != 80 || info.Height != 24 {
+			t.Fatalf("unexpected info: %+v", info)
+		}
+	}
+	if parts != len(list)-1 {
+		t.Fatalf("expected one base file, got %+v", list)
+	}
+}
+
+func TestListAndExport(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+
+	dir, err := Dir(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dir != filepath.Join(home, ".config", "pterminal", "recordings") {
+		t.Fatalf("unexpected default dir %s", dir)
+	}
+
+	empty, err := List(dir)
+	if err != nil || len(empty) != 0 {
+		t.Fatalf("expected empty list for missing dir, got %v %v", empty, err)
+	}
+
+	rec, err := Start(Options{Dir: dir}, Meta{HostID: 7, HostName: "db", Cols: 80, Rows: 24})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec.Output([]byte("ok"))
+	_ = rec.Close()
+
+	list, err := List(dir)
+	if err != nil || len(list) != 1 {
+		t.Fatalf("list: %v %v", list, err)
+	}
+	if list[0].Title != "db (tab 1)" || list[0].Duration < 0 {
+		t.Fatalf("unexpected info: %+v", list[0])
+	}
+
+	out, err := Export(dir, list[0].Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filepath.Dir(out) != filepath.Join(home, "Downloads") {
+		t.Fatalf("unexpected export path %s", out)
+	}
+	if _, events := readCast(t, out); len(events) != 1 {
+		t.Fatalf("exported file has %d events", len(events))
+	}
+
+	if _, err := Export(dir, "../pterminal.json"); err == nil {
+		t.Fatal("expected invalid name error")
+	}
+}
@@ -0,0 +1,175 @@
+package recording
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ankouros/pterminal/internal/config"
+	"github.com/ankouros/pterminal/internal/model"
+)
+
+// Info describes a recording file on disk.
+type Info struct {
+	Name      string  `json:"name"`
+	Size      int64   `json:"size"`
+	ModTime   int64   `json:"modTime"`
+	Title     string  `json:"title,omitempty"`
+	Width     int     `json:"width,omitempty"`
+	Height    int     `json:"height,omitempty"`
+	Timestamp int64   `json:"timestamp,omitempty"`
+	Duration  float64 `json:"duration,omitempty"`
+}
+
+// Dir resolves the recording directory from the app settings.
+func Dir(settings *model.RecordingSettings) (string, error) {
+	if settings != nil && strings.TrimSpace(settings.Dir) != "" {
+		dir := strings.TrimSpace(settings.Dir)
+		if dir == "~" || strings.HasPrefix(dir, "~/") {
+			home, err := os.UserHomeDir()
+			if err != nil {
+				return "", err
+			}
+			dir = filepath.Join(home, strings.TrimPrefix(dir, "~"))
+		}
+		return dir, nil
+	}
+	p, err := config.ConfigPath()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(filepath.Dir(p), "recordings"), nil
+}
+
+// MaxBytes returns the rotation size from the app settings.
+func MaxBytes(settings *model.RecordingSettings) int64 {
+	if settings == nil || settings.MaxFileMB <= 0 {
+		return DefaultMaxBytes
+	}
+	return int64(settings.MaxFileMB) << 20
+}
+
+// List returns the recordings in dir, newest first. A missing dir is empty.
+func List(dir string) ([]Info, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return []Info{}, nil
+		}
+		return nil, err
+	}
+
+	out := make([]Info, 0, len(entries))
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), Ext) {
+			continue
+		}
+		fi, err := e.Info()
+		if err != nil {
+			continue
+		}
+		info := Info{Name: e.Name(), Size: fi.Size(), ModTime: fi.ModTime().Unix()}
+		readInfo(filepath.Join(dir, e.Name()), &info)
+		out = append(out, info)
+	}
+
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].ModTime != out[j].ModTime {
+			return out[i].ModTime > out[j].ModTime
+		}
+		return out[i].Name > out[j].Name
+	})
+	return out, nil
+}
+
+// readInfo fills header fields and the duration (time of the last event).
+// Unreadable files keep just their name and size.
+func readInfo(path string, info *Info) {
+	f, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	line, err := bufio.NewReader(f).ReadBytes('\n')
+	if err != nil && len(line) == 0 {
+		return
+	}
+	var h header
+	if json.Unmarshal(line, &h) != nil || h.Version != 2 {
+		return
+	}
+	info.Title = h.Title
+	info.Width = h.Width
+	info.Height = h.Height
+	info.Timestamp = h.Timestamp
+
+	const tailSize = 64 * 1024
+	off := info.Size - tailSize
+	if off < int64(len(line)) {
+		off = int64(len(line))
+	}
+	if _, err := f.Seek(off, io.SeekStart); err != nil {
+		return
+	}
+	tail, err := io.ReadAll(f)
+	if err != nil {
+		return
+	}
+	lines := strings.Split(strings.TrimRight(string(tail), "\n"), "\n")
+	for i := len(lines) - 1; i >= 0; i-- {
+		var ev []json.RawMessage
+		if json.Unmarshal([]byte(lines[i]), &ev) != nil || len(ev) == 0 {
+			continue
+		}
+		var t float64
+		if json.Unmarshal(ev[0], &t) == nil {
+			info.Duration = t
+		}
+		return
+	}
+}
+
+// Export copies a recording into ~/Downloads and returns the new path.
+func Export(dir, name string) (string, error) {
+	if name == "" || name != filepath.Base(name) || !strings.HasSuffix(name, Ext) {
+		return "", errors.New("invalid recording name")
+	}
+
+	src, err := os.Open(filepath.Join(dir, name))
+	if err != nil {
+		return "", err
+	}
+	defer src.Close()
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	downloads := filepath.Join(home, "Downloads")
+	_ = os.MkdirAll(downloads, 0o755)
+
+	out := filepath.Join(downloads, name)
+	if _, err := os.Stat(out); err == nil {
+		out = filepath.Join(downloads, strings.TrimSuffix(name, Ext)+"-"+time.Now().Format("20060102-150405")+Ext)
+	}
+
+	dst, err := os.OpenFile(out, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		_ = dst.Close()
+		return "", err
+	}
+	if err := dst.Close(); err != nil {
+		return "", err
+	}
+	return out, nil
+}
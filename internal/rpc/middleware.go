@@ -0,0 +1,58 @@
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"time"
+)
+
+// Logf matches log.Printf.
+type Logf func(format string, args ...any)
+
+// Recover turns a panicking handler into a CodeInternal failure and logs the
+// stack.
+func Recover(logf Logf) Middleware {
+	return func(method string, next Handler) Handler {
+		return func(ctx context.Context, params json.RawMessage) (resp any, err error) {
+			defer func() {
+				if p := recover(); p != nil {
+					if logf != nil {
+						logf("rpc: %s panicked: %v\n%s", method, p, debug.Stack())
+					}
+					resp, err = nil, Fail(CodeInternal, map[string]any{"detail": fmt.Sprint(p)})
+				}
+			}()
+			return next(ctx, params)
+		}
+	}
+}
+
+// Timing reports how long each call took and how it ended.
+func Timing(observe func(method string, elapsed time.Duration, err error)) Middleware {
+	return func(method string, next Handler) Handler {
+		return func(ctx context.Context, params json.RawMessage) (any, error) {
+			start := time.Now()
+			resp, err := next(ctx, params)
+			observe(method, time.Since(start), err)
+			return resp, err
+		}
+	}
+}
+
+// Logging logs calls that fail unexpectedly (errors other than *Error, and
+// CodeInternal) and calls slower than slow (0 disables that). Params are never
+// logged: they may carry passwords.
+func Logging(logf Logf, slow time.Duration) Middleware {
+	return Timing(func(method string, elapsed time.Duration, err error) {
+		var e *Error
+		switch {
+		case err != nil && (!errors.As(err, &e) || e.Code == CodeInternal):
+			logf("rpc: %s failed after %s: %v", method, elapsed.Round(time.Millisecond), err)
+		case slow > 0 && elapsed >= slow:
+			logf("rpc: %s took %s", method, elapsed.Round(time.Millisecond))
+		}
+	})
+}
@@ -0,0 +1,181 @@
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+/*
+RPC dispatch
+
+A Registry maps method names ("config_save", "sftp_ls", "select", ...) to
+handlers. Handlers are registered with a typed request and response: the
+request is decoded from the call's JSON params and the response is encoded by
+the frontend, so the same method serves the WebView binding, the control
+socket and tests alike. Middleware wraps every call (logging, timing, panic
+recovery, ...).
+
+Methods are only served to the app's own window unless they are exposed:
+the control socket refuses the rest, so a new method that trusts a key or
+opens a dialog stays with the person at the window until it opts in.
+
+Failures are *Error values carrying the stable error code the UI switches on
+("password_required", "unknown_host_key", ...) plus optional reply fields.
+*/
+
+// Common error codes.
+const (
+	CodeBadRequest    = "bad_request"
+	CodeUnknownMethod = "unknown_rpc"
+	CodeInternal      = "internal_error"
+)
+
+// Error is a failed call.
+type Error struct {
+	Code string
+	// Data holds extra reply fields, usually "detail".
+	Data map[string]any
+}
+
+func (e *Error) Error() string {
+	if d, ok := e.Data["detail"].(string); ok && d != "" {
+		return e.Code + ": " + d
+	}
+	return e.Code
+}
+
+// Fail returns an error with code and optional reply fields.
+func Fail(code string, data map[string]any) *Error {
+	return &Error{Code: code, Data: data}
+}
+
+// FailDetail returns an error with code whose "detail" is err's text.
+func FailDetail(code string, err error) *Error {
+	return &Error{Code: code, Data: map[string]any{"detail": err.Error()}}
+}
+
+// AsError converts err to an *Error; errors that are not one become
+// CodeInternal.
+func AsError(err error) *Error {
+	var e *Error
+	if errors.As(err, &e) {
+		return e
+	}
+	return FailDetail(CodeInternal, err)
+}
+
+// Empty is the response of methods that only report success.
+type Empty struct{}
+
+// Handler serves one call. params is the raw request object (may be empty).
+type Handler func(ctx context.Context, params json.RawMessage) (any, error)
+
+// Middleware wraps the handler of method.
+type Middleware func(method string, next Handler) Handler
+
+// Registry is safe for concurrent use.
+type Registry struct {
+	mu         sync.RWMutex
+	handlers   map[string]Handler
+	exposed    map[string]bool
+	middleware []Middleware
+}
+
+func NewRegistry() *Registry {
+	return &Registry{handlers: map[string]Handler{}, exposed: map[string]bool{}}
+}
+
+// Handle registers h for method. Registering a method twice panics.
+func (r *Registry) Handle(method string, h Handler) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, dup := r.handlers[method]; dup {
+		panic(fmt.Sprintf("rpc: method %q registered twice", method))
+	}
+	r.handlers[method] = h
+}
+
+// Register registers fn for method. Params are decoded into Req (missing
+// params leave it zero; undecodable ones fail with CodeBadRequest). Resp
+// should encode to a JSON object.
+func Register[Req, Resp any](r *Registry, method string, fn func(context.Context, Req) (Resp, error)) {
+	r.Handle(method, func(ctx context.Context, params json.RawMessage) (any, error) {
+		var req Req
+		if len(params) > 0 && string(params) != "null" {
+			if err := json.Unmarshal(params, &req); err != nil {
+				return nil, Fail(CodeBadRequest, map[string]any{"detail": err.Error()})
+			}
+		}
+		resp, err := fn(ctx, req)
+		if err != nil {
+			return nil, err
+		}
+		return resp, nil
+	})
+}
+
+// Use appends middleware; the first one added is the outermost.
+func (r *Registry) Use(mw ...Middleware) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.middleware = append(r.middleware, mw...)
+}
+
+// Has reports whether method is registered.
+func (r *Registry) Has(method string) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	_, ok := r.handlers[method]
+	return ok
+}
+
+// Expose lets other programs (the control socket) call methods.
+func (r *Registry) Expose(methods ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, m := range methods {
+		r.exposed[m] = true
+	}
+}
+
+// Exposed reports whether method is registered and exposed.
+func (r *Registry) Exposed(method string) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	_, ok := r.handlers[method]
+	return ok && r.exposed[method]
+}
+
+// Methods lists the registered methods, sorted.
+func (r *Registry) Methods() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	out := make([]string, 0, len(r.handlers))
+	for m := range r.handlers {
+		out = append(out, m)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// Call runs method through the middleware. Unknown methods fail with
+// CodeUnknownMethod.
+func (r *Registry) Call(ctx context.Context, method string, params json.RawMessage) (any, error) {
+	r.mu.RLock()
+	h, ok := r.handlers[method]
+	mw := r.middleware
+	r.mu.RUnlock()
+	if !ok {
+		h = func(context.Context, json.RawMessage) (any, error) {
+			return nil, Fail(CodeUnknownMethod, nil)
+		}
+	}
+	for i := len(mw) - 1; i >= 0; i-- {
+		h = mw[i](method, h)
+	}
+	return h(ctx, params)
+}
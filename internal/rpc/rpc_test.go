@@ -0,0 +1,100 @@
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+type echoReq struct {
+	Name string `json:"name"`
+}
+
+type echoResp struct {
+	Greeting string `json:"greeting"`
+}
+
+func TestRegistry(t *testing.T) {
+	r := NewRegistry()
+	Register(r, "echo", func(_ context.Context, req echoReq) (echoResp, error) {
+		if req.Name == "" {
+			return echoResp{}, Fail("name_required", map[string]any{"detail": "who?"})
+		}
+		return echoResp{Greeting: "hi " + req.Name}, nil
+	})
+	Register(r, "boom", func(context.Context, struct{}) (Empty, error) {
+		panic("kaboom")
+	})
+
+	var logged []string
+	logf := func(format string, args ...any) { logged = append(logged, fmt.Sprintf(format, args...)) }
+	var timed []string
+	r.Use(
+		Timing(func(method string, _ time.Duration, _ error) { timed = append(timed, method) }),
+		Recover(logf),
+	)
+
+	resp, err := r.Call(context.Background(), "echo", json.RawMessage(`{"type":"echo","name":"bob"}`))
+	if err != nil || resp.(echoResp).Greeting != "hi bob" {
+		t.Fatalf("echo: %v %v", resp, err)
+	}
+
+	_, err = r.Call(context.Background(), "echo", nil)
+	var e *Error
+	if !errors.As(err, &e) || e.Code != "name_required" || e.Data["detail"] != "who?" {
+		t.Fatalf("expected name_required, got %v", err)
+	}
+
+	_, err = r.Call(context.Background(), "echo", json.RawMessage(`{"name":5}`))
+	if AsError(err).Code != CodeBadRequest {
+		t.Fatalf("expected bad_request, got %v", err)
+	}
+
+	_, err = r.Call(context.Background(), "nope", nil)
+	if AsError(err).Code != CodeUnknownMethod {
+		t.Fatalf("expected unknown_rpc, got %v", err)
+	}
+
+	_, err = r.Call(context.Background(), "boom", nil)
+	if AsError(err).Code != CodeInternal || len(logged) != 1 || !strings.Contains(logged[0], "kaboom") {
+		t.Fatalf("panic not recovered: %v %q", err, logged)
+	}
+
+	if strings.Join(timed, ",") != "echo,echo,echo,nope,boom" {
+		t.Fatalf("timing saw %q", timed)
+	}
+	if got := strings.Join(r.Methods(), ","); got != "boom,echo" {
+		t.Fatalf("methods %q", got)
+	}
+}
+
+func TestExpose(t *testing.T) {
+	r := NewRegistry()
+	Register(r, "state", func(context.Context, struct{}) (Empty, error) { return Empty{}, nil })
+	Register(r, "trust_host", func(context.Context, struct{}) (Empty, error) { return Empty{}, nil })
+	r.Expose("state", "missing")
+	if !r.Exposed("state") {
+		t.Fatal("exposed method not reported")
+	}
+	if r.Exposed("trust_host") || r.Exposed("missing") {
+		t.Fatal("methods are exposed only when registered and opted in")
+	}
+}
+
+func TestLogging(t *testing.T) {
+	var logged []string
+	mw := Logging(func(format string, args ...any) { logged = append(logged, fmt.Sprintf(format, args...)) }, time.Hour)
+	call := func(err error) {
+		_, _ = mw("m", func(context.Context, json.RawMessage) (any, error) { return nil, err })(context.Background(), nil)
+	}
+	call(nil)
+	call(Fail("password_required", nil))
+	call(errors.New("disk on fire"))
+	if len(logged) != 1 || !strings.Contains(logged[0], "disk on fire") {
+		t.Fatalf("logged %q", logged)
+	}
+}
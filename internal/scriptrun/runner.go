@@ -0,0 +1,544 @@
+package scriptrun
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ankouros/pterminal/internal/model"
+	"github.com/ankouros/pterminal/internal/sshclient"
+	"golang.org/x/crypto/ssh"
+)
+
+/*
+Script runner
+
+Runs a TeamScript non-interactively (one exec channel per host, no PTY) on a
+set of hosts with a concurrency limit, and keeps each run's per-host stdout,
+stderr, exit code and duration as history.
+*/
+
+const (
+	StatusQueued   = "queued"
+	StatusRunning  = "running"
+	StatusPassed   = "passed"
+	StatusFailed   = "failed" // command ran and exited non-zero
+	StatusError    = "error"  // could not connect or run the command
+	StatusCanceled = "canceled"
+
+	DefaultConcurrency = 4
+	MaxConcurrency     = 32
+	DefaultTimeout     = 5 * time.Minute
+
+	maxHistory     = 50
+	maxOutputBytes = 64 * 1024
+)
+
+type HostResult struct {
+	HostID   int    `json:"hostId"`
+	HostName string `json:"hostName"`
+	Address  string `json:"address,omitempty"`
+
+	Status   string `json:"status"`
+	ExitCode int    `json:"exitCode"`
+	Stdout   string `json:"stdout,omitempty"`
+	Stderr   string `json:"stderr,omitempty"`
+	Error    string `json:"error,omitempty"`
+
+	// Truncated is set when stdout or stderr exceeded the capture limit.
+	Truncated bool `json:"truncated,omitempty"`
+
+	StartedAt  int64 `json:"startedAt,omitempty"` // unix ms
+	DurationMs int64 `json:"durationMs,omitempty"`
+}
+
+type Run struct {
+	ID         string `json:"id"`
+	ScriptID   string `json:"scriptId,omitempty"`
+	ScriptName string `json:"scriptName"`
+	Command    string `json:"command"`
+
+	StartedAt  int64 `json:"startedAt"`            // unix ms
+	FinishedAt int64 `json:"finishedAt,omitempty"` // unix ms; 0 while running
+	Done       bool  `json:"done"`
+
+	Passed int `json:"passed"`
+	Failed int `json:"failed"` // failed + error + canceled
+
+	Results []HostResult `json:"results"`
+}
+
+type Options struct {
+	Concurrency int
+	Timeout     time.Duration
+}
+
+// DialFunc opens an SSH client for host.
+type DialFunc func(ctx context.Context, host model.Host) (*ssh.Client, error)
+
+type Runner struct {
+	mu sync.Mutex
+
+	cfg         model.AppConfig
+	historyPath string
+	dial        DialFunc
+
+	seq     int64
+	runs    []*Run // newest first
+	cancels map[string]context.CancelFunc
+	done    map[string]chan struct{} // closed when a run finishes
+}
+
+// NewRunner loads the run history from historyPath ("" keeps it in memory).
+func NewRunner(cfg model.AppConfig, historyPath string) *Runner {
+	r := &Runner{
+		cfg:         cfg,
+		historyPath: historyPath,
+		cancels:     make(map[string]context.CancelFunc),
+		done:        make(map[string]chan struct{}),
+	}
+	r.loadHistory()
+	return r
+}
+
+func (r *Runner) SetConfig(cfg model.AppConfig) {
+	r.mu.Lock()
+	r.cfg = cfg
+	r.mu.Unlock()
+}
+
+// SetDialer overrides how hosts are dialed (used by tests).
+func (r *Runner) SetDialer(dial DialFunc) {
+	r.mu.Lock()
+	r.dial = dial
+	r.mu.Unlock()
+}
+
+// ResolveHosts returns the hosts to run on: the given IDs, or every SSH host
+// in networkID when no IDs are given.
+func ResolveHosts(cfg model.AppConfig, hostIDs []int, networkID int) ([]model.Host, error) {
+	var out []model.Host
+	if len(hostIDs) > 0 {
+		want := make(map[int]struct{}, len(hostIDs))
+		for _, id := range hostIDs {
+			want[id] = struct{}{}
+		}
+		for _, n := range cfg.Networks {
+			for _, h := range n.Hosts {
+				if _, ok := want[h.ID]; ok && !h.Deleted {
+					out = append(out, h)
+					delete(want, h.ID)
+				}
+			}
+		}
+		if len(want) > 0 {
+			return nil, errors.New("some hosts were not found")
+		}
+	} else {
+		for _, n := range cfg.Networks {
+			if n.ID != networkID || n.Deleted {
+				continue
+			}
+			for _, h := range n.Hosts {
+				if !h.Deleted && (h.Driver == "" || h.Driver == model.DriverSSH) {
+					out = append(out, h)
+				}
+			}
+		}
+	}
+	if len(out) == 0 {
+		return nil, errors.New("no hosts selected")
+	}
+	return out, nil
+}
+
+// Start runs script on hosts in the background and returns the run ID.
+// pw supplies passwords for hosts (and jump hosts) that need one.
+func (r *Runner) Start(
+	script model.TeamScript,
+	hosts []model.Host,
+	opts Options,
+	pw func(hostID int) (string, error),
+) (string, error) {
+	if strings.TrimSpace(script.Command) == "" {
+		return "", errors.New("script has no command")
+	}
+	if len(hosts) == 0 {
+		return "", errors.New("no hosts selected")
+	}
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = DefaultConcurrency
+	}
+	if opts.Concurrency > MaxConcurrency {
+		opts.Concurrency = MaxConcurrency
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = DefaultTimeout
+	}
+
+	now := time.Now()
+	run := &Run{
+		ScriptID:   script.ID,
+		ScriptName: script.Name,
+		Command:    script.Command,
+		StartedAt:  now.UnixMilli(),
+		Results:    make([]HostResult, len(hosts)),
+	}
+	for i, h := range hosts {
+		run.Results[i] = HostResult{
+			HostID:   h.ID,
+			HostName: h.Name,
+			Address:  hostAddress(h),
+			Status:   StatusQueued,
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	r.mu.Lock()
+	r.seq++
+	run.ID = strconv.FormatInt(now.Unix(), 36) + "-" + strconv.FormatInt(r.seq, 10)
+	r.runs = append([]*Run{run}, r.runs...)
+	if len(r.runs) > maxHistory {
+		r.runs = r.runs[:maxHistory]
+	}
+	r.cancels[run.ID] = cancel
+	r.done[run.ID] = make(chan struct{})
+	cfg := r.cfg
+	dial := r.dial
+	r.mu.Unlock()
+
+	if dial == nil {
+		dial = func(ctx context.Context, host model.Host) (*ssh.Client, error) {
+			dopts, err := sshclient.ResolveDialOptions(cfg, host, pw)
+			if err != nil {
+				return nil, err
+			}
+			client, _, err := sshclient.DialClient(ctx, host, func() (string, error) {
+				if pw == nil {
+					return "", errors.New("password provider not set")
+				}
+				return pw(host.ID)
+			}, dopts)
+			return client, err
+		}
+	}
+
+	go r.execute(ctx, cancel, run, hosts, opts, dial)
+	return run.ID, nil
+}
+
+func (r *Runner) execute(
+	ctx context.Context,
+	cancel context.CancelFunc,
+	run *Run,
+	hosts []model.Host,
+	opts Options,
+	dial DialFunc,
+) {
+	defer cancel()
+
+	sem := make(chan struct{}, opts.Concurrency)
+	var wg sync.WaitGroup
+	for i, h := range hosts {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			r.update(run, i, func(res *HostResult) { res.Status = StatusCanceled })
+			continue
+		}
+
+		wg.Add(1)
+		go func(i int, h model.Host) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			r.runHost(ctx, run, i, h, opts.Timeout, dial)
+		}(i, h)
+	}
+	wg.Wait()
+
+	r.mu.Lock()
+	run.Done = true
+	run.FinishedAt = time.Now().UnixMilli()
+	run.Passed, run.Failed = 0, 0
+	for _, res := range run.Results {
+		if res.Status == StatusPassed {
+			run.Passed++
+		} else {
+			run.Failed++
+		}
+	}
+	delete(r.cancels, run.ID)
+	done := r.done[run.ID]
+	delete(r.done, run.ID)
+	r.mu.Unlock()
+
+	r.saveHistory()
+	if done != nil {
+		close(done)
+	}
+}
+
+func (r *Runner) runHost(
+	ctx context.Context,
+	run *Run,
+	i int,
+	host model.Host,
+	timeout time.Duration,
+	dial DialFunc,
+) {
+	started := time.Now()
+	r.update(run, i, func(res *HostResult) {
+		res.Status = StatusRunning
+		res.StartedAt = started.UnixMilli()
+	})
+
+	status, code, stdout, stderr, truncated, err := execOnHost(ctx, host, run.Command, timeout, dial)
+
+	r.update(run, i, func(res *HostResult) {
+		res.Status = status
+		res.ExitCode = code
+		res.Stdout = stdout
+		res.Stderr = stderr
+		res.Truncated = truncated
+		res.DurationMs = time.Since(started).Milliseconds()
+		if err != nil {
+			res.Error = err.Error()
+		}
+	})
+}
+
+func execOnHost(
+	ctx context.Context,
+	host model.Host,
+	command string,
+	timeout time.Duration,
+	dial DialFunc,
+) (status string, code int, stdout, stderr string, truncated bool, err error) {
+	if host.Driver != "" && host.Driver != model.DriverSSH {
+		return StatusError, -1, "", "", false, errors.New("not an SSH host")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	client, err := dial(ctx, host)
+	if err != nil {
+		return errorStatus(ctx), -1, "", "", false, err
+	}
+	defer client.Close()
+
+	sess, err := client.NewSession()
+	if err != nil {
+		return StatusError, -1, "", "", false, err
+	}
+	defer sess.Close()
+
+	outBuf := &limitedBuffer{max: maxOutputBytes}
+	errBuf := &limitedBuffer{max: maxOutputBytes}
+	sess.Stdout = outBuf
+	sess.Stderr = errBuf
+
+	done := make(chan error, 1)
+	go func() { done <- sess.Run(command) }()
+
+	var runErr error
+	select {
+	case runErr = <-done:
+	case <-ctx.Done():
+		_ = sess.Signal(ssh.SIGTERM)
+		_ = sess.Close()
+		<-done
+		return errorStatus(ctx), -1, outBuf.String(), errBuf.String(),
+			outBuf.truncated || errBuf.truncated, ctx.Err()
+	}
+
+	truncated = outBuf.truncated || errBuf.truncated
+	if runErr == nil {
+		return StatusPassed, 0, outBuf.String(), errBuf.String(), truncated, nil
+	}
+	var exitErr *ssh.ExitError
+	if errors.As(runErr, &exitErr) {
+		return StatusFailed, exitErr.ExitStatus(), outBuf.String(), errBuf.String(), truncated, nil
+	}
+	return StatusError, -1, outBuf.String(), errBuf.String(), truncated, runErr
+}
+
+func errorStatus(ctx context.Context) string {
+	if errors.Is(ctx.Err(), context.Canceled) {
+		return StatusCanceled
+	}
+	return StatusError
+}
+
+func (r *Runner) update(run *Run, i int, fn func(res *HostResult)) {
+	r.mu.Lock()
+	fn(&run.Results[i])
+	r.mu.Unlock()
+}
+
+// Cancel stops a running run. Hosts not yet finished are marked canceled.
+func (r *Runner) Cancel(id string) bool {
+	r.mu.Lock()
+	cancel := r.cancels[id]
+	r.mu.Unlock()
+	if cancel == nil {
+		return false
+	}
+	cancel()
+	return true
+}
+
+// Wait blocks until run id finishes (or ctx ends) and returns it.
+func (r *Runner) Wait(ctx context.Context, id string) (Run, error) {
+	r.mu.Lock()
+	done := r.done[id]
+	r.mu.Unlock()
+	if done != nil {
+		select {
+		case <-done:
+		case <-ctx.Done():
+			return Run{}, ctx.Err()
+		}
+	}
+	run, ok := r.Get(id)
+	if !ok {
+		return Run{}, errors.New("run not found")
+	}
+	return run, nil
+}
+
+// Runs returns copies of the run history, newest first.
+func (r *Runner) Runs() []Run {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	out := make([]Run, 0, len(r.runs))
+	for _, run := range r.runs {
+		out = append(out, copyRun(run))
+	}
+	return out
+}
+
+// Get returns a copy of one run.
+func (r *Runner) Get(id string) (Run, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, run := range r.runs {
+		if run.ID == id {
+			return copyRun(run), true
+		}
+	}
+	return Run{}, false
+}
+
+func copyRun(run *Run) Run {
+	c := *run
+	c.Results = append([]HostResult(nil), run.Results...)
+	return c
+}
+
+/*
+History
+*/
+
+func (r *Runner) loadHistory() {
+	if r.historyPath == "" {
+		return
+	}
+	data, err := os.ReadFile(r.historyPath)
+	if err != nil {
+		return
+	}
+	var runs []*Run
+	if err := json.Unmarshal(data, &runs); err != nil {
+		return
+	}
+	for _, run := range runs {
+		// A run interrupted by a restart will never finish.
+		if !run.Done {
+			run.Done = true
+			for i := range run.Results {
+				switch run.Results[i].Status {
+				case StatusQueued, StatusRunning:
+					run.Results[i].Status = StatusCanceled
+				}
+			}
+		}
+	}
+	if len(runs) > maxHistory {
+		runs = runs[:maxHistory]
+	}
+	r.runs = runs
+}
+
+func (r *Runner) saveHistory() {
+	if r.historyPath == "" {
+		return
+	}
+	r.mu.Lock()
+	data, err := json.MarshalIndent(r.runs, "", "  ")
+	r.mu.Unlock()
+	if err != nil {
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(r.historyPath), 0o700); err != nil {
+		return
+	}
+	tmp := r.historyPath + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return
+	}
+	_ = os.Rename(tmp, r.historyPath)
+}
+
+/*
+Helpers
+*/
+
+func hostAddress(h model.Host) string {
+	addr := h.Host
+	if h.User != "" {
+		addr = h.User + "@" + addr
+	}
+	if h.Port > 0 && h.Port != 22 {
+		addr += ":" + strconv.Itoa(h.Port)
+	}
+	return addr
+}
+
+// limitedBuffer keeps the first max bytes and drops the rest.
+type limitedBuffer struct {
+	mu        sync.Mutex
+	buf       bytes.Buffer
+	max       int
+	truncated bool
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if room := b.max - b.buf.Len(); room < len(p) {
+		if room > 0 {
+			b.buf.Write(p[:room])
+		}
+		b.truncated = true
+		return len(p), nil
+	}
+	b.buf.Write(p)
+	return len(p), nil
+}
+
+func (b *limitedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
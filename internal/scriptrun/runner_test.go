@@ -0,0 +1,270 @@
+package scriptrun
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"net"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ankouros/pterminal/internal/model"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestRunnerCapturesResults(t *testing.T) {
+	srv := startExecServer(t)
+	history := filepath.Join(t.TempDir(), "script-runs.json")
+
+	r := NewRunner(model.AppConfig{}, history)
+	r.SetDialer(srv.dial)
+
+	hosts := []model.Host{
+		{ID: 1, Name: "ok", Host: "10.0.0.1", User: "root", Port: 22},
+		{ID: 2, Name: "bad", Host: "10.0.0.2", Port: 2222},
+		{ID: 3, Name: "down", Host: "unreachable"},
+		{ID: 4, Name: "console", Driver: model.DriverTelecom},
+	}
+	id, err := r.Start(model.TeamScript{ID: "s1", Name: "verify", Command: "check"}, hosts, Options{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	run := waitRun(t, r, id)
+
+	if run.Passed != 1 || run.Failed != 3 {
+		t.Fatalf("unexpected counts: %+v", run)
+	}
+	want := []struct {
+		status string
+		code   int
+		stdout string
+		stderr string
+	}{
+		{StatusPassed, 0, "ok from 10.0.0.1\n", ""},
+		{StatusFailed, 3, "", "boom on 10.0.0.2\n"},
+		{StatusError, -1, "", ""},
+		{StatusError, -1, "", ""},
+	}
+	for i, w := range want {
+		res := run.Results[i]
+		if res.Status != w.status || res.ExitCode != w.code || res.Stdout != w.stdout || res.Stderr != w.stderr {
+			t.Fatalf("host %d: got %+v, want %+v", i, res, w)
+		}
+	}
+	if run.Results[0].Address != "root@10.0.0.1" || run.Results[1].Address != "10.0.0.2:2222" {
+		t.Fatalf("unexpected addresses: %+v", run.Results)
+	}
+	if run.Results[2].Error == "" || run.Results[3].Error != "not an SSH host" {
+		t.Fatalf("expected errors: %+v", run.Results[2:])
+	}
+
+	// History survives a restart.
+	again := NewRunner(model.AppConfig{}, history)
+	got, ok := again.Get(id)
+	if !ok || !got.Done || got.Results[1].ExitCode != 3 {
+		t.Fatalf("history not restored: %+v", got)
+	}
+}
+
+func TestRunnerConcurrencyLimitAndCancel(t *testing.T) {
+	srv := startExecServer(t)
+	r := NewRunner(model.AppConfig{}, "")
+	r.SetDialer(srv.dial)
+
+	var hosts []model.Host
+	for i := 1; i <= 6; i++ {
+		hosts = append(hosts, model.Host{ID: i, Name: "n", Host: "10.0.0.1"})
+	}
+	id, err := r.Start(model.TeamScript{Name: "wait", Command: "block"}, hosts, Options{Concurrency: 2}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for srv.running.Load() < 2 {
+		if time.Now().After(deadline) {
+			t.Fatal("commands did not start")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if n := srv.maxRunning.Load(); n != 2 {
+		t.Fatalf("expected 2 concurrent commands, saw %d", n)
+	}
+
+	if !r.Cancel(id) {
+		t.Fatal("cancel returned false")
+	}
+	run := waitRun(t, r, id)
+	for _, res := range run.Results {
+		if res.Status != StatusCanceled {
+			t.Fatalf("expected canceled results, got %+v", run.Results)
+		}
+	}
+}
+
+func TestResolveHosts(t *testing.T) {
+	cfg := model.AppConfig{Networks: []model.Network{{
+		ID: 1,
+		Hosts: []model.Host{
+			{ID: 1, Name: "a"},
+			{ID: 2, Name: "b", Deleted: true},
+			{ID: 3, Name: "c", Driver: model.DriverTelecom},
+		},
+	}}}
+
+	hosts, err := ResolveHosts(cfg, nil, 1)
+	if err != nil || len(hosts) != 1 || hosts[0].ID != 1 {
+		t.Fatalf("network resolve: %+v %v", hosts, err)
+	}
+	if _, err := ResolveHosts(cfg, []int{1, 2}, 0); err == nil {
+		t.Fatal("expected error for deleted host")
+	}
+	if _, err := ResolveHosts(cfg, nil, 9); err == nil {
+		t.Fatal("expected error for empty selection")
+	}
+}
+
+func waitRun(t *testing.T, r *Runner, id string) Run {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	run, err := r.Wait(ctx, id)
+	if err != nil {
+		t.Fatalf("run %s: %v", id, err)
+	}
+	if !run.Done {
+		t.Fatalf("run returned before it finished: %+v", run)
+	}
+	return run
+}
+
+/*
+Test SSH server
+
+exec "check" prints to stdout and exits 0 on 10.0.0.1, and prints to stderr
+and exits 3 elsewhere. exec "block" waits until the channel is closed.
+*/
+
+type execServer struct {
+	addr       string
+	running    atomic.Int32
+	maxRunning atomic.Int32
+}
+
+func startExecServer(t *testing.T) *execServer {
+	t.Helper()
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = ln.Close() })
+
+	srv := &execServer{addr: ln.Addr().String()}
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go srv.serve(c, signer)
+		}
+	}()
+	return srv
+}
+
+// dial connects as user "<host address>" so the server knows which host it plays.
+func (s *execServer) dial(ctx context.Context, host model.Host) (*ssh.Client, error) {
+	if host.Host == "unreachable" {
+		return nil, errors.New("dial tcp: connection refused")
+	}
+	return ssh.Dial("tcp", s.addr, &ssh.ClientConfig{
+		User:            host.Host,
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		Timeout:         5 * time.Second,
+	})
+}
+
+func (s *execServer) serve(c net.Conn, signer ssh.Signer) {
+	defer c.Close()
+	cfg := &ssh.ServerConfig{NoClientAuth: true}
+	cfg.AddHostKey(signer)
+	sc, chans, reqs, err := ssh.NewServerConn(c, cfg)
+	if err != nil {
+		return
+	}
+	defer sc.Close()
+	go ssh.DiscardRequests(reqs)
+
+	for nc := range chans {
+		if nc.ChannelType() != "session" {
+			_ = nc.Reject(ssh.UnknownChannelType, "unsupported")
+			continue
+		}
+		ch, chReqs, err := nc.Accept()
+		if err != nil {
+			continue
+		}
+		go s.session(sc.User(), ch, chReqs)
+	}
+}
+
+func (s *execServer) session(addr string, ch ssh.Channel, reqs <-chan *ssh.Request) {
+	defer ch.Close()
+	closed := make(chan struct{})
+	defer close(closed)
+	var once sync.Once
+	for req := range reqs {
+		if req.Type != "exec" {
+			if req.WantReply {
+				_ = req.Reply(false, nil)
+			}
+			continue
+		}
+		var p struct{ Command string }
+		_ = ssh.Unmarshal(req.Payload, &p)
+		_ = req.Reply(true, nil)
+
+		once.Do(func() {
+			go func() {
+				status := uint32(0)
+				switch strings.TrimSpace(p.Command) {
+				case "check":
+					if addr == "10.0.0.1" {
+						_, _ = ch.Write([]byte("ok from " + addr + "\n"))
+					} else {
+						_, _ = ch.Stderr().Write([]byte("boom on " + addr + "\n"))
+						status = 3
+					}
+				case "block":
+					n := s.running.Add(1)
+					for {
+						m := s.maxRunning.Load()
+						if n <= m || s.maxRunning.CompareAndSwap(m, n) {
+							break
+						}
+					}
+					<-closed // the request stream ends when the client closes the channel
+					s.running.Add(-1)
+					return
+				}
+				_, _ = ch.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{status}))
+				_ = ch.Close()
+			}()
+		})
+	}
+}
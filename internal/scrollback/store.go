@@ -0,0 +1,332 @@
+package scrollback
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ankouros/pterminal/internal/config"
+	"github.com/ankouros/pterminal/internal/model"
+)
+
+/*
+Scrollback store
+
+Terminal output is kept per host/tab in memory, capped at MaxBytes, and
+written to <dir>/<host UID>-t<tab>.log by a background flusher. Hosts are
+named by UID rather than their numeric ID, which is local to one config and
+can be handed to another host after an import or a sync; hosts without a UID
+are not stored. Each flush
+replaces the whole file, so it never grows past the cap. Files older than
+MaxAge are ignored on restore and deleted when the store opens.
+*/
+
+const (
+	Ext = ".log"
+
+	DefaultMaxBytes = 1 << 20
+	DefaultMaxAge   = 7 * 24 * time.Hour
+
+	flushInterval = 2 * time.Second
+)
+
+// Options control where scrollback is stored and how much is kept.
+type Options struct {
+	Dir      string
+	MaxBytes int
+	MaxAge   time.Duration
+}
+
+type tabKey struct {
+	hostUID string
+	tabID   int
+}
+
+type tabLog struct {
+	data    []byte
+	updated time.Time
+	dirty   bool
+}
+
+type Store struct {
+	opts Options
+
+	mu     sync.Mutex
+	logs   map[tabKey]*tabLog
+	closed bool
+
+	// fileMu orders file writes and removals, so a flush in progress cannot
+	// bring back a file Remove just deleted.
+	fileMu sync.Mutex
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// Dir resolves the scrollback directory from the app settings.
+func Dir(settings *model.ScrollbackSettings) (string, error) {
+	if settings != nil && strings.TrimSpace(settings.Dir) != "" {
+		dir := strings.TrimSpace(settings.Dir)
+		if dir == "~" || strings.HasPrefix(dir, "~/") {
+			home, err := os.UserHomeDir()
+			if err != nil {
+				return "", err
+			}
+			dir = filepath.Join(home, strings.TrimPrefix(dir, "~"))
+		}
+		return dir, nil
+	}
+	p, err := config.ConfigPath()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(filepath.Dir(p), "scrollback"), nil
+}
+
+// OptionsFrom resolves the app settings against the defaults.
+func OptionsFrom(settings *model.ScrollbackSettings) (Options, error) {
+	dir, err := Dir(settings)
+	if err != nil {
+		return Options{}, err
+	}
+	opts := Options{Dir: dir, MaxBytes: DefaultMaxBytes, MaxAge: DefaultMaxAge}
+	if settings != nil && settings.MaxKB > 0 {
+		opts.MaxBytes = settings.MaxKB << 10
+	}
+	if settings != nil && settings.MaxAgeHours > 0 {
+		opts.MaxAge = time.Duration(settings.MaxAgeHours) * time.Hour
+	}
+	return opts, nil
+}
+
+// Open creates the store directory, deletes expired files and starts the
+// background flusher.
+func Open(opts Options) (*Store, error) {
+	if strings.TrimSpace(opts.Dir) == "" {
+		return nil, errors.New("scrollback dir is required")
+	}
+	if opts.MaxBytes <= 0 {
+		opts.MaxBytes = DefaultMaxBytes
+	}
+	if opts.MaxAge <= 0 {
+		opts.MaxAge = DefaultMaxAge
+	}
+	if err := os.MkdirAll(opts.Dir, 0o700); err != nil {
+		return nil, err
+	}
+
+	s := &Store{
+		opts: opts,
+		logs: make(map[tabKey]*tabLog),
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	s.prune()
+	go s.flusher()
+	return s, nil
+}
+
+func (s *Store) path(k tabKey) string {
+	name := strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_' {
+			return r
+		}
+		return '_'
+	}, k.hostUID)
+	return filepath.Join(s.opts.Dir, fmt.Sprintf("%s-t%d%s", name, k.tabID, Ext))
+}
+
+// logLocked returns the in-memory log for k, loading a fresh file on first use
+// so new output extends what an earlier run stored.
+func (s *Store) logLocked(k tabKey) *tabLog {
+	if l := s.logs[k]; l != nil {
+		return l
+	}
+	l := &tabLog{}
+	path := s.path(k)
+	if fi, err := os.Stat(path); err == nil && time.Since(fi.ModTime()) < s.opts.MaxAge {
+		if b, err := os.ReadFile(path); err == nil {
+			l.data = append([]byte(nil), tail(b, s.opts.MaxBytes)...)
+			l.updated = fi.ModTime()
+		}
+	}
+	s.logs[k] = l
+	return l
+}
+
+// Append adds terminal output for a tab.
+func (s *Store) Append(hostUID string, tabID int, p []byte) {
+	if len(p) == 0 || hostUID == "" {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+	l := s.logLocked(tabKey{hostUID, tabID})
+	l.data = append(l.data, p...)
+	// Trim with slack so a burst of small chunks does not copy the whole
+	// buffer each time.
+	if len(l.data) > 2*s.opts.MaxBytes {
+		l.data = append([]byte(nil), tail(l.data, s.opts.MaxBytes)...)
+	}
+	l.updated = time.Now()
+	l.dirty = true
+}
+
+// Restore returns the stored scrollback for a tab and when it was last
+// written. ok is false when there is nothing (fresh enough) to restore.
+func (s *Store) Restore(hostUID string, tabID int) (data []byte, updated time.Time, ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed || hostUID == "" {
+		return nil, time.Time{}, false
+	}
+	l := s.logLocked(tabKey{hostUID, tabID})
+	data = tail(l.data, s.opts.MaxBytes)
+	if len(data) == 0 {
+		return nil, time.Time{}, false
+	}
+	return append([]byte(nil), data...), l.updated, true
+}
+
+// Remove forgets a tab's scrollback and deletes its file.
+func (s *Store) Remove(hostUID string, tabID int) {
+	if hostUID == "" {
+		return
+	}
+	k := tabKey{hostUID, tabID}
+	s.fileMu.Lock()
+	defer s.fileMu.Unlock()
+	s.mu.Lock()
+	delete(s.logs, k)
+	s.mu.Unlock()
+	_ = os.Remove(s.path(k))
+}
+
+// Flush writes every changed tab to disk.
+func (s *Store) Flush() error {
+	type pending struct {
+		path string
+		data []byte
+	}
+	s.fileMu.Lock()
+	defer s.fileMu.Unlock()
+	s.mu.Lock()
+	var out []pending
+	for k, l := range s.logs {
+		if !l.dirty {
+			continue
+		}
+		l.dirty = false
+		out = append(out, pending{path: s.path(k), data: append([]byte(nil), tail(l.data, s.opts.MaxBytes)...)})
+	}
+	s.mu.Unlock()
+
+	var errs []error
+	for _, p := range out {
+		if err := writeFile(p.path, p.data); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Close stops the flusher and writes pending output.
+func (s *Store) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	s.mu.Unlock()
+
+	close(s.stop)
+	<-s.done
+	return s.Flush()
+}
+
+// Clear closes the store and deletes all stored scrollback.
+func (s *Store) Clear() error {
+	_ = s.Close()
+	entries, err := os.ReadDir(s.opts.Dir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	for _, e := range entries {
+		if !e.IsDir() && strings.HasSuffix(e.Name(), Ext) {
+			_ = os.Remove(filepath.Join(s.opts.Dir, e.Name()))
+		}
+	}
+	return nil
+}
+
+func (s *Store) flusher() {
+	defer close(s.done)
+	t := time.NewTicker(flushInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-t.C:
+			_ = s.Flush()
+		}
+	}
+}
+
+func (s *Store) prune() {
+	entries, err := os.ReadDir(s.opts.Dir)
+	if err != nil {
+		return
+	}
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), Ext) {
+			continue
+		}
+		fi, err := e.Info()
+		if err != nil {
+			continue
+		}
+		if time.Since(fi.ModTime()) >= s.opts.MaxAge {
+			_ = os.Remove(filepath.Join(s.opts.Dir, e.Name()))
+		}
+	}
+}
+
+// writeFile replaces path atomically so a crash never leaves a torn file.
+func writeFile(path string, data []byte) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// tail returns the last max bytes of b. When it has to cut, it also drops the
+// partial first line, so replay does not start inside an escape sequence or a
+// multi-byte character.
+func tail(b []byte, max int) []byte {
+	if len(b) <= max {
+		return b
+	}
+	b = b[len(b)-max:]
+	if i := bytes.IndexByte(b, '\n'); i >= 0 && i < len(b)-1 {
+		b = b[i+1:]
+	}
+	return b
+}
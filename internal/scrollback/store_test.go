@@ -0,0 +1,122 @@
+package scrollback
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStorePersistsAcrossReopen(t *testing.T) {
+	dir := t.TempDir()
+	s, err := Open(Options{Dir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Append("host-1", 2, []byte("$ uptime\r\n"))
+	s.Append("host-1", 2, []byte(" 10:00 up 3 days\r\n"))
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	fi, err := os.Stat(filepath.Join(dir, "host-1-t2.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0o600 {
+		t.Fatalf("unexpected mode %v", fi.Mode())
+	}
+
+	again, err := Open(Options{Dir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer again.Close()
+	data, at, ok := again.Restore("host-1", 2)
+	if !ok || string(data) != "$ uptime\r\n 10:00 up 3 days\r\n" || at.IsZero() {
+		t.Fatalf("unexpected restore: %q %v %v", data, at, ok)
+	}
+	if _, _, ok := again.Restore("host-1", 3); ok {
+		t.Fatal("unexpected scrollback for another tab")
+	}
+
+	// New output extends the restored history.
+	again.Append("host-1", 2, []byte("$ exit\r\n"))
+	if err := again.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	b, _ := os.ReadFile(filepath.Join(dir, "host-1-t2.log"))
+	if !strings.HasSuffix(string(b), "days\r\n$ exit\r\n") {
+		t.Fatalf("history not extended: %q", b)
+	}
+
+	again.Remove("host-1", 2)
+	if _, err := os.Stat(filepath.Join(dir, "host-1-t2.log")); !os.IsNotExist(err) {
+		t.Fatalf("file not removed: %v", err)
+	}
+}
+
+func TestStoreCapsSizeAtLineBoundary(t *testing.T) {
+	s, err := Open(Options{Dir: t.TempDir(), MaxBytes: 64})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	for i := 0; i < 50; i++ {
+		s.Append("host-1", 1, []byte("line of output\r\n"))
+	}
+	data, _, ok := s.Restore("host-1", 1)
+	if !ok || len(data) > 64 {
+		t.Fatalf("cap not applied: %d bytes", len(data))
+	}
+	if !bytes.HasPrefix(data, []byte("line of output\r\n")) {
+		t.Fatalf("restore starts mid-line: %q", data)
+	}
+}
+
+func TestStoreDropsExpired(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "host-4-t1.log")
+	if err := os.WriteFile(path, []byte("old\r\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-2 * time.Hour)
+	if err := os.Chtimes(path, old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := Open(Options{Dir: dir, MaxAge: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if _, _, ok := s.Restore("host-4", 1); ok {
+		t.Fatal("expired scrollback restored")
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("expired file not pruned: %v", err)
+	}
+}
+
+func TestStoreNamesFilesByHostUID(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "sb")
+	s, err := Open(Options{Dir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Append("../../evil", 1, []byte("x\r\n"))
+	s.Append("", 1, []byte("no uid\r\n"))
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name() != "______evil-t1.log" {
+		t.Fatalf("unexpected files: %v", entries)
+	}
+}
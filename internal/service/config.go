@@ -0,0 +1,218 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"strings"
+
+	"github.com/ankouros/pterminal/internal/config"
+	"github.com/ankouros/pterminal/internal/model"
+	"github.com/ankouros/pterminal/internal/p2p"
+	"github.com/ankouros/pterminal/internal/rpc"
+	"github.com/ankouros/pterminal/internal/teamrepo"
+)
+
+// ConfigRequest carries a config edited by the UI.
+type ConfigRequest struct {
+	Config json.RawMessage `json:"config"`
+}
+
+// ConfigResponse is the active config.
+type ConfigResponse struct {
+	Config model.AppConfig `json:"config"`
+}
+
+// ImportRequest imports hosts into a network (created when missing).
+type ImportRequest struct {
+	Path        string `json:"path"`
+	NetworkName string `json:"networkName"`
+	MatchMode   string `json:"matchMode"`
+}
+
+// ImportResponse is the config after an import and what changed.
+type ImportResponse struct {
+	Config       model.AppConfig              `json:"config"`
+	ImportPath   string                       `json:"importPath"`
+	BackupPath   string                       `json:"backupPath,omitempty"`
+	Summary      *config.SamakiaImportSummary `json:"summary,omitempty"`
+	Disconnected bool                         `json:"disconnected,omitempty"`
+}
+
+// NetworkRequest names a network.
+type NetworkRequest struct {
+	NetworkID int `json:"networkId"`
+}
+
+// ReportRequest writes an import summary as json (default), csv or md.
+type ReportRequest struct {
+	Summary json.RawMessage `json:"summary"`
+	Format  string          `json:"format"`
+	Path    string          `json:"path"`
+}
+
+// PresenceResponse lists the LAN peers and the local user.
+type PresenceResponse struct {
+	Peers       []p2p.PeerInfo    `json:"peers"`
+	User        model.UserProfile `json:"user"`
+	Fingerprint string            `json:"fingerprint,omitempty"`
+	TeamKeys    map[string]int    `json:"teamKeys,omitempty"`
+}
+
+// TeamPathsResponse maps team IDs to their repository directories.
+type TeamPathsResponse struct {
+	Paths map[string]string `json:"paths"`
+}
+
+func (s *Service) registerConfig(r *rpc.Registry) {
+	rpc.Register(r, "config_get", s.configGet)
+	rpc.Register(r, "config_save", s.configSave)
+	rpc.Register(r, "config_export", func(context.Context, struct{}) (PathResponse, error) {
+		path, err := config.ExportToDownloads()
+		if err != nil {
+			return PathResponse{}, rpc.Fail("export_failed", nil)
+		}
+		return PathResponse{Path: path}, nil
+	})
+	rpc.Register(r, "ssh_config_import", func(_ context.Context, req ImportRequest) (ImportResponse, error) {
+		path := strings.TrimSpace(req.Path)
+		if path == "" {
+			path = "~/.ssh/config"
+		}
+		return s.importHosts(config.ImportSSHConfig, path, req.NetworkName, req.MatchMode)
+	})
+	rpc.Register(r, "ssh_config_export", func(_ context.Context, req NetworkRequest) (PathResponse, error) {
+		path, err := config.ExportNetworkSSHConfig(s.mgr.Config(), req.NetworkID)
+		if err != nil {
+			return PathResponse{}, rpc.FailDetail("export_failed", err)
+		}
+		return PathResponse{Path: path}, nil
+	})
+	rpc.Register(r, "samakia_import_report", s.importReport)
+
+	rpc.Register(r, "teams_presence", func(context.Context, struct{}) (PresenceResponse, error) {
+		if s.p2p == nil {
+			return PresenceResponse{Peers: []p2p.PeerInfo{}, User: s.mgr.Config().User}, nil
+		}
+		presence := s.p2p.Presence()
+		return PresenceResponse{
+			Peers:       presence.Peers,
+			User:        presence.User,
+			Fingerprint: presence.Fingerprint,
+			TeamKeys:    presence.TeamKeys,
+		}, nil
+	})
+	rpc.Register(r, "team_repo_paths", s.teamRepoPaths)
+
+	r.Expose("config_get", "config_save", "config_export", "ssh_config_import", "ssh_config_export",
+		"samakia_import_report", "teams_presence", "team_repo_paths")
+}
+
+func (s *Service) configGet(context.Context, struct{}) (ConfigResponse, error) {
+	cfg, _, err := config.EnsureConfig()
+	if err != nil {
+		return ConfigResponse{}, rpc.Fail("config_load_failed", nil)
+	}
+	s.ApplyConfig(cfg)
+	return ConfigResponse{Config: cfg}, nil
+}
+
+func (s *Service) configSave(_ context.Context, req ConfigRequest) (ConfigResponse, error) {
+	var incoming model.AppConfig
+	if err := json.Unmarshal(req.Config, &incoming); err != nil {
+		return ConfigResponse{}, rpc.Fail("config_save_failed", nil)
+	}
+	if s.p2p != nil {
+		incoming = s.p2p.RegisterDevice(incoming)
+	}
+	updated, _ := p2p.ApplyLocalEdits(s.mgr.Config(), incoming)
+	_ = config.StripSecrets(&updated)
+	if s.p2p != nil {
+		updated = s.p2p.SignConfig(updated)
+	}
+	if err := config.Save(updated); err != nil {
+		return ConfigResponse{}, rpc.Fail("config_save_failed", nil)
+	}
+	s.ApplyConfig(updated)
+	s.syncNow()
+	return ConfigResponse{Config: updated}, nil
+}
+
+// ImportConfig replaces the config with the file at path (backing the old one
+// up). Every session is disconnected first: the import may reuse host IDs.
+func (s *Service) ImportConfig(path string) (ImportResponse, error) {
+	cfg, backup, err := config.ImportFromFile(path)
+	if err != nil {
+		return ImportResponse{}, rpc.FailDetail("import_failed", err)
+	}
+	s.mgr.DisconnectAll()
+	s.sftp.DisconnectAll()
+	s.Reset()
+	s.ApplyConfig(cfg)
+	s.syncNow()
+	return ImportResponse{Config: cfg, ImportPath: path, BackupPath: backup, Disconnected: true}, nil
+}
+
+// ImportSamakiaInventory imports a Samakia inventory file into networkName.
+func (s *Service) ImportSamakiaInventory(path, networkName, matchMode string) (ImportResponse, error) {
+	return s.importHosts(config.ImportSamakiaInventory, path, networkName, matchMode)
+}
+
+type hostImporter func(cfg model.AppConfig, path, networkName, matchMode string) (model.AppConfig, config.SamakiaImportSummary, error)
+
+func (s *Service) importHosts(importer hostImporter, path, networkName, matchMode string) (ImportResponse, error) {
+	updated, summary, err := importer(s.mgr.Config(), path, networkName, matchMode)
+	if err != nil {
+		return ImportResponse{}, rpc.FailDetail("import_failed", err)
+	}
+	if err := config.Save(updated); err != nil {
+		return ImportResponse{}, rpc.FailDetail("config_save_failed", err)
+	}
+	s.ApplyConfig(updated)
+	s.syncNow()
+	return ImportResponse{Config: updated, ImportPath: path, Summary: &summary}, nil
+}
+
+func (s *Service) syncNow() {
+	if s.p2p != nil {
+		s.p2p.SyncNow()
+	}
+}
+
+func (s *Service) importReport(_ context.Context, req ReportRequest) (PathResponse, error) {
+	var summary config.SamakiaImportSummary
+	if err := json.Unmarshal(req.Summary, &summary); err != nil {
+		return PathResponse{}, rpc.Fail("report_failed", map[string]any{"detail": "invalid summary payload"})
+	}
+
+	var path string
+	var err error
+	switch strings.ToLower(strings.TrimSpace(req.Format)) {
+	case "csv":
+		path, err = config.ExportSamakiaImportReportCSV(summary, req.Path)
+	case "md", "markdown":
+		path, err = config.ExportSamakiaImportReportMarkdown(summary, req.Path)
+	default:
+		path, err = config.ExportSamakiaImportReport(summary, req.Path)
+	}
+	if err != nil {
+		return PathResponse{}, rpc.FailDetail("report_failed", err)
+	}
+	return PathResponse{Path: path}, nil
+}
+
+func (s *Service) teamRepoPaths(context.Context, struct{}) (TeamPathsResponse, error) {
+	p, err := config.ConfigPath()
+	if err != nil {
+		return TeamPathsResponse{}, rpc.Fail("config_load_failed", nil)
+	}
+	base := filepath.Dir(p)
+	paths := map[string]string{}
+	for _, t := range s.mgr.Config().Teams {
+		if t.ID == "" {
+			continue
+		}
+		paths[t.ID] = teamrepo.TeamDir(base, t.ID)
+	}
+	return TeamPathsResponse{Paths: paths}, nil
+}
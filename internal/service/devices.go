@@ -0,0 +1,108 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/ankouros/pterminal/internal/p2p"
+	"github.com/ankouros/pterminal/internal/rpc"
+)
+
+// DevicesResponse lists the pinned LAN sync peers and this device's key.
+type DevicesResponse struct {
+	Fingerprint string       `json:"fingerprint"`
+	Devices     []p2p.Device `json:"devices"`
+}
+
+// RejectionsResponse lists remote changes left out of LAN sync merges.
+type RejectionsResponse struct {
+	Rejections []p2p.Rejection `json:"rejections"`
+}
+
+// DeviceRequest names a pinned device by key fingerprint.
+type DeviceRequest struct {
+	Fingerprint string `json:"fingerprint"`
+	Blocked     bool   `json:"blocked"`
+}
+
+// TeamBundleRequest exports a team to Path (default: ~/Downloads) or imports
+// the bundle at Path. Passphrase wraps the team key for devices without it.
+type TeamBundleRequest struct {
+	TeamID     string `json:"teamId"`
+	Path       string `json:"path"`
+	Passphrase string `json:"passphrase"`
+}
+
+// TeamBundleResponse describes an imported team bundle.
+type TeamBundleResponse struct {
+	Bundle p2p.BundleResult `json:"bundle"`
+}
+
+func (s *Service) registerDevices(r *rpc.Registry) {
+	rpc.Register(r, "p2p_devices", func(context.Context, struct{}) (DevicesResponse, error) {
+		if s.p2p == nil {
+			return DevicesResponse{Devices: []p2p.Device{}}, nil
+		}
+		return DevicesResponse{Fingerprint: s.p2p.Presence().Fingerprint, Devices: s.p2p.Devices()}, nil
+	})
+	rpc.Register(r, "p2p_rejections", func(context.Context, struct{}) (RejectionsResponse, error) {
+		if s.p2p == nil {
+			return RejectionsResponse{Rejections: []p2p.Rejection{}}, nil
+		}
+		return RejectionsResponse{Rejections: s.p2p.Rejections()}, nil
+	})
+	rpc.Register(r, "team_bundle_export", func(_ context.Context, req TeamBundleRequest) (PathResponse, error) {
+		if s.p2p == nil {
+			return PathResponse{}, rpc.Fail("p2p_unavailable", nil)
+		}
+		if req.TeamID == "" {
+			return PathResponse{}, rpc.Fail(rpc.CodeBadRequest, nil)
+		}
+		path, err := s.p2p.ExportTeamBundle(req.TeamID, strings.TrimSpace(req.Path), req.Passphrase)
+		if err != nil {
+			return PathResponse{}, rpc.FailDetail("export_failed", err)
+		}
+		return PathResponse{Path: path}, nil
+	})
+	rpc.Register(r, "team_bundle_import", func(_ context.Context, req TeamBundleRequest) (TeamBundleResponse, error) {
+		if s.p2p == nil {
+			return TeamBundleResponse{}, rpc.Fail("p2p_unavailable", nil)
+		}
+		path := strings.TrimSpace(req.Path)
+		if path == "" {
+			return TeamBundleResponse{}, rpc.Fail(rpc.CodeBadRequest, nil)
+		}
+		res, err := s.p2p.ImportTeamBundle(path, req.Passphrase)
+		if errors.Is(err, p2p.ErrBundlePassphrase) {
+			return TeamBundleResponse{}, rpc.FailDetail("passphrase_required", err)
+		}
+		if err != nil {
+			return TeamBundleResponse{}, rpc.FailDetail("import_failed", err)
+		}
+		return TeamBundleResponse{Bundle: res}, nil
+	})
+	rpc.Register(r, "p2p_device_forget", s.deviceAction(func(req DeviceRequest) error {
+		return s.p2p.ForgetDevice(req.Fingerprint)
+	}))
+	rpc.Register(r, "p2p_device_block", s.deviceAction(func(req DeviceRequest) error {
+		return s.p2p.BlockDevice(req.Fingerprint, req.Blocked)
+	}))
+
+	r.Expose("p2p_devices", "p2p_rejections", "team_bundle_export")
+}
+
+func (s *Service) deviceAction(fn func(DeviceRequest) error) func(context.Context, DeviceRequest) (DevicesResponse, error) {
+	return func(_ context.Context, req DeviceRequest) (DevicesResponse, error) {
+		if s.p2p == nil {
+			return DevicesResponse{}, rpc.Fail("p2p_unavailable", nil)
+		}
+		if req.Fingerprint == "" {
+			return DevicesResponse{}, rpc.Fail(rpc.CodeBadRequest, nil)
+		}
+		if err := fn(req); err != nil {
+			return DevicesResponse{}, rpc.FailDetail("not_found", err)
+		}
+		return DevicesResponse{Fingerprint: s.p2p.Presence().Fingerprint, Devices: s.p2p.Devices()}, nil
+	}
+}
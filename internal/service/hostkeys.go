@@ -0,0 +1,137 @@
+package service
+
+import (
+	"context"
+	"errors"
+
+	"github.com/ankouros/pterminal/internal/hostkeys"
+	"github.com/ankouros/pterminal/internal/rpc"
+	"github.com/ankouros/pterminal/internal/sshclient"
+	"golang.org/x/crypto/ssh"
+)
+
+// Host key error codes; a host with one pending can be trusted with
+// trust_host.
+const (
+	codeUnknownHostKey  = "unknown_host_key"
+	codeHostKeyMismatch = "host_key_mismatch"
+)
+
+type pendingKey struct {
+	kind        string
+	hostPort    string
+	fingerprint string
+	key         ssh.PublicKey
+}
+
+// TrustResponse reports a trusted key.
+type TrustResponse struct {
+	Replaced    bool   `json:"replaced"`
+	Fingerprint string `json:"fingerprint"`
+}
+
+// HostKeyPinRequest pins or unpins a recorded key.
+type HostKeyPinRequest struct {
+	HostID      int    `json:"hostId"`
+	Fingerprint string `json:"fingerprint"`
+	Enabled     bool   `json:"enabled"`
+}
+
+// HostKeysResponse is a host's record in the host key store.
+type HostKeysResponse struct {
+	Record hostkeys.Record `json:"record"`
+	Pinned []string        `json:"pinned"`
+}
+
+func (s *Service) registerHostKeys(r *rpc.Registry) {
+	rpc.Register(r, "trust_host", s.trustHost)
+	rpc.Register(r, "hostkeys_get", s.hostKeysGet)
+	rpc.Register(r, "hostkeys_pin", s.hostKeysPin)
+
+	r.Expose("hostkeys_get")
+}
+
+// noteHostKeyErr remembers the key of a failed connection for trust_host and
+// returns it when err is about a host key.
+func (s *Service) noteHostKeyErr(hostID int, err error) (pendingKey, bool) {
+	var p pendingKey
+	var unk sshclient.ErrUnknownHostKey
+	var mismatch sshclient.ErrHostKeyMismatch
+	switch {
+	case errors.As(err, &unk):
+		p = pendingKey{kind: codeUnknownHostKey, hostPort: unk.HostPort, fingerprint: unk.Fingerprint, key: unk.Key}
+	case errors.As(err, &mismatch):
+		p = pendingKey{kind: codeHostKeyMismatch, hostPort: mismatch.HostPort, fingerprint: mismatch.Fingerprint, key: mismatch.Key}
+	default:
+		return pendingKey{}, false
+	}
+	s.trustMu.Lock()
+	s.pendingTrust[hostID] = p
+	s.trustMu.Unlock()
+	return p, true
+}
+
+func (s *Service) pending(hostID int) pendingKey {
+	s.trustMu.Lock()
+	defer s.trustMu.Unlock()
+	return s.pendingTrust[hostID]
+}
+
+// hostKeyFail is the reply for a connection stopped by a host key.
+func hostKeyFail(p pendingKey) *rpc.Error {
+	return rpc.Fail(p.kind, map[string]any{
+		"hostPort":    p.hostPort,
+		"fingerprint": p.fingerprint,
+	})
+}
+
+func (s *Service) trustHost(_ context.Context, req HostRequest) (TrustResponse, error) {
+	p := s.pending(req.HostID)
+	if p.key == nil {
+		return TrustResponse{}, rpc.Fail("no_pending_trust", nil)
+	}
+	replaced := p.kind == codeHostKeyMismatch
+	if host, found := s.HostByID(req.HostID); found && host.UID != "" && s.hostKeys != nil {
+		record := s.hostKeys.Trust
+		if replaced {
+			record = s.hostKeys.Replace
+		}
+		if err := record(host.UID, p.hostPort, p.key, s.actor()); err != nil {
+			return TrustResponse{}, rpc.FailDetail("trust_failed", err)
+		}
+	}
+	if err := sshclient.TrustHostKey(p.hostPort, p.key); err != nil {
+		return TrustResponse{}, rpc.Fail("trust_failed", nil)
+	}
+	s.trustMu.Lock()
+	delete(s.pendingTrust, req.HostID)
+	s.trustMu.Unlock()
+	return TrustResponse{Replaced: replaced, Fingerprint: p.fingerprint}, nil
+}
+
+func (s *Service) hostKeysGet(_ context.Context, req HostRequest) (HostKeysResponse, error) {
+	host, found := s.HostByID(req.HostID)
+	if !found {
+		return HostKeysResponse{}, rpc.Fail("host_not_found", nil)
+	}
+	if s.hostKeys == nil {
+		return HostKeysResponse{}, rpc.Fail("hostkeys_unavailable", nil)
+	}
+	rec, _ := s.hostKeys.Get(host.UID)
+	return HostKeysResponse{Record: rec, Pinned: s.hostKeys.Pinned(host.UID)}, nil
+}
+
+func (s *Service) hostKeysPin(_ context.Context, req HostKeyPinRequest) (HostKeysResponse, error) {
+	host, found := s.HostByID(req.HostID)
+	if !found {
+		return HostKeysResponse{}, rpc.Fail("host_not_found", nil)
+	}
+	if s.hostKeys == nil {
+		return HostKeysResponse{}, rpc.Fail("hostkeys_unavailable", nil)
+	}
+	if err := s.hostKeys.SetPinned(host.UID, req.Fingerprint, req.Enabled, s.actor()); err != nil {
+		return HostKeysResponse{}, rpc.FailDetail("hostkeys_pin_failed", err)
+	}
+	rec, _ := s.hostKeys.Get(host.UID)
+	return HostKeysResponse{Record: rec, Pinned: s.hostKeys.Pinned(host.UID)}, nil
+}
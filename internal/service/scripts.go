@@ -0,0 +1,88 @@
+package service
+
+import (
+	"context"
+
+	"github.com/ankouros/pterminal/internal/model"
+	"github.com/ankouros/pterminal/internal/rpc"
+	"github.com/ankouros/pterminal/internal/scriptrun"
+)
+
+// ScriptRunRequest runs a saved script (ScriptID) or an ad-hoc command on
+// hosts, or on every host of a network.
+type ScriptRunRequest struct {
+	ScriptID    string `json:"scriptId"`
+	Name        string `json:"name"`
+	Command     string `json:"command"`
+	HostIDs     []int  `json:"hostIds"`
+	NetworkID   int    `json:"networkId"`
+	Concurrency int    `json:"concurrency"`
+}
+
+// RunRequest names a script run.
+type RunRequest struct {
+	RunID string `json:"runId"`
+}
+
+// RunIDResponse names a started script run.
+type RunIDResponse struct {
+	RunID string `json:"runId"`
+}
+
+// RunResponse is one script run.
+type RunResponse struct {
+	Run scriptrun.Run `json:"run"`
+}
+
+// RunsResponse lists the script runs.
+type RunsResponse struct {
+	Runs []scriptrun.Run `json:"runs"`
+}
+
+func (s *Service) registerScripts(r *rpc.Registry) {
+	rpc.Register(r, "script_run", s.scriptRun)
+	rpc.Register(r, "script_runs", func(context.Context, struct{}) (RunsResponse, error) {
+		return RunsResponse{Runs: s.scripts.Runs()}, nil
+	})
+	rpc.Register(r, "script_run_get", func(_ context.Context, req RunRequest) (RunResponse, error) {
+		run, found := s.scripts.Get(req.RunID)
+		if !found {
+			return RunResponse{}, rpc.Fail("not_found", nil)
+		}
+		return RunResponse{Run: run}, nil
+	})
+	rpc.Register(r, "script_run_cancel", func(_ context.Context, req RunRequest) (rpc.Empty, error) {
+		if !s.scripts.Cancel(req.RunID) {
+			return rpc.Empty{}, rpc.Fail("not_found", nil)
+		}
+		return rpc.Empty{}, nil
+	})
+
+	r.Expose("script_run", "script_runs", "script_run_get", "script_run_cancel")
+}
+
+func (s *Service) scriptRun(_ context.Context, req ScriptRunRequest) (RunIDResponse, error) {
+	cfg := s.mgr.Config()
+	script := model.TeamScript{Name: req.Name, Command: req.Command}
+	if req.ScriptID != "" {
+		found := false
+		for _, sc := range cfg.Scripts {
+			if sc.ID == req.ScriptID && !sc.Deleted {
+				script, found = sc, true
+				break
+			}
+		}
+		if !found {
+			return RunIDResponse{}, rpc.Fail("not_found", map[string]any{"detail": "script not found"})
+		}
+	}
+	hosts, err := scriptrun.ResolveHosts(cfg, req.HostIDs, req.NetworkID)
+	if err != nil {
+		return RunIDResponse{}, rpc.FailDetail(rpc.CodeBadRequest, err)
+	}
+	id, err := s.scripts.Start(script, hosts, scriptrun.Options{Concurrency: req.Concurrency}, s.sshPassword)
+	if err != nil {
+		return RunIDResponse{}, rpc.FailDetail("script_run_failed", err)
+	}
+	return RunIDResponse{RunID: id}, nil
+}
@@ -0,0 +1,286 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"strings"
+	"time"
+
+	"github.com/ankouros/pterminal/internal/credstore"
+	"github.com/ankouros/pterminal/internal/model"
+	"github.com/ankouros/pterminal/internal/rpc"
+	"github.com/ankouros/pterminal/internal/sshagent"
+	"github.com/ankouros/pterminal/internal/vault"
+	"golang.org/x/crypto/ssh"
+)
+
+// PassphraseRequest unlocks or creates the vault.
+type PassphraseRequest struct {
+	PassphraseB64 string `json:"passphraseB64"`
+}
+
+// MinutesRequest sets a duration in minutes.
+type MinutesRequest struct {
+	Minutes int `json:"minutes"`
+}
+
+// HostRequest names a host.
+type HostRequest struct {
+	HostID int `json:"hostId"`
+}
+
+// KeyringStatus describes the desktop keyring.
+type KeyringStatus struct {
+	Enabled   bool `json:"enabled"`
+	Available bool `json:"available"`
+}
+
+// SecretsStatus describes the vault and keyring.
+type SecretsStatus struct {
+	Vault   *vault.Status `json:"vault,omitempty"`
+	Keyring KeyringStatus `json:"keyring"`
+}
+
+// AgentAddRequest loads a key file into the built-in agent.
+type AgentAddRequest struct {
+	Path          string `json:"path"`
+	PassphraseB64 string `json:"passphraseB64"`
+	Minutes       int    `json:"minutes"`
+}
+
+// AgentRemoveRequest removes one key, or every key without a fingerprint.
+type AgentRemoveRequest struct {
+	Fingerprint string `json:"fingerprint"`
+}
+
+// AgentStatus lists the built-in agent's keys and socket.
+type AgentStatus struct {
+	Keys        []sshagent.KeyInfo `json:"keys"`
+	Socket      string             `json:"socket"`
+	SocketError string             `json:"socketError,omitempty"`
+}
+
+func (s *Service) registerSecrets(r *rpc.Registry) {
+	rpc.Register(r, "vault_status", func(context.Context, struct{}) (SecretsStatus, error) {
+		return s.SecretsStatus(), nil
+	})
+	rpc.Register(r, "vault_create", func(_ context.Context, req PassphraseRequest) (SecretsStatus, error) {
+		return s.openVault(req, true)
+	})
+	rpc.Register(r, "vault_unlock", func(_ context.Context, req PassphraseRequest) (SecretsStatus, error) {
+		return s.openVault(req, false)
+	})
+	rpc.Register(r, "vault_lock", s.vaultLock)
+	rpc.Register(r, "vault_auto_lock", s.vaultAutoLock)
+	rpc.Register(r, "vault_reset", s.vaultReset)
+	rpc.Register(r, "secrets_forget", s.secretsForget)
+
+	rpc.Register(r, "agent_keys", func(context.Context, struct{}) (AgentStatus, error) {
+		return s.AgentStatus(), nil
+	})
+	rpc.Register(r, "agent_add", s.agentAdd)
+	rpc.Register(r, "agent_remove", s.agentRemove)
+
+	r.Expose("vault_status", "vault_create", "vault_unlock", "vault_lock", "vault_auto_lock", "secrets_forget",
+		"agent_keys", "agent_add", "agent_remove")
+}
+
+// SecretsStatus describes the vault and keyring for the UI.
+func (s *Service) SecretsStatus() SecretsStatus {
+	var resp SecretsStatus
+	if s.vault != nil {
+		st := s.vault.Status()
+		resp.Vault = &st
+	}
+	if c := s.mgr.Config().Credentials; c != nil {
+		resp.Keyring.Enabled = c.SecretService
+	}
+	resp.Keyring.Available = resp.Keyring.Enabled && s.keyring != nil && s.keyring.Available()
+	return resp
+}
+
+func (s *Service) openVault(req PassphraseRequest, create bool) (SecretsStatus, error) {
+	if s.vault == nil {
+		return SecretsStatus{}, rpc.Fail("vault_unavailable", nil)
+	}
+	passphrase, err := b64dec(req.PassphraseB64)
+	if err != nil || passphrase == "" {
+		return SecretsStatus{}, rpc.Fail(rpc.CodeBadRequest, nil)
+	}
+	if create {
+		err = s.vault.Create(passphrase)
+		if err == nil {
+			// Keep the passwords typed so far.
+			s.pwMu.RLock()
+			sshPw := maps.Clone(s.pwCache)
+			sftpPw := maps.Clone(s.sftpPw)
+			s.pwMu.RUnlock()
+			for id, pw := range sshPw {
+				if host, ok := s.HostByID(id); ok && host.UID != "" {
+					_ = s.vault.Set(secretKind(host, false), host.UID, pw)
+				}
+			}
+			for id, pw := range sftpPw {
+				if host, ok := s.HostByID(id); ok && host.UID != "" {
+					_ = s.vault.Set(credstore.KindSFTP, host.UID, pw)
+				}
+			}
+		}
+	} else {
+		err = s.vault.Unlock(passphrase)
+	}
+	if errors.Is(err, vault.ErrBadPassphrase) {
+		return SecretsStatus{}, rpc.Fail("bad_passphrase", nil)
+	}
+	if err != nil {
+		return SecretsStatus{}, rpc.FailDetail("vault_failed", err)
+	}
+	return s.SecretsStatus(), nil
+}
+
+func (s *Service) vaultLock(context.Context, struct{}) (SecretsStatus, error) {
+	if s.vault == nil {
+		return SecretsStatus{}, rpc.Fail("vault_unavailable", nil)
+	}
+	s.vault.Lock()
+	return s.SecretsStatus(), nil
+}
+
+func (s *Service) vaultAutoLock(_ context.Context, req MinutesRequest) (SecretsStatus, error) {
+	if s.vault == nil {
+		return SecretsStatus{}, rpc.Fail("vault_unavailable", nil)
+	}
+	if req.Minutes < 0 {
+		return SecretsStatus{}, rpc.Fail(rpc.CodeBadRequest, nil)
+	}
+	if err := s.vault.SetAutoLock(time.Duration(req.Minutes) * time.Minute); err != nil {
+		return SecretsStatus{}, rpc.FailDetail("vault_failed", err)
+	}
+	return s.SecretsStatus(), nil
+}
+
+func (s *Service) vaultReset(context.Context, struct{}) (SecretsStatus, error) {
+	if s.vault == nil {
+		return SecretsStatus{}, rpc.Fail("vault_unavailable", nil)
+	}
+	if err := s.vault.Destroy(); err != nil {
+		return SecretsStatus{}, rpc.FailDetail("vault_failed", err)
+	}
+	return s.SecretsStatus(), nil
+}
+
+func (s *Service) secretsForget(_ context.Context, req HostRequest) (SecretsStatus, error) {
+	host, found := s.HostByID(req.HostID)
+	if !found || host.UID == "" {
+		return SecretsStatus{}, rpc.Fail(rpc.CodeBadRequest, nil)
+	}
+	if err := s.credStores().Forget(host.UID); err != nil {
+		return SecretsStatus{}, rpc.FailDetail("forget_failed", err)
+	}
+	s.pwMu.Lock()
+	delete(s.pwCache, req.HostID)
+	delete(s.sftpPw, req.HostID)
+	s.pwMu.Unlock()
+	s.sftp.SetCustomPassword(req.HostID, "")
+	return s.SecretsStatus(), nil
+}
+
+// credStores lists the enabled credential stores, most preferred first.
+func (s *Service) credStores() credstore.Chain {
+	var chain credstore.Chain
+	if c := s.mgr.Config().Credentials; c != nil && c.SecretService && s.keyring != nil {
+		chain = append(chain, s.keyring)
+	}
+	if s.vault != nil {
+		chain = append(chain, s.vault)
+	}
+	return chain
+}
+
+func secretKind(host model.Host, sftp bool) string {
+	if sftp {
+		return credstore.KindSFTP
+	}
+	return credstore.KindFor(host)
+}
+
+// storedSecret looks a host's connection (or custom SFTP) secret up in the
+// credential stores.
+func (s *Service) storedSecret(hostID int, sftp bool) string {
+	host, ok := s.HostByID(hostID)
+	if !ok || host.UID == "" {
+		return ""
+	}
+	pw, _ := s.credStores().Get(secretKind(host, sftp), host.UID)
+	return pw
+}
+
+// saveSecret remembers a newly entered secret in the first available store.
+// It runs in the background: the keyring may ask the desktop to unlock.
+func (s *Service) saveSecret(hostID int, sftp bool, pw string) {
+	if pw == "" {
+		return
+	}
+	host, ok := s.HostByID(hostID)
+	if !ok || host.UID == "" {
+		return
+	}
+	chain := s.credStores()
+	go func() { _ = chain.Save(secretKind(host, sftp), host.UID, pw) }()
+}
+
+// AgentStatus lists the built-in agent's keys and socket.
+func (s *Service) AgentStatus() AgentStatus {
+	if s.agent == nil {
+		return AgentStatus{}
+	}
+	keys, err := s.agent.Keys()
+	if err != nil {
+		keys = nil
+	}
+	socket, sockErr := s.agent.Socket()
+	resp := AgentStatus{Keys: keys, Socket: socket}
+	if sockErr != nil {
+		resp.SocketError = sockErr.Error()
+	}
+	return resp
+}
+
+func (s *Service) agentAdd(_ context.Context, req AgentAddRequest) (AgentStatus, error) {
+	if s.agent == nil {
+		return AgentStatus{}, rpc.Fail("agent_unavailable", nil)
+	}
+	passphrase, err := b64dec(req.PassphraseB64)
+	if err != nil {
+		return AgentStatus{}, rpc.Fail("bad_passphrase", nil)
+	}
+	if strings.TrimSpace(req.Path) == "" {
+		return AgentStatus{}, rpc.Fail("path_required", nil)
+	}
+	lifetime := time.Duration(req.Minutes) * time.Minute
+	if _, err := s.agent.AddKeyFile(req.Path, passphrase, lifetime); err != nil {
+		var missing *ssh.PassphraseMissingError
+		if errors.As(err, &missing) {
+			return AgentStatus{}, rpc.Fail("passphrase_required", nil)
+		}
+		return AgentStatus{}, rpc.FailDetail("agent_add_failed", err)
+	}
+	return s.AgentStatus(), nil
+}
+
+func (s *Service) agentRemove(_ context.Context, req AgentRemoveRequest) (AgentStatus, error) {
+	if s.agent == nil {
+		return AgentStatus{}, rpc.Fail("agent_unavailable", nil)
+	}
+	var err error
+	if req.Fingerprint == "" {
+		err = s.agent.RemoveAllKeys()
+	} else {
+		err = s.agent.RemoveKey(req.Fingerprint)
+	}
+	if err != nil {
+		return AgentStatus{}, rpc.FailDetail("agent_remove_failed", err)
+	}
+	return s.AgentStatus(), nil
+}
@@ -0,0 +1,286 @@
+package service
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+
+	"github.com/ankouros/pterminal/internal/credstore"
+	"github.com/ankouros/pterminal/internal/hostkeys"
+	"github.com/ankouros/pterminal/internal/model"
+	"github.com/ankouros/pterminal/internal/p2p"
+	"github.com/ankouros/pterminal/internal/rpc"
+	"github.com/ankouros/pterminal/internal/scriptrun"
+	"github.com/ankouros/pterminal/internal/session"
+	"github.com/ankouros/pterminal/internal/sftpclient"
+	"github.com/ankouros/pterminal/internal/sshagent"
+	"github.com/ankouros/pterminal/internal/terminal"
+	"github.com/ankouros/pterminal/internal/vault"
+)
+
+/*
+Service layer
+
+Service implements the operations behind the UI (config, SFTP, sessions,
+host keys, secrets, scripts, ...) as rpc methods with typed requests and
+responses. Frontends are thin adapters over an rpc.Registry: the WebView
+binding, the control socket and tests all call the same methods.
+
+Frontend-specific work (attaching terminal output to the window, dialogs,
+updates) stays in the frontend; the service reaches it through Hooks.
+*/
+
+// Hooks connect the service to a frontend. Any of them may be nil.
+type Hooks struct {
+	// Attach is called with a tab's session once it is connected.
+	Attach func(hostID, tabID int, sess terminal.Session)
+	// Selected is called when a tab is selected; sess is set when it was
+	// already connected.
+	Selected func(hostID, tabID int, sess terminal.Session)
+	// Detached is called after a tab was disconnected.
+	Detached func(hostID, tabID int)
+	// ConfigApplied is called after a new config was handed to the backends.
+	ConfigApplied func(cfg model.AppConfig)
+	// Transfer is called as SFTP transfers progress.
+	Transfer func(t sftpclient.Transfer)
+}
+
+// Deps are the backends the service drives. Manager, SFTP and Scripts are
+// required; the others may be nil when unavailable.
+type Deps struct {
+	Manager  *session.Manager
+	SFTP     *sftpclient.Manager
+	Scripts  *scriptrun.Runner
+	Agent    *sshagent.Agent
+	HostKeys *hostkeys.Store
+	Vault    *vault.Vault
+	Keyring  *credstore.SecretService
+	P2P      *p2p.Service
+	Hooks    Hooks
+}
+
+// Service is safe for concurrent use.
+type Service struct {
+	mgr      *session.Manager
+	sftp     *sftpclient.Manager
+	scripts  *scriptrun.Runner
+	agent    *sshagent.Agent
+	hostKeys *hostkeys.Store
+	vault    *vault.Vault
+	keyring  *credstore.SecretService
+	p2p      *p2p.Service
+	hooks    Hooks
+
+	// pending host-key trust data
+	trustMu      sync.Mutex
+	pendingTrust map[int]pendingKey
+
+	// per-host password cache (memory only)
+	pwMu    sync.RWMutex
+	pwCache map[int]string
+	sftpPw  map[int]string
+
+	ioCancel context.CancelFunc
+	inputCh  chan inputMsg
+	resizeCh chan resizeMsg
+}
+
+func New(d Deps) *Service {
+	s := &Service{
+		mgr:          d.Manager,
+		sftp:         d.SFTP,
+		scripts:      d.Scripts,
+		agent:        d.Agent,
+		hostKeys:     d.HostKeys,
+		vault:        d.Vault,
+		keyring:      d.Keyring,
+		p2p:          d.P2P,
+		hooks:        d.Hooks,
+		pendingTrust: make(map[int]pendingKey),
+		pwCache:      make(map[int]string),
+		sftpPw:       make(map[int]string),
+		inputCh:      make(chan inputMsg, 16384),
+		resizeCh:     make(chan resizeMsg, 256),
+	}
+	if d.Hooks.Transfer != nil {
+		s.sftp.SetTransferListener(d.Hooks.Transfer)
+	}
+	s.startIOLoops()
+	return s
+}
+
+// Register adds the service's methods to r.
+func (s *Service) Register(r *rpc.Registry) {
+	r.Use(s.rememberPasswords)
+	s.registerConfig(r)
+	s.registerSFTP(r)
+	s.registerTransfers(r)
+	s.registerDevices(r)
+	s.registerSessions(r)
+	s.registerHostKeys(r)
+	s.registerSecrets(r)
+	s.registerScripts(r)
+}
+
+// ApplyConfig hands cfg to every backend.
+func (s *Service) ApplyConfig(cfg model.AppConfig) {
+	s.mgr.SetConfig(cfg)
+	s.sftp.SetConfig(cfg)
+	s.scripts.SetConfig(cfg)
+	if s.agent != nil {
+		_ = s.agent.Configure(cfg.Agent)
+	}
+	if s.p2p != nil {
+		s.p2p.SetConfig(cfg)
+	}
+	if s.hooks.ConfigApplied != nil {
+		s.hooks.ConfigApplied(cfg)
+	}
+}
+
+// Reset forgets pending host key prompts and typed passwords, e.g. after the
+// config was replaced.
+func (s *Service) Reset() {
+	s.trustMu.Lock()
+	s.pendingTrust = make(map[int]pendingKey)
+	s.trustMu.Unlock()
+	s.pwMu.Lock()
+	s.pwCache = make(map[int]string)
+	s.sftpPw = make(map[int]string)
+	s.pwMu.Unlock()
+}
+
+// Close stops the input workers.
+func (s *Service) Close() {
+	if s.ioCancel != nil {
+		s.ioCancel()
+	}
+}
+
+// Config returns the active config.
+func (s *Service) Config() model.AppConfig { return s.mgr.Config() }
+
+// HostByID finds a host in the active config.
+func (s *Service) HostByID(hostID int) (model.Host, bool) {
+	for _, n := range s.mgr.Config().Networks {
+		for _, h := range n.Hosts {
+			if h.ID == hostID {
+				return h, true
+			}
+		}
+	}
+	return model.Host{}, false
+}
+
+// actor names the local user in audit records.
+func (s *Service) actor() string {
+	user := s.mgr.Config().User
+	for _, v := range []string{user.Email, user.Name, user.DeviceID} {
+		if strings.TrimSpace(v) != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// rememberPasswords caches passwords sent along with any request that names
+// a host, so JS can supply them to avoid prompting repeatedly.
+func (s *Service) rememberPasswords(method string, next rpc.Handler) rpc.Handler {
+	return func(ctx context.Context, params json.RawMessage) (any, error) {
+		var p struct {
+			HostID          int    `json:"hostId"`
+			PasswordB64     string `json:"passwordB64"`
+			SftpPasswordB64 string `json:"sftpPasswordB64"`
+		}
+		_ = json.Unmarshal(params, &p)
+		if p.HostID != 0 && p.PasswordB64 != "" {
+			if pw, err := b64dec(p.PasswordB64); err == nil && pw != "" {
+				s.setCachedPassword(p.HostID, pw)
+			}
+		}
+		if p.HostID != 0 && p.SftpPasswordB64 != "" {
+			if pw, err := b64dec(p.SftpPasswordB64); err == nil && pw != "" {
+				s.setCachedSFTPPassword(p.HostID, pw)
+				s.sftp.SetCustomPassword(p.HostID, pw)
+			}
+		} else if p.HostID != 0 && strings.HasPrefix(method, "sftp_") {
+			// The SFTP manager does not ask a provider for custom passwords;
+			// hand it a stored one, if any.
+			if pw := s.storedSecret(p.HostID, true); pw != "" {
+				s.sftp.SetCustomPassword(p.HostID, pw)
+			}
+		}
+		return next(ctx, params)
+	}
+}
+
+func (s *Service) getCachedPassword(hostID int) string {
+	s.pwMu.RLock()
+	pw := s.pwCache[hostID]
+	s.pwMu.RUnlock()
+	if pw == "" {
+		pw = s.storedSecret(hostID, false)
+	}
+	return pw
+}
+
+func (s *Service) setCachedPassword(hostID int, pw string) {
+	s.pwMu.Lock()
+	changed := s.pwCache[hostID] != pw
+	s.pwCache[hostID] = pw
+	s.pwMu.Unlock()
+	if changed {
+		s.saveSecret(hostID, false, pw)
+	}
+}
+
+func (s *Service) getCachedSFTPPassword(hostID int) string {
+	s.pwMu.RLock()
+	pw := s.sftpPw[hostID]
+	s.pwMu.RUnlock()
+	if pw == "" {
+		pw = s.storedSecret(hostID, true)
+	}
+	return pw
+}
+
+func (s *Service) setCachedSFTPPassword(hostID int, pw string) {
+	s.pwMu.Lock()
+	changed := s.sftpPw[hostID] != pw
+	if pw == "" {
+		delete(s.sftpPw, hostID)
+	} else {
+		s.sftpPw[hostID] = pw
+	}
+	s.pwMu.Unlock()
+	if changed {
+		s.saveSecret(hostID, true, pw)
+	}
+}
+
+// sshPassword provides a host's password for connections.
+func (s *Service) sshPassword(hostID int) (string, error) {
+	if pw := s.getCachedPassword(hostID); pw != "" {
+		return pw, nil
+	}
+	// only request password if NONE was ever provided
+	return "", errPasswordRequired
+}
+
+// sftpPassword prefers a host's custom SFTP password.
+func (s *Service) sftpPassword(hostID int) (string, error) {
+	if pw := s.getCachedSFTPPassword(hostID); pw != "" {
+		return pw, nil
+	}
+	return s.sshPassword(hostID)
+}
+
+var errPasswordRequired = errors.New("password_required")
+
+func b64dec(b string) (string, error) {
+	d, err := base64.StdEncoding.DecodeString(b)
+	return string(d), err
+}
@@ -0,0 +1,138 @@
+package service
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"path/filepath"
+	"testing"
+
+	"github.com/ankouros/pterminal/internal/hostkeys"
+	"github.com/ankouros/pterminal/internal/model"
+	"github.com/ankouros/pterminal/internal/rpc"
+	"github.com/ankouros/pterminal/internal/scriptrun"
+	"github.com/ankouros/pterminal/internal/session"
+	"github.com/ankouros/pterminal/internal/sftpclient"
+)
+
+func newTestService(t *testing.T, hooks Hooks) (*Service, *rpc.Registry) {
+	t.Helper()
+	t.Setenv("HOME", t.TempDir())
+	cfg := model.AppConfig{
+		Version: 2,
+		Networks: []model.Network{{
+			ID:   1,
+			Name: "lab",
+			Hosts: []model.Host{{
+				ID: 7, UID: "uid-7", Name: "db", Host: "127.0.0.1", Port: 22, User: "root",
+				Auth: model.AuthConfig{Method: model.AuthPassword},
+			}},
+		}},
+	}
+	dir := t.TempDir()
+	store, err := hostkeys.Open(filepath.Join(dir, "hostkeys.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mgr := session.NewManager(cfg)
+	s := New(Deps{
+		Manager:  mgr,
+		SFTP:     sftpclient.NewManager(cfg),
+		Scripts:  scriptrun.NewRunner(cfg, filepath.Join(dir, "runs.json")),
+		HostKeys: store,
+		Hooks:    hooks,
+	})
+	t.Cleanup(s.Close)
+	r := rpc.NewRegistry()
+	s.Register(r)
+	return s, r
+}
+
+func call(t *testing.T, r *rpc.Registry, method, params string) (any, *rpc.Error) {
+	t.Helper()
+	resp, err := r.Call(context.Background(), method, json.RawMessage(params))
+	if err != nil {
+		return nil, rpc.AsError(err)
+	}
+	return resp, nil
+}
+
+func TestServiceMethods(t *testing.T) {
+	s, r := newTestService(t, Hooks{})
+
+	resp, rerr := call(t, r, "state", `{"hostId":7,"tabId":1}`)
+	if rerr != nil {
+		t.Fatal(rerr)
+	}
+	if st := resp.(StateResponse); st.State != "disconnected" || st.Connected || st.HostID != 7 {
+		t.Fatalf("unexpected state %+v", st)
+	}
+
+	for _, tc := range []struct{ method, params, code string }{
+		{"select", `{}`, rpc.CodeBadRequest},
+		{"input", `{"hostId":7}`, "bad_input"},
+		{"hostkeys_get", `{"hostId":99}`, "host_not_found"},
+		{"trust_host", `{"hostId":7}`, "no_pending_trust"},
+		{"script_run", `{"scriptId":"nope","hostIds":[7]}`, "not_found"},
+		{"vault_lock", `{}`, "vault_unavailable"},
+		{"agent_keys_nope", `{}`, rpc.CodeUnknownMethod},
+		{"sftp_read", `{"hostId":7}`, rpc.CodeBadRequest},
+		{"state", `{"hostId":"seven"}`, rpc.CodeBadRequest},
+		{"transfer_start", `{"hostId":7,"direction":"sideways","source":"/etc"}`, rpc.CodeBadRequest},
+		{"transfer_start", `{"hostId":7,"direction":"download","source":"/etc","conflict":"merge"}`, rpc.CodeBadRequest},
+		{"transfer_cancel", `{"transferId":"nope"}`, "not_found"},
+		{"p2p_device_forget", `{"fingerprint":"SHA256:x"}`, "p2p_unavailable"},
+	} {
+		if _, rerr := call(t, r, tc.method, tc.params); rerr == nil || rerr.Code != tc.code {
+			t.Errorf("%s: expected %s, got %v", tc.method, tc.code, rerr)
+		}
+	}
+
+	resp, rerr = call(t, r, "hostkeys_get", `{"hostId":7}`)
+	if rerr != nil {
+		t.Fatal(rerr)
+	}
+	if hk := resp.(HostKeysResponse); len(hk.Record.Keys) != 0 || len(hk.Pinned) != 0 {
+		t.Fatalf("unexpected host keys %+v", hk)
+	}
+
+	// Passwords sent with any request are remembered for the host.
+	pw := base64.StdEncoding.EncodeToString([]byte("s3cret"))
+	if _, rerr := call(t, r, "state", `{"hostId":7,"passwordB64":"`+pw+`"}`); rerr != nil {
+		t.Fatal(rerr)
+	}
+	if got, err := s.sshPassword(7); err != nil || got != "s3cret" {
+		t.Fatalf("cached password %q %v", got, err)
+	}
+	s.Reset()
+	if _, err := s.sshPassword(7); err == nil {
+		t.Fatal("expected the password to be forgotten")
+	}
+}
+
+func TestServiceConfigSave(t *testing.T) {
+	var applied []model.AppConfig
+	s, r := newTestService(t, Hooks{ConfigApplied: func(cfg model.AppConfig) { applied = append(applied, cfg) }})
+
+	cfg := s.Config()
+	cfg.Networks[0].Hosts[0].Name = "db-renamed"
+	raw, err := json.Marshal(map[string]any{"config": cfg})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, rerr := call(t, r, "config_save", string(raw))
+	if rerr != nil {
+		t.Fatal(rerr)
+	}
+	saved := resp.(ConfigResponse).Config
+	if host, ok := s.HostByID(7); !ok || host.Name != "db-renamed" || len(applied) != 1 {
+		t.Fatalf("config not applied: %+v %d", host, len(applied))
+	}
+	if saved.Networks[0].Hosts[0].Name != "db-renamed" {
+		t.Fatalf("unexpected saved config %+v", saved.Networks)
+	}
+
+	if _, rerr := call(t, r, "config_save", `{"config":"nope"}`); rerr == nil || rerr.Code != "config_save_failed" {
+		t.Fatalf("expected config_save_failed, got %v", rerr)
+	}
+}
@@ -0,0 +1,381 @@
+package service
+
+import (
+	"context"
+	"errors"
+
+	"github.com/ankouros/pterminal/internal/forward"
+	"github.com/ankouros/pterminal/internal/recording"
+	"github.com/ankouros/pterminal/internal/rpc"
+	"github.com/ankouros/pterminal/internal/session"
+	"github.com/ankouros/pterminal/internal/sshclient"
+	"github.com/ankouros/pterminal/internal/terminal"
+)
+
+// TabRequest names a host's terminal tab.
+type TabRequest struct {
+	HostID int `json:"hostId"`
+	TabID  int `json:"tabId"`
+}
+
+// SelectRequest shows a tab, connecting it if needed.
+type SelectRequest struct {
+	HostID int `json:"hostId"`
+	TabID  int `json:"tabId"`
+	Cols   int `json:"cols"`
+	Rows   int `json:"rows"`
+}
+
+// SelectResponse reports that connecting started.
+type SelectResponse struct {
+	Started bool `json:"started"`
+}
+
+// InputRequest types base64 data into a tab.
+type InputRequest struct {
+	HostID  int    `json:"hostId"`
+	TabID   int    `json:"tabId"`
+	DataB64 string `json:"dataB64"`
+}
+
+// StateResponse describes a tab's connection. ErrCode (with HostPort and
+// Fingerprint for host keys) tells the UI what to ask the user for.
+type StateResponse struct {
+	HostID           int                 `json:"hostId"`
+	TabID            int                 `json:"tabId"`
+	State            string              `json:"state"`
+	Attempts         int                 `json:"attempts"`
+	Detail           string              `json:"detail"`
+	Connected        bool                `json:"connected"`
+	Recording        bool                `json:"recording"`
+	Certs            *sshclient.ConnInfo `json:"certs,omitempty"`
+	RTTMs            float64             `json:"rttMs,omitempty"`
+	KeepalivesMissed int                 `json:"keepalivesMissed,omitempty"`
+	ErrCode          string              `json:"errCode,omitempty"`
+	HostPort         string              `json:"hostPort,omitempty"`
+	Fingerprint      string              `json:"fingerprint,omitempty"`
+	KeyPath          string              `json:"keyPath,omitempty"`
+	KeyChecked       []string            `json:"keyChecked,omitempty"`
+}
+
+// BroadcastRequest joins, leaves or pauses a tab in a broadcast group.
+type BroadcastRequest struct {
+	HostID  int    `json:"hostId"`
+	TabID   int    `json:"tabId"`
+	GroupID string `json:"groupId"`
+	Name    string `json:"name"`
+	Paused  bool   `json:"paused"`
+}
+
+// BroadcastResponse lists the broadcast groups.
+type BroadcastResponse struct {
+	GroupID string                   `json:"groupId,omitempty"`
+	Groups  []session.BroadcastGroup `json:"groups"`
+}
+
+// ForwardRequest names a host's port forward.
+type ForwardRequest struct {
+	HostID    int    `json:"hostId"`
+	ForwardID string `json:"forwardId"`
+}
+
+// ForwardsResponse lists a host's port forwards.
+type ForwardsResponse struct {
+	Forwards []forward.Stats `json:"forwards"`
+}
+
+// RecordingRequest turns recording a tab on or off.
+type RecordingRequest struct {
+	HostID  int  `json:"hostId"`
+	TabID   int  `json:"tabId"`
+	Enabled bool `json:"enabled"`
+}
+
+// RecordingsResponse lists the recordings.
+type RecordingsResponse struct {
+	Dir        string           `json:"dir"`
+	Recordings []recording.Info `json:"recordings"`
+}
+
+// NameRequest names a file (a recording to export).
+type NameRequest struct {
+	Name string `json:"name"`
+}
+
+// PathResponse names a written file.
+type PathResponse struct {
+	Path string `json:"path"`
+}
+
+type inputMsg struct {
+	hostID  int
+	tabID   int
+	dataB64 string
+}
+
+type resizeMsg struct {
+	hostID int
+	tabID  int
+	cols   int
+	rows   int
+}
+
+func (s *Service) registerSessions(r *rpc.Registry) {
+	rpc.Register(r, "select", s.selectTab)
+	rpc.Register(r, "input", s.input)
+	rpc.Register(r, "resize", s.resize)
+	rpc.Register(r, "state", s.state)
+	rpc.Register(r, "disconnect", s.disconnect)
+
+	rpc.Register(r, "broadcast_list", func(context.Context, struct{}) (BroadcastResponse, error) {
+		return BroadcastResponse{Groups: s.mgr.BroadcastGroups()}, nil
+	})
+	rpc.Register(r, "broadcast_join", s.broadcastJoin)
+	rpc.Register(r, "broadcast_leave", s.broadcastLeave)
+	rpc.Register(r, "broadcast_pause", s.broadcastPause)
+
+	rpc.Register(r, "forward_list", s.forwardList)
+	rpc.Register(r, "forward_start", s.forwardStart)
+	rpc.Register(r, "forward_stop", s.forwardStop)
+
+	rpc.Register(r, "recording_set", s.recordingSet)
+	rpc.Register(r, "recording_list", s.recordingList)
+	rpc.Register(r, "recording_export", s.recordingExport)
+
+	r.Expose("select", "input", "resize", "state", "disconnect",
+		"broadcast_list", "broadcast_join", "broadcast_leave", "broadcast_pause",
+		"forward_list", "forward_start", "forward_stop",
+		"recording_set", "recording_list", "recording_export")
+}
+
+// startIOLoops moves terminal writes off the caller: the WebView calls in
+// on its UI thread, which must never block on network/PTY writes.
+func (s *Service) startIOLoops() {
+	ctx, cancel := context.WithCancel(context.Background())
+	s.ioCancel = cancel
+
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case msg := <-s.inputCh:
+				_ = s.mgr.WriteTab(msg.hostID, msg.tabID, msg.dataB64)
+			}
+		}
+	}()
+
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case msg := <-s.resizeCh:
+				_ = s.mgr.ResizeTab(msg.hostID, msg.tabID, msg.cols, msg.rows)
+			}
+		}
+	}()
+}
+
+func (s *Service) selectTab(_ context.Context, req SelectRequest) (SelectResponse, error) {
+	hostID := req.HostID
+	if hostID == 0 {
+		return SelectResponse{}, rpc.Fail(rpc.CodeBadRequest, nil)
+	}
+	tabID := req.TabID
+	if tabID <= 0 {
+		tabID = 1
+	}
+
+	// Start connecting asynchronously to avoid blocking the caller.
+	sess, alreadyConnected, err := s.mgr.StartConnectAsync(hostID, tabID, req.Cols, req.Rows, s.sshPassword,
+		func(sess terminal.Session, err error) {
+			if err != nil {
+				s.noteHostKeyErr(hostID, err)
+				return
+			}
+			if sess != nil && s.hooks.Attach != nil {
+				s.hooks.Attach(hostID, tabID, sess)
+			}
+		})
+	if err != nil {
+		return SelectResponse{}, rpc.FailDetail("connect_failed", err)
+	}
+
+	if !alreadyConnected {
+		sess = nil
+	}
+	if s.hooks.Selected != nil {
+		s.hooks.Selected(hostID, tabID, sess)
+	}
+	return SelectResponse{Started: true}, nil
+}
+
+func (s *Service) input(_ context.Context, req InputRequest) (rpc.Empty, error) {
+	if req.HostID == 0 || req.DataB64 == "" {
+		return rpc.Empty{}, rpc.Fail("bad_input", nil)
+	}
+	s.inputCh <- inputMsg{hostID: req.HostID, tabID: req.TabID, dataB64: req.DataB64}
+	return rpc.Empty{}, nil
+}
+
+func (s *Service) resize(_ context.Context, req SelectRequest) (rpc.Empty, error) {
+	if req.HostID != 0 && req.Cols > 0 && req.Rows > 0 {
+		select {
+		case s.resizeCh <- resizeMsg{hostID: req.HostID, tabID: req.TabID, cols: req.Cols, rows: req.Rows}:
+		default:
+			// Best effort; a later resize will win.
+		}
+	}
+	return rpc.Empty{}, nil
+}
+
+func (s *Service) state(_ context.Context, req TabRequest) (StateResponse, error) {
+	info := s.mgr.SessionInfoTab(req.HostID, req.TabID)
+	resp := StateResponse{
+		HostID:           req.HostID,
+		TabID:            req.TabID,
+		State:            info.State.String(),
+		Attempts:         info.Attempts,
+		Detail:           info.LastErr,
+		Connected:        info.State == session.StateConnected,
+		Recording:        info.Recording,
+		Certs:            info.Certs,
+		KeepalivesMissed: info.KeepalivesMissed,
+	}
+	if info.RTT > 0 {
+		resp.RTTMs = float64(info.RTT.Microseconds()) / 1000
+	}
+
+	// Host key trust hints (also used for reconnect failures)
+	p := s.pending(req.HostID)
+	if p.key == nil && info.Err != nil {
+		p, _ = s.noteHostKeyErr(req.HostID, info.Err)
+	}
+	if p.key != nil {
+		resp.ErrCode, resp.HostPort, resp.Fingerprint = p.kind, p.hostPort, p.fingerprint
+		return resp, nil
+	}
+
+	if info.Err != nil {
+		var missingKey *sshclient.ErrKeyNotFound
+		if errors.As(info.Err, &missingKey) {
+			resp.ErrCode = "key_not_found"
+			resp.KeyPath = missingKey.Requested
+			resp.KeyChecked = missingKey.Checked
+		}
+		if info.LastErr == errPasswordRequired.Error() {
+			resp.ErrCode = "password_required"
+		}
+		if errors.Is(info.Err, sshclient.ErrPassphraseRequired) {
+			resp.ErrCode = "passphrase_required"
+		}
+	}
+	return resp, nil
+}
+
+func (s *Service) disconnect(_ context.Context, req TabRequest) (rpc.Empty, error) {
+	if req.HostID == 0 {
+		return rpc.Empty{}, rpc.Fail(rpc.CodeBadRequest, nil)
+	}
+	if err := s.mgr.DisconnectTab(req.HostID, req.TabID); err != nil {
+		return rpc.Empty{}, rpc.FailDetail("disconnect_failed", err)
+	}
+	s.sftp.Disconnect(req.HostID)
+	if s.hooks.Detached != nil {
+		s.hooks.Detached(req.HostID, req.TabID)
+	}
+	return rpc.Empty{}, nil
+}
+
+func (s *Service) broadcastJoin(_ context.Context, req BroadcastRequest) (BroadcastResponse, error) {
+	if req.HostID == 0 {
+		return BroadcastResponse{}, rpc.Fail(rpc.CodeBadRequest, nil)
+	}
+	id, err := s.mgr.BroadcastJoin(req.GroupID, req.Name, req.HostID, req.TabID)
+	if err != nil {
+		return BroadcastResponse{}, rpc.FailDetail("broadcast_failed", err)
+	}
+	return BroadcastResponse{GroupID: id, Groups: s.mgr.BroadcastGroups()}, nil
+}
+
+func (s *Service) broadcastLeave(_ context.Context, req BroadcastRequest) (BroadcastResponse, error) {
+	if req.HostID == 0 {
+		return BroadcastResponse{}, rpc.Fail(rpc.CodeBadRequest, nil)
+	}
+	s.mgr.BroadcastLeave(req.HostID, req.TabID)
+	return BroadcastResponse{Groups: s.mgr.BroadcastGroups()}, nil
+}
+
+func (s *Service) broadcastPause(_ context.Context, req BroadcastRequest) (BroadcastResponse, error) {
+	if req.HostID == 0 {
+		return BroadcastResponse{}, rpc.Fail(rpc.CodeBadRequest, nil)
+	}
+	if err := s.mgr.BroadcastPause(req.HostID, req.TabID, req.Paused); err != nil {
+		return BroadcastResponse{}, rpc.FailDetail("broadcast_failed", err)
+	}
+	return BroadcastResponse{Groups: s.mgr.BroadcastGroups()}, nil
+}
+
+func (s *Service) forwardList(_ context.Context, req HostRequest) (ForwardsResponse, error) {
+	if req.HostID == 0 {
+		return ForwardsResponse{}, rpc.Fail(rpc.CodeBadRequest, nil)
+	}
+	forwards, err := s.mgr.Forwards(req.HostID)
+	if err != nil {
+		return ForwardsResponse{}, rpc.FailDetail("forward_failed", err)
+	}
+	return ForwardsResponse{Forwards: forwards}, nil
+}
+
+func (s *Service) forwardStart(_ context.Context, req ForwardRequest) (rpc.Empty, error) {
+	if req.HostID == 0 || req.ForwardID == "" {
+		return rpc.Empty{}, rpc.Fail(rpc.CodeBadRequest, nil)
+	}
+	if err := s.mgr.StartForward(req.HostID, req.ForwardID); err != nil {
+		return rpc.Empty{}, rpc.FailDetail("forward_failed", err)
+	}
+	return rpc.Empty{}, nil
+}
+
+func (s *Service) forwardStop(_ context.Context, req ForwardRequest) (rpc.Empty, error) {
+	if req.HostID == 0 || req.ForwardID == "" {
+		return rpc.Empty{}, rpc.Fail(rpc.CodeBadRequest, nil)
+	}
+	s.mgr.StopForward(req.HostID, req.ForwardID)
+	return rpc.Empty{}, nil
+}
+
+func (s *Service) recordingSet(_ context.Context, req RecordingRequest) (PathResponse, error) {
+	if req.HostID == 0 {
+		return PathResponse{}, rpc.Fail(rpc.CodeBadRequest, nil)
+	}
+	if err := s.mgr.SetRecording(req.HostID, req.TabID, req.Enabled); err != nil {
+		return PathResponse{}, rpc.FailDetail("recording_failed", err)
+	}
+	return PathResponse{Path: s.mgr.RecordingPath(req.HostID, req.TabID)}, nil
+}
+
+func (s *Service) recordingList(context.Context, struct{}) (RecordingsResponse, error) {
+	dir, err := s.mgr.RecordingDir()
+	if err != nil {
+		return RecordingsResponse{}, rpc.FailDetail("recording_failed", err)
+	}
+	list, err := recording.List(dir)
+	if err != nil {
+		return RecordingsResponse{}, rpc.FailDetail("recording_failed", err)
+	}
+	return RecordingsResponse{Dir: dir, Recordings: list}, nil
+}
+
+func (s *Service) recordingExport(_ context.Context, req NameRequest) (PathResponse, error) {
+	dir, err := s.mgr.RecordingDir()
+	if err != nil {
+		return PathResponse{}, rpc.FailDetail("export_failed", err)
+	}
+	path, err := recording.Export(dir, req.Name)
+	if err != nil {
+		return PathResponse{}, rpc.FailDetail("export_failed", err)
+	}
+	return PathResponse{Path: path}, nil
+}
@@ -0,0 +1,253 @@
+package service
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"time"
+
+	"github.com/ankouros/pterminal/internal/rpc"
+	"github.com/ankouros/pterminal/internal/sftpclient"
+	"github.com/ankouros/pterminal/internal/sshclient"
+)
+
+// sftpReadMax caps files opened in the inline editor.
+const sftpReadMax = 2 * 1024 * 1024
+
+// PathRequest names a remote path on a host.
+type PathRequest struct {
+	HostID int    `json:"hostId"`
+	Path   string `json:"path"`
+}
+
+// ListResponse is a directory listing.
+type ListResponse struct {
+	Cwd     string             `json:"cwd"`
+	Entries []sftpclient.Entry `json:"entries"`
+}
+
+// MoveRequest renames a remote path.
+type MoveRequest struct {
+	HostID int    `json:"hostId"`
+	From   string `json:"from"`
+	To     string `json:"to"`
+}
+
+// DownloadRequest saves a remote file into Dir ("" = the download directory).
+type DownloadRequest struct {
+	HostID int    `json:"hostId"`
+	Path   string `json:"path"`
+	Dir    string `json:"dir"`
+}
+
+// DownloadResponse names the downloaded file.
+type DownloadResponse struct {
+	LocalPath string `json:"localPath"`
+	sftpclient.FileResult
+}
+
+// UploadBeginRequest starts an upload of name (Size bytes, last modified at
+// ModTime in Unix milliseconds) into dir. An interrupted upload of the same
+// file resumes when Size and ModTime are given.
+type UploadBeginRequest struct {
+	HostID  int    `json:"hostId"`
+	Dir     string `json:"dir"`
+	Name    string `json:"name"`
+	Size    int64  `json:"size"`
+	ModTime int64  `json:"modTime"`
+}
+
+// UploadRequest continues (with DataB64) or ends an upload.
+type UploadRequest struct {
+	UploadID string `json:"uploadId"`
+	DataB64  string `json:"dataB64"`
+}
+
+// UploadResponse identifies an upload and the offset to send data from.
+type UploadResponse struct {
+	UploadID string `json:"uploadId"`
+	Offset   int64  `json:"offset"`
+}
+
+// FileRequest replaces a remote file's content. Version is the token
+// sftp_read returned; the save fails with remote_changed when the file has
+// changed since, unless Force is set. Atomic and Backup override the editor
+// settings.
+type FileRequest struct {
+	HostID  int    `json:"hostId"`
+	Path    string `json:"path"`
+	DataB64 string `json:"dataB64"`
+	Version string `json:"version"`
+	Force   bool   `json:"force"`
+	Atomic  *bool  `json:"atomic"`
+	Backup  *bool  `json:"backup"`
+}
+
+// FileResponse holds a remote file's content and version.
+type FileResponse struct {
+	DataB64 string `json:"dataB64"`
+	Version string `json:"version"`
+}
+
+func (s *Service) registerSFTP(r *rpc.Registry) {
+	rpc.Register(r, "sftp_ls", s.sftpList)
+	rpc.Register(r, "sftp_mkdir", s.sftpMkdir)
+	rpc.Register(r, "sftp_rm", s.sftpRemove)
+	rpc.Register(r, "sftp_mv", s.sftpMove)
+	rpc.Register(r, "sftp_download", s.sftpDownload)
+	rpc.Register(r, "sftp_upload_begin", s.sftpUploadBegin)
+	rpc.Register(r, "sftp_upload_chunk", s.sftpUploadChunk)
+	rpc.Register(r, "sftp_upload_end", s.sftpUploadEnd)
+	rpc.Register(r, "sftp_read", s.sftpRead)
+	rpc.Register(r, "sftp_write", s.sftpWrite)
+
+	r.Expose("sftp_ls", "sftp_mkdir", "sftp_rm", "sftp_mv", "sftp_download",
+		"sftp_upload_begin", "sftp_upload_chunk", "sftp_upload_end", "sftp_read", "sftp_write")
+}
+
+// sftpError maps an SFTP failure to its reply.
+func (s *Service) sftpError(hostID int, err error) error {
+	if p, ok := s.noteHostKeyErr(hostID, err); ok {
+		return hostKeyFail(p)
+	}
+	if errors.Is(err, sshclient.ErrPassphraseRequired) {
+		return rpc.Fail("passphrase_required", nil)
+	}
+	if err.Error() == errPasswordRequired.Error() {
+		return rpc.Fail("password_required", nil)
+	}
+	if errors.Is(err, sftpclient.ErrChecksumMismatch) {
+		return rpc.FailDetail("checksum_mismatch", err)
+	}
+	if errors.Is(err, sftpclient.ErrRemoteChanged) {
+		return rpc.FailDetail("remote_changed", err)
+	}
+	return rpc.FailDetail("sftp_failed", err)
+}
+
+func (s *Service) sftpList(ctx context.Context, req PathRequest) (ListResponse, error) {
+	ctx, cancel := context.WithTimeout(ctx, 15*time.Second)
+	defer cancel()
+	entries, cwd, err := s.sftp.List(ctx, req.HostID, req.Path, s.sftpPassword)
+	if err != nil {
+		return ListResponse{}, s.sftpError(req.HostID, err)
+	}
+	return ListResponse{Cwd: cwd, Entries: entries}, nil
+}
+
+func (s *Service) sftpMkdir(ctx context.Context, req PathRequest) (rpc.Empty, error) {
+	ctx, cancel := context.WithTimeout(ctx, 15*time.Second)
+	defer cancel()
+	if err := s.sftp.MkdirAll(ctx, req.HostID, req.Path, s.sftpPassword); err != nil {
+		return rpc.Empty{}, s.sftpError(req.HostID, err)
+	}
+	return rpc.Empty{}, nil
+}
+
+func (s *Service) sftpRemove(ctx context.Context, req PathRequest) (rpc.Empty, error) {
+	ctx, cancel := context.WithTimeout(ctx, 15*time.Second)
+	defer cancel()
+	if err := s.sftp.Remove(ctx, req.HostID, req.Path, s.sftpPassword); err != nil {
+		return rpc.Empty{}, s.sftpError(req.HostID, err)
+	}
+	return rpc.Empty{}, nil
+}
+
+func (s *Service) sftpMove(ctx context.Context, req MoveRequest) (rpc.Empty, error) {
+	ctx, cancel := context.WithTimeout(ctx, 15*time.Second)
+	defer cancel()
+	if err := s.sftp.Rename(ctx, req.HostID, req.From, req.To, s.sftpPassword); err != nil {
+		return rpc.Empty{}, s.sftpError(req.HostID, err)
+	}
+	return rpc.Empty{}, nil
+}
+
+func (s *Service) sftpDownload(ctx context.Context, req DownloadRequest) (DownloadResponse, error) {
+	ctx, cancel := context.WithTimeout(ctx, 60*time.Second)
+	defer cancel()
+	out, res, err := s.sftp.DownloadToDir(ctx, req.HostID, req.Path, req.Dir, s.sftpPassword)
+	if err != nil {
+		return DownloadResponse{}, s.sftpError(req.HostID, err)
+	}
+	return DownloadResponse{LocalPath: out, FileResult: res}, nil
+}
+
+func (s *Service) sftpUploadBegin(ctx context.Context, req UploadBeginRequest) (UploadResponse, error) {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	var modTime time.Time
+	if req.ModTime > 0 {
+		modTime = time.UnixMilli(req.ModTime)
+	}
+	id, offset, err := s.sftp.BeginUpload(ctx, req.HostID, req.Dir, req.Name, req.Size, modTime, s.sftpPassword)
+	if err != nil {
+		return UploadResponse{}, s.sftpError(req.HostID, err)
+	}
+	return UploadResponse{UploadID: id, Offset: offset}, nil
+}
+
+func (s *Service) sftpUploadChunk(_ context.Context, req UploadRequest) (rpc.Empty, error) {
+	if req.UploadID == "" || req.DataB64 == "" {
+		return rpc.Empty{}, rpc.Fail(rpc.CodeBadRequest, nil)
+	}
+	data, err := base64.StdEncoding.DecodeString(req.DataB64)
+	if err != nil {
+		return rpc.Empty{}, rpc.Fail(rpc.CodeBadRequest, nil)
+	}
+	if err := s.sftp.UploadChunk(req.UploadID, data); err != nil {
+		return rpc.Empty{}, rpc.FailDetail("sftp_failed", err)
+	}
+	return rpc.Empty{}, nil
+}
+
+func (s *Service) sftpUploadEnd(ctx context.Context, req UploadRequest) (sftpclient.FileResult, error) {
+	if req.UploadID == "" {
+		return sftpclient.FileResult{}, rpc.Fail(rpc.CodeBadRequest, nil)
+	}
+	// The server hashes the whole file, which takes a while for large ones.
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Minute)
+	defer cancel()
+	res, err := s.sftp.EndUpload(ctx, req.UploadID)
+	if errors.Is(err, sftpclient.ErrChecksumMismatch) {
+		return res, rpc.FailDetail("checksum_mismatch", err)
+	}
+	if err != nil {
+		return res, rpc.FailDetail("sftp_failed", err)
+	}
+	return res, nil
+}
+
+func (s *Service) sftpRead(ctx context.Context, req PathRequest) (FileResponse, error) {
+	if req.HostID == 0 || req.Path == "" {
+		return FileResponse{}, rpc.Fail(rpc.CodeBadRequest, nil)
+	}
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	b, version, err := s.sftp.ReadFile(ctx, req.HostID, req.Path, sftpReadMax, s.sftpPassword)
+	if err != nil {
+		return FileResponse{}, s.sftpError(req.HostID, err)
+	}
+	return FileResponse{DataB64: base64.StdEncoding.EncodeToString(b), Version: version}, nil
+}
+
+func (s *Service) sftpWrite(ctx context.Context, req FileRequest) (sftpclient.WriteResult, error) {
+	if req.HostID == 0 || req.Path == "" || req.DataB64 == "" {
+		return sftpclient.WriteResult{}, rpc.Fail(rpc.CodeBadRequest, nil)
+	}
+	data, err := base64.StdEncoding.DecodeString(req.DataB64)
+	if err != nil {
+		return sftpclient.WriteResult{}, rpc.Fail(rpc.CodeBadRequest, nil)
+	}
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	res, err := s.sftp.WriteFile(ctx, req.HostID, req.Path, data, sftpclient.WriteOptions{
+		Version: req.Version,
+		Force:   req.Force,
+		Atomic:  req.Atomic,
+		Backup:  req.Backup,
+	}, s.sftpPassword)
+	if err != nil {
+		return sftpclient.WriteResult{}, s.sftpError(req.HostID, err)
+	}
+	return res, nil
+}
@@ -0,0 +1,93 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"github.com/ankouros/pterminal/internal/rpc"
+	"github.com/ankouros/pterminal/internal/sftpclient"
+)
+
+// TransferStartRequest copies Source (a remote path for downloads, a local
+// one for uploads) into DestDir. An empty DestDir downloads into the
+// download directory; an empty Conflict uses the app setting.
+type TransferStartRequest struct {
+	HostID    int    `json:"hostId"`
+	Direction string `json:"direction"`
+	Source    string `json:"source"`
+	DestDir   string `json:"destDir"`
+	Conflict  string `json:"conflict"`
+}
+
+// TransferRequest names a transfer.
+type TransferRequest struct {
+	TransferID string `json:"transferId"`
+}
+
+// TransferIDResponse names a queued transfer.
+type TransferIDResponse struct {
+	TransferID string `json:"transferId"`
+}
+
+// TransferResponse is one transfer.
+type TransferResponse struct {
+	Transfer sftpclient.Transfer `json:"transfer"`
+}
+
+// TransfersResponse lists the transfers, newest first.
+type TransfersResponse struct {
+	Transfers []sftpclient.Transfer `json:"transfers"`
+}
+
+func (s *Service) registerTransfers(r *rpc.Registry) {
+	rpc.Register(r, "transfer_start", s.transferStart)
+	rpc.Register(r, "transfer_list", func(context.Context, struct{}) (TransfersResponse, error) {
+		return TransfersResponse{Transfers: s.sftp.Transfers()}, nil
+	})
+	rpc.Register(r, "transfer_get", func(_ context.Context, req TransferRequest) (TransferResponse, error) {
+		t, found := s.sftp.Transfer(req.TransferID)
+		if !found {
+			return TransferResponse{}, rpc.Fail("not_found", nil)
+		}
+		return TransferResponse{Transfer: t}, nil
+	})
+	rpc.Register(r, "transfer_pause", transferAction(s.sftp.PauseTransfer))
+	rpc.Register(r, "transfer_resume", transferAction(s.sftp.ResumeTransfer))
+	rpc.Register(r, "transfer_cancel", transferAction(s.sftp.CancelTransfer))
+
+	r.Expose("transfer_start", "transfer_list", "transfer_get", "transfer_pause", "transfer_resume", "transfer_cancel")
+}
+
+// transferAction serves a pause, resume or cancel.
+func transferAction(fn func(id string) bool) func(context.Context, TransferRequest) (rpc.Empty, error) {
+	return func(_ context.Context, req TransferRequest) (rpc.Empty, error) {
+		if !fn(req.TransferID) {
+			return rpc.Empty{}, rpc.Fail("not_found", nil)
+		}
+		return rpc.Empty{}, nil
+	}
+}
+
+func (s *Service) transferStart(ctx context.Context, req TransferStartRequest) (TransferIDResponse, error) {
+	dir := sftpclient.Direction(req.Direction)
+	if req.HostID == 0 || (dir != sftpclient.DirectionUpload && dir != sftpclient.DirectionDownload) {
+		return TransferIDResponse{}, rpc.Fail(rpc.CodeBadRequest, nil)
+	}
+	policy, err := sftpclient.ParseConflictPolicy(req.Conflict, s.mgr.Config().Transfers)
+	if err != nil {
+		return TransferIDResponse{}, rpc.FailDetail(rpc.CodeBadRequest, err)
+	}
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	id, err := s.sftp.StartTransfer(ctx, sftpclient.TransferRequest{
+		HostID:    req.HostID,
+		Direction: dir,
+		Source:    req.Source,
+		DestDir:   req.DestDir,
+		Conflict:  policy,
+	}, s.sftpPassword)
+	if err != nil {
+		return TransferIDResponse{}, s.sftpError(req.HostID, err)
+	}
+	return TransferIDResponse{TransferID: id}, nil
+}
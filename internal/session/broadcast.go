@@ -0,0 +1,260 @@
+package session
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+/*
+Broadcast groups
+
+Input typed into a member tab is copied to every member of its group. Each
+member writes from its own queue, so a slow or stuck node never holds up the
+others: when a queue fills up the member is paused (Stalled) and skipped until
+it is resumed. Paused members neither send nor receive broadcast input.
+*/
+
+const (
+	broadcastQueueSize  = 1024
+	broadcastStallAfter = 200 * time.Millisecond
+)
+
+type BroadcastMember struct {
+	HostID  int  `json:"hostId"`
+	TabID   int  `json:"tabId"`
+	Paused  bool `json:"paused,omitempty"`
+	Stalled bool `json:"stalled,omitempty"`
+}
+
+type BroadcastGroup struct {
+	ID      string            `json:"id"`
+	Name    string            `json:"name,omitempty"`
+	Members []BroadcastMember `json:"members"`
+}
+
+type broadcastMember struct {
+	key     sessionKey
+	queue   chan []byte
+	quit    chan struct{}
+	paused  bool
+	stalled bool
+}
+
+type broadcastGroup struct {
+	id      string
+	name    string
+	members map[sessionKey]*broadcastMember
+}
+
+type broadcaster struct {
+	mu     sync.Mutex
+	seq    int
+	groups map[string]*broadcastGroup
+	byTab  map[sessionKey]*broadcastGroup
+}
+
+func newBroadcaster() *broadcaster {
+	return &broadcaster{
+		groups: make(map[string]*broadcastGroup),
+		byTab:  make(map[sessionKey]*broadcastGroup),
+	}
+}
+
+// BroadcastJoin adds a tab to a group. An empty groupID creates a new group
+// named name. A tab is in at most one group; joining moves it.
+func (m *Manager) BroadcastJoin(groupID, name string, hostID, tabID int) (string, error) {
+	if hostID == 0 {
+		return "", errors.New("host id is required")
+	}
+	k := makeSessionKey(hostID, tabID)
+	b := m.broadcast
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var g *broadcastGroup
+	if groupID == "" {
+		b.seq++
+		g = &broadcastGroup{
+			id:      "bc" + strconv.Itoa(b.seq),
+			name:    name,
+			members: make(map[sessionKey]*broadcastMember),
+		}
+		b.groups[g.id] = g
+	} else if g = b.groups[groupID]; g == nil {
+		return "", fmt.Errorf("broadcast group %q not found", groupID)
+	}
+
+	if cur := b.byTab[k]; cur == g {
+		return g.id, nil
+	} else if cur != nil {
+		b.removeLocked(cur, k)
+	}
+
+	mem := &broadcastMember{
+		key:   k,
+		queue: make(chan []byte, broadcastQueueSize),
+		quit:  make(chan struct{}),
+	}
+	g.members[k] = mem
+	b.byTab[k] = g
+	go m.broadcastWriter(mem)
+	return g.id, nil
+}
+
+// BroadcastLeave removes a tab from its group. Empty groups are dropped.
+func (m *Manager) BroadcastLeave(hostID, tabID int) {
+	k := makeSessionKey(hostID, tabID)
+	b := m.broadcast
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if g := b.byTab[k]; g != nil {
+		b.removeLocked(g, k)
+	}
+}
+
+// BroadcastPause pauses or resumes one member. Resuming clears Stalled.
+func (m *Manager) BroadcastPause(hostID, tabID int, paused bool) error {
+	k := makeSessionKey(hostID, tabID)
+	b := m.broadcast
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	g := b.byTab[k]
+	if g == nil {
+		return errors.New("tab is not in a broadcast group")
+	}
+	mem := g.members[k]
+	mem.paused = paused
+	mem.stalled = false
+	return nil
+}
+
+// BroadcastGroups lists the groups and their members.
+func (m *Manager) BroadcastGroups() []BroadcastGroup {
+	b := m.broadcast
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	out := make([]BroadcastGroup, 0, len(b.groups))
+	for _, g := range b.groups {
+		bg := BroadcastGroup{ID: g.id, Name: g.name, Members: make([]BroadcastMember, 0, len(g.members))}
+		for _, mem := range g.members {
+			bg.Members = append(bg.Members, BroadcastMember{
+				HostID:  mem.key.hostID,
+				TabID:   mem.key.tabID,
+				Paused:  mem.paused || mem.stalled,
+				Stalled: mem.stalled,
+			})
+		}
+		sort.Slice(bg.Members, func(i, j int) bool {
+			a, c := bg.Members[i], bg.Members[j]
+			if a.HostID != c.HostID {
+				return a.HostID < c.HostID
+			}
+			return a.TabID < c.TabID
+		})
+		out = append(out, bg)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	return out
+}
+
+func (b *broadcaster) removeLocked(g *broadcastGroup, k sessionKey) {
+	if mem := g.members[k]; mem != nil {
+		close(mem.quit)
+		delete(g.members, k)
+	}
+	delete(b.byTab, k)
+	if len(g.members) == 0 {
+		delete(b.groups, g.id)
+	}
+}
+
+func (b *broadcaster) reset() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, g := range b.groups {
+		for _, mem := range g.members {
+			close(mem.quit)
+		}
+	}
+	b.groups = make(map[string]*broadcastGroup)
+	b.byTab = make(map[sessionKey]*broadcastGroup)
+}
+
+// fanOut queues data for every active member of k's group. It reports false
+// when k is not an active member, so the caller writes to k directly.
+func (b *broadcaster) fanOut(k sessionKey, data []byte) bool {
+	b.mu.Lock()
+	g := b.byTab[k]
+	if g == nil {
+		b.mu.Unlock()
+		return false
+	}
+	if src := g.members[k]; src.paused || src.stalled {
+		b.mu.Unlock()
+		return false
+	}
+	targets := make([]*broadcastMember, 0, len(g.members))
+	for _, mem := range g.members {
+		if !mem.paused && !mem.stalled {
+			targets = append(targets, mem)
+		}
+	}
+	b.mu.Unlock()
+
+	var stalled []*broadcastMember
+	var grace <-chan time.Time
+	for _, mem := range targets {
+		select {
+		case mem.queue <- data:
+			continue
+		default:
+		}
+		// A full queue gets one short grace period per fan-out (bursts such as
+		// pastes); a node still not keeping up is stalled instead of blocking the group.
+		if grace == nil {
+			grace = time.After(broadcastStallAfter)
+		}
+		select {
+		case mem.queue <- data:
+		case <-grace:
+			stalled = append(stalled, mem)
+		}
+	}
+
+	if len(stalled) > 0 {
+		b.mu.Lock()
+		for _, mem := range stalled {
+			mem.stalled = true
+		}
+		b.mu.Unlock()
+	}
+	return true
+}
+
+func (m *Manager) broadcastWriter(mem *broadcastMember) {
+	for {
+		select {
+		case <-mem.quit:
+			return
+		case data := <-mem.queue:
+			m.mu.Lock()
+			ms := m.sessions[mem.key]
+			m.mu.Unlock()
+			if ms == nil {
+				continue
+			}
+			ms.mu.Lock()
+			sess := ms.Sess
+			ms.mu.Unlock()
+			if sess != nil {
+				_ = sess.Write(data)
+			}
+		}
+	}
+}
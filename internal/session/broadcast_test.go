@@ -0,0 +1,116 @@
+package session
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ankouros/pterminal/internal/model"
+)
+
+func addFakeTab(mgr *Manager, hostID, tabID int) *fakeSession {
+	fs := newFakeSession()
+	k := makeSessionKey(hostID, tabID)
+	mgr.sessions[k] = &ManagedSession{Key: k, Host: model.Host{ID: hostID}, Sess: fs, State: StateConnected}
+	return fs
+}
+
+func waitInput(t *testing.T, fs *fakeSession, want string) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for fs.Input() != want {
+		if time.Now().After(deadline) {
+			t.Fatalf("input = %q, want %q", fs.Input(), want)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestBroadcastFansOutInput(t *testing.T) {
+	mgr := NewManager(model.AppConfig{})
+	a := addFakeTab(mgr, 1, 1)
+	b := addFakeTab(mgr, 2, 1)
+	c := addFakeTab(mgr, 3, 1)
+	solo := addFakeTab(mgr, 4, 1)
+
+	id, err := mgr.BroadcastJoin("", "fabric", 1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, host := range []int{2, 3} {
+		if _, err := mgr.BroadcastJoin(id, "", host, 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write := func(hostID int, s string) {
+		t.Helper()
+		if err := mgr.WriteTab(hostID, 1, base64.StdEncoding.EncodeToString([]byte(s))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write(1, "uptime\r")
+	waitInput(t, a, "uptime\r")
+	waitInput(t, b, "uptime\r")
+	waitInput(t, c, "uptime\r")
+
+	// A paused member keeps its own input and stops receiving the group's.
+	if err := mgr.BroadcastPause(2, 1, true); err != nil {
+		t.Fatal(err)
+	}
+	write(2, "q")
+	write(3, "ls\r")
+	waitInput(t, a, "uptime\rls\r")
+	waitInput(t, b, "uptime\rq")
+	waitInput(t, c, "uptime\rls\r")
+
+	// Tabs outside the group are untouched.
+	write(4, "solo")
+	waitInput(t, solo, "solo")
+
+	mgr.BroadcastLeave(3, 1)
+	write(1, "x")
+	waitInput(t, a, "uptime\rls\rx")
+	time.Sleep(20 * time.Millisecond)
+	if c.Input() != "uptime\rls\r" {
+		t.Fatalf("member received input after leaving: %q", c.Input())
+	}
+
+	groups := mgr.BroadcastGroups()
+	if len(groups) != 1 || groups[0].Name != "fabric" || len(groups[0].Members) != 2 || !groups[0].Members[1].Paused {
+		t.Fatalf("unexpected groups: %+v", groups)
+	}
+}
+
+func TestBroadcastStalledMemberDoesNotBlock(t *testing.T) {
+	mgr := NewManager(model.AppConfig{})
+	fast := addFakeTab(mgr, 1, 1)
+	slow := addFakeTab(mgr, 2, 1)
+	slow.block = make(chan struct{})
+	defer close(slow.block)
+
+	id, _ := mgr.BroadcastJoin("", "", 1, 1)
+	_, _ = mgr.BroadcastJoin(id, "", 2, 1)
+
+	n := broadcastQueueSize + 10
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < n; i++ {
+			_ = mgr.WriteTab(1, 1, base64.StdEncoding.EncodeToString([]byte("k")))
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("writes blocked on a stuck member")
+	}
+	waitInput(t, fast, strings.Repeat("k", n))
+
+	groups := mgr.BroadcastGroups()
+	if m := groups[0].Members[1]; !m.Stalled || !m.Paused {
+		t.Fatalf("expected stuck member to be stalled: %+v", m)
+	}
+}
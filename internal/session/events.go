@@ -0,0 +1,43 @@
+package session
+
+/*
+State events
+
+A listener registered with SetStateListener hears every session state
+change (connecting, connected, disconnected), e.g. to stream them to clients
+of the control socket. It is called without the manager's locks held.
+*/
+
+// StateFunc is told about a tab's new state; err is why it disconnected, if
+// known.
+type StateFunc func(hostID, tabID int, state SessionState, err error)
+
+func (s SessionState) String() string {
+	switch s {
+	case StateConnected:
+		return "connected"
+	case StateReconnecting:
+		return "reconnecting"
+	}
+	return "disconnected"
+}
+
+// SetStateListener registers fn for state changes (nil removes it).
+func (m *Manager) SetStateListener(fn StateFunc) {
+	m.mu.Lock()
+	m.onState = fn
+	m.mu.Unlock()
+}
+
+func (m *Manager) emitState(ms *ManagedSession) {
+	m.mu.Lock()
+	fn := m.onState
+	m.mu.Unlock()
+	if fn == nil {
+		return
+	}
+	ms.mu.Lock()
+	k, state, err := ms.Key, ms.State, ms.Err
+	ms.mu.Unlock()
+	fn(k.hostID, k.tabID, state, err)
+}
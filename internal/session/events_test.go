@@ -0,0 +1,57 @@
+package session
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ankouros/pterminal/internal/model"
+)
+
+func TestStateListener(t *testing.T) {
+	// A port nothing listens on, so the connect attempt fails quickly.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().(*net.TCPAddr)
+	_ = ln.Close()
+
+	cfg := model.AppConfig{Networks: []model.Network{{ID: 1, Hosts: []model.Host{{
+		ID: 7, Host: addr.IP.String(), Port: addr.Port, User: "u",
+		Auth:    model.AuthConfig{Method: model.AuthAgent},
+		HostKey: model.HostKeyConfig{Mode: model.HostKeyInsecure},
+	}}}}}
+	mgr := NewManager(cfg)
+
+	var mu sync.Mutex
+	var states []string
+	done := make(chan struct{})
+	mgr.SetStateListener(func(hostID, tabID int, state SessionState, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if hostID != 7 || tabID != 2 {
+			t.Errorf("unexpected tab %d/%d", hostID, tabID)
+		}
+		states = append(states, state.String())
+		if state == StateDisconnected && err != nil {
+			close(done)
+		}
+	})
+
+	pw := func(int) (string, error) { return "", nil }
+	if _, _, err := mgr.StartConnectAsync(7, 2, 80, 24, pw, nil); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no disconnected event")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(states) != 2 || states[0] != "reconnecting" || states[1] != "disconnected" {
+		t.Fatalf("unexpected states %v", states)
+	}
+}
@@ -0,0 +1,162 @@
+package session
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/ankouros/pterminal/internal/forward"
+	"github.com/ankouros/pterminal/internal/model"
+	"github.com/ankouros/pterminal/internal/sshclient"
+	"github.com/ankouros/pterminal/internal/terminal"
+	"golang.org/x/crypto/ssh"
+)
+
+/*
+Port forwarding
+
+Forwards are per host and run on the SSH client of one of the host's live
+tabs. When that tab drops they wait, and come back when it (or another tab)
+reconnects.
+*/
+
+func sshClientOf(sess terminal.Session) *ssh.Client {
+	ns, ok := unwrapSession(sess).(*sshclient.NodeSession)
+	if !ok || ns == nil {
+		return nil
+	}
+	return ns.Client()
+}
+
+func (m *Manager) forwardSet(hostID int) *forward.Set {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	set := m.forwards[hostID]
+	if set == nil {
+		set = forward.NewSet()
+		m.forwards[hostID] = set
+	}
+	return set
+}
+
+// attachForwards runs the host's wanted forwards on sess unless another tab
+// already carries them. autoStart adds the host's AutoStart rules (fresh
+// user-initiated connects only, so a stopped rule stays stopped on reconnect).
+func (m *Manager) attachForwards(hostID int, sess terminal.Session, autoStart bool) {
+	client := sshClientOf(sess)
+	if client == nil {
+		return
+	}
+	set := m.forwardSet(hostID)
+	if set.Client() != nil {
+		return
+	}
+
+	var rules []model.PortForward
+	if autoStart {
+		if host, ok := m.findHost(hostID); ok {
+			for _, rule := range host.Forwards {
+				if rule.AutoStart {
+					rules = append(rules, rule)
+				}
+			}
+		}
+	}
+	set.Attach(client, rules)
+}
+
+// detachForwards moves the host's forwards off a closed session, onto another
+// live tab when there is one.
+func (m *Manager) detachForwards(hostID int, sess terminal.Session) {
+	client := sshClientOf(sess)
+	if client == nil {
+		return
+	}
+
+	m.mu.Lock()
+	set := m.forwards[hostID]
+	m.mu.Unlock()
+	if set == nil {
+		return
+	}
+	set.Detach(client)
+
+	if other := m.liveSession(hostID); other != nil {
+		m.attachForwards(hostID, other, false)
+	}
+}
+
+func (m *Manager) liveSession(hostID int) terminal.Session {
+	m.mu.Lock()
+	sessions := make([]*ManagedSession, 0, len(m.sessions))
+	for k, ms := range m.sessions {
+		if k.hostID == hostID && ms != nil {
+			sessions = append(sessions, ms)
+		}
+	}
+	m.mu.Unlock()
+
+	for _, ms := range sessions {
+		ms.mu.Lock()
+		sess := ms.Sess
+		ms.mu.Unlock()
+		if sess != nil && sshClientOf(sess) != nil {
+			return sess
+		}
+	}
+	return nil
+}
+
+func (m *Manager) findForward(hostID int, id string) (model.PortForward, error) {
+	host, ok := m.findHost(hostID)
+	if !ok {
+		return model.PortForward{}, fmt.Errorf("host %d not found", hostID)
+	}
+	for _, rule := range host.Forwards {
+		if rule.ID == id {
+			return rule, nil
+		}
+	}
+	return model.PortForward{}, fmt.Errorf("forward %s not found", id)
+}
+
+// StartForward starts a configured rule on the host's live SSH connection.
+func (m *Manager) StartForward(hostID int, id string) error {
+	rule, err := m.findForward(hostID, id)
+	if err != nil {
+		return err
+	}
+
+	set := m.forwardSet(hostID)
+	if set.Client() == nil {
+		sess := m.liveSession(hostID)
+		if sess == nil {
+			return errors.New("session not connected")
+		}
+		m.attachForwards(hostID, sess, false)
+	}
+	return set.Start(rule)
+}
+
+func (m *Manager) StopForward(hostID int, id string) {
+	m.mu.Lock()
+	set := m.forwards[hostID]
+	m.mu.Unlock()
+	if set != nil {
+		set.Stop(id)
+	}
+}
+
+// Forwards lists the host's rules with their state and byte counters.
+func (m *Manager) Forwards(hostID int) ([]forward.Stats, error) {
+	host, ok := m.findHost(hostID)
+	if !ok {
+		return nil, fmt.Errorf("host %d not found", hostID)
+	}
+	m.mu.Lock()
+	set := m.forwards[hostID]
+	m.mu.Unlock()
+	if set == nil {
+		set = forward.NewSet()
+	}
+	return set.Stats(host.Forwards), nil
+}
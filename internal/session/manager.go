@@ -6,10 +6,13 @@ import (
 	"errors"
 	"fmt"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/ankouros/pterminal/internal/cmdclient"
+	"github.com/ankouros/pterminal/internal/forward"
 	"github.com/ankouros/pterminal/internal/model"
+	"github.com/ankouros/pterminal/internal/scrollback"
 	"github.com/ankouros/pterminal/internal/sshclient"
 	"github.com/ankouros/pterminal/internal/terminal"
 )
@@ -41,6 +44,16 @@ type SessionInfo struct {
 	Attempts int          `json:"-"`
 	LastErr  string       `json:"-"`
 	Err      error        `json:"-"`
+
+	Recording bool `json:"-"`
+
+	// Certs lists the SSH certificates used by the connection, if any.
+	Certs *sshclient.ConnInfo `json:"-"`
+
+	// RTT is the last keepalive round trip (0 = not measured yet);
+	// KeepalivesMissed counts probes still waiting for a reply.
+	RTT              time.Duration `json:"-"`
+	KeepalivesMissed int           `json:"-"`
 }
 
 type ManagedSession struct {
@@ -72,22 +85,63 @@ type Manager struct {
 	bufBytes map[sessionKey]int
 	bufDrop  map[sessionKey]bool
 
+	// forwards holds per-host port forwarding state (see forwards.go).
+	forwards map[int]*forward.Set
+
+	// recordTabs holds per-tab recording overrides (see recording.go).
+	recordTabs map[sessionKey]bool
+
+	// broadcast fans tab input out to groups of tabs (see broadcast.go).
+	broadcast *broadcaster
+
+	// scrollback persists tab output across restarts (see scrollback.go).
+	scrollback atomic.Pointer[scrollback.Store]
+	sbMu       sync.Mutex
+	sbSettings *model.ScrollbackSettings
+	restored   map[sessionKey]bool
+	// hostUIDs caches the UID of each host ID in cfg; reset with cfg.
+	hostUIDs map[int]string
+
 	passwordProvider func(hostID int) (string, error)
+
+	// agentConfirm approves forwarded-agent signatures for confirm-mode hosts.
+	agentConfirm sshclient.AgentConfirmFunc
+
+	// onState hears state changes (see events.go).
+	onState StateFunc
 }
 
 func NewManager(cfg model.AppConfig) *Manager {
-	return &Manager{
+	m := &Manager{
 		cfg:      cfg,
 		sessions: make(map[sessionKey]*ManagedSession),
 		buffers:  make(map[sessionKey][][]byte),
 		bufBytes: make(map[sessionKey]int),
 		bufDrop:  make(map[sessionKey]bool),
+		forwards: make(map[int]*forward.Set),
+
+		recordTabs: make(map[sessionKey]bool),
+		broadcast:  newBroadcaster(),
+		restored:   make(map[sessionKey]bool),
+		hostUIDs:   make(map[int]string),
 	}
+	m.configureScrollback(cfg.Scrollback)
+	return m
 }
 
 func (m *Manager) SetConfig(cfg model.AppConfig) {
 	m.mu.Lock()
 	m.cfg = cfg
+	m.hostUIDs = make(map[int]string)
+	m.mu.Unlock()
+	m.configureScrollback(cfg.Scrollback)
+}
+
+// SetAgentConfirm sets the callback asked before a remote host signs with the
+// forwarded agent (hosts with ForwardAgent "confirm").
+func (m *Manager) SetAgentConfirm(fn sshclient.AgentConfirmFunc) {
+	m.mu.Lock()
+	m.agentConfirm = fn
 	m.mu.Unlock()
 }
 
@@ -141,11 +195,13 @@ func (m *Manager) Ensure(
 	if !ok {
 		return nil, fmt.Errorf("host %d not found", hostID)
 	}
+	m.restoreScrollback(k)
 
 	sess, err := m.dial(ctx, host, cols, rows, pw)
 	if err != nil {
 		return nil, err
 	}
+	sess = wrapRecorded(sess, cols, rows, m.planRecording(k, host))
 
 	ms := &ManagedSession{
 		Key:           k,
@@ -160,8 +216,10 @@ func (m *Manager) Ensure(
 	m.mu.Lock()
 	m.sessions[k] = ms
 	m.mu.Unlock()
+	m.emitState(ms)
 
 	go m.monitor(ms)
+	m.attachForwards(hostID, sess, true)
 	return sess, nil
 }
 
@@ -249,11 +307,14 @@ func (m *Manager) StartConnectAsync(
 	ms.mu.Unlock()
 
 	m.mu.Unlock()
+	m.emitState(ms)
+	m.restoreScrollback(k)
 
 	go func() {
 		defer cancel()
 
 		sess, err := m.dial(ctx, host, cols, rows, pw)
+		plan := m.planRecording(k, host)
 
 		// If disconnected or a newer connect attempt started, discard the result.
 		m.mu.Lock()
@@ -278,20 +339,24 @@ func (m *Manager) StartConnectAsync(
 			ms.Attempts = 0
 			ms.AutoReconnect = false
 			ms.mu.Unlock()
+			m.emitState(ms)
 			if onResult != nil {
 				onResult(nil, err)
 			}
 			return
 		}
 
+		sess = wrapRecorded(sess, cols, rows, plan)
 		ms.Sess = sess
 		ms.State = StateConnected
 		ms.Err = nil
 		ms.Attempts = 0
 		ms.AutoReconnect = host.Driver == "" || host.Driver == model.DriverSSH
 		ms.mu.Unlock()
+		m.emitState(ms)
 
 		go m.monitor(ms)
+		m.attachForwards(hostID, sess, true)
 		if onResult != nil {
 			onResult(sess, nil)
 		}
@@ -312,11 +377,17 @@ func (m *Manager) monitor(ms *ManagedSession) {
 	ms.mu.Lock()
 	ms.State = StateDisconnected
 	ms.Sess = nil
+	if ns, ok := unwrapSession(sess).(*sshclient.NodeSession); ok && ns.Err() != nil {
+		ms.Err = ns.Err()
+	}
 	if ms.Err == nil {
 		ms.Err = errors.New("connection lost")
 	}
 	auto := ms.AutoReconnect
 	ms.mu.Unlock()
+	m.emitState(ms)
+
+	m.detachForwards(ms.Host.ID, sess)
 
 	if auto {
 		go m.reconnect(ms)
@@ -348,6 +419,7 @@ func (m *Manager) reconnect(ms *ManagedSession) {
 		ms.State = StateReconnecting
 		ms.Attempts = attempt
 		ms.mu.Unlock()
+		m.emitState(ms)
 
 		time.Sleep(backoff(attempt))
 
@@ -376,6 +448,7 @@ func (m *Manager) reconnect(ms *ManagedSession) {
 				ms.Err = err
 				ms.Attempts = 0
 				ms.mu.Unlock()
+				m.emitState(ms)
 				return
 			}
 
@@ -385,6 +458,8 @@ func (m *Manager) reconnect(ms *ManagedSession) {
 			continue
 		}
 
+		sess = wrapRecorded(sess, ms.cols, ms.rows, m.planRecording(ms.Key, ms.Host))
+
 		ms.mu.Lock()
 		ms.Sess = sess
 		ms.State = StateConnected
@@ -392,8 +467,10 @@ func (m *Manager) reconnect(ms *ManagedSession) {
 		ms.Attempts = 0
 		ms.AutoReconnect = true
 		ms.mu.Unlock()
+		m.emitState(ms)
 
 		go m.monitor(ms)
+		m.attachForwards(ms.Host.ID, sess, false)
 		return
 	}
 }
@@ -442,6 +519,17 @@ func (m *Manager) SessionInfoTab(hostID, tabID int) SessionInfo {
 	if ms.Err != nil {
 		info.LastErr = ms.Err.Error()
 	}
+	if rs, ok := ms.Sess.(*recordedSession); ok {
+		info.Recording = rs.recorder() != nil
+	}
+	if ns, ok := unwrapSession(ms.Sess).(*sshclient.NodeSession); ok && ns != nil {
+		info.RTT = ns.RTT()
+		info.KeepalivesMissed = ns.KeepalivesMissed()
+		if ns.Certs.UserCert != nil || ns.Certs.HostCert != nil {
+			certs := ns.Certs
+			info.Certs = &certs
+		}
+	}
 	return info
 }
 
@@ -449,6 +537,9 @@ func (m *Manager) BufferOutput(hostID int, data []byte) { m.BufferOutputTab(host
 
 func (m *Manager) BufferOutputTab(hostID, tabID int, data []byte) {
 	k := makeSessionKey(hostID, tabID)
+	if store := m.scrollback.Load(); store != nil {
+		store.Append(m.hostUID(k.hostID), k.tabID, data)
+	}
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
@@ -583,6 +674,9 @@ func (m *Manager) WriteTab(hostID, tabID int, b64 string) error {
 	if err != nil {
 		return err
 	}
+	if m.broadcast.fanOut(k, data) {
+		return nil
+	}
 	return sess.Write(data)
 }
 
@@ -599,12 +693,19 @@ func (m *Manager) dial(
 
 	switch driver {
 	case model.DriverSSH:
+		opts, err := sshclient.ResolveDialOptions(m.Config(), host, pw)
+		if err != nil {
+			return nil, err
+		}
+		m.mu.Lock()
+		opts.ConfirmAgent = m.agentConfirm
+		m.mu.Unlock()
 		return sshclient.DialAndStart(ctx, host, cols, rows, func() (string, error) {
 			if pw == nil {
 				return "", errors.New("password provider not set")
 			}
 			return pw(host.ID)
-		})
+		}, opts)
 
 	case model.DriverTelecom, model.DriverIOShell:
 		return cmdclient.StartTelecom(ctx, host, cols, rows)
@@ -628,8 +729,21 @@ func (m *Manager) DisconnectTab(hostID, tabID int) error {
 	delete(m.buffers, k)
 	delete(m.bufBytes, k)
 	delete(m.bufDrop, k)
+	delete(m.recordTabs, k)
+	delete(m.restored, k)
+	// Forwards end with the host's last tab.
+	var forwards *forward.Set
+	if !m.hasSessionsLocked(hostID) {
+		forwards = m.forwards[hostID]
+		delete(m.forwards, hostID)
+	}
 	m.mu.Unlock()
 
+	if forwards != nil {
+		forwards.StopAll()
+	}
+	m.BroadcastLeave(hostID, tabID)
+
 	ms.mu.Lock()
 	if ms.connectCancel != nil {
 		ms.connectCancel()
@@ -643,11 +757,17 @@ func (m *Manager) DisconnectTab(hostID, tabID int) error {
 	ms.Attempts = 0
 	ms.Err = nil
 	ms.mu.Unlock()
+	m.emitState(ms)
 
+	var err error
 	if sess != nil {
-		return sess.Close()
+		err = sess.Close()
 	}
-	return nil
+	// A disconnected tab is cleared in the UI; do not bring its output back.
+	if store := m.scrollback.Load(); store != nil {
+		store.Remove(m.hostUID(k.hostID), k.tabID)
+	}
+	return err
 }
 
 func (m *Manager) DisconnectAll() {
@@ -662,8 +782,16 @@ func (m *Manager) DisconnectAll() {
 	m.buffers = make(map[sessionKey][][]byte)
 	m.bufBytes = make(map[sessionKey]int)
 	m.bufDrop = make(map[sessionKey]bool)
+	m.recordTabs = make(map[sessionKey]bool)
+	forwards := m.forwards
+	m.forwards = make(map[int]*forward.Set)
 	m.mu.Unlock()
 
+	for _, set := range forwards {
+		set.StopAll()
+	}
+	m.broadcast.reset()
+
 	for _, ms := range sessions {
 		ms.mu.Lock()
 		if ms.connectCancel != nil {
@@ -683,6 +811,16 @@ func (m *Manager) DisconnectAll() {
 			_ = sess.Close()
 		}
 	}
+	_ = m.FlushScrollback()
+}
+
+func (m *Manager) hasSessionsLocked(hostID int) bool {
+	for k := range m.sessions {
+		if k.hostID == hostID {
+			return true
+		}
+	}
+	return false
 }
 
 func (m *Manager) findHost(id int) (model.Host, bool) {
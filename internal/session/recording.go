@@ -0,0 +1,231 @@
+package session
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/ankouros/pterminal/internal/model"
+	"github.com/ankouros/pterminal/internal/recording"
+	"github.com/ankouros/pterminal/internal/terminal"
+)
+
+/*
+Session recording
+
+Every dialed session is wrapped in a recordedSession that tees output (and,
+when enabled, input and resizes) into an asciicast recorder. Recording is on
+when the host enables it, unless the tab overrides it; a reconnect starts a
+new file.
+*/
+
+type recordedSession struct {
+	terminal.Session
+	out chan []byte
+
+	mu   sync.Mutex
+	rec  *recording.Recorder
+	cols int
+	rows int
+}
+
+func newRecordedSession(sess terminal.Session, cols, rows int) *recordedSession {
+	rs := &recordedSession{
+		Session: sess,
+		out:     make(chan []byte, 512),
+		cols:    cols,
+		rows:    rows,
+	}
+	go rs.pump()
+	return rs
+}
+
+func (rs *recordedSession) pump() {
+	in := rs.Session.Output()
+	for chunk := range in {
+		rec := rs.recorder()
+		if rec != nil {
+			rec.Output(chunk)
+			// Flush at the end of each burst so a crash loses little.
+			if len(in) == 0 {
+				_ = rec.Flush()
+			}
+		}
+		rs.out <- chunk
+	}
+	close(rs.out)
+	rs.stop()
+}
+
+func (rs *recordedSession) recorder() *recording.Recorder {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	return rs.rec
+}
+
+func (rs *recordedSession) Output() <-chan []byte { return rs.out }
+
+func (rs *recordedSession) Write(p []byte) error {
+	if rec := rs.recorder(); rec != nil {
+		rec.Input(p)
+	}
+	return rs.Session.Write(p)
+}
+
+func (rs *recordedSession) Resize(cols, rows int) error {
+	rs.mu.Lock()
+	rs.cols, rs.rows = cols, rows
+	rec := rs.rec
+	rs.mu.Unlock()
+	if rec != nil {
+		rec.Resize(cols, rows)
+	}
+	return rs.Session.Resize(cols, rows)
+}
+
+func (rs *recordedSession) start(opts recording.Options, meta recording.Meta) error {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	if rs.rec != nil {
+		return nil
+	}
+	meta.Cols, meta.Rows = rs.cols, rs.rows
+	rec, err := recording.Start(opts, meta)
+	if err != nil {
+		return err
+	}
+	rs.rec = rec
+	return nil
+}
+
+func (rs *recordedSession) stop() {
+	rs.mu.Lock()
+	rec := rs.rec
+	rs.rec = nil
+	rs.mu.Unlock()
+	if rec != nil {
+		_ = rec.Close()
+	}
+}
+
+// unwrapSession returns the dialed session under the recording wrapper.
+func unwrapSession(sess terminal.Session) terminal.Session {
+	if rs, ok := sess.(*recordedSession); ok {
+		return rs.Session
+	}
+	return sess
+}
+
+// RecordingDir returns the directory recordings are written to.
+func (m *Manager) RecordingDir() (string, error) {
+	return recording.Dir(m.Config().Recording)
+}
+
+func (m *Manager) recordingOptions(host model.Host) (recording.Options, error) {
+	cfg := m.Config()
+	dir, err := recording.Dir(cfg.Recording)
+	if err != nil {
+		return recording.Options{}, err
+	}
+	opts := recording.Options{Dir: dir, MaxBytes: recording.MaxBytes(cfg.Recording)}
+	if host.Recording != nil {
+		opts.Input = host.Recording.Input
+		opts.Resize = host.Recording.Resize
+	}
+	return opts, nil
+}
+
+type recordingPlan struct {
+	on   bool
+	opts recording.Options
+	meta recording.Meta
+}
+
+// planRecording decides whether a new session for k records. It takes m.mu,
+// so call it before locking the ManagedSession.
+func (m *Manager) planRecording(k sessionKey, host model.Host) recordingPlan {
+	m.mu.Lock()
+	on, override := m.recordTabs[k]
+	m.mu.Unlock()
+	if !override {
+		on = host.Recording != nil && host.Recording.Enabled
+	}
+	if !on {
+		return recordingPlan{}
+	}
+	opts, err := m.recordingOptions(host)
+	if err != nil {
+		return recordingPlan{}
+	}
+	return recordingPlan{
+		on:   true,
+		opts: opts,
+		meta: recording.Meta{HostID: host.ID, TabID: k.tabID, HostName: host.Name},
+	}
+}
+
+// wrapRecorded installs the recording tee on a freshly dialed session.
+// Recording is best-effort; a storage problem must not block the terminal.
+func wrapRecorded(sess terminal.Session, cols, rows int, plan recordingPlan) terminal.Session {
+	rs := newRecordedSession(sess, cols, rows)
+	if plan.on {
+		_ = rs.start(plan.opts, plan.meta)
+	}
+	return rs
+}
+
+// SetRecording switches recording for one tab, overriding the host setting
+// until the tab is closed. It applies immediately when the tab is connected.
+func (m *Manager) SetRecording(hostID, tabID int, on bool) error {
+	k := makeSessionKey(hostID, tabID)
+
+	m.mu.Lock()
+	m.recordTabs[k] = on
+	ms := m.sessions[k]
+	m.mu.Unlock()
+	if ms == nil {
+		return nil
+	}
+
+	ms.mu.Lock()
+	rs, _ := ms.Sess.(*recordedSession)
+	host := ms.Host
+	ms.mu.Unlock()
+	if rs == nil {
+		return nil
+	}
+
+	if !on {
+		rs.stop()
+		return nil
+	}
+	opts, err := m.recordingOptions(host)
+	if err != nil {
+		return err
+	}
+	if err := rs.start(opts, recording.Meta{HostID: hostID, TabID: k.tabID, HostName: host.Name}); err != nil {
+		return fmt.Errorf("start recording: %w", err)
+	}
+	return nil
+}
+
+// RecordingPath returns the file a tab is recording into, or "" when it is not recording.
+func (m *Manager) RecordingPath(hostID, tabID int) string {
+	k := makeSessionKey(hostID, tabID)
+	m.mu.Lock()
+	ms := m.sessions[k]
+	m.mu.Unlock()
+	if ms == nil {
+		return ""
+	}
+
+	ms.mu.Lock()
+	rs, _ := ms.Sess.(*recordedSession)
+	ms.mu.Unlock()
+	if rs == nil {
+		return ""
+	}
+	if rec := rs.recorder(); rec != nil {
+		return rec.Path()
+	}
+	return ""
+}
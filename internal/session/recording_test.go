@@ -0,0 +1,98 @@
+package session
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/ankouros/pterminal/internal/model"
+)
+
+type fakeSession struct {
+	out  chan []byte
+	done chan struct{}
+
+	mu      sync.Mutex
+	written []byte
+	block   chan struct{} // when set, Write waits for it to close
+}
+
+func newFakeSession() *fakeSession {
+	return &fakeSession{out: make(chan []byte, 8), done: make(chan struct{})}
+}
+
+func (f *fakeSession) Write(p []byte) error {
+	if f.block != nil {
+		<-f.block
+	}
+	f.mu.Lock()
+	f.written = append(f.written, p...)
+	f.mu.Unlock()
+	return nil
+}
+
+func (f *fakeSession) Input() string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return string(f.written)
+}
+
+func (f *fakeSession) Resize(cols, rows int) error { return nil }
+func (f *fakeSession) Output() <-chan []byte       { return f.out }
+func (f *fakeSession) Done() <-chan struct{}       { return f.done }
+func (f *fakeSession) Close() error {
+	close(f.out)
+	close(f.done)
+	return nil
+}
+
+func TestRecordedSessionTeesOutput(t *testing.T) {
+	dir := t.TempDir()
+	host := model.Host{ID: 1, Name: "web", Recording: &model.RecordingConfig{Enabled: true}}
+	mgr := NewManager(model.AppConfig{
+		Networks:  []model.Network{{ID: 1, Hosts: []model.Host{host}}},
+		Recording: &model.RecordingSettings{Dir: dir},
+	})
+
+	k := makeSessionKey(1, 1)
+	inner := newFakeSession()
+	sess := wrapRecorded(inner, 80, 24, mgr.planRecording(k, host))
+	mgr.sessions[k] = &ManagedSession{Key: k, Host: host, Sess: sess}
+
+	if !mgr.SessionInfoTab(1, 1).Recording {
+		t.Fatal("expected host setting to start recording")
+	}
+
+	inner.out <- []byte("hello")
+	if got := string(<-sess.Output()); got != "hello" {
+		t.Fatalf("output not passed through: %q", got)
+	}
+
+	// The tab override stops recording without touching the stream.
+	if err := mgr.SetRecording(1, 1, false); err != nil {
+		t.Fatal(err)
+	}
+	if mgr.RecordingPath(1, 1) != "" {
+		t.Fatal("expected recording to stop")
+	}
+	inner.out <- []byte("unrecorded")
+	<-sess.Output()
+
+	_ = sess.Close()
+	for range sess.Output() {
+	}
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*.cast"))
+	if len(files) != 1 {
+		t.Fatalf("expected one recording, got %v", files)
+	}
+	data, err := os.ReadFile(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `"o", "hello"`) || strings.Contains(string(data), "unrecorded") {
+		t.Fatalf("unexpected recording:\n%s", data)
+	}
+}
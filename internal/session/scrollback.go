@@ -0,0 +1,120 @@
+package session
+
+import (
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/ankouros/pterminal/internal/model"
+	"github.com/ankouros/pterminal/internal/scrollback"
+)
+
+/*
+Scrollback persistence
+
+When enabled in the app settings, tab output is also written to a
+scrollback.Store. The first time a tab connects in this process, the stored
+output is queued ahead of the new session's output, followed by a "restored"
+divider, so the terminal picks up where it was before a restart.
+*/
+
+// restoreReset leaves any alternate screen, attributes or hidden cursor the
+// restored output ended in, so the new session starts from a clean state.
+const restoreReset = "\x1b[0m\x1b[?1047l\x1b[?25h"
+
+func restoredDivider(at time.Time) []byte {
+	return []byte(fmt.Sprintf("%s\r\n\x1b[2m──── restored from %s ────\x1b[0m\r\n",
+		restoreReset, at.Format("2006-01-02 15:04")))
+}
+
+// configureScrollback opens, reopens or closes the store to match settings.
+// Turning persistence off deletes what was stored.
+func (m *Manager) configureScrollback(settings *model.ScrollbackSettings) {
+	m.sbMu.Lock()
+	defer m.sbMu.Unlock()
+
+	if reflect.DeepEqual(settings, m.sbSettings) {
+		return
+	}
+	m.sbSettings = settings
+
+	if old := m.scrollback.Swap(nil); old != nil {
+		if settings == nil || !settings.Enabled {
+			_ = old.Clear()
+		} else {
+			_ = old.Close()
+		}
+	}
+	if settings == nil || !settings.Enabled {
+		return
+	}
+	opts, err := scrollback.OptionsFrom(settings)
+	if err != nil {
+		return
+	}
+	// Persistence is best-effort; a storage problem must not block terminals.
+	store, err := scrollback.Open(opts)
+	if err != nil {
+		return
+	}
+	m.scrollback.Store(store)
+}
+
+// restoreScrollback queues a tab's stored output once per process.
+func (m *Manager) restoreScrollback(k sessionKey) {
+	store := m.scrollback.Load()
+	if store == nil {
+		return
+	}
+
+	m.mu.Lock()
+	done := m.restored[k]
+	m.restored[k] = true
+	m.mu.Unlock()
+	if done {
+		return
+	}
+
+	uid := m.hostUID(k.hostID)
+	data, at, ok := store.Restore(uid, k.tabID)
+	if !ok {
+		return
+	}
+	divider := restoredDivider(at)
+	// Keep the divider in the stored history too, so a later restore shows
+	// where each earlier run ended.
+	store.Append(uid, k.tabID, divider)
+
+	m.mu.Lock()
+	m.buffers[k] = append([][]byte{data, divider}, m.buffers[k]...)
+	m.bufBytes[k] += len(data) + len(divider)
+	m.mu.Unlock()
+}
+
+// hostUID names a host in the scrollback store: numeric IDs are local to
+// one config and may be reused for another host after an import or a sync.
+func (m *Manager) hostUID(hostID int) string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if uid, ok := m.hostUIDs[hostID]; ok {
+		return uid
+	}
+	var uid string
+	for _, netw := range m.cfg.Networks {
+		for _, h := range netw.Hosts {
+			if h.ID == hostID {
+				uid = h.UID
+			}
+		}
+	}
+	m.hostUIDs[hostID] = uid
+	return uid
+}
+
+// FlushScrollback writes pending scrollback to disk.
+func (m *Manager) FlushScrollback() error {
+	if store := m.scrollback.Load(); store != nil {
+		return store.Flush()
+	}
+	return nil
+}
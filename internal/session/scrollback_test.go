@@ -0,0 +1,56 @@
+package session
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ankouros/pterminal/internal/model"
+)
+
+func TestScrollbackRestoredAfterRestart(t *testing.T) {
+	dir := t.TempDir()
+	cfg := model.AppConfig{
+		Scrollback: &model.ScrollbackSettings{Enabled: true, Dir: dir},
+		Networks:   []model.Network{{ID: 1, Hosts: []model.Host{{ID: 1, UID: "host-a"}}}},
+	}
+
+	mgr := NewManager(cfg)
+	mgr.BufferOutputTab(1, 2, []byte("$ make\r\nok\r\n"))
+	mgr.DisconnectAll()
+	_ = mgr.scrollback.Load().Close()
+
+	// Simulate the next app start.
+	next := NewManager(cfg)
+	k := makeSessionKey(1, 2)
+	next.restoreScrollback(k)
+	next.restoreScrollback(k)
+
+	chunks := next.DrainBufferedTab(1, 2)
+	if len(chunks) != 2 || string(chunks[0]) != "$ make\r\nok\r\n" {
+		t.Fatalf("unexpected restored chunks: %q", chunks)
+	}
+	if !bytes.Contains(chunks[1], []byte("restored from")) {
+		t.Fatalf("missing divider: %q", chunks[1])
+	}
+
+	// Output follows the host's UID, not its numeric ID.
+	renumbered := cfg
+	renumbered.Networks = []model.Network{{ID: 1, Hosts: []model.Host{{ID: 1, UID: "host-b"}, {ID: 7, UID: "host-a"}}}}
+	moved := NewManager(renumbered)
+	moved.restoreScrollback(makeSessionKey(1, 2))
+	if chunks := moved.DrainBufferedTab(1, 2); len(chunks) != 0 {
+		t.Fatalf("another host's scrollback restored: %q", chunks)
+	}
+	moved.restoreScrollback(makeSessionKey(7, 2))
+	if chunks := moved.DrainBufferedTab(7, 2); len(chunks) == 0 || string(chunks[0]) != "$ make\r\nok\r\n" {
+		t.Fatalf("scrollback lost with the host's new ID: %q", chunks)
+	}
+
+	// Disabling persistence deletes what was stored.
+	next.SetConfig(model.AppConfig{})
+	if _, err := os.Stat(filepath.Join(dir, "host-a-t2.log")); !os.IsNotExist(err) {
+		t.Fatalf("scrollback not cleared: %v", err)
+	}
+}
@@ -0,0 +1,227 @@
+package sftpclient
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/sftp"
+)
+
+/*
+Editing remote files
+
+ReadFile returns a version token, "<mtime>-<size>-<sha256>", that WriteFile
+checks before saving: when the file's size or content changed since it was
+read, the save is refused with ErrRemoteChanged so someone else's edit is not
+lost. A touched but unchanged file is not a conflict. Force skips the check.
+
+Saves rewrite the file in place by default, which keeps its inode, mode and
+owner. An atomic save writes ".<name>.pterminal-edit" next to it, copies the
+mode and owner over and renames it into place, so readers never see a half
+written file. When the owner cannot be kept (we are not root), the save falls
+back to in place.
+*/
+
+// ErrRemoteChanged means a file changed on the server since it was read.
+var ErrRemoteChanged = errors.New("remote file changed since it was read")
+
+const (
+	editSuffix   = ".pterminal-edit"
+	backupSuffix = ".bak"
+)
+
+// WriteOptions control WriteFile.
+type WriteOptions struct {
+	// Version is the token ReadFile returned ("" for a new file).
+	Version string
+	// Force saves even when the file changed.
+	Force bool
+	// Atomic and Backup override the editor settings when set.
+	Atomic *bool
+	Backup *bool
+}
+
+// WriteResult describes a save.
+type WriteResult struct {
+	// Version is the saved file's new token.
+	Version string `json:"version"`
+	Atomic  bool   `json:"atomic"`
+	// Backup is the path of the previous content's copy, if one was kept.
+	Backup string `json:"backup,omitempty"`
+}
+
+// fileVersion is the token for data read from a file with info fi.
+func fileVersion(fi os.FileInfo, data []byte) string {
+	sum := sha256.Sum256(data)
+	return fmt.Sprintf("%d-%d-%s", fi.ModTime().Unix(), len(data), hex.EncodeToString(sum[:]))
+}
+
+// checkVersion reports ErrRemoteChanged when current (nil if the file does
+// not exist) no longer matches version.
+func checkVersion(version string, current []byte) error {
+	if version == "" {
+		if current != nil {
+			return fmt.Errorf("%w: the file already exists", ErrRemoteChanged)
+		}
+		return nil
+	}
+	if current == nil {
+		return fmt.Errorf("%w: the file was deleted", ErrRemoteChanged)
+	}
+	parts := strings.SplitN(version, "-", 3)
+	if len(parts) != 3 {
+		return errors.New("invalid file version")
+	}
+	size, err := strconv.Atoi(parts[1])
+	want, herr := hex.DecodeString(parts[2])
+	if err != nil || herr != nil {
+		return errors.New("invalid file version")
+	}
+	sum := sha256.Sum256(current)
+	if size != len(current) || !bytes.Equal(sum[:], want) {
+		return ErrRemoteChanged
+	}
+	return nil
+}
+
+// WriteFile saves data to a remote file that was read with version opts.Version.
+func (m *Manager) WriteFile(ctx context.Context, hostID int, remotePath string, data []byte, opts WriteOptions, passwordProvider func(hostID int) (string, error)) (WriteResult, error) {
+	var res WriteResult
+	c, err := m.ensure(ctx, hostID, passwordProvider)
+	if err != nil {
+		return res, err
+	}
+	p := cleanRemotePath(remotePath)
+
+	m.mu.Lock()
+	var atomic, backup bool
+	if s := m.cfg.Editor; s != nil {
+		atomic, backup = s.AtomicSave, s.Backup
+	}
+	m.mu.Unlock()
+	if opts.Atomic != nil {
+		atomic = *opts.Atomic
+	}
+	if opts.Backup != nil {
+		backup = *opts.Backup
+	}
+
+	fi, err := c.Stat(p)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return res, err
+	}
+	exists := err == nil
+	if !exists {
+		fi = nil
+	} else if fi.IsDir() {
+		return res, errors.New("path is a directory")
+	}
+
+	var current []byte
+	if exists && (!opts.Force || backup) {
+		if current, err = readAll(c, p); err != nil {
+			return res, err
+		}
+	}
+	if !opts.Force {
+		if err := checkVersion(opts.Version, current); err != nil {
+			return res, err
+		}
+	}
+
+	if backup && exists {
+		bak := p + backupSuffix
+		if err := writeInPlace(c, bak, current); err != nil {
+			return res, fmt.Errorf("backup: %w", err)
+		}
+		_ = c.Chmod(bak, fi.Mode().Perm())
+		res.Backup = bak
+	}
+
+	err = nil
+	if atomic {
+		res.Atomic, err = writeAtomic(c, p, data, fi)
+	}
+	if err == nil && !res.Atomic {
+		err = writeInPlace(c, p, data)
+	}
+	if err != nil {
+		return res, err
+	}
+
+	if fi, err := c.Stat(p); err == nil {
+		res.Version = fileVersion(fi, data)
+	}
+	return res, nil
+}
+
+func readAll(c *sftp.Client, p string) ([]byte, error) {
+	f, err := c.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	b, err := io.ReadAll(f)
+	if b == nil && err == nil {
+		b = []byte{}
+	}
+	return b, err
+}
+
+func writeInPlace(c *sftp.Client, p string, data []byte) error {
+	f, err := c.OpenFile(p, os.O_CREATE|os.O_TRUNC|os.O_WRONLY)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// writeAtomic replaces p (with info fi, nil when new) by a renamed temporary
+// file. It reports false without an error when the original owner cannot be
+// kept and the caller should write in place instead.
+func writeAtomic(c *sftp.Client, p string, data []byte, fi os.FileInfo) (bool, error) {
+	// Replace a symlink's target, not the link.
+	if lfi, err := c.Lstat(p); err == nil && lfi.Mode()&os.ModeSymlink != 0 {
+		target, err := c.RealPath(p)
+		if err != nil {
+			return false, err
+		}
+		p = target
+	}
+	tmp := path.Join(path.Dir(p), "."+path.Base(p)+editSuffix)
+	if err := writeInPlace(c, tmp, data); err != nil {
+		return false, err
+	}
+	if fi != nil {
+		err := c.Chmod(tmp, fi.Mode().Perm())
+		if st, ok := fi.Sys().(*sftp.FileStat); ok && err == nil {
+			err = c.Chown(tmp, int(st.UID), int(st.GID))
+			if err != nil {
+				_ = c.Remove(tmp)
+				return false, nil
+			}
+		}
+		if err != nil {
+			_ = c.Remove(tmp)
+			return false, err
+		}
+	}
+	if err := (remoteFS{c}).Rename(tmp, p); err != nil {
+		_ = c.Remove(tmp)
+		return false, err
+	}
+	return true, nil
+}
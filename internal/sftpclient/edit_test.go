@@ -0,0 +1,68 @@
+package sftpclient
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestWriteFileDetectsRemoteChanges(t *testing.T) {
+	m, c := newMemManager(t)
+	ctx := context.Background()
+	writeRemote(t, c, "/motd", "hello\n")
+
+	data, version, err := m.ReadFile(ctx, 1, "/motd", 0, nil)
+	if err != nil || string(data) != "hello\n" || version == "" {
+		t.Fatalf("read: %q %q %v", data, version, err)
+	}
+
+	// Someone else edits the file in the meantime.
+	writeRemote(t, c, "/motd", "HELLO\n")
+	if _, err := m.WriteFile(ctx, 1, "/motd", []byte("mine\n"), WriteOptions{Version: version}, nil); !errors.Is(err, ErrRemoteChanged) {
+		t.Fatalf("expected ErrRemoteChanged, got %v", err)
+	}
+	if got := readRemote(t, c, "/motd"); got != "HELLO\n" {
+		t.Fatalf("their edit was overwritten: %q", got)
+	}
+	if _, err := m.WriteFile(ctx, 1, "/motd", []byte("mine\n"), WriteOptions{}, nil); !errors.Is(err, ErrRemoteChanged) {
+		t.Fatalf("an existing file needs a version, got %v", err)
+	}
+
+	res, err := m.WriteFile(ctx, 1, "/motd", []byte("mine\n"), WriteOptions{Version: version, Force: true}, nil)
+	if err != nil || res.Atomic || res.Version == "" {
+		t.Fatalf("forced save: %+v %v", res, err)
+	}
+	// The returned version allows the next save.
+	if _, err := m.WriteFile(ctx, 1, "/motd", []byte("mine again\n"), WriteOptions{Version: res.Version}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := m.WriteFile(ctx, 1, "/new.txt", []byte("x"), WriteOptions{}, nil); err != nil {
+		t.Fatalf("new file: %v", err)
+	}
+}
+
+func TestWriteFileAtomicWithBackup(t *testing.T) {
+	m, c := newMemManager(t)
+	ctx := context.Background()
+	writeRemote(t, c, "/app.conf", "a=1\n")
+	_, version, err := m.ReadFile(ctx, 1, "/app.conf", 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	on := true
+	res, err := m.WriteFile(ctx, 1, "/app.conf", []byte("a=2\n"), WriteOptions{Version: version, Atomic: &on, Backup: &on}, nil)
+	if err != nil || !res.Atomic || res.Backup != "/app.conf.bak" {
+		t.Fatalf("atomic save: %+v %v", res, err)
+	}
+	if got := readRemote(t, c, "/app.conf"); got != "a=2\n" {
+		t.Fatalf("saved %q", got)
+	}
+	if got := readRemote(t, c, "/app.conf.bak"); got != "a=1\n" {
+		t.Fatalf("backup %q", got)
+	}
+	if _, err := c.Stat("/.app.conf" + editSuffix); err == nil {
+		t.Fatal("temporary file left behind")
+	}
+}
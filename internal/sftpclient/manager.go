@@ -36,9 +36,15 @@ type session struct {
 	sftp    *sftp.Client
 }
 
+// upload is a chunked upload into part, renamed to dst once complete.
 type upload struct {
-	hostID int
-	f      *sftp.File
+	hostID    int
+	c         *sftp.Client
+	f         *sftp.File
+	part, dst string
+	key       string // in partials
+	offset    int64  // resumed from
+	sum       *partialSum
 }
 
 type Manager struct {
@@ -49,21 +55,38 @@ type Manager struct {
 	sessions        map[int]*session
 	uploads         map[string]*upload
 	customPasswords map[int]string
+
+	// partials hash chunked uploads by host and partial file as they are sent,
+	// so a resumed upload is verified against the data itself.
+	partials map[string]*partialSum
+
+	// remoteSum overrides how remote files are hashed (tests).
+	remoteSum remoteSumFunc
+
+	xfer transferQueue
 }
 
 func NewManager(cfg model.AppConfig) *Manager {
-	return &Manager{
+	m := &Manager{
 		cfg:             cfg,
 		sessions:        make(map[int]*session),
 		uploads:         make(map[string]*upload),
 		customPasswords: make(map[int]string),
+		partials:        make(map[string]*partialSum),
 	}
+	m.xfer.limit = transferConcurrency(cfg.Transfers)
+	return m
 }
 
 func (m *Manager) SetConfig(cfg model.AppConfig) {
 	m.mu.Lock()
 	m.cfg = cfg
 	m.mu.Unlock()
+
+	m.xfer.mu.Lock()
+	m.xfer.limit = transferConcurrency(cfg.Transfers)
+	m.scheduleLocked()
+	m.xfer.mu.Unlock()
 }
 
 func (m *Manager) Disconnect(hostID int) {
@@ -136,9 +159,18 @@ func (m *Manager) ensure(ctx context.Context, hostID int, passwordProvider func(
 		host.Auth.Method = model.AuthPassword
 	}
 
+	m.mu.Lock()
+	cfg := m.cfg
+	m.mu.Unlock()
+	// Bastions always use their own connection credentials.
+	opts, err := sshclient.ResolveDialOptions(cfg, host, passwordProvider)
+	if err != nil {
+		return nil, err
+	}
+
 	client, cleanup, err := sshclient.DialClient(ctx, host, func() (string, error) {
 		return pwFn()
-	})
+	}, opts)
 	if err != nil {
 		if cleanup != nil {
 			cleanup()
@@ -242,27 +274,29 @@ func (m *Manager) Rename(ctx context.Context, hostID int, fromPath, toPath strin
 	return c.Rename(cleanRemotePath(fromPath), cleanRemotePath(toPath))
 }
 
-func (m *Manager) ReadFile(ctx context.Context, hostID int, remotePath string, maxBytes int64, passwordProvider func(hostID int) (string, error)) ([]byte, error) {
+// ReadFile reads a remote file of at most maxBytes, with a version token
+// for WriteFile.
+func (m *Manager) ReadFile(ctx context.Context, hostID int, remotePath string, maxBytes int64, passwordProvider func(hostID int) (string, error)) ([]byte, string, error) {
 	c, err := m.ensure(ctx, hostID, passwordProvider)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 	p := cleanRemotePath(remotePath)
 
 	fi, err := c.Stat(p)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 	if fi.IsDir() {
-		return nil, errors.New("path is a directory")
+		return nil, "", errors.New("path is a directory")
 	}
 	if maxBytes > 0 && fi.Size() > maxBytes {
-		return nil, fmt.Errorf("file too large (%d bytes, limit %d)", fi.Size(), maxBytes)
+		return nil, "", fmt.Errorf("file too large (%d bytes, limit %d)", fi.Size(), maxBytes)
 	}
 
 	f, err := c.Open(p)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 	defer f.Close()
 
@@ -281,7 +315,7 @@ func (m *Manager) ReadFile(ctx context.Context, hostID int, remotePath string, m
 		if n > 0 {
 			total += int64(n)
 			if maxBytes > 0 && total > maxBytes {
-				return nil, fmt.Errorf("file exceeded limit %d bytes", maxBytes)
+				return nil, "", fmt.Errorf("file exceeded limit %d bytes", maxBytes)
 			}
 			buf = append(buf, tmp[:n]...)
 		}
@@ -289,96 +323,182 @@ func (m *Manager) ReadFile(ctx context.Context, hostID int, remotePath string, m
 			if errors.Is(rerr, io.EOF) {
 				break
 			}
-			return nil, rerr
+			return nil, "", rerr
 		}
 	}
 
-	return buf, nil
+	return buf, fileVersion(fi, buf), nil
 }
 
-func (m *Manager) WriteFile(ctx context.Context, hostID int, remotePath string, data []byte, passwordProvider func(hostID int) (string, error)) error {
+// DownloadToDir saves a remote file into dir ("" = the configured download
+// directory) under a name that does not clash with earlier downloads. An
+// interrupted download of the same file resumes.
+func (m *Manager) DownloadToDir(ctx context.Context, hostID int, remotePath, dir string, passwordProvider func(hostID int) (string, error)) (string, FileResult, error) {
 	c, err := m.ensure(ctx, hostID, passwordProvider)
 	if err != nil {
-		return err
+		return "", FileResult{}, err
 	}
-	p := cleanRemotePath(remotePath)
 
-	fi, err := c.Stat(p)
-	if err == nil && fi.IsDir() {
-		return errors.New("path is a directory")
+	if strings.TrimSpace(dir) == "" {
+		m.mu.Lock()
+		settings := m.cfg.Transfers
+		m.mu.Unlock()
+		dir, err = DownloadDir(settings)
+	} else {
+		dir, err = localPath(dir)
 	}
-
-	f, err := c.OpenFile(p, os.O_CREATE|os.O_TRUNC|os.O_WRONLY)
 	if err != nil {
-		return err
+		return "", FileResult{}, err
 	}
-	defer f.Close()
+	_ = os.MkdirAll(dir, 0o755)
 
-	_, err = f.Write(data)
-	return err
+	rp := cleanRemotePath(remotePath)
+	base := path.Base(rp)
+	base = strings.ReplaceAll(base, "/", "_")
+	base = strings.ReplaceAll(base, string(filepath.Separator), "_")
+	if base == "" || base == "." {
+		base = "download"
+	}
+
+	// The partial file has no timestamp so a retry finds it.
+	part := partPath(filepath.Join(dir, fmt.Sprintf("pterminal-%d-%s", hostID, base)))
+	out := filepath.Join(dir, fmt.Sprintf("pterminal-%d-%s-%s", hostID, time.Now().Format("20060102-150405"), base))
+	res, err := m.copyResumable(ctx, hostID, DirectionDownload, remoteFS{c}, localFS{}, rp, part, out, nil)
+	if err != nil {
+		return "", res, err
+	}
+	return out, res, nil
 }
 
-func (m *Manager) DownloadToDownloads(ctx context.Context, hostID int, remotePath string, passwordProvider func(hostID int) (string, error)) (string, error) {
+// DownloadFile copies a remote file to localPath, resuming an interrupted
+// download and verifying the result.
+func (m *Manager) DownloadFile(ctx context.Context, hostID int, remotePath, localPath string, passwordProvider func(hostID int) (string, error)) (FileResult, error) {
 	c, err := m.ensure(ctx, hostID, passwordProvider)
 	if err != nil {
-		return "", err
+		return FileResult{}, err
 	}
+	return m.copyResumable(ctx, hostID, DirectionDownload, remoteFS{c}, localFS{}, cleanRemotePath(remotePath), partPath(localPath), localPath, nil)
+}
 
-	rp := cleanRemotePath(remotePath)
-	rf, err := c.Open(rp)
+// UploadFile copies localPath to a remote file, resuming an interrupted
+// upload and verifying the result.
+func (m *Manager) UploadFile(ctx context.Context, hostID int, localPath, remotePath string, passwordProvider func(hostID int) (string, error)) (FileResult, error) {
+	c, err := m.ensure(ctx, hostID, passwordProvider)
 	if err != nil {
-		return "", err
+		return FileResult{}, err
+	}
+	rp := cleanRemotePath(remotePath)
+	if fi, err := c.Stat(rp); err == nil && fi.IsDir() {
+		return FileResult{}, errors.New("path is a directory")
 	}
-	defer rf.Close()
+	return m.copyResumable(ctx, hostID, DirectionUpload, localFS{}, remoteFS{c}, localPath, partPath(rp), rp, nil)
+}
 
-	home, err := os.UserHomeDir()
+// Download streams a remote file into w and returns the bytes copied.
+func (m *Manager) Download(ctx context.Context, hostID int, remotePath string, w io.Writer, passwordProvider func(hostID int) (string, error)) (int64, error) {
+	c, err := m.ensure(ctx, hostID, passwordProvider)
 	if err != nil {
-		return "", err
+		return 0, err
 	}
-	downloads := filepath.Join(home, "Downloads")
-	_ = os.MkdirAll(downloads, 0o755)
-
-	base := path.Base(rp)
-	base = strings.ReplaceAll(base, "/", "_")
-	base = strings.ReplaceAll(base, string(filepath.Separator), "_")
-	if base == "" || base == "." {
-		base = "download"
+	p := cleanRemotePath(remotePath)
+	fi, err := c.Stat(p)
+	if err != nil {
+		return 0, err
 	}
-
-	out := filepath.Join(downloads, fmt.Sprintf("pterminal-%d-%s-%s", hostID, time.Now().Format("20060102-150405"), base))
-	lf, err := os.OpenFile(out, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if fi.IsDir() {
+		return 0, errors.New("path is a directory")
+	}
+	f, err := c.Open(p)
 	if err != nil {
-		return "", err
+		return 0, err
 	}
-	defer lf.Close()
+	defer f.Close()
+	return io.Copy(w, f)
+}
 
-	if _, err := io.Copy(lf, rf); err != nil {
-		return "", err
+// Upload streams r into a remote file, replacing it, and returns the bytes
+// copied.
+func (m *Manager) Upload(ctx context.Context, hostID int, remotePath string, r io.Reader, passwordProvider func(hostID int) (string, error)) (int64, error) {
+	c, err := m.ensure(ctx, hostID, passwordProvider)
+	if err != nil {
+		return 0, err
+	}
+	p := cleanRemotePath(remotePath)
+	if fi, err := c.Stat(p); err == nil && fi.IsDir() {
+		return 0, errors.New("path is a directory")
 	}
-	return out, nil
+	f, err := c.OpenFile(p, os.O_CREATE|os.O_TRUNC|os.O_WRONLY)
+	if err != nil {
+		return 0, err
+	}
+	n, err := io.Copy(f, r)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return n, err
 }
 
-func (m *Manager) BeginUpload(ctx context.Context, hostID int, remoteDir, filename string, passwordProvider func(hostID int) (string, error)) (string, error) {
+// BeginUpload starts a chunked upload of a size-byte file last modified at
+// modTime into remoteDir and returns its ID and the offset to send from. An
+// interrupted upload of the same, unchanged file continues when this process
+// hashed the data it sent, so the finished file can still be verified against
+// it; anything else, including a zero size or modTime, starts over.
+func (m *Manager) BeginUpload(ctx context.Context, hostID int, remoteDir, filename string, size int64, modTime time.Time, passwordProvider func(hostID int) (string, error)) (string, int64, error) {
 	c, err := m.ensure(ctx, hostID, passwordProvider)
 	if err != nil {
-		return "", err
+		return "", 0, err
 	}
 	if filename == "" {
-		return "", errors.New("filename is empty")
+		return "", 0, errors.New("filename is empty")
 	}
 	dir := cleanRemotePath(remoteDir)
 	p := path.Join(dir, path.Base(filename))
+	part := partPath(p)
+	key := fmt.Sprintf("%d:%s", hostID, part)
 
-	f, err := c.OpenFile(p, os.O_CREATE|os.O_TRUNC|os.O_WRONLY)
+	src := partSource{Size: size, ModTime: modTime.Unix()}
+	m.mu.Lock()
+	sum := m.partials[key]
+	m.mu.Unlock()
+	var offset int64
+	if fi, err := c.Stat(part); err == nil && !fi.IsDir() && size > 0 && !modTime.IsZero() && fi.Size() <= size &&
+		sum != nil && sum.Size() == fi.Size() {
+		if have, ok := readPartSource(remoteFS{c}, part); ok && have == src {
+			offset = fi.Size()
+		}
+	}
+	if offset == 0 {
+		// Data read back from the server could only be checked against
+		// itself: start over, hashing what is sent.
+		sum = newPartialSum()
+		if size > 0 && !modTime.IsZero() {
+			if err := writePartSource(remoteFS{c}, part, src); err != nil {
+				return "", 0, err
+			}
+		} else {
+			_ = c.Remove(partSrcPath(part))
+		}
+	}
+
+	flags := os.O_CREATE | os.O_WRONLY
+	if offset == 0 {
+		flags |= os.O_TRUNC
+	}
+	f, err := c.OpenFile(part, flags)
 	if err != nil {
-		return "", err
+		return "", 0, err
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		_ = f.Close()
+		return "", 0, err
 	}
 
 	id := fmt.Sprintf("%d-%d", hostID, time.Now().UnixNano())
 	m.mu.Lock()
-	m.uploads[id] = &upload{hostID: hostID, f: f}
+	m.partials[key] = sum
+	m.uploads[id] = &upload{hostID: hostID, c: c, f: f, part: part, dst: p, key: key, offset: offset, sum: sum}
 	m.mu.Unlock()
-	return id, nil
+	return id, offset, nil
 }
 
 func (m *Manager) UploadChunk(uploadID string, data []byte) error {
@@ -388,22 +508,41 @@ func (m *Manager) UploadChunk(uploadID string, data []byte) error {
 	if u == nil || u.f == nil {
 		return errors.New("upload not found")
 	}
-	_, err := u.f.Write(data)
+	n, err := u.f.Write(data)
+	_, _ = u.sum.Write(data[:n])
 	return err
 }
 
-func (m *Manager) EndUpload(uploadID string) error {
+// EndUpload completes an upload: the file is verified and renamed into
+// place. A mismatching upload is removed so the next attempt starts over.
+func (m *Manager) EndUpload(ctx context.Context, uploadID string) (FileResult, error) {
 	m.mu.Lock()
 	u := m.uploads[uploadID]
 	delete(m.uploads, uploadID)
 	m.mu.Unlock()
-	if u == nil {
-		return nil
+	if u == nil || u.f == nil {
+		return FileResult{}, nil
+	}
+	if err := u.f.Close(); err != nil {
+		return FileResult{}, err
+	}
+
+	res := FileResult{Resumed: u.offset, Bytes: u.sum.Size() - u.offset}
+	var err error
+	res.Verify, res.Algorithm, err = m.verify(ctx, u.hostID, u.part, u.sum.Sum)
+	if err == nil {
+		err = remoteFS{u.c}.Rename(u.part, u.dst)
+	}
+	if err == nil || errors.Is(err, ErrChecksumMismatch) {
+		m.mu.Lock()
+		delete(m.partials, u.key)
+		m.mu.Unlock()
+		_ = u.c.Remove(partSrcPath(u.part))
 	}
-	if u.f != nil {
-		return u.f.Close()
+	if errors.Is(err, ErrChecksumMismatch) {
+		_ = u.c.Remove(u.part)
 	}
-	return nil
+	return res, err
 }
 
 func isSFTPEnabled(h model.Host) bool {
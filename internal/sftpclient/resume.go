@@ -0,0 +1,281 @@
+package sftpclient
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+/*
+Resumable copies
+
+A file is copied to a hidden partial file next to its destination
+(".name.pterminal-part") and renamed into place once it is complete and
+verified. A copy that is interrupted leaves the partial file behind, with
+the source's size and modification time in a second hidden file
+(".name.pterminal-part-src"); the next copy of the same, unchanged file
+continues from the partial file's size instead of starting over. A partial
+file larger than the source, or made from a different version of it, is
+discarded. Resumed data is only kept when the finished copy can be verified:
+without a way to hash the remote file, the copy is made again from the start.
+*/
+
+const (
+	partSuffix    = ".pterminal-part"
+	partSrcSuffix = ".pterminal-part-src"
+)
+
+// FileResult describes one copied file.
+type FileResult struct {
+	// Bytes were copied by this call; Resumed were kept from an earlier,
+	// interrupted copy.
+	Bytes   int64 `json:"bytes"`
+	Resumed int64 `json:"resumed,omitempty"`
+
+	// Verify is how the copy was checked against the remote file
+	// ("check-file" or "exec"), or "" when it could not be.
+	Verify    string `json:"verify,omitempty"`
+	Algorithm string `json:"algorithm,omitempty"`
+}
+
+// partPath names the partial file a copy to p is assembled in.
+func partPath(p string) string {
+	dir, base := filepath.Split(p)
+	return dir + "." + base + partSuffix
+}
+
+func isPartial(p string) bool {
+	return strings.HasPrefix(filepath.Base(p), ".") && (strings.HasSuffix(p, partSuffix) || strings.HasSuffix(p, partSrcSuffix))
+}
+
+// partSource is the version of the source a partial file was copied from.
+type partSource struct {
+	Size    int64 `json:"size"`
+	ModTime int64 `json:"modTime"` // Unix seconds
+}
+
+func sourceOf(fi os.FileInfo) partSource {
+	return partSource{Size: fi.Size(), ModTime: fi.ModTime().Unix()}
+}
+
+// partSrcPath names the file that records the source of part.
+func partSrcPath(part string) string {
+	return strings.TrimSuffix(part, partSuffix) + partSrcSuffix
+}
+
+func readPartSource(fsys transferFS, part string) (partSource, bool) {
+	var src partSource
+	r, err := fsys.Open(partSrcPath(part))
+	if err != nil {
+		return src, false
+	}
+	defer r.Close()
+	b, err := io.ReadAll(io.LimitReader(r, 1024))
+	if err != nil || json.Unmarshal(b, &src) != nil {
+		return src, false
+	}
+	return src, true
+}
+
+func writePartSource(fsys transferFS, part string, src partSource) error {
+	b, err := json.Marshal(src)
+	if err != nil {
+		return err
+	}
+	w, err := fsys.OpenWrite(partSrcPath(part), 0, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(b); err != nil {
+		_ = w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+// copyResumable copies src to dst through part, continuing from part's size
+// when an earlier copy of the same source was interrupted. progress is called
+// with the bytes in part after every chunk; an error from it stops the copy,
+// keeping part. The complete copy is verified before it replaces dst; on a
+// mismatch part is removed, and a resumed copy that cannot be verified is
+// made again from the start.
+func (m *Manager) copyResumable(
+	ctx context.Context,
+	hostID int,
+	dir Direction,
+	from, to transferFS,
+	src, part, dst string,
+	progress func(done int64) error,
+) (FileResult, error) {
+	fi, err := from.Stat(src)
+	if err != nil {
+		return FileResult{}, err
+	}
+	if fi.IsDir() {
+		return FileResult{}, errors.New("path is a directory")
+	}
+	var offset int64
+	if pfi, err := to.Stat(part); err == nil && !pfi.IsDir() && pfi.Size() <= fi.Size() {
+		if have, ok := readPartSource(to, part); ok && have == sourceOf(fi) {
+			offset = pfi.Size()
+		}
+	}
+	if offset == 0 {
+		if err := writePartSource(to, part, sourceOf(fi)); err != nil {
+			return FileResult{}, err
+		}
+	}
+
+	res, err := m.copyPart(ctx, hostID, dir, from, to, src, part, fi, offset, progress)
+	if err == nil && res.Resumed > 0 && res.Verify == "" {
+		res, err = m.copyPart(ctx, hostID, dir, from, to, src, part, fi, 0, progress)
+	}
+	if err != nil {
+		if errors.Is(err, ErrChecksumMismatch) {
+			_ = to.Remove(part)
+			_ = to.Remove(partSrcPath(part))
+		}
+		return res, err
+	}
+	if err := to.Rename(part, dst); err != nil {
+		return res, err
+	}
+	_ = to.Remove(partSrcPath(part))
+	_ = to.Chtimes(dst, fi.ModTime())
+	return res, nil
+}
+
+// copyPart copies src into part from offset on and verifies the result.
+func (m *Manager) copyPart(
+	ctx context.Context,
+	hostID int,
+	dir Direction,
+	from, to transferFS,
+	src, part string,
+	fi os.FileInfo,
+	offset int64,
+	progress func(done int64) error,
+) (FileResult, error) {
+	res := FileResult{Resumed: offset}
+	r, err := from.Open(src)
+	if err != nil {
+		return res, err
+	}
+	defer r.Close()
+	if _, err := r.Seek(offset, io.SeekStart); err != nil {
+		return res, err
+	}
+	w, err := to.OpenWrite(part, offset, fi.Mode().Perm())
+	if err != nil {
+		return res, err
+	}
+
+	buf := make([]byte, transferChunk)
+	for err == nil {
+		if err = ctx.Err(); err != nil {
+			break
+		}
+		n, rerr := r.Read(buf)
+		if n > 0 {
+			if _, err = w.Write(buf[:n]); err != nil {
+				break
+			}
+			res.Bytes += int64(n)
+			if progress != nil {
+				err = progress(offset + res.Bytes)
+			}
+		}
+		if rerr != nil {
+			if !errors.Is(rerr, io.EOF) {
+				err = rerr
+			}
+			break
+		}
+	}
+	if cerr := w.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return res, err
+	}
+
+	local, remote := part, src
+	if dir == DirectionUpload {
+		local, remote = src, part
+	}
+	res.Verify, res.Algorithm, err = m.verify(ctx, hostID, remote, func(alg string) ([]byte, error) {
+		return fileSum(local, alg)
+	})
+	return res, err
+}
+
+// partialSum hashes a chunked upload as it arrives, so it can be verified
+// once complete and continued after a dropped connection.
+type partialSum struct {
+	mu     sync.Mutex
+	size   int64
+	sha256 hash.Hash
+	md5    hash.Hash
+}
+
+func newPartialSum() *partialSum {
+	return &partialSum{sha256: sha256.New(), md5: md5.New()}
+}
+
+func (p *partialSum) Write(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.size += int64(len(b))
+	p.sha256.Write(b)
+	p.md5.Write(b)
+	return len(b), nil
+}
+
+func (p *partialSum) Size() int64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.size
+}
+
+// Sum returns the digest for alg (sha256 or md5).
+func (p *partialSum) Sum(alg string) ([]byte, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	switch alg {
+	case "sha256":
+		return p.sha256.Sum(nil), nil
+	case "md5":
+		return p.md5.Sum(nil), nil
+	}
+	return nil, errUnsupportedHash
+}
+
+// fileSum hashes a local file with alg (sha256 or md5).
+func fileSum(p, alg string) ([]byte, error) {
+	var h hash.Hash
+	switch alg {
+	case "sha256":
+		h = sha256.New()
+	case "md5":
+		h = md5.New()
+	default:
+		return nil, errUnsupportedHash
+	}
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if _, err := io.Copy(h, f); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
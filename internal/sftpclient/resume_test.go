@@ -0,0 +1,259 @@
+package sftpclient
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/sftp"
+)
+
+// memSum makes m hash remote files by reading them back, as a server with
+// check-file would.
+func memSum(m *Manager, c *sftp.Client) {
+	m.remoteSum = func(_ context.Context, _ int, p string) (string, string, []byte, error) {
+		f, err := c.Open(p)
+		if err != nil {
+			return "", "", nil, err
+		}
+		defer f.Close()
+		h := sha256.New()
+		if _, err := io.Copy(h, f); err != nil {
+			return "", "", nil, err
+		}
+		return VerifyCheckFile, "sha256", h.Sum(nil), nil
+	}
+}
+
+func writeRemote(t *testing.T, c *sftp.Client, p, data string) {
+	t.Helper()
+	f, err := c.Create(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write([]byte(data)); err != nil {
+		t.Fatal(err)
+	}
+	_ = f.Close()
+}
+
+func readRemote(t *testing.T, c *sftp.Client, p string) string {
+	t.Helper()
+	f, err := c.Open(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	b, err := io.ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestCopyResumable(t *testing.T) {
+	m, c := newMemManager(t)
+	memSum(m, c)
+	data := strings.Repeat("0123456789", 1000)
+	src := filepath.Join(t.TempDir(), "big.bin")
+	writeFile(t, src, data)
+	if err := c.MkdirAll("/up"); err != nil {
+		t.Fatal(err)
+	}
+
+	// An interrupted upload left the first half behind.
+	fi, err := os.Stat(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeRemote(t, c, partPath("/up/big.bin"), data[:4000])
+	if err := writePartSource(remoteFS{c}, partPath("/up/big.bin"), sourceOf(fi)); err != nil {
+		t.Fatal(err)
+	}
+	res, err := m.UploadFile(context.Background(), 1, src, "/up/big.bin", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Resumed != 4000 || res.Bytes != int64(len(data)-4000) || res.Verify != VerifyCheckFile {
+		t.Fatalf("unexpected result %+v", res)
+	}
+	if got := readRemote(t, c, "/up/big.bin"); got != data {
+		t.Fatal("uploaded file differs")
+	}
+	for _, p := range []string{partPath("/up/big.bin"), partSrcPath(partPath("/up/big.bin"))} {
+		if _, err := c.Stat(p); !errors.Is(err, os.ErrNotExist) {
+			t.Fatalf("partial file left behind: %v", err)
+		}
+	}
+
+	// A partial file of another version of the source is not continued.
+	writeRemote(t, c, partPath("/up/big.bin"), "stale data")
+	if err := writePartSource(remoteFS{c}, partPath("/up/big.bin"), partSource{Size: fi.Size(), ModTime: fi.ModTime().Unix() - 60}); err != nil {
+		t.Fatal(err)
+	}
+	if res, err := m.UploadFile(context.Background(), 1, src, "/up/big.bin", nil); err != nil || res.Resumed != 0 {
+		t.Fatalf("changed source resumed: %+v %v", res, err)
+	}
+
+	// Without a way to verify the copy, resumed data is not trusted.
+	writeRemote(t, c, partPath("/up/big.bin"), "garbage")
+	if err := writePartSource(remoteFS{c}, partPath("/up/big.bin"), sourceOf(fi)); err != nil {
+		t.Fatal(err)
+	}
+	m.remoteSum = func(context.Context, int, string) (string, string, []byte, error) { return "", "", nil, nil }
+	if res, err := m.UploadFile(context.Background(), 1, src, "/up/big.bin", nil); err != nil || res.Resumed != 0 || res.Verify != "" {
+		t.Fatalf("unverified copy resumed: %+v %v", res, err)
+	}
+	if got := readRemote(t, c, "/up/big.bin"); got != data {
+		t.Fatal("unverified resumed upload differs")
+	}
+	memSum(m, c)
+
+	// A corrupt partial download fails verification and is discarded.
+	dst := filepath.Join(t.TempDir(), "big.bin")
+	writeFile(t, partPath(dst), "garbage")
+	rfi, err := c.Stat("/up/big.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := writePartSource(localFS{}, partPath(dst), sourceOf(rfi)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.DownloadFile(context.Background(), 1, "/up/big.bin", dst, nil); !errors.Is(err, ErrChecksumMismatch) {
+		t.Fatalf("expected a checksum mismatch, got %v", err)
+	}
+	for _, p := range []string{partPath(dst), partSrcPath(partPath(dst))} {
+		if _, err := os.Stat(p); !errors.Is(err, os.ErrNotExist) {
+			t.Fatalf("corrupt partial file kept: %v", err)
+		}
+	}
+	if _, err := os.Stat(dst); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("corrupt download renamed into place: %v", err)
+	}
+	res, err = m.DownloadFile(context.Background(), 1, "/up/big.bin", dst, nil)
+	if err != nil || res.Resumed != 0 {
+		t.Fatalf("retry: %+v %v", res, err)
+	}
+	if b, _ := os.ReadFile(dst); string(b) != data {
+		t.Fatal("downloaded file differs")
+	}
+}
+
+func TestChunkedUploadResumes(t *testing.T) {
+	m, c := newMemManager(t)
+	memSum(m, c)
+	data := []byte(strings.Repeat("abcdef", 500))
+	size := int64(len(data))
+	mtime := time.Unix(1700000000, 0)
+	ctx := context.Background()
+	abandon := func(id string) {
+		m.mu.Lock()
+		_ = m.uploads[id].f.Close()
+		delete(m.uploads, id)
+		m.mu.Unlock()
+	}
+
+	id, offset, err := m.BeginUpload(ctx, 1, "/", "a.bin", size, mtime, nil)
+	if err != nil || offset != 0 {
+		t.Fatalf("begin: %d %v", offset, err)
+	}
+	if err := m.UploadChunk(id, data[:1000]); err != nil {
+		t.Fatal(err)
+	}
+	abandon(id)
+
+	id, offset, err = m.BeginUpload(ctx, 1, "/", "a.bin", size, mtime, nil)
+	if err != nil || offset != 1000 {
+		t.Fatalf("resume: %d %v", offset, err)
+	}
+	if err := m.UploadChunk(id, data[offset:]); err != nil {
+		t.Fatal(err)
+	}
+	res, err := m.EndUpload(ctx, id)
+	if err != nil || res.Resumed != 1000 || res.Verify == "" {
+		t.Fatalf("end: %+v %v", res, err)
+	}
+	if got := readRemote(t, c, "/a.bin"); got != string(data) {
+		t.Fatal("uploaded file differs")
+	}
+	if _, err := c.Stat(partSrcPath(partPath("/a.bin"))); err == nil {
+		t.Fatal("part source marker left behind")
+	}
+
+	// A changed source starts over.
+	id, _, err = m.BeginUpload(ctx, 1, "/", "b.bin", size, mtime, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.UploadChunk(id, data[:1000]); err != nil {
+		t.Fatal(err)
+	}
+	abandon(id)
+	id, offset, err = m.BeginUpload(ctx, 1, "/", "b.bin", size, mtime.Add(time.Second), nil)
+	if err != nil || offset != 0 {
+		t.Fatalf("changed source: %d %v", offset, err)
+	}
+	if err := m.UploadChunk(id, data[:1000]); err != nil {
+		t.Fatal(err)
+	}
+	abandon(id)
+
+	// A partial this process did not hash cannot be verified: start over.
+	m.mu.Lock()
+	m.partials = map[string]*partialSum{}
+	m.mu.Unlock()
+	if _, offset, err := m.BeginUpload(ctx, 1, "/", "b.bin", size, mtime.Add(time.Second), nil); err != nil || offset != 0 {
+		t.Fatalf("unhashed partial: %d %v", offset, err)
+	}
+
+	// Without a size or modification time the upload starts over.
+	writeRemote(t, c, partPath("/a.bin"), "stale")
+	if _, offset, err := m.BeginUpload(ctx, 1, "/", "a.bin", 0, time.Time{}, nil); err != nil || offset != 0 {
+		t.Fatalf("sizeless begin: %d %v", offset, err)
+	}
+}
+
+func TestCheckFile(t *testing.T) {
+	want := sha256.Sum256([]byte("hello"))
+	srv, cli := net.Pipe()
+	defer cli.Close()
+	go func() {
+		defer srv.Close()
+		if typ, _, err := readPacket(srv); err != nil || typ != fxpInit {
+			return
+		}
+		version := binary.BigEndian.AppendUint32(nil, 3)
+		version = appendString(version, "check-file-name")
+		version = appendString(version, "1")
+		if err := writePacket(srv, fxpVersion, version); err != nil {
+			return
+		}
+		_, req, err := readPacket(srv)
+		if err != nil {
+			return
+		}
+		name, rest, _ := readString(req[4:])
+		p, rest, _ := readString(rest)
+		algs, _, _ := readString(rest)
+		if name != "check-file-name" || p != "/a" || algs != "sha256,md5" {
+			_ = writePacket(srv, fxpStatus, append(req[:4], 0, 0, 0, 8))
+			return
+		}
+		reply := appendString(append([]byte(nil), req[:4]...), "sha256")
+		_ = writePacket(srv, fxpExtendedReply, append(reply, want[:]...))
+	}()
+
+	alg, sum, err := checkFile(cli, "/a")
+	if err != nil || alg != "sha256" || !bytes.Equal(sum, want[:]) {
+		t.Fatalf("checkFile: %s %x %v", alg, sum, err)
+	}
+}
@@ -0,0 +1,833 @@
+package sftpclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ankouros/pterminal/internal/model"
+	"github.com/pkg/sftp"
+)
+
+/*
+Transfers
+
+A transfer copies a file or a whole directory tree between the local disk
+and a host, in either direction. Transfers are queued and run up to a
+concurrency limit; the files of one transfer are copied one at a time.
+Listeners get the transfer's overall progress along with the file in flight.
+
+Pausing a running transfer parks it between chunks and frees its slot for
+the next queued one. A destination file that already exists is handled by
+the transfer's ConflictPolicy; directories are merged.
+*/
+
+type Direction string
+
+const (
+	DirectionUpload   Direction = "upload"
+	DirectionDownload Direction = "download"
+)
+
+type ConflictPolicy string
+
+const (
+	ConflictOverwrite ConflictPolicy = "overwrite"
+	ConflictSkip      ConflictPolicy = "skip"
+	ConflictRename    ConflictPolicy = "rename" // copy to "name (1).ext"
+	ConflictNewer     ConflictPolicy = "newer"  // replace only when the source is newer
+)
+
+const (
+	TransferQueued   = "queued"
+	TransferRunning  = "running"
+	TransferPaused   = "paused"
+	TransferDone     = "done"
+	TransferFailed   = "failed" // could not start, or some files failed
+	TransferCanceled = "canceled"
+
+	DefaultTransferConcurrency = 2
+	MaxTransferConcurrency     = 8
+
+	maxTransferHistory = 50
+	transferChunk      = 256 * 1024
+	progressInterval   = 250 * time.Millisecond
+)
+
+// TransferRequest describes a transfer. Source is a remote path for
+// downloads and a local one for uploads; it is copied into DestDir.
+type TransferRequest struct {
+	HostID    int
+	Direction Direction
+	Source    string
+	DestDir   string
+	Conflict  ConflictPolicy // default: the app setting
+}
+
+// FileProgress is the file a transfer is copying.
+type FileProgress struct {
+	Path string `json:"path"` // relative to the transfer's source
+	Size int64  `json:"size"`
+	Done int64  `json:"done"`
+}
+
+// FileError is a file that could not be copied.
+type FileError struct {
+	Path  string `json:"path"`
+	Error string `json:"error"`
+}
+
+type Transfer struct {
+	ID        string         `json:"id"`
+	HostID    int            `json:"hostId"`
+	Direction Direction      `json:"direction"`
+	Source    string         `json:"source"`
+	Dest      string         `json:"dest,omitempty"` // the copy of Source; set once started
+	Conflict  ConflictPolicy `json:"conflict"`
+	Status    string         `json:"status"`
+	Error     string         `json:"error,omitempty"`
+
+	// Files and bytes found under Source; skipped and failed files count as
+	// done so the totals reach 100%.
+	Files        int   `json:"files"`
+	FilesDone    int   `json:"filesDone"`
+	FilesSkipped int   `json:"filesSkipped,omitempty"`
+	Bytes        int64 `json:"bytes"`
+	BytesDone    int64 `json:"bytesDone"`
+
+	// FilesResumed continued a partial copy; FilesUnverified were copied but
+	// the server could not hash them.
+	FilesResumed    int `json:"filesResumed,omitempty"`
+	FilesUnverified int `json:"filesUnverified,omitempty"`
+
+	Current *FileProgress `json:"current,omitempty"`
+	Errors  []FileError   `json:"errors,omitempty"`
+
+	CreatedAt  int64 `json:"createdAt"`            // unix ms
+	FinishedAt int64 `json:"finishedAt,omitempty"` // unix ms
+}
+
+// Finished reports whether t stopped for good.
+func (t Transfer) Finished() bool {
+	switch t.Status {
+	case TransferDone, TransferFailed, TransferCanceled:
+		return true
+	}
+	return false
+}
+
+type transferJob struct {
+	t       Transfer
+	destDir string
+	pw      func(hostID int) (string, error)
+	ctx     context.Context
+
+	cancel  context.CancelFunc
+	started bool          // has a goroutine
+	holding bool          // counts toward the concurrency limit
+	wake    chan struct{} // closed when a paused job may continue
+	done    chan struct{} // closed when the job finished
+	base    int64         // BytesDone before the current file
+
+	lastEmit time.Time
+}
+
+type transferQueue struct {
+	mu       sync.Mutex
+	seq      int64
+	limit    int
+	running  int
+	jobs     []*transferJob // newest first
+	listener func(Transfer)
+}
+
+// DownloadDir returns where downloads are saved.
+func DownloadDir(settings *model.TransferSettings) (string, error) {
+	if settings != nil && strings.TrimSpace(settings.DownloadDir) != "" {
+		return localPath(settings.DownloadDir)
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, "Downloads"), nil
+}
+
+// ParseConflictPolicy validates a policy name; "" is the app default.
+func ParseConflictPolicy(s string, settings *model.TransferSettings) (ConflictPolicy, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if s == "" && settings != nil {
+		s = strings.ToLower(strings.TrimSpace(settings.Conflict))
+	}
+	switch p := ConflictPolicy(s); p {
+	case ConflictOverwrite, ConflictSkip, ConflictRename, ConflictNewer:
+		return p, nil
+	case "":
+		return ConflictRename, nil
+	}
+	return "", fmt.Errorf("unknown conflict policy %q", s)
+}
+
+func transferConcurrency(settings *model.TransferSettings) int {
+	if settings == nil || settings.Concurrency <= 0 {
+		return DefaultTransferConcurrency
+	}
+	if settings.Concurrency > MaxTransferConcurrency {
+		return MaxTransferConcurrency
+	}
+	return settings.Concurrency
+}
+
+// SetTransferListener registers fn to receive transfer updates. Progress
+// within a file is reported at most every progressInterval per transfer.
+func (m *Manager) SetTransferListener(fn func(Transfer)) {
+	m.xfer.mu.Lock()
+	m.xfer.listener = fn
+	m.xfer.mu.Unlock()
+}
+
+// StartTransfer connects to the host and queues req, returning its ID.
+// Connecting up front lets password and host key prompts reach the caller.
+func (m *Manager) StartTransfer(ctx context.Context, req TransferRequest, passwordProvider func(hostID int) (string, error)) (string, error) {
+	m.mu.Lock()
+	settings := m.cfg.Transfers
+	m.mu.Unlock()
+
+	if req.Direction != DirectionUpload && req.Direction != DirectionDownload {
+		return "", fmt.Errorf("unknown direction %q", req.Direction)
+	}
+	if strings.TrimSpace(req.Source) == "" {
+		return "", errors.New("source is empty")
+	}
+	policy, err := ParseConflictPolicy(string(req.Conflict), settings)
+	if err != nil {
+		return "", err
+	}
+	req.Conflict = policy
+	if strings.TrimSpace(req.DestDir) == "" {
+		if req.Direction == DirectionUpload {
+			return "", errors.New("destination directory is empty")
+		}
+		if req.DestDir, err = DownloadDir(settings); err != nil {
+			return "", err
+		}
+	}
+	if _, err := m.ensure(ctx, req.HostID, passwordProvider); err != nil {
+		return "", err
+	}
+
+	jctx, cancel := context.WithCancel(context.Background())
+	now := time.Now()
+	j := &transferJob{
+		t: Transfer{
+			HostID:    req.HostID,
+			Direction: req.Direction,
+			Source:    req.Source,
+			Conflict:  req.Conflict,
+			Status:    TransferQueued,
+			CreatedAt: now.UnixMilli(),
+		},
+		pw:      passwordProvider,
+		ctx:     jctx,
+		cancel:  cancel,
+		done:    make(chan struct{}),
+		destDir: req.DestDir,
+	}
+
+	q := &m.xfer
+	q.mu.Lock()
+	q.seq++
+	j.t.ID = strconv.FormatInt(now.Unix(), 36) + "-" + strconv.FormatInt(q.seq, 10)
+	q.jobs = append([]*transferJob{j}, q.jobs...)
+	q.trimLocked()
+	m.scheduleLocked()
+	q.mu.Unlock()
+
+	m.emitTransfer(j, true)
+	return j.t.ID, nil
+}
+
+// Transfers returns the queued, running and recent transfers, newest first.
+func (m *Manager) Transfers() []Transfer {
+	m.xfer.mu.Lock()
+	defer m.xfer.mu.Unlock()
+	out := make([]Transfer, 0, len(m.xfer.jobs))
+	for _, j := range m.xfer.jobs {
+		out = append(out, j.snapshot())
+	}
+	return out
+}
+
+// Transfer returns one transfer.
+func (m *Manager) Transfer(id string) (Transfer, bool) {
+	m.xfer.mu.Lock()
+	defer m.xfer.mu.Unlock()
+	if j := m.xfer.find(id); j != nil {
+		return j.snapshot(), true
+	}
+	return Transfer{}, false
+}
+
+// WaitTransfer blocks until transfer id finishes (or ctx ends) and returns it.
+func (m *Manager) WaitTransfer(ctx context.Context, id string) (Transfer, error) {
+	m.xfer.mu.Lock()
+	j := m.xfer.find(id)
+	m.xfer.mu.Unlock()
+	if j == nil {
+		return Transfer{}, errors.New("transfer not found")
+	}
+	select {
+	case <-j.done:
+	case <-ctx.Done():
+		return Transfer{}, ctx.Err()
+	}
+	t, _ := m.Transfer(id)
+	return t, nil
+}
+
+// PauseTransfer holds a queued or running transfer.
+func (m *Manager) PauseTransfer(id string) bool {
+	q := &m.xfer
+	q.mu.Lock()
+	j := q.find(id)
+	if j == nil {
+		q.mu.Unlock()
+		return false
+	}
+	switch j.t.Status {
+	case TransferQueued:
+		j.t.Status = TransferPaused
+	case TransferRunning:
+		j.t.Status = TransferPaused
+		j.wake = make(chan struct{})
+		j.holding = false
+		q.running--
+		m.scheduleLocked()
+	default:
+		q.mu.Unlock()
+		return false
+	}
+	q.mu.Unlock()
+	m.emitTransfer(j, true)
+	return true
+}
+
+// ResumeTransfer queues a paused transfer again.
+func (m *Manager) ResumeTransfer(id string) bool {
+	q := &m.xfer
+	q.mu.Lock()
+	j := q.find(id)
+	if j == nil || j.t.Status != TransferPaused {
+		q.mu.Unlock()
+		return false
+	}
+	j.t.Status = TransferQueued
+	m.scheduleLocked()
+	q.mu.Unlock()
+	m.emitTransfer(j, true)
+	return true
+}
+
+// CancelTransfer stops a transfer that has not finished. A partly copied
+// file is removed.
+func (m *Manager) CancelTransfer(id string) bool {
+	q := &m.xfer
+	q.mu.Lock()
+	j := q.find(id)
+	if j == nil || j.t.Finished() {
+		q.mu.Unlock()
+		return false
+	}
+	j.cancel()
+	if !j.started {
+		// Never ran; a started job finishes through runTransfer.
+		j.t.Status = TransferCanceled
+		j.t.FinishedAt = time.Now().UnixMilli()
+		close(j.done)
+	}
+	q.mu.Unlock()
+	m.emitTransfer(j, true)
+	return true
+}
+
+func (q *transferQueue) find(id string) *transferJob {
+	for _, j := range q.jobs {
+		if j.t.ID == id {
+			return j
+		}
+	}
+	return nil
+}
+
+// trimLocked drops the oldest finished transfers beyond the history size.
+func (q *transferQueue) trimLocked() {
+	for i := len(q.jobs) - 1; i >= 0 && len(q.jobs) > maxTransferHistory; i-- {
+		if q.jobs[i].t.Finished() {
+			q.jobs = append(q.jobs[:i], q.jobs[i+1:]...)
+		}
+	}
+}
+
+// scheduleLocked starts queued transfers, oldest first, while slots are
+// free. q.mu is held.
+func (m *Manager) scheduleLocked() {
+	q := &m.xfer
+	limit := q.limit
+	if limit <= 0 {
+		limit = DefaultTransferConcurrency
+	}
+	for i := len(q.jobs) - 1; i >= 0 && q.running < limit; i-- {
+		j := q.jobs[i]
+		if j.t.Status != TransferQueued {
+			continue
+		}
+		j.t.Status = TransferRunning
+		j.holding = true
+		q.running++
+		if j.started {
+			close(j.wake)
+			continue
+		}
+		j.started = true
+		go m.runTransfer(j)
+	}
+}
+
+func (j *transferJob) snapshot() Transfer {
+	t := j.t
+	if t.Current != nil {
+		cur := *t.Current
+		t.Current = &cur
+	}
+	t.Errors = append([]FileError(nil), t.Errors...)
+	return t
+}
+
+// emitTransfer sends j to the listener; unless force is set, progress is
+// throttled.
+func (m *Manager) emitTransfer(j *transferJob, force bool) {
+	m.xfer.mu.Lock()
+	fn := m.xfer.listener
+	now := time.Now()
+	if fn == nil || (!force && now.Sub(j.lastEmit) < progressInterval) {
+		m.xfer.mu.Unlock()
+		return
+	}
+	j.lastEmit = now
+	t := j.snapshot()
+	m.xfer.mu.Unlock()
+	fn(t)
+}
+
+func (m *Manager) updateTransfer(j *transferJob, force bool, fn func(t *Transfer)) {
+	m.xfer.mu.Lock()
+	fn(&j.t)
+	m.xfer.mu.Unlock()
+	m.emitTransfer(j, force)
+}
+
+func (m *Manager) runTransfer(j *transferJob) {
+	err := m.copyTree(j)
+
+	q := &m.xfer
+	q.mu.Lock()
+	switch {
+	case j.ctx.Err() != nil:
+		j.t.Status = TransferCanceled
+	case err != nil:
+		j.t.Status = TransferFailed
+		j.t.Error = err.Error()
+	case len(j.t.Errors) > 0:
+		j.t.Status = TransferFailed
+		j.t.Error = fmt.Sprintf("%d of %d files failed", len(j.t.Errors), j.t.Files)
+	default:
+		j.t.Status = TransferDone
+	}
+	j.t.Current = nil
+	j.t.FinishedAt = time.Now().UnixMilli()
+	if j.holding {
+		j.holding = false
+		q.running--
+	}
+	j.cancel()
+	close(j.done)
+	m.scheduleLocked()
+	q.mu.Unlock()
+	m.emitTransfer(j, true)
+}
+
+// transferGate blocks while j is paused and fails once it was canceled.
+func (m *Manager) transferGate(j *transferJob) error {
+	waited := false
+	for {
+		if err := j.ctx.Err(); err != nil {
+			return err
+		}
+		m.xfer.mu.Lock()
+		if j.t.Status == TransferRunning {
+			m.xfer.mu.Unlock()
+			if waited {
+				m.emitTransfer(j, true)
+			}
+			return nil
+		}
+		wake := j.wake
+		m.xfer.mu.Unlock()
+		waited = true
+		select {
+		case <-wake:
+		case <-j.ctx.Done():
+		}
+	}
+}
+
+type transferItem struct {
+	rel  string // slash-separated; "" for a single-file transfer
+	dir  bool
+	size int64
+	mode os.FileMode
+	mod  time.Time
+}
+
+func (m *Manager) copyTree(j *transferJob) error {
+	c, err := m.ensure(j.ctx, j.t.HostID, j.pw)
+	if err != nil {
+		return err
+	}
+	var from, to transferFS = remoteFS{c}, localFS{}
+	src, dst := cleanRemotePath(j.t.Source), j.destDir
+	if j.t.Direction == DirectionUpload {
+		from, to = to, from
+		if src, err = localPath(j.t.Source); err != nil {
+			return err
+		}
+		dst = cleanRemotePath(j.destDir)
+	} else if dst, err = localPath(j.destDir); err != nil {
+		return err
+	}
+
+	fi, err := from.Stat(src)
+	if err != nil {
+		return err
+	}
+	var items []transferItem
+	if fi.IsDir() {
+		if items, err = walkItems(j.ctx, from, src); err != nil {
+			return err
+		}
+	} else {
+		items = []transferItem{{size: fi.Size(), mode: fi.Mode().Perm(), mod: fi.ModTime()}}
+	}
+
+	base := path.Base(filepath.ToSlash(src))
+	root := to.Join(dst, base)
+	if err := to.MkdirAll(dst); err != nil {
+		return err
+	}
+	m.updateTransfer(j, true, func(t *Transfer) {
+		t.Dest = root
+		for _, it := range items {
+			if !it.dir {
+				t.Files++
+				t.Bytes += it.size
+			}
+		}
+	})
+
+	for _, it := range items {
+		if err := m.transferGate(j); err != nil {
+			return err
+		}
+		name := it.rel
+		if name == "" {
+			name = base
+		}
+		if it.dir {
+			if err := to.MkdirAll(to.Join(root, it.rel)); err != nil {
+				m.updateTransfer(j, true, func(t *Transfer) {
+					t.Errors = append(t.Errors, FileError{Path: name, Error: err.Error()})
+				})
+			}
+			continue
+		}
+
+		target, skip, err := resolveConflict(to, to.Join(root, it.rel), it.mod, j.t.Conflict)
+		if err == nil && !skip {
+			err = m.copyFile(j, from, to, from.Join(src, it.rel), target, name, it)
+		}
+		if err != nil && j.ctx.Err() != nil {
+			return j.ctx.Err()
+		}
+		m.updateTransfer(j, true, func(t *Transfer) {
+			t.FilesDone++
+			if skip {
+				t.FilesSkipped++
+			}
+			if err != nil {
+				t.Errors = append(t.Errors, FileError{Path: name, Error: err.Error()})
+			}
+			j.base += it.size
+			t.BytesDone = j.base
+			t.Current = nil
+		})
+	}
+	return nil
+}
+
+// walkItems lists the directories and files below src. Every path has to
+// stay inside src once joined to the destination.
+func walkItems(ctx context.Context, from transferFS, src string) ([]transferItem, error) {
+	var items []transferItem
+	err := from.Walk(src, func(rel string, fi os.FileInfo) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if !filepath.IsLocal(filepath.FromSlash(rel)) {
+			return fmt.Errorf("unsafe path %q in %s", rel, src)
+		}
+		items = append(items, transferItem{rel: rel, dir: fi.IsDir(), size: fi.Size(), mode: fi.Mode().Perm(), mod: fi.ModTime()})
+		return nil
+	})
+	return items, err
+}
+
+func (m *Manager) copyFile(j *transferJob, from, to transferFS, src, dst, name string, it transferItem) error {
+	m.updateTransfer(j, true, func(t *Transfer) {
+		t.Current = &FileProgress{Path: name, Size: it.size}
+	})
+	part := partPath(dst)
+	res, err := m.copyResumable(j.ctx, j.t.HostID, j.t.Direction, from, to, src, part, dst, func(done int64) error {
+		if err := m.transferGate(j); err != nil {
+			return err
+		}
+		m.updateTransfer(j, false, func(t *Transfer) {
+			t.Current.Done = done
+			t.BytesDone = j.base + done
+		})
+		return nil
+	})
+	if j.ctx.Err() != nil {
+		// Canceled: nobody will resume this copy.
+		_ = to.Remove(part)
+		_ = to.Remove(partSrcPath(part))
+	}
+	if err != nil {
+		return err
+	}
+	m.updateTransfer(j, true, func(t *Transfer) {
+		if res.Resumed > 0 {
+			t.FilesResumed++
+		}
+		if res.Verify == "" {
+			t.FilesUnverified++
+		}
+	})
+	return nil
+}
+
+// resolveConflict decides where a file goes when target may already exist;
+// skip is set when it should not be copied at all.
+func resolveConflict(fsys transferFS, target string, srcMod time.Time, policy ConflictPolicy) (string, bool, error) {
+	fi, err := fsys.Stat(target)
+	if errors.Is(err, os.ErrNotExist) {
+		return target, false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	switch policy {
+	case ConflictSkip:
+		return target, true, nil
+	case ConflictRename:
+		return freeName(fsys, target)
+	}
+	if fi.IsDir() {
+		return "", false, errors.New("destination is a directory")
+	}
+	if policy == ConflictNewer {
+		// Compare whole seconds: SFTP carries no sub-second times.
+		return target, !srcMod.Truncate(time.Second).After(fi.ModTime().Truncate(time.Second)), nil
+	}
+	return target, false, nil
+}
+
+// freeName returns "name (n).ext" for the first n that does not exist.
+func freeName(fsys transferFS, target string) (string, bool, error) {
+	ext := path.Ext(target)
+	stem := strings.TrimSuffix(target, ext)
+	for n := 1; n < 1000; n++ {
+		p := fmt.Sprintf("%s (%d)%s", stem, n, ext)
+		if _, err := fsys.Stat(p); errors.Is(err, os.ErrNotExist) {
+			return p, false, nil
+		} else if err != nil {
+			return "", false, err
+		}
+	}
+	return "", false, errors.New("no free name for " + target)
+}
+
+// transferFS is one side of a transfer.
+type transferFS interface {
+	Stat(p string) (os.FileInfo, error)
+	Open(p string) (io.ReadSeekCloser, error)
+	// OpenWrite opens p for writing at offset; offset 0 truncates it.
+	OpenWrite(p string, offset int64, perm os.FileMode) (io.WriteCloser, error)
+	MkdirAll(p string) error
+	Remove(p string) error
+	// Rename moves from to to, replacing to.
+	Rename(from, to string) error
+	Chtimes(p string, mtime time.Time) error
+	Join(dir, rel string) string
+	// Walk calls fn for the directories and regular files below root, parents
+	// first; rel is slash-separated. Symlinks, special files and partial
+	// copies are skipped.
+	Walk(root string, fn func(rel string, fi os.FileInfo) error) error
+}
+
+type localFS struct{}
+
+func (localFS) Stat(p string) (os.FileInfo, error)       { return os.Stat(p) }
+func (localFS) Open(p string) (io.ReadSeekCloser, error) { return os.Open(p) }
+func (localFS) MkdirAll(p string) error                  { return os.MkdirAll(p, 0o755) }
+func (localFS) Remove(p string) error                    { return os.Remove(p) }
+func (localFS) Rename(from, to string) error             { return os.Rename(from, to) }
+
+func (localFS) OpenWrite(p string, offset int64, perm os.FileMode) (io.WriteCloser, error) {
+	if perm == 0 {
+		perm = 0o644
+	}
+	flags := os.O_CREATE | os.O_WRONLY
+	if offset == 0 {
+		flags |= os.O_TRUNC
+	}
+	f, err := os.OpenFile(p, flags, perm)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+func (localFS) Chtimes(p string, mtime time.Time) error { return os.Chtimes(p, mtime, mtime) }
+
+func (localFS) Join(dir, rel string) string {
+	if rel == "" {
+		return dir
+	}
+	return filepath.Join(dir, filepath.FromSlash(rel))
+}
+
+func (localFS) Walk(root string, fn func(rel string, fi os.FileInfo) error) error {
+	return filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if p == root || !(d.IsDir() || d.Type().IsRegular()) || isPartial(p) {
+			return nil
+		}
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		return fn(filepath.ToSlash(rel), fi)
+	})
+}
+
+type remoteFS struct{ c *sftp.Client }
+
+func (r remoteFS) Stat(p string) (os.FileInfo, error)       { return r.c.Stat(p) }
+func (r remoteFS) Open(p string) (io.ReadSeekCloser, error) { return r.c.Open(p) }
+func (r remoteFS) MkdirAll(p string) error                  { return r.c.MkdirAll(p) }
+func (r remoteFS) Remove(p string) error                    { return r.c.Remove(p) }
+
+func (r remoteFS) OpenWrite(p string, offset int64, perm os.FileMode) (io.WriteCloser, error) {
+	flags := os.O_CREATE | os.O_WRONLY
+	if offset == 0 {
+		flags |= os.O_TRUNC
+	}
+	f, err := r.c.OpenFile(p, flags)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	if perm != 0 {
+		_ = f.Chmod(perm)
+	}
+	return f, nil
+}
+
+func (r remoteFS) Rename(from, to string) error {
+	if _, ok := r.c.HasExtension("posix-rename@openssh.com"); ok {
+		return r.c.PosixRename(from, to)
+	}
+	// Plain SFTP rename fails when the target exists.
+	if err := r.c.Remove(to); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return r.c.Rename(from, to)
+}
+
+func (r remoteFS) Chtimes(p string, mtime time.Time) error { return r.c.Chtimes(p, mtime, mtime) }
+
+func (remoteFS) Join(dir, rel string) string { return path.Join(dir, rel) }
+
+func (r remoteFS) Walk(root string, fn func(rel string, fi os.FileInfo) error) error {
+	w := r.c.Walk(root)
+	for w.Step() {
+		if err := w.Err(); err != nil {
+			return err
+		}
+		fi := w.Stat()
+		if w.Path() == root {
+			continue
+		}
+		// Entry names come from the server; one like "../x" must not lead
+		// the copy out of the destination.
+		if name := fi.Name(); name == "" || name == "." || name == ".." || strings.Contains(name, "/") {
+			return fmt.Errorf("unsafe name %q in %s", name, path.Dir(w.Path()))
+		}
+		if !(fi.IsDir() || fi.Mode().IsRegular()) || isPartial(w.Path()) {
+			continue
+		}
+		rel := w.Path()
+		if root != "." {
+			rel = strings.TrimPrefix(rel, strings.TrimSuffix(root, "/")+"/")
+		}
+		if err := fn(rel, fi); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// localPath expands a leading "~" in a local path.
+func localPath(p string) (string, error) {
+	p = strings.TrimSpace(p)
+	if p == "~" || strings.HasPrefix(p, "~/") {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		p = filepath.Join(home, strings.TrimPrefix(p, "~"))
+	}
+	return filepath.Clean(p), nil
+}
@@ -0,0 +1,219 @@
+package sftpclient
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ankouros/pterminal/internal/model"
+	"github.com/pkg/sftp"
+)
+
+// newMemManager returns a manager whose host 1 is an in-memory SFTP server.
+func newMemManager(t *testing.T) (*Manager, *sftp.Client) {
+	t.Helper()
+	srvConn, cliConn := net.Pipe()
+	srv := sftp.NewRequestServer(srvConn, sftp.InMemHandler())
+	go func() { _ = srv.Serve() }()
+	c, err := sftp.NewClientPipe(cliConn, cliConn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = c.Close()
+		_ = srv.Close()
+	})
+	m := NewManager(model.AppConfig{})
+	m.sessions[1] = &session{sftp: c}
+	return m, c
+}
+
+func waitTransfer(t *testing.T, m *Manager, id string) Transfer {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	tr, err := m.WaitTransfer(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tr
+}
+
+func writeFile(t *testing.T, p, data string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(p, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTransferDirectoryRoundTrip(t *testing.T) {
+	m, c := newMemManager(t)
+	var mu sync.Mutex
+	var events []Transfer
+	m.SetTransferListener(func(tr Transfer) {
+		mu.Lock()
+		events = append(events, tr)
+		mu.Unlock()
+	})
+
+	src := filepath.Join(t.TempDir(), "site")
+	writeFile(t, filepath.Join(src, "index.html"), "<h1>hi</h1>")
+	writeFile(t, filepath.Join(src, "css", "app.css"), "body{}")
+	if err := os.MkdirAll(filepath.Join(src, "empty"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	id, err := m.StartTransfer(context.Background(), TransferRequest{
+		HostID: 1, Direction: DirectionUpload, Source: src, DestDir: "/srv",
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	up := waitTransfer(t, m, id)
+	if up.Status != TransferDone || up.Files != 2 || up.FilesDone != 2 || up.BytesDone != up.Bytes || up.Dest != "/srv/site" {
+		t.Fatalf("unexpected upload %+v", up)
+	}
+	f, err := c.Open("/srv/site/css/app.css")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = f.Close()
+	if fi, err := c.Stat("/srv/site/empty"); err != nil || !fi.IsDir() {
+		t.Fatalf("empty directory not created: %v", err)
+	}
+	mu.Lock()
+	last := events[len(events)-1]
+	mu.Unlock()
+	if last.ID != id || last.Status != TransferDone {
+		t.Fatalf("last event %+v", last)
+	}
+
+	// Download it back twice: the second copy is renamed file by file.
+	dest := t.TempDir()
+	for i := 0; i < 2; i++ {
+		id, err = m.StartTransfer(context.Background(), TransferRequest{
+			HostID: 1, Direction: DirectionDownload, Source: "/srv/site", DestDir: dest, Conflict: ConflictRename,
+		}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if down := waitTransfer(t, m, id); down.Status != TransferDone || down.FilesDone != 2 {
+			t.Fatalf("unexpected download %+v", down)
+		}
+	}
+	for _, name := range []string{"index.html", "index (1).html", "css/app.css", "css/app (1).css"} {
+		b, err := os.ReadFile(filepath.Join(dest, "site", filepath.FromSlash(name)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(b) == 0 {
+			t.Fatalf("%s is empty", name)
+		}
+	}
+
+	id, err = m.StartTransfer(context.Background(), TransferRequest{
+		HostID: 1, Direction: DirectionDownload, Source: "/srv/site/index.html", DestDir: filepath.Join(dest, "site"), Conflict: ConflictSkip,
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if skipped := waitTransfer(t, m, id); skipped.Status != TransferDone || skipped.FilesSkipped != 1 {
+		t.Fatalf("expected the file to be skipped: %+v", skipped)
+	}
+	if got := m.Transfers(); len(got) != 4 || got[0].ID != id {
+		t.Fatalf("unexpected history %+v", got)
+	}
+}
+
+func TestTransferRequestValidation(t *testing.T) {
+	m, _ := newMemManager(t)
+	for _, req := range []TransferRequest{
+		{HostID: 1, Direction: "sideways", Source: "/a", DestDir: "/b"},
+		{HostID: 1, Direction: DirectionUpload, Source: "", DestDir: "/b"},
+		{HostID: 1, Direction: DirectionUpload, Source: "/a"},
+		{HostID: 1, Direction: DirectionUpload, Source: "/a", DestDir: "/b", Conflict: "merge"},
+	} {
+		if _, err := m.StartTransfer(context.Background(), req, nil); err == nil {
+			t.Errorf("expected %+v to be rejected", req)
+		}
+	}
+
+	// A missing source fails the transfer, not the request.
+	id, err := m.StartTransfer(context.Background(), TransferRequest{
+		HostID: 1, Direction: DirectionUpload, Source: filepath.Join(t.TempDir(), "nope"), DestDir: "/b",
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tr := waitTransfer(t, m, id); tr.Status != TransferFailed || tr.Error == "" {
+		t.Fatalf("unexpected transfer %+v", tr)
+	}
+	if m.PauseTransfer(id) || m.ResumeTransfer(id) || m.CancelTransfer(id) {
+		t.Fatal("finished transfers cannot be paused, resumed or canceled")
+	}
+}
+
+// evilFS lists a file whose name climbs out of the walked directory.
+type evilFS struct {
+	localFS
+	rel string
+}
+
+func (e evilFS) Walk(root string, fn func(rel string, fi os.FileInfo) error) error {
+	fi, err := os.Stat(root)
+	if err != nil {
+		return err
+	}
+	return fn(e.rel, fi)
+}
+
+func TestWalkItemsRejectsUnsafePaths(t *testing.T) {
+	dir := t.TempDir()
+	for _, rel := range []string{"../../.bashrc", "/etc/passwd", "a/../../b", ".."} {
+		if items, err := walkItems(context.Background(), evilFS{rel: rel}, dir); err == nil {
+			t.Errorf("%q accepted: %+v", rel, items)
+		}
+	}
+	if items, err := walkItems(context.Background(), evilFS{rel: "a/b.txt"}, dir); err != nil || len(items) != 1 {
+		t.Fatalf("safe path rejected: %v", err)
+	}
+}
+
+func TestResolveConflict(t *testing.T) {
+	dir := t.TempDir()
+	target := filepath.Join(dir, "a.txt")
+	writeFile(t, target, "old")
+	writeFile(t, filepath.Join(dir, "a (1).txt"), "old")
+	mod := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(target, mod, mod); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		policy ConflictPolicy
+		srcMod time.Time
+		want   string
+		skip   bool
+	}{
+		{ConflictOverwrite, mod, target, false},
+		{ConflictSkip, mod, target, true},
+		{ConflictRename, mod, filepath.Join(dir, "a (2).txt"), false},
+		{ConflictNewer, mod, target, true},
+		{ConflictNewer, mod.Add(time.Minute), target, false},
+	} {
+		got, skip, err := resolveConflict(localFS{}, target, tc.srcMod, tc.policy)
+		if err != nil || got != tc.want || skip != tc.skip {
+			t.Errorf("%s: got %q skip=%v err=%v", tc.policy, got, skip, err)
+		}
+	}
+	if got, skip, err := resolveConflict(localFS{}, filepath.Join(dir, "new.txt"), mod, ConflictSkip); err != nil || skip || got != filepath.Join(dir, "new.txt") {
+		t.Fatalf("missing target: %q %v %v", got, skip, err)
+	}
+}
@@ -0,0 +1,260 @@
+package sftpclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+/*
+Integrity checks
+
+After a copy, the remote file's hash is compared with the local data's. The
+server hashes the file itself when it offers the check-file extension
+(sha256, else md5); pkg/sftp cannot send arbitrary extended requests, so
+that goes over a second "sftp" subsystem channel. Otherwise `sha256sum`
+runs over an exec channel. When neither works the copy stays unverified
+rather than failing.
+*/
+
+// ErrChecksumMismatch means a copy differs from its source.
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+var (
+	errUnsupportedHash = errors.New("unsupported hash algorithm")
+	errNoCheckFile     = errors.New("server does not support check-file")
+)
+
+const (
+	VerifyCheckFile = "check-file"
+	VerifyExec      = "exec"
+)
+
+// remoteSumFunc hashes a remote file on the server. method is "" when the
+// server offers no way to.
+type remoteSumFunc func(ctx context.Context, hostID int, p string) (method, alg string, sum []byte, err error)
+
+// verify compares remote's hash with localSum's for the same algorithm. It
+// returns the method used, or "" when the file could not be checked.
+func (m *Manager) verify(ctx context.Context, hostID int, remote string, localSum func(alg string) ([]byte, error)) (string, string, error) {
+	m.mu.Lock()
+	sumFn := m.remoteSum
+	m.mu.Unlock()
+	if sumFn == nil {
+		sumFn = m.serverSum
+	}
+	method, alg, want, err := sumFn(ctx, hostID, remote)
+	if err != nil || method == "" {
+		return "", "", nil
+	}
+	got, err := localSum(alg)
+	if err != nil {
+		return "", "", nil
+	}
+	if !bytes.Equal(got, want) {
+		return method, alg, fmt.Errorf("%w (%s)", ErrChecksumMismatch, alg)
+	}
+	return method, alg, nil
+}
+
+// serverSum hashes p with the check-file extension, or sha256sum.
+func (m *Manager) serverSum(ctx context.Context, hostID int, p string) (string, string, []byte, error) {
+	m.mu.Lock()
+	var client *ssh.Client
+	if s := m.sessions[hostID]; s != nil {
+		client = s.ssh
+	}
+	m.mu.Unlock()
+	if client == nil {
+		return "", "", nil, nil
+	}
+
+	alg, sum, err := withSession(ctx, client, func(sess *ssh.Session) (string, []byte, error) {
+		stdin, err := sess.StdinPipe()
+		if err != nil {
+			return "", nil, err
+		}
+		stdout, err := sess.StdoutPipe()
+		if err != nil {
+			return "", nil, err
+		}
+		if err := sess.RequestSubsystem("sftp"); err != nil {
+			return "", nil, err
+		}
+		return checkFile(struct {
+			io.Reader
+			io.Writer
+		}{stdout, stdin}, p)
+	})
+	if err == nil {
+		return VerifyCheckFile, alg, sum, nil
+	}
+
+	sum, err = execSHA256(ctx, client, p)
+	if err != nil {
+		return "", "", nil, nil
+	}
+	return VerifyExec, "sha256", sum, nil
+}
+
+// withSession runs fn on a new session of client, closing it when ctx ends.
+func withSession(ctx context.Context, client *ssh.Client, fn func(sess *ssh.Session) (string, []byte, error)) (string, []byte, error) {
+	sess, err := client.NewSession()
+	if err != nil {
+		return "", nil, err
+	}
+	defer sess.Close()
+	stop := context.AfterFunc(ctx, func() { _ = sess.Close() })
+	defer stop()
+	return fn(sess)
+}
+
+func execSHA256(ctx context.Context, client *ssh.Client, p string) ([]byte, error) {
+	// Exec starts in the home directory like SFTP, which does not expand "~".
+	p = strings.TrimPrefix(p, "~/")
+	_, sum, err := withSession(ctx, client, func(sess *ssh.Session) (string, []byte, error) {
+		out, err := sess.Output("sha256sum -- " + shellQuote(p))
+		if err != nil {
+			return "", nil, err
+		}
+		fields := strings.Fields(string(out))
+		if len(fields) == 0 {
+			return "", nil, errors.New("sha256sum printed nothing")
+		}
+		sum, err := hex.DecodeString(fields[0])
+		if err != nil || len(sum) != 32 {
+			return "", nil, fmt.Errorf("unexpected sha256sum output %q", fields[0])
+		}
+		return "sha256", sum, nil
+	})
+	return sum, err
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// SFTP packet types used by checkFile.
+const (
+	fxpInit          = 1
+	fxpVersion       = 2
+	fxpStatus        = 101
+	fxpExtended      = 200
+	fxpExtendedReply = 201
+
+	maxPacket = 256 * 1024
+)
+
+// checkFile asks the SFTP server on rw for p's hash with the check-file-name
+// extension (draft-ietf-secsh-filexfer-extensions), preferring sha256.
+func checkFile(rw io.ReadWriter, p string) (string, []byte, error) {
+	if err := writePacket(rw, fxpInit, binary.BigEndian.AppendUint32(nil, 3)); err != nil {
+		return "", nil, err
+	}
+	typ, data, err := readPacket(rw)
+	if err != nil {
+		return "", nil, err
+	}
+	if typ != fxpVersion || len(data) < 4 {
+		return "", nil, fmt.Errorf("unexpected sftp packet %d", typ)
+	}
+	supported := false
+	for rest := data[4:]; len(rest) > 0; {
+		var name string
+		var ok bool
+		if name, rest, ok = readString(rest); !ok {
+			break
+		}
+		if _, rest, ok = readString(rest); !ok {
+			break
+		}
+		if name == "check-file" || name == "check-file-name" {
+			supported = true
+		}
+	}
+	if !supported {
+		return "", nil, errNoCheckFile
+	}
+
+	req := binary.BigEndian.AppendUint32(nil, 1)
+	req = appendString(req, "check-file-name")
+	req = appendString(req, p)
+	req = appendString(req, "sha256,md5")
+	req = binary.BigEndian.AppendUint64(req, 0) // from the start
+	req = binary.BigEndian.AppendUint64(req, 0) // to the end
+	req = binary.BigEndian.AppendUint32(req, 0) // one hash for the whole file
+	if err := writePacket(rw, fxpExtended, req); err != nil {
+		return "", nil, err
+	}
+	typ, data, err = readPacket(rw)
+	if err != nil {
+		return "", nil, err
+	}
+	if len(data) < 4 {
+		return "", nil, errors.New("short sftp reply")
+	}
+	data = data[4:] // request id
+	switch typ {
+	case fxpExtendedReply:
+		alg, rest, ok := readString(data)
+		if ok && alg == "check-file" {
+			alg, rest, ok = readString(rest)
+		}
+		if !ok || len(rest) == 0 {
+			return "", nil, errors.New("malformed check-file reply")
+		}
+		return alg, rest, nil
+	case fxpStatus:
+		if len(data) >= 4 {
+			msg, _, _ := readString(data[4:])
+			return "", nil, fmt.Errorf("check-file failed: %d %s", binary.BigEndian.Uint32(data), msg)
+		}
+	}
+	return "", nil, fmt.Errorf("unexpected sftp packet %d", typ)
+}
+
+func writePacket(w io.Writer, typ byte, payload []byte) error {
+	b := binary.BigEndian.AppendUint32(make([]byte, 0, 5+len(payload)), uint32(1+len(payload)))
+	b = append(b, typ)
+	_, err := w.Write(append(b, payload...))
+	return err
+}
+
+func readPacket(r io.Reader) (byte, []byte, error) {
+	var hdr [4]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return 0, nil, err
+	}
+	n := binary.BigEndian.Uint32(hdr[:])
+	if n < 1 || n > maxPacket {
+		return 0, nil, fmt.Errorf("bad sftp packet length %d", n)
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return 0, nil, err
+	}
+	return b[0], b[1:], nil
+}
+
+func appendString(b []byte, s string) []byte {
+	b = binary.BigEndian.AppendUint32(b, uint32(len(s)))
+	return append(b, s...)
+}
+
+func readString(b []byte) (string, []byte, bool) {
+	if len(b) < 4 {
+		return "", nil, false
+	}
+	n := binary.BigEndian.Uint32(b)
+	if uint64(len(b)-4) < uint64(n) {
+		return "", nil, false
+	}
+	return string(b[4 : 4+n]), b[4+n:], true
+}
@@ -0,0 +1,304 @@
+package sshagent
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ankouros/pterminal/internal/config"
+	"github.com/ankouros/pterminal/internal/model"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+/*
+Built-in SSH agent
+
+An in-process agent.Keyring managed by pTerminal, for machines without a
+running ssh-agent. Keys are added from files (optionally with a lifetime),
+or automatically once their passphrase was entered for a connection. The
+agent can also be served on a unix socket so other local tools (ssh, git)
+can use it through SSH_AUTH_SOCK.
+*/
+
+// ErrKeyNotLoaded is returned by RemoveKey for an unknown fingerprint.
+var ErrKeyNotLoaded = errors.New("key is not loaded")
+
+// KeyInfo describes a loaded key.
+type KeyInfo struct {
+	Fingerprint string `json:"fingerprint"`
+	Type        string `json:"type"`
+	Comment     string `json:"comment"`
+	ExpiresAt   int64  `json:"expiresAt,omitempty"` // unix seconds; 0 = no lifetime
+}
+
+type Agent struct {
+	agent.ExtendedAgent
+
+	mu       sync.Mutex
+	expires  map[string]time.Time // fingerprint -> expiry, for keys added here
+	autoAdd  bool
+	lifetime time.Duration
+	socket   string
+	sockErr  error // why the configured socket could not be served
+	ln       net.Listener
+}
+
+// New returns an empty agent without a socket.
+func New() *Agent {
+	return &Agent{
+		ExtendedAgent: agent.NewKeyring().(agent.ExtendedAgent),
+		expires:       map[string]time.Time{},
+	}
+}
+
+// SocketPath resolves the socket location from the settings.
+func SocketPath(settings *model.AgentSettings) (string, error) {
+	if settings != nil && strings.TrimSpace(settings.SocketPath) != "" {
+		p := strings.TrimSpace(settings.SocketPath)
+		if p == "~" || strings.HasPrefix(p, "~/") {
+			home, err := os.UserHomeDir()
+			if err != nil {
+				return "", err
+			}
+			p = filepath.Join(home, strings.TrimPrefix(p, "~"))
+		}
+		return p, nil
+	}
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		return filepath.Join(dir, config.ConfigDirName, "agent.sock"), nil
+	}
+	p, err := config.ConfigPath()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(filepath.Dir(p), "agent.sock"), nil
+}
+
+// Configure applies the app settings, opening or closing the socket as needed.
+// A socket error is also kept for Socket.
+func (a *Agent) Configure(settings *model.AgentSettings) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	err := a.configureLocked(settings)
+	a.sockErr = err
+	return err
+}
+
+func (a *Agent) configureLocked(settings *model.AgentSettings) error {
+	a.autoAdd = settings != nil && settings.AddUnlockedKeys
+	a.lifetime = 0
+	if settings != nil && settings.LifetimeMin > 0 {
+		a.lifetime = time.Duration(settings.LifetimeMin) * time.Minute
+	}
+
+	want := ""
+	if settings != nil && settings.Socket {
+		p, err := SocketPath(settings)
+		if err != nil {
+			return err
+		}
+		want = p
+	}
+	if want == a.socket {
+		return nil
+	}
+	a.closeSocketLocked()
+	if want == "" {
+		return nil
+	}
+	return a.listenLocked(want)
+}
+
+// Socket returns the path the agent is served on ("" when not exposed) and
+// the error of the last attempt to serve it.
+func (a *Agent) Socket() (string, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.socket, a.sockErr
+}
+
+func (a *Agent) listenLocked(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	// A socket left behind by a crashed instance blocks Listen; only remove
+	// it when nothing answers on it.
+	if c, err := net.DialTimeout("unix", path, time.Second); err == nil {
+		_ = c.Close()
+		return fmt.Errorf("agent socket %s is in use", path)
+	}
+	_ = os.Remove(path)
+
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return err
+	}
+	if err := os.Chmod(path, 0o600); err != nil {
+		_ = ln.Close()
+		return err
+	}
+	a.ln, a.socket = ln, path
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				_ = agent.ServeAgent(a, conn)
+			}()
+		}
+	}()
+	return nil
+}
+
+func (a *Agent) closeSocketLocked() {
+	if a.ln != nil {
+		_ = a.ln.Close()
+		_ = os.Remove(a.socket)
+	}
+	a.ln, a.socket = nil, ""
+}
+
+// Close stops serving the socket and drops every key.
+func (a *Agent) Close() error {
+	a.mu.Lock()
+	a.closeSocketLocked()
+	a.expires = map[string]time.Time{}
+	a.mu.Unlock()
+	return a.ExtendedAgent.RemoveAll()
+}
+
+// AddKeyFile loads a private key file. lifetime 0 uses the configured default.
+func (a *Agent) AddKeyFile(path, passphrase string, lifetime time.Duration) (KeyInfo, error) {
+	b, err := os.ReadFile(expandHome(path))
+	if err != nil {
+		return KeyInfo{}, err
+	}
+	var key any
+	if passphrase != "" {
+		key, err = ssh.ParseRawPrivateKeyWithPassphrase(b, []byte(passphrase))
+	} else {
+		key, err = ssh.ParseRawPrivateKey(b)
+	}
+	if err != nil {
+		return KeyInfo{}, err
+	}
+	return a.AddKey(key, filepath.Base(path), lifetime)
+}
+
+// AddKey loads a parsed private key. lifetime 0 uses the configured default.
+func (a *Agent) AddKey(key any, comment string, lifetime time.Duration) (KeyInfo, error) {
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		return KeyInfo{}, err
+	}
+	a.mu.Lock()
+	if lifetime <= 0 {
+		lifetime = a.lifetime
+	}
+	a.mu.Unlock()
+
+	added := agent.AddedKey{PrivateKey: key, Comment: comment}
+	if lifetime > 0 {
+		added.LifetimeSecs = uint32(lifetime / time.Second)
+	}
+	if err := a.ExtendedAgent.Add(added); err != nil {
+		return KeyInfo{}, err
+	}
+
+	info := KeyInfo{
+		Fingerprint: ssh.FingerprintSHA256(signer.PublicKey()),
+		Type:        signer.PublicKey().Type(),
+		Comment:     comment,
+	}
+	a.mu.Lock()
+	if lifetime > 0 {
+		exp := time.Now().Add(lifetime)
+		a.expires[info.Fingerprint] = exp
+		info.ExpiresAt = exp.Unix()
+	} else {
+		delete(a.expires, info.Fingerprint)
+	}
+	a.mu.Unlock()
+	return info, nil
+}
+
+// KeyUnlocked adds a key whose passphrase was just entered for a connection,
+// when the settings ask for it.
+func (a *Agent) KeyUnlocked(key any, comment string) {
+	a.mu.Lock()
+	autoAdd := a.autoAdd
+	a.mu.Unlock()
+	if autoAdd {
+		_, _ = a.AddKey(key, comment, 0)
+	}
+}
+
+// Keys lists the loaded keys.
+func (a *Agent) Keys() ([]KeyInfo, error) {
+	keys, err := a.ExtendedAgent.List()
+	if err != nil {
+		return nil, err
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	out := make([]KeyInfo, 0, len(keys))
+	for _, k := range keys {
+		info := KeyInfo{
+			Fingerprint: ssh.FingerprintSHA256(k),
+			Type:        k.Type(),
+			Comment:     k.Comment,
+		}
+		if exp, ok := a.expires[info.Fingerprint]; ok {
+			info.ExpiresAt = exp.Unix()
+		}
+		out = append(out, info)
+	}
+	return out, nil
+}
+
+// RemoveKey drops the key with the given SHA256 fingerprint.
+func (a *Agent) RemoveKey(fingerprint string) error {
+	keys, err := a.ExtendedAgent.List()
+	if err != nil {
+		return err
+	}
+	for _, k := range keys {
+		if ssh.FingerprintSHA256(k) != fingerprint {
+			continue
+		}
+		if err := a.ExtendedAgent.Remove(k); err != nil {
+			return err
+		}
+		a.mu.Lock()
+		delete(a.expires, fingerprint)
+		a.mu.Unlock()
+		return nil
+	}
+	return ErrKeyNotLoaded
+}
+
+// RemoveAllKeys drops every key.
+func (a *Agent) RemoveAllKeys() error {
+	a.mu.Lock()
+	a.expires = map[string]time.Time{}
+	a.mu.Unlock()
+	return a.ExtendedAgent.RemoveAll()
+}
+
+func expandHome(p string) string {
+	if len(p) >= 2 && p[:2] == "~/" {
+		if h, err := os.UserHomeDir(); err == nil {
+			return filepath.Join(h, p[2:])
+		}
+	}
+	return p
+}
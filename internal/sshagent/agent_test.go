@@ -0,0 +1,136 @@
+package sshagent
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ankouros/pterminal/internal/model"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+func writeTestKey(t *testing.T, passphrase string) (string, ed25519.PrivateKey) {
+	t.Helper()
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var block *pem.Block
+	if passphrase != "" {
+		block, err = ssh.MarshalPrivateKeyWithPassphrase(priv, "test", []byte(passphrase))
+	} else {
+		block, err = ssh.MarshalPrivateKey(priv, "test")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "id_ed25519")
+	if err := os.WriteFile(path, pem.EncodeToMemory(block), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path, priv
+}
+
+func TestAgentKeys(t *testing.T) {
+	a := New()
+	t.Cleanup(func() { _ = a.Close() })
+
+	path, _ := writeTestKey(t, "secret")
+	if _, err := a.AddKeyFile(path, "", 0); err == nil {
+		t.Fatal("expected an error without the passphrase")
+	}
+	info, err := a.AddKeyFile(path, "secret", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Type != ssh.KeyAlgoED25519 || info.Comment != "id_ed25519" || info.ExpiresAt == 0 {
+		t.Fatalf("unexpected key info %+v", info)
+	}
+
+	keys, err := a.Keys()
+	if err != nil || len(keys) != 1 || keys[0] != info {
+		t.Fatalf("unexpected keys %+v %v", keys, err)
+	}
+
+	if err := a.RemoveKey("SHA256:nope"); err != ErrKeyNotLoaded {
+		t.Fatalf("expected ErrKeyNotLoaded, got %v", err)
+	}
+	if err := a.RemoveKey(info.Fingerprint); err != nil {
+		t.Fatal(err)
+	}
+	if keys, _ := a.Keys(); len(keys) != 0 {
+		t.Fatalf("key not removed: %+v", keys)
+	}
+}
+
+func TestAgentKeyUnlocked(t *testing.T) {
+	a := New()
+	t.Cleanup(func() { _ = a.Close() })
+	_, priv := writeTestKey(t, "")
+
+	a.KeyUnlocked(priv, "off")
+	if keys, _ := a.Keys(); len(keys) != 0 {
+		t.Fatal("key added although AddUnlockedKeys is off")
+	}
+
+	if err := a.Configure(&model.AgentSettings{AddUnlockedKeys: true, LifetimeMin: 5}); err != nil {
+		t.Fatal(err)
+	}
+	a.KeyUnlocked(priv, "on")
+	keys, _ := a.Keys()
+	if len(keys) != 1 || keys[0].Comment != "on" {
+		t.Fatalf("unexpected keys %+v", keys)
+	}
+	if d := time.Until(time.Unix(keys[0].ExpiresAt, 0)); d < 4*time.Minute || d > 5*time.Minute {
+		t.Fatalf("default lifetime not applied: %v", d)
+	}
+}
+
+func TestAgentSocket(t *testing.T) {
+	a := New()
+	t.Cleanup(func() { _ = a.Close() })
+	_, priv := writeTestKey(t, "")
+	if _, err := a.AddKey(priv, "sock", 0); err != nil {
+		t.Fatal(err)
+	}
+
+	sock := filepath.Join(t.TempDir(), "agent.sock")
+	if err := a.Configure(&model.AgentSettings{Socket: true, SocketPath: sock}); err != nil {
+		t.Fatal(err)
+	}
+	if path, err := a.Socket(); path != sock || err != nil {
+		t.Fatalf("unexpected socket %q %v", path, err)
+	}
+	if fi, err := os.Stat(sock); err != nil || fi.Mode().Perm() != 0o600 {
+		t.Fatalf("unexpected socket mode %v %v", fi, err)
+	}
+
+	conn, err := net.Dial("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, err := agent.NewClient(conn).List()
+	_ = conn.Close()
+	if err != nil || len(keys) != 1 || keys[0].Comment != "sock" {
+		t.Fatalf("unexpected keys over the socket %+v %v", keys, err)
+	}
+
+	// A second agent must not take over a live socket.
+	other := New()
+	if err := other.Configure(&model.AgentSettings{Socket: true, SocketPath: sock}); err == nil {
+		t.Fatal("expected the socket to be in use")
+	}
+
+	if err := a.Configure(nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(sock); !os.IsNotExist(err) {
+		t.Fatalf("socket not removed: %v", err)
+	}
+}
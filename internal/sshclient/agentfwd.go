@@ -0,0 +1,122 @@
+package sshclient
+
+import (
+	"errors"
+
+	"github.com/ankouros/pterminal/internal/model"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+/*
+Agent forwarding
+
+Agent-auth hosts may forward the local agent (SSH_AUTH_SOCK) to their
+terminal sessions, e.g. for git over SSH on the remote side. In confirm mode
+every signing request from the remote side is put to ConfirmAgent first, and
+requests that would change the agent (adding or removing keys, locking it,
+extensions) are refused.
+*/
+
+// ErrAgentSignDenied is returned to the remote side when a signing request is
+// not confirmed.
+var ErrAgentSignDenied = errors.New("agent signing request denied")
+
+// ErrAgentRequestDenied is returned to the remote side for requests that
+// change the agent in confirm mode.
+var ErrAgentRequestDenied = errors.New("agent request not allowed over a confirmed forward")
+
+// AgentSignRequest describes a remote signing request awaiting confirmation.
+type AgentSignRequest struct {
+	Host        model.Host
+	Fingerprint string
+	Comment     string
+}
+
+// AgentConfirmFunc reports whether a signing request may proceed. It may block
+// until the user answers.
+type AgentConfirmFunc func(req AgentSignRequest) bool
+
+// ForwardsAgent reports whether host forwards the local agent.
+func ForwardsAgent(host model.Host) bool {
+	return host.Auth.Method == model.AuthAgent && host.ForwardAgent != model.AgentForwardOff
+}
+
+// forwardAgent serves the agent at SSH_AUTH_SOCK (or, without one, the
+// built-in agent) on client's agent channels and asks the server to forward it
+// for sess. The returned closer drops the agent connection.
+func forwardAgent(client *ssh.Client, sess *ssh.Session, host model.Host, confirm AgentConfirmFunc) (func(), error) {
+	source, closeSource, err := systemAgent()
+	if err != nil {
+		local := getLocalAgent()
+		if local == nil {
+			return nil, err
+		}
+		source, closeSource = local, func() {}
+	}
+	var keyring agent.Agent = source
+	if host.ForwardAgent == model.AgentForwardConfirm {
+		keyring = &confirmAgent{ExtendedAgent: source, host: host, confirm: confirm}
+	}
+	if err := agent.ForwardToAgent(client, keyring); err != nil {
+		closeSource()
+		return nil, err
+	}
+	if err := agent.RequestAgentForwarding(sess); err != nil {
+		closeSource()
+		return nil, err
+	}
+	return closeSource, nil
+}
+
+// confirmAgent asks before every signature and refuses changes to the agent;
+// listing keys passes through.
+type confirmAgent struct {
+	agent.ExtendedAgent
+	host    model.Host
+	confirm AgentConfirmFunc
+}
+
+func (a *confirmAgent) allow(key ssh.PublicKey) bool {
+	if a.confirm == nil {
+		return false
+	}
+	req := AgentSignRequest{Host: a.host, Fingerprint: ssh.FingerprintSHA256(key)}
+	if keys, err := a.List(); err == nil {
+		for _, k := range keys {
+			if string(k.Marshal()) == string(key.Marshal()) {
+				req.Comment = k.Comment
+				break
+			}
+		}
+	}
+	return a.confirm(req)
+}
+
+func (a *confirmAgent) Sign(key ssh.PublicKey, data []byte) (*ssh.Signature, error) {
+	if !a.allow(key) {
+		return nil, ErrAgentSignDenied
+	}
+	return a.ExtendedAgent.Sign(key, data)
+}
+
+func (a *confirmAgent) SignWithFlags(key ssh.PublicKey, data []byte, flags agent.SignatureFlags) (*ssh.Signature, error) {
+	if !a.allow(key) {
+		return nil, ErrAgentSignDenied
+	}
+	return a.ExtendedAgent.SignWithFlags(key, data, flags)
+}
+
+func (a *confirmAgent) Add(agent.AddedKey) error { return ErrAgentRequestDenied }
+
+func (a *confirmAgent) Remove(ssh.PublicKey) error { return ErrAgentRequestDenied }
+
+func (a *confirmAgent) RemoveAll() error { return ErrAgentRequestDenied }
+
+func (a *confirmAgent) Lock([]byte) error { return ErrAgentRequestDenied }
+
+func (a *confirmAgent) Unlock([]byte) error { return ErrAgentRequestDenied }
+
+func (a *confirmAgent) Extension(string, []byte) ([]byte, error) {
+	return nil, ErrAgentRequestDenied
+}
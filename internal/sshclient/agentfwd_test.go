@@ -0,0 +1,149 @@
+package sshclient
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/ankouros/pterminal/internal/model"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// startAgentSignServer runs an SSH server that, once a shell starts on a
+// session that asked for agent forwarding, signs a challenge with the
+// forwarded agent and reports the outcome.
+func startAgentSignServer(t *testing.T) (*net.TCPAddr, <-chan error) {
+	t.Helper()
+	signer := mustTestSigner(t)
+	cfg := &ssh.ServerConfig{
+		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if !bytes.Equal(key.Marshal(), signer.PublicKey().Marshal()) {
+				return nil, errors.New("unknown key")
+			}
+			return nil, nil
+		},
+	}
+	cfg.AddHostKey(signer)
+
+	results := make(chan error, 1)
+	session := func(conn ssh.Conn, ch ssh.Channel, reqs <-chan *ssh.Request) {
+		defer ch.Close()
+		forwarded := false
+		for req := range reqs {
+			switch req.Type {
+			case "auth-agent-req@openssh.com":
+				forwarded = true
+				_ = req.Reply(true, nil)
+			case "shell":
+				_ = req.Reply(true, nil)
+				if !forwarded {
+					results <- errors.New("agent forwarding was not requested")
+					continue
+				}
+				results <- signWithForwardedAgent(conn, signer.PublicKey())
+			default:
+				if req.WantReply {
+					_ = req.Reply(req.Type == "pty-req", nil)
+				}
+			}
+		}
+	}
+	return startTestSSHServer(t, cfg, &testSSHHandlers{session: session}), results
+}
+
+func signWithForwardedAgent(conn ssh.Conn, key ssh.PublicKey) error {
+	ch, reqs, err := conn.OpenChannel("auth-agent@openssh.com", nil)
+	if err != nil {
+		return err
+	}
+	defer ch.Close()
+	go ssh.DiscardRequests(reqs)
+
+	data := []byte("challenge")
+	sig, err := agent.NewClient(ch).Sign(key, data)
+	if err != nil {
+		return err
+	}
+	return key.Verify(data, sig)
+}
+
+func TestAgentForwardingConfirm(t *testing.T) {
+	t.Setenv("SSH_AUTH_SOCK", setupTestAgent(t))
+
+	for _, tc := range []struct {
+		name    string
+		mode    model.AgentForwardMode
+		allow   bool
+		asked   bool
+		wantErr bool
+	}{
+		{name: "on", mode: model.AgentForwardOn},
+		{name: "confirm-allow", mode: model.AgentForwardConfirm, allow: true, asked: true},
+		{name: "confirm-deny", mode: model.AgentForwardConfirm, asked: true, wantErr: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			addr, results := startAgentSignServer(t)
+			host := model.Host{
+				Name:         "agent-host",
+				Host:         addr.IP.String(),
+				Port:         addr.Port,
+				User:         testSSHUser,
+				Auth:         model.AuthConfig{Method: model.AuthAgent},
+				HostKey:      model.HostKeyConfig{Mode: model.HostKeyInsecure},
+				Keepalive:    &model.KeepaliveConfig{Disabled: true},
+				ForwardAgent: tc.mode,
+			}
+
+			asks := make(chan AgentSignRequest, 1)
+			opts := DialOptions{ConfirmAgent: func(req AgentSignRequest) bool {
+				asks <- req
+				return tc.allow
+			}}
+			ns, err := DialAndStart(context.Background(), host, 80, 24, nil, opts)
+			if err != nil {
+				t.Fatalf("dial: %v", err)
+			}
+			defer ns.Close()
+
+			select {
+			case err := <-results:
+				if tc.wantErr != (err != nil) {
+					t.Fatalf("unexpected sign result: %v", err)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("server did not sign")
+			}
+			select {
+			case req := <-asks:
+				if !tc.asked {
+					t.Fatal("confirmation asked in forward-only mode")
+				}
+				if req.Comment != "pterminal-test" || req.Host.Name != "agent-host" {
+					t.Fatalf("unexpected request %+v", req)
+				}
+			default:
+				if tc.asked {
+					t.Fatal("confirmation not asked")
+				}
+			}
+		})
+	}
+}
+
+func TestConfirmAgentRefusesChanges(t *testing.T) {
+	keyring := agent.NewKeyring().(agent.ExtendedAgent)
+	a := &confirmAgent{ExtendedAgent: keyring, confirm: func(AgentSignRequest) bool { return true }}
+	if err := a.RemoveAll(); !errors.Is(err, ErrAgentRequestDenied) {
+		t.Fatalf("remove all: %v", err)
+	}
+	if err := a.Lock([]byte("pw")); !errors.Is(err, ErrAgentRequestDenied) {
+		t.Fatalf("lock: %v", err)
+	}
+	if _, err := a.Extension("session-bind@openssh.com", nil); !errors.Is(err, ErrAgentRequestDenied) {
+		t.Fatalf("extension: %v", err)
+	}
+}
@@ -0,0 +1,169 @@
+package sshclient
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ankouros/pterminal/internal/model"
+	"golang.org/x/crypto/ssh"
+)
+
+/*
+SSH certificates
+
+User certificates are picked up from <key>-cert.pub (or Auth.CertPath) and
+offered before the plain key. Host certificates are accepted when signed by a
+CA from the host's team or an @cert-authority line in known_hosts; otherwise
+the certified key is checked like a plain host key.
+*/
+
+// CertInfo describes a certificate used or presented on a connection.
+type CertInfo struct {
+	KeyID       string   `json:"keyId,omitempty"`
+	Principals  []string `json:"principals,omitempty"`
+	ValidAfter  int64    `json:"validAfter,omitempty"`  // unix seconds; 0 = no lower bound
+	ValidBefore int64    `json:"validBefore,omitempty"` // unix seconds; 0 = never expires
+	Authority   string   `json:"authority,omitempty"`   // CA fingerprint
+}
+
+// ConnInfo reports the certificates negotiated during the handshake.
+type ConnInfo struct {
+	UserCert *CertInfo `json:"userCert,omitempty"`
+	HostCert *CertInfo `json:"hostCert,omitempty"`
+}
+
+func certInfo(cert *ssh.Certificate) *CertInfo {
+	info := &CertInfo{
+		KeyID:      cert.KeyId,
+		Principals: append([]string(nil), cert.ValidPrincipals...),
+		Authority:  ssh.FingerprintSHA256(cert.SignatureKey),
+	}
+	if cert.ValidAfter != 0 {
+		info.ValidAfter = int64(cert.ValidAfter)
+	}
+	if cert.ValidBefore != ssh.CertTimeInfinity {
+		info.ValidBefore = int64(cert.ValidBefore)
+	}
+	return info
+}
+
+// loadUserCert returns the user certificate for the key at keyPath, or nil when
+// there is none. certPath overrides the <key>-cert.pub default; an explicit
+// path that cannot be used is an error, a default one is skipped silently.
+func loadUserCert(certPath, keyPath string, pub ssh.PublicKey) (*ssh.Certificate, error) {
+	path := expandHome(strings.TrimSpace(certPath))
+	explicit := path != ""
+	if !explicit {
+		path = keyPath + "-cert.pub"
+	}
+	skip := func(err error) (*ssh.Certificate, error) {
+		if explicit {
+			return nil, fmt.Errorf("ssh certificate %s: %w", path, err)
+		}
+		return nil, nil
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return skip(err)
+	}
+	parsed, _, _, _, err := ssh.ParseAuthorizedKey(b)
+	if err != nil {
+		return skip(err)
+	}
+	cert, ok := parsed.(*ssh.Certificate)
+	if !ok || cert.CertType != ssh.UserCert {
+		return skip(errors.New("not a user certificate"))
+	}
+	if !bytes.Equal(cert.Key.Marshal(), pub.Marshal()) {
+		return skip(errors.New("certificate does not match the private key"))
+	}
+	if cert.ValidBefore != ssh.CertTimeInfinity && uint64(time.Now().Unix()) >= cert.ValidBefore {
+		return skip(fmt.Errorf("certificate expired at %s",
+			time.Unix(int64(cert.ValidBefore), 0).Format(time.RFC3339)))
+	}
+	return cert, nil
+}
+
+// ParseHostCA parses a CA public key in authorized_keys format. An optional
+// leading "@cert-authority <hosts>" (known_hosts style) is accepted.
+func ParseHostCA(line string) (ssh.PublicKey, error) {
+	line = strings.TrimSpace(line)
+	if strings.HasPrefix(line, "@cert-authority") {
+		fields := strings.Fields(line)
+		if len(fields) < 3 {
+			return nil, errors.New("invalid @cert-authority line")
+		}
+		line = strings.Join(fields[2:], " ")
+	}
+	key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(line))
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := key.(*ssh.Certificate); ok {
+		return nil, errors.New("a CA must be a plain public key")
+	}
+	return key, nil
+}
+
+func parseHostCAs(lines []string) []ssh.PublicKey {
+	out := make([]ssh.PublicKey, 0, len(lines))
+	for _, line := range lines {
+		if key, err := ParseHostCA(line); err == nil {
+			out = append(out, key)
+		}
+	}
+	return out
+}
+
+// TeamHostCAs returns the host CAs trusted for host: those of the host's team,
+// or of its network's team for hosts without one.
+func TeamHostCAs(cfg model.AppConfig, host model.Host) []string {
+	teamID := host.TeamID
+	if teamID == "" {
+		for _, n := range cfg.Networks {
+			for _, h := range n.Hosts {
+				if h.ID == host.ID {
+					teamID = n.TeamID
+				}
+			}
+		}
+	}
+	if teamID == "" {
+		return nil
+	}
+	for _, t := range cfg.Teams {
+		if t.ID == teamID && !t.Deleted {
+			return t.HostCAs
+		}
+	}
+	return nil
+}
+
+// checkHostCert accepts cert when one of cas signed it for hostname.
+func checkHostCert(hostname string, cert *ssh.Certificate, cas []ssh.PublicKey) (bool, error) {
+	trusted := false
+	for _, ca := range cas {
+		if bytes.Equal(ca.Marshal(), cert.SignatureKey.Marshal()) {
+			trusted = true
+			break
+		}
+	}
+	if !trusted {
+		return false, nil
+	}
+	if cert.CertType != ssh.HostCert {
+		return true, fmt.Errorf("certificate presented as a host key has type %d", cert.CertType)
+	}
+	host, _, err := net.SplitHostPort(hostname)
+	if err != nil {
+		host = hostname
+	}
+	checker := ssh.CertChecker{}
+	return true, checker.CheckCert(host, cert)
+}
@@ -0,0 +1,59 @@
+package sshclient
+
+import (
+	"sync/atomic"
+
+	"github.com/ankouros/pterminal/internal/hostkeys"
+	"github.com/ankouros/pterminal/internal/model"
+	"golang.org/x/crypto/ssh"
+)
+
+/*
+Host key store
+
+When a hostkeys.Store is registered, it is consulted before known_hosts:
+pinned and trusted keys are accepted, replaced keys and keys missing from a
+host's pins are reported as mismatches, and every accepted key is recorded.
+Pins shared through the config (host.HostKey.Pins) apply even without a
+store.
+*/
+
+var hostKeyStore atomic.Pointer[hostkeys.Store]
+
+// SetHostKeyStore registers the host key store (nil unregisters it).
+func SetHostKeyStore(s *hostkeys.Store) { hostKeyStore.Store(s) }
+
+// checkStoredHostKey applies the store and shared pins. done reports whether
+// the key was decided here; otherwise known_hosts decides.
+func checkStoredHostKey(host model.Host, hostPort string, key ssh.PublicKey) (done bool, err error) {
+	mismatch := ErrHostKeyMismatch{HostPort: hostPort, Fingerprint: ssh.FingerprintSHA256(key), Key: key}
+
+	store := hostKeyStore.Load()
+	if store == nil || host.UID == "" {
+		if len(host.HostKey.Pins) == 0 {
+			return false, nil
+		}
+		for _, pin := range host.HostKey.Pins {
+			if pin == mismatch.Fingerprint {
+				return true, nil
+			}
+		}
+		return true, mismatch
+	}
+
+	switch store.Check(host.UID, key, host.HostKey.Pins) {
+	case hostkeys.Trusted:
+		_ = store.Seen(host.UID, hostPort, key)
+		return true, nil
+	case hostkeys.Rejected:
+		return true, mismatch
+	}
+	return false, nil
+}
+
+// recordHostKey notes a key known_hosts accepted.
+func recordHostKey(host model.Host, hostPort string, key ssh.PublicKey) {
+	if store := hostKeyStore.Load(); store != nil {
+		_ = store.Seen(host.UID, hostPort, key)
+	}
+}
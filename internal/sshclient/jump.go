@@ -0,0 +1,234 @@
+package sshclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/ankouros/pterminal/internal/model"
+	"golang.org/x/crypto/ssh"
+)
+
+// maxJumpDepth bounds ProxyJump chains so a misconfigured loop fails fast.
+const maxJumpDepth = 8
+
+// Hop is one bastion in a ProxyJump chain.
+type Hop struct {
+	Host             model.Host
+	PasswordProvider func() (string, error)
+	HostCAs          []string
+}
+
+// DialOptions carries per-connection settings that are resolved from the wider
+// config rather than from the target host itself.
+type DialOptions struct {
+	// Jumps lists the bastions to tunnel through, outermost first.
+	Jumps []Hop
+
+	// HostCAs lists extra CAs (authorized_keys format) trusted to sign the
+	// target's host certificate.
+	HostCAs []string
+
+	// ConfirmAgent approves remote signing requests when the host forwards
+	// the agent in confirm mode. Nil denies them.
+	ConfirmAgent AgentConfirmFunc
+}
+
+/*
+ProxyJump chains
+*/
+
+// ResolveJumpChain expands host.JumpHosts (and the jump hosts' own chains) into
+// an ordered list of bastions, outermost first.
+func ResolveJumpChain(cfg model.AppConfig, host model.Host) ([]model.Host, error) {
+	if len(host.JumpHosts) == 0 {
+		return nil, nil
+	}
+
+	byUID := map[string]model.Host{}
+	for _, netw := range cfg.Networks {
+		if netw.Deleted {
+			continue
+		}
+		for _, h := range netw.Hosts {
+			if h.Deleted || h.UID == "" {
+				continue
+			}
+			byUID[h.UID] = h
+		}
+	}
+
+	chain := []model.Host{}
+	added := map[string]struct{}{}
+	visiting := map[string]struct{}{}
+	if host.UID != "" {
+		visiting[host.UID] = struct{}{}
+	}
+
+	var expand func(h model.Host, depth int) error
+	expand = func(h model.Host, depth int) error {
+		if depth > maxJumpDepth {
+			return fmt.Errorf("jump chain for %q is too deep", h.Name)
+		}
+		for _, uid := range h.JumpHosts {
+			uid = strings.TrimSpace(uid)
+			if uid == "" {
+				continue
+			}
+			if _, ok := visiting[uid]; ok {
+				return fmt.Errorf("jump chain for %q contains a loop", host.Name)
+			}
+			jump, ok := byUID[uid]
+			if !ok {
+				return fmt.Errorf("jump host %s not found", uid)
+			}
+			if jump.Driver != "" && jump.Driver != model.DriverSSH {
+				return fmt.Errorf("jump host %q is not an ssh host", jump.Name)
+			}
+			if _, ok := added[uid]; ok {
+				continue
+			}
+			visiting[uid] = struct{}{}
+			if err := expand(jump, depth+1); err != nil {
+				return err
+			}
+			delete(visiting, uid)
+			added[uid] = struct{}{}
+			chain = append(chain, jump)
+		}
+		return nil
+	}
+
+	if err := expand(host, 1); err != nil {
+		return nil, err
+	}
+	return chain, nil
+}
+
+// ResolveDialOptions builds the DialOptions for host from cfg. Each bastion
+// asks passwordProvider for its own credentials (keyed by its host ID).
+func ResolveDialOptions(
+	cfg model.AppConfig,
+	host model.Host,
+	passwordProvider func(hostID int) (string, error),
+) (DialOptions, error) {
+	chain, err := ResolveJumpChain(cfg, host)
+	if err != nil {
+		return DialOptions{}, err
+	}
+
+	opts := DialOptions{HostCAs: TeamHostCAs(cfg, host)}
+	for _, jump := range chain {
+		jump := jump
+		opts.Jumps = append(opts.Jumps, Hop{
+			Host:    jump,
+			HostCAs: TeamHostCAs(cfg, jump),
+			PasswordProvider: func() (string, error) {
+				if passwordProvider == nil {
+					return "", errors.New("password provider not set")
+				}
+				return passwordProvider(jump.ID)
+			},
+		})
+	}
+	return opts, nil
+}
+
+/*
+Dialing
+*/
+
+// dial connects to host, tunnelling through opts.Jumps when set. Every hop runs
+// its own handshake (auth + host key verification). Bastion clients are closed
+// once the returned client shuts down. The ConnInfo is the target's.
+func dial(
+	ctx context.Context,
+	host model.Host,
+	passwordProvider func() (string, error),
+	opts DialOptions,
+) (*ssh.Client, *ConnInfo, error) {
+	var (
+		prev  *ssh.Client
+		jumps []*ssh.Client
+	)
+	closeJumps := func() {
+		for i := len(jumps) - 1; i >= 0; i-- {
+			_ = jumps[i].Close()
+		}
+	}
+
+	for _, hop := range opts.Jumps {
+		c, _, err := dialHop(ctx, prev, hop.Host, hop.PasswordProvider, hop.HostCAs)
+		if err != nil {
+			closeJumps()
+			return nil, nil, fmt.Errorf("jump host %q: %w", hopName(hop.Host), err)
+		}
+		jumps = append(jumps, c)
+		prev = c
+	}
+
+	client, info, err := dialHop(ctx, prev, host, passwordProvider, opts.HostCAs)
+	if err != nil {
+		closeJumps()
+		return nil, nil, err
+	}
+
+	if len(jumps) > 0 {
+		go func() {
+			_ = client.Wait()
+			closeJumps()
+		}()
+	}
+	return client, info, nil
+}
+
+// dialHop opens a transport to host (directly, or as a direct-tcpip channel
+// through via) and completes the SSH handshake on it.
+func dialHop(
+	ctx context.Context,
+	via *ssh.Client,
+	host model.Host,
+	passwordProvider func() (string, error),
+	hostCAs []string,
+) (*ssh.Client, *ConnInfo, error) {
+	cfg, info, cleanup, err := buildClientConfig(host, passwordProvider, parseHostCAs(hostCAs))
+	if err != nil {
+		return nil, nil, err
+	}
+	defer func() {
+		if cleanup != nil {
+			cleanup()
+		}
+	}()
+
+	addr := net.JoinHostPort(host.Host, fmt.Sprint(host.Port))
+
+	var conn net.Conn
+	if via == nil {
+		dialer := net.Dialer{Timeout: 8 * time.Second}
+		conn, err = dialer.DialContext(ctx, "tcp", addr)
+	} else {
+		conn, err = via.DialContext(ctx, "tcp", addr)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	c, chans, reqs, err := ssh.NewClientConn(conn, addr, cfg)
+	if err != nil {
+		_ = conn.Close()
+		return nil, nil, err
+	}
+
+	return ssh.NewClient(c, chans, reqs), info, nil
+}
+
+func hopName(h model.Host) string {
+	if strings.TrimSpace(h.Name) != "" {
+		return h.Name
+	}
+	return net.JoinHostPort(h.Host, fmt.Sprint(h.Port))
+}
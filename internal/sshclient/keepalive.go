@@ -0,0 +1,105 @@
+package sshclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/ankouros/pterminal/internal/model"
+	"golang.org/x/crypto/ssh"
+)
+
+/*
+Keepalives
+
+A dropped NAT mapping or a dead peer leaves the TCP connection looking open
+until something is written. NodeSession sends keepalive@openssh.com global
+requests and closes itself after too many go unanswered, so the session
+manager's reconnect loop takes over. Any reply (success or failure) counts.
+*/
+
+const (
+	keepaliveRequest = "keepalive@openssh.com"
+
+	DefaultKeepaliveInterval  = 30 * time.Second
+	DefaultKeepaliveMaxMissed = 3
+)
+
+// ErrKeepaliveTimeout is the close reason after MaxMissed unanswered keepalives.
+var ErrKeepaliveTimeout = errors.New("keepalive timeout")
+
+// KeepaliveSettings resolves host.Keepalive against the defaults. ok is false
+// when keepalives are disabled.
+func KeepaliveSettings(host model.Host) (interval time.Duration, maxMissed int, ok bool) {
+	interval, maxMissed = DefaultKeepaliveInterval, DefaultKeepaliveMaxMissed
+	k := host.Keepalive
+	if k == nil {
+		return interval, maxMissed, true
+	}
+	if k.Disabled {
+		return 0, 0, false
+	}
+	if k.IntervalSec > 0 {
+		interval = time.Duration(k.IntervalSec) * time.Second
+	}
+	if k.MaxMissed > 0 {
+		maxMissed = k.MaxMissed
+	}
+	return interval, maxMissed, true
+}
+
+// keepaliveStats is shared between the keepalive loop and readers.
+type keepaliveStats struct {
+	rtt    atomic.Int64 // last round trip, ns; 0 = none yet
+	missed atomic.Int32
+}
+
+// keepalive probes client every interval until ctx ends. onDead is called
+// once maxMissed probes in a row got no reply.
+func keepalive(
+	ctx context.Context,
+	client *ssh.Client,
+	interval time.Duration,
+	maxMissed int,
+	stats *keepaliveStats,
+	onDead func(error),
+) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	replies := make(chan time.Duration, 1)
+	inFlight := false
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case rtt := <-replies:
+			inFlight = false
+			stats.rtt.Store(int64(rtt))
+			stats.missed.Store(0)
+
+		case <-t.C:
+			if inFlight {
+				if n := stats.missed.Add(1); int(n) >= maxMissed {
+					onDead(fmt.Errorf("%w: no reply to %d keepalives", ErrKeepaliveTimeout, n))
+					return
+				}
+				continue
+			}
+			inFlight = true
+			started := time.Now()
+			go func() {
+				if _, _, err := client.SendRequest(keepaliveRequest, true, nil); err != nil {
+					return // connection closed; the session notices on its own
+				}
+				select {
+				case replies <- time.Since(started):
+				default:
+				}
+			}()
+		}
+	}
+}
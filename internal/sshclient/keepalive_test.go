@@ -0,0 +1,99 @@
+package sshclient
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ankouros/pterminal/internal/model"
+	"golang.org/x/crypto/ssh"
+)
+
+// startKeepaliveServer answers global requests when answer is set; otherwise it
+// never reads them, like a peer behind a dropped NAT mapping.
+func startKeepaliveServer(t *testing.T, answer bool) *ssh.Client {
+	t.Helper()
+	cfg := &ssh.ServerConfig{NoClientAuth: true}
+	cfg.AddHostKey(mustTestSigner(t))
+	addr := startTestSSHServer(t, cfg, &testSSHHandlers{global: func(_ ssh.Conn, reqs <-chan *ssh.Request) {
+		if answer {
+			ssh.DiscardRequests(reqs)
+		}
+	}})
+
+	client, err := ssh.Dial("tcp", addr.String(), &ssh.ClientConfig{
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		Timeout:         5 * time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = client.Close() })
+	return client
+}
+
+func TestKeepaliveMeasuresRTT(t *testing.T) {
+	client := startKeepaliveServer(t, true)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var stats keepaliveStats
+	dead := make(chan error, 1)
+	go keepalive(ctx, client, 20*time.Millisecond, 2, &stats, func(err error) { dead <- err })
+
+	deadline := time.Now().Add(2 * time.Second)
+	for stats.rtt.Load() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("no keepalive reply recorded")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	select {
+	case err := <-dead:
+		t.Fatalf("responsive peer reported dead: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestKeepaliveDetectsDeadPeer(t *testing.T) {
+	client := startKeepaliveServer(t, false)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var stats keepaliveStats
+	dead := make(chan error, 1)
+	go keepalive(ctx, client, 20*time.Millisecond, 3, &stats, func(err error) { dead <- err })
+
+	select {
+	case err := <-dead:
+		if !errors.Is(err, ErrKeepaliveTimeout) {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if stats.missed.Load() != 3 {
+			t.Fatalf("expected 3 missed keepalives, got %d", stats.missed.Load())
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("dead peer not detected")
+	}
+}
+
+func TestKeepaliveSettings(t *testing.T) {
+	cases := []struct {
+		cfg      *model.KeepaliveConfig
+		interval time.Duration
+		missed   int
+		ok       bool
+	}{
+		{nil, DefaultKeepaliveInterval, DefaultKeepaliveMaxMissed, true},
+		{&model.KeepaliveConfig{IntervalSec: 10}, 10 * time.Second, DefaultKeepaliveMaxMissed, true},
+		{&model.KeepaliveConfig{MaxMissed: 5}, DefaultKeepaliveInterval, 5, true},
+		{&model.KeepaliveConfig{Disabled: true, IntervalSec: 10}, 0, 0, false},
+	}
+	for i, c := range cases {
+		interval, missed, ok := KeepaliveSettings(model.Host{Keepalive: c.cfg})
+		if interval != c.interval || missed != c.missed || ok != c.ok {
+			t.Fatalf("case %d: got %v/%d/%v", i, interval, missed, ok)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package sshclient
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+/*
+Local agent
+
+The built-in agent (internal/sshagent) registers itself here. Agent-auth
+hosts use its keys ahead of SSH_AUTH_SOCK, key-auth hosts use a loaded key
+instead of asking for the passphrase again, and keys decrypted with a
+passphrase are handed back to it.
+*/
+
+// LocalAgent is the in-process agent.
+type LocalAgent interface {
+	agent.ExtendedAgent
+
+	// KeyUnlocked is told about a private key just decrypted with a passphrase.
+	KeyUnlocked(key any, comment string)
+}
+
+var (
+	localAgentMu sync.RWMutex
+	localAgent   LocalAgent
+)
+
+// SetLocalAgent registers the built-in agent (nil unregisters it).
+func SetLocalAgent(a LocalAgent) {
+	localAgentMu.Lock()
+	localAgent = a
+	localAgentMu.Unlock()
+}
+
+func getLocalAgent() LocalAgent {
+	localAgentMu.RLock()
+	defer localAgentMu.RUnlock()
+	return localAgent
+}
+
+// localSigner returns the local agent's signer for pub, if the key is loaded.
+func localSigner(pub ssh.PublicKey) ssh.Signer {
+	a := getLocalAgent()
+	if a == nil || pub == nil {
+		return nil
+	}
+	signers, err := a.Signers()
+	if err != nil {
+		return nil
+	}
+	for _, s := range signers {
+		if bytes.Equal(s.PublicKey().Marshal(), pub.Marshal()) {
+			return s
+		}
+	}
+	return nil
+}
+
+// systemAgent connects to the agent at SSH_AUTH_SOCK.
+func systemAgent() (agent.ExtendedAgent, func(), error) {
+	sock := os.Getenv("SSH_AUTH_SOCK")
+	if sock == "" {
+		return nil, nil, errors.New("SSH_AUTH_SOCK is not set")
+	}
+	conn, err := net.DialTimeout("unix", sock, 2*time.Second)
+	if err != nil {
+		return nil, nil, err
+	}
+	return agent.NewClient(conn), func() { _ = conn.Close() }, nil
+}
+
+// agentAuth offers the local agent's keys, then those of SSH_AUTH_SOCK.
+func agentAuth() (ssh.AuthMethod, func(), error) {
+	local := getLocalAgent()
+	system, cleanup, err := systemAgent()
+	if err != nil && local == nil {
+		return nil, nil, err
+	}
+	return ssh.PublicKeysCallback(func() ([]ssh.Signer, error) {
+		var signers []ssh.Signer
+		if local != nil {
+			if s, err := local.Signers(); err == nil {
+				signers = append(signers, s...)
+			}
+		}
+		if system != nil {
+			s, err := system.Signers()
+			if err != nil && len(signers) == 0 {
+				return nil, err
+			}
+			signers = append(signers, s...)
+		}
+		return signers, nil
+	}), cleanup, nil
+}
@@ -0,0 +1,100 @@
+package sshclient
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ankouros/pterminal/internal/model"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// recordingAgent is a keyring that loads every unlocked key.
+type recordingAgent struct {
+	agent.ExtendedAgent
+	unlocked int
+}
+
+func (a *recordingAgent) KeyUnlocked(key any, comment string) {
+	a.unlocked++
+	_ = a.Add(agent.AddedKey{PrivateKey: key, Comment: comment})
+}
+
+func TestLocalAgentKeyAuth(t *testing.T) {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := ssh.MarshalPrivateKeyWithPassphrase(priv, "", []byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPath := filepath.Join(t.TempDir(), "id_ed25519")
+	if err := os.WriteFile(keyPath, pem.EncodeToMemory(block), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	pub, err := ssh.NewPublicKey(priv.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &ssh.ServerConfig{
+		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if !bytes.Equal(key.Marshal(), pub.Marshal()) {
+				return nil, errors.New("unknown key")
+			}
+			return nil, nil
+		},
+	}
+	cfg.AddHostKey(mustTestSigner(t))
+	addr := startTestSSHServer(t, cfg, nil)
+
+	local := &recordingAgent{ExtendedAgent: agent.NewKeyring().(agent.ExtendedAgent)}
+	SetLocalAgent(local)
+	t.Cleanup(func() { SetLocalAgent(nil) })
+
+	host := model.Host{
+		Host:    addr.IP.String(),
+		Port:    addr.Port,
+		User:    testSSHUser,
+		Auth:    model.AuthConfig{Method: model.AuthKey, KeyPath: keyPath},
+		HostKey: model.HostKeyConfig{Mode: model.HostKeyInsecure},
+	}
+
+	// The first connect asks for the passphrase and hands the key to the agent.
+	asked := 0
+	client, _, err := DialClient(context.Background(), host, func() (string, error) {
+		asked++
+		return "secret", nil
+	}, DialOptions{})
+	if err != nil {
+		t.Fatalf("dial with passphrase: %v", err)
+	}
+	_ = client.Close()
+	if asked != 1 || local.unlocked != 1 {
+		t.Fatalf("asked %d, unlocked %d", asked, local.unlocked)
+	}
+
+	// Later connects use the loaded key without asking.
+	client, _, err = DialClient(context.Background(), host, nil, DialOptions{})
+	if err != nil {
+		t.Fatalf("dial through the local agent: %v", err)
+	}
+	_ = client.Close()
+
+	// Agent auth works without SSH_AUTH_SOCK.
+	t.Setenv("SSH_AUTH_SOCK", "")
+	host.Auth = model.AuthConfig{Method: model.AuthAgent}
+	client, _, err = DialClient(context.Background(), host, nil, DialOptions{})
+	if err != nil {
+		t.Fatalf("agent auth with the local agent: %v", err)
+	}
+	_ = client.Close()
+}
@@ -14,7 +14,6 @@ import (
 
 	"github.com/ankouros/pterminal/internal/model"
 	"golang.org/x/crypto/ssh"
-	"golang.org/x/crypto/ssh/agent"
 	"golang.org/x/crypto/ssh/knownhosts"
 )
 
@@ -67,6 +66,9 @@ NodeSession
 type NodeSession struct {
 	Node model.Host
 
+	// Certs holds the user/host certificates used by the connection.
+	Certs ConnInfo
+
 	client *ssh.Client
 	sess   *ssh.Session
 
@@ -80,42 +82,29 @@ type NodeSession struct {
 	cancel context.CancelFunc
 	once   sync.Once
 	wg     sync.WaitGroup
+
+	keepalive keepaliveStats
+
+	closeAgent func() // drops the forwarded agent connection, if any
+
+	errMu sync.Mutex
+	err   error // why the session closed itself, if it did
 }
 
 // DialClient establishes an SSH connection and returns an ssh.Client without
-// starting a remote shell/pty. Callers must Close() the returned client.
+// starting a remote shell/pty. Callers must Close() the returned client; the
+// cleanup func is kept for callers that track per-connection resources and may be nil.
 func DialClient(
 	ctx context.Context,
 	host model.Host,
 	passwordProvider func() (string, error),
+	opts DialOptions,
 ) (*ssh.Client, func(), error) {
-	cfg, cleanup, err := buildClientConfig(host, passwordProvider)
+	client, _, err := dial(ctx, host, passwordProvider, opts)
 	if err != nil {
 		return nil, nil, err
 	}
-
-	addr := net.JoinHostPort(host.Host, fmt.Sprint(host.Port))
-
-	dialer := net.Dialer{Timeout: 8 * time.Second}
-	conn, err := dialer.DialContext(ctx, "tcp", addr)
-	if err != nil {
-		if cleanup != nil {
-			cleanup()
-		}
-		return nil, nil, err
-	}
-
-	c, chans, reqs, err := ssh.NewClientConn(conn, addr, cfg)
-	if err != nil {
-		_ = conn.Close()
-		if cleanup != nil {
-			cleanup()
-		}
-		return nil, nil, err
-	}
-
-	client := ssh.NewClient(c, chans, reqs)
-	return client, cleanup, nil
+	return client, nil, nil
 }
 
 func DialAndStart(
@@ -123,33 +112,13 @@ func DialAndStart(
 	host model.Host,
 	cols, rows int,
 	passwordProvider func() (string, error),
+	opts DialOptions,
 ) (*NodeSession, error) {
 
-	cfg, cleanup, err := buildClientConfig(host, passwordProvider)
+	client, info, err := dial(ctx, host, passwordProvider, opts)
 	if err != nil {
 		return nil, err
 	}
-	defer func() {
-		if cleanup != nil {
-			cleanup()
-		}
-	}()
-
-	addr := net.JoinHostPort(host.Host, fmt.Sprint(host.Port))
-
-	dialer := net.Dialer{Timeout: 8 * time.Second}
-	conn, err := dialer.DialContext(ctx, "tcp", addr)
-	if err != nil {
-		return nil, err
-	}
-
-	c, chans, reqs, err := ssh.NewClientConn(conn, addr, cfg)
-	if err != nil {
-		_ = conn.Close()
-		return nil, err
-	}
-
-	client := ssh.NewClient(c, chans, reqs)
 
 	sess, err := client.NewSession()
 	if err != nil {
@@ -169,28 +138,48 @@ func DialAndStart(
 		return nil, err
 	}
 
+	// Forwarding is best-effort like in OpenSSH: a refusal is shown in the
+	// terminal instead of failing the connection.
+	var closeAgent func()
+	var agentNotice string
+	if ForwardsAgent(host) {
+		closeAgent, err = forwardAgent(client, sess, host, opts.ConfirmAgent)
+		if err != nil {
+			agentNotice = fmt.Sprintf("\x1b[33m[agent forwarding unavailable: %v]\x1b[0m\r\n", err)
+		}
+	}
+	cleanupAgent := func() {
+		if closeAgent != nil {
+			closeAgent()
+		}
+	}
+
 	stdin, err := sess.StdinPipe()
 	if err != nil {
 		_ = sess.Close()
 		client.Close()
+		cleanupAgent()
 		return nil, err
 	}
 	stdout, err := sess.StdoutPipe()
 	if err != nil {
 		_ = sess.Close()
 		client.Close()
+		cleanupAgent()
 		return nil, err
 	}
 	stderr, err := sess.StderrPipe()
 	if err != nil {
 		_ = sess.Close()
 		client.Close()
+		cleanupAgent()
 		return nil, err
 	}
 
 	if err := sess.Shell(); err != nil {
 		_ = sess.Close()
 		client.Close()
+		cleanupAgent()
 		return nil, err
 	}
 
@@ -198,6 +187,7 @@ func DialAndStart(
 
 	ns := &NodeSession{
 		Node:   host,
+		Certs:  *info,
 		client: client,
 		sess:   sess,
 		stdin:  stdin,
@@ -206,6 +196,11 @@ func DialAndStart(
 		output: make(chan []byte, 512),
 		done:   make(chan struct{}),
 		cancel: cancel,
+
+		closeAgent: closeAgent,
+	}
+	if agentNotice != "" {
+		ns.output <- []byte(agentNotice)
 	}
 
 	ns.wg.Add(2)
@@ -225,6 +220,15 @@ func DialAndStart(
 		_ = ns.Close()
 	}()
 
+	if interval, maxMissed, ok := KeepaliveSettings(host); ok {
+		go keepalive(ctx2, client, interval, maxMissed, &ns.keepalive, func(err error) {
+			ns.errMu.Lock()
+			ns.err = err
+			ns.errMu.Unlock()
+			_ = ns.Close()
+		})
+	}
+
 	return ns, nil
 }
 
@@ -251,6 +255,22 @@ func (s *NodeSession) pump(ctx context.Context, r io.Reader) {
 	}
 }
 
+// Client exposes the underlying SSH connection (e.g. for port forwarding).
+func (s *NodeSession) Client() *ssh.Client { return s.client }
+
+// Err reports why the session closed itself (e.g. ErrKeepaliveTimeout), or nil.
+func (s *NodeSession) Err() error {
+	s.errMu.Lock()
+	defer s.errMu.Unlock()
+	return s.err
+}
+
+// RTT is the round trip of the last answered keepalive (0 before the first).
+func (s *NodeSession) RTT() time.Duration { return time.Duration(s.keepalive.rtt.Load()) }
+
+// KeepalivesMissed counts unanswered keepalives since the last reply.
+func (s *NodeSession) KeepalivesMissed() int { return int(s.keepalive.missed.Load()) }
+
 func (s *NodeSession) Output() <-chan []byte { return s.output }
 func (s *NodeSession) Done() <-chan struct{} { return s.done }
 
@@ -280,6 +300,9 @@ func (s *NodeSession) Close() error {
 		if s.client != nil {
 			ret = s.client.Close()
 		}
+		if s.closeAgent != nil {
+			s.closeAgent()
+		}
 
 		close(s.done)
 		s.wg.Wait()
@@ -292,22 +315,26 @@ func (s *NodeSession) Close() error {
 Client config
 */
 
+// buildClientConfig also returns the ConnInfo that auth and host key
+// verification fill in as the handshake proceeds.
 func buildClientConfig(
 	host model.Host,
 	passwordProvider func() (string, error),
-) (*ssh.ClientConfig, func(), error) {
+	hostCAs []ssh.PublicKey,
+) (*ssh.ClientConfig, *ConnInfo, func(), error) {
+	info := &ConnInfo{}
 
-	auth, cleanup, err := authMethod(host, passwordProvider)
+	auth, cleanup, err := authMethod(host, passwordProvider, info)
 	if err != nil {
-		return nil, nil, err
+		return nil, nil, nil, err
 	}
 
-	hkcb, err := hostKeyCallback(host)
+	hkcb, err := hostKeyCallback(host, hostCAs, info)
 	if err != nil {
 		if cleanup != nil {
 			cleanup()
 		}
-		return nil, nil, err
+		return nil, nil, nil, err
 	}
 
 	return &ssh.ClientConfig{
@@ -315,16 +342,19 @@ func buildClientConfig(
 		Auth:            []ssh.AuthMethod{auth},
 		HostKeyCallback: hkcb,
 		Timeout:         10 * time.Second,
-	}, cleanup, nil
+	}, info, cleanup, nil
 }
 
 /*
 Authentication
 */
 
+// authMethod builds the auth method for host. When a user certificate is
+// used it is recorded in info (which may be nil).
 func authMethod(
 	host model.Host,
 	passwordProvider func() (string, error),
+	info *ConnInfo,
 ) (ssh.AuthMethod, func(), error) {
 
 	switch host.Auth.Method {
@@ -353,8 +383,8 @@ func authMethod(
 				continue
 			}
 			checked = append(checked, candidate)
-			info, err := os.Stat(candidate)
-			if err != nil || info.IsDir() {
+			fi, err := os.Stat(candidate)
+			if err != nil || fi.IsDir() {
 				continue
 			}
 
@@ -366,22 +396,48 @@ func authMethod(
 			if err != nil {
 				var missing *ssh.PassphraseMissingError
 				if errors.As(err, &missing) {
-					if passwordProvider == nil {
-						return nil, nil, ErrPassphraseRequired
-					}
-					pass, perr := passwordProvider()
-					if perr != nil || pass == "" {
-						return nil, nil, ErrPassphraseRequired
-					}
-					signer, err = ssh.ParsePrivateKeyWithPassphrase(b, []byte(pass))
-					if err != nil {
-						return nil, nil, err
+					// The built-in agent may already hold the decrypted key.
+					if s := localSigner(missing.PublicKey); s != nil {
+						signer = s
+					} else {
+						if passwordProvider == nil {
+							return nil, nil, ErrPassphraseRequired
+						}
+						pass, perr := passwordProvider()
+						if perr != nil || pass == "" {
+							return nil, nil, ErrPassphraseRequired
+						}
+						raw, err := ssh.ParseRawPrivateKeyWithPassphrase(b, []byte(pass))
+						if err != nil {
+							return nil, nil, err
+						}
+						if signer, err = ssh.NewSignerFromKey(raw); err != nil {
+							return nil, nil, err
+						}
+						if a := getLocalAgent(); a != nil {
+							a.KeyUnlocked(raw, candidate)
+						}
 					}
 				} else {
 					return nil, nil, err
 				}
 			}
-			return ssh.PublicKeys(signer), nil, nil
+			cert, err := loadUserCert(host.Auth.CertPath, candidate, signer.PublicKey())
+			if err != nil {
+				return nil, nil, err
+			}
+			if cert == nil {
+				return ssh.PublicKeys(signer), nil, nil
+			}
+			certSigner, err := ssh.NewCertSigner(cert, signer)
+			if err != nil {
+				return nil, nil, err
+			}
+			if info != nil {
+				info.UserCert = certInfo(cert)
+			}
+			// Offer the certificate first and fall back to the plain key.
+			return ssh.PublicKeys(certSigner, signer), nil, nil
 		}
 
 		return nil, nil, &ErrKeyNotFound{
@@ -390,16 +446,7 @@ func authMethod(
 		}
 
 	case model.AuthAgent:
-		sock := os.Getenv("SSH_AUTH_SOCK")
-		if sock == "" {
-			return nil, nil, errors.New("SSH_AUTH_SOCK is not set")
-		}
-		conn, err := net.DialTimeout("unix", sock, 2*time.Second)
-		if err != nil {
-			return nil, nil, err
-		}
-		ag := agent.NewClient(conn)
-		return ssh.PublicKeysCallback(ag.Signers), func() { _ = conn.Close() }, nil
+		return agentAuth()
 
 	case model.AuthKeyboardInteractive:
 		if passwordProvider == nil {
@@ -426,16 +473,52 @@ func authMethod(
 Host key verification
 */
 
-func hostKeyCallback(host model.Host) (ssh.HostKeyCallback, error) {
+// hostKeyCallback verifies host keys against the host key store (see
+// hostkeys.go), then ~/.ssh/known_hosts. Host
+// certificates signed by one of hostCAs (or an @cert-authority entry) are
+// accepted and recorded in info; other certificates fall back to checking the
+// certified key.
+func hostKeyCallback(host model.Host, hostCAs []ssh.PublicKey, info *ConnInfo) (ssh.HostKeyCallback, error) {
 	if host.HostKey.Mode == model.HostKeyInsecure {
 		return ssh.InsecureIgnoreHostKey(), nil
 	}
 	khPath := expandHome("~/.ssh/known_hosts")
 
 	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
-		fp := ssh.FingerprintSHA256(key)
 		hostPort := knownhosts.Normalize(hostname)
 
+		if cert, ok := key.(*ssh.Certificate); ok {
+			trusted, err := checkHostCert(hostname, cert, hostCAs)
+			if trusted {
+				if err != nil {
+					return fmt.Errorf("host certificate for %s: %w", hostPort, err)
+				}
+				if info != nil {
+					info.HostCert = certInfo(cert)
+				}
+				return nil
+			}
+			if matcher, err := knownhosts.New(khPath); err == nil {
+				err = matcher(hostname, remote, cert)
+				if err == nil {
+					if info != nil {
+						info.HostCert = certInfo(cert)
+					}
+					return nil
+				}
+				if !strings.Contains(err.Error(), "no authorities") {
+					return fmt.Errorf("host certificate for %s: %w", hostPort, err)
+				}
+			}
+			key = cert.Key
+		}
+
+		if done, err := checkStoredHostKey(host, hostPort, key); done {
+			return err
+		}
+
+		fp := ssh.FingerprintSHA256(key)
+
 		matcher, err := knownhosts.New(khPath)
 		if err != nil {
 			return err
@@ -443,6 +526,7 @@ func hostKeyCallback(host model.Host) (ssh.HostKeyCallback, error) {
 
 		err = matcher(hostname, remote, key)
 		if err == nil {
+			recordHostKey(host, hostPort, key)
 			return nil
 		}
 
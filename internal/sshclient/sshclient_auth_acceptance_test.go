@@ -283,7 +283,7 @@ func runSSHAuthScenario(t *testing.T, host *model.Host, serverCfg *ssh.ServerCon
 	host.Host = ln.Addr().(*net.TCPAddr).IP.String()
 	host.Port = ln.Addr().(*net.TCPAddr).Port
 
-	client, cleanup, err := DialClient(context.Background(), *host, passwordProvider)
+	client, cleanup, err := DialClient(context.Background(), *host, passwordProvider, DialOptions{})
 	if err != nil {
 		t.Fatalf("dial client: %v", err)
 	}
@@ -0,0 +1,201 @@
+package sshclient
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/ankouros/pterminal/internal/model"
+	"golang.org/x/crypto/ssh"
+)
+
+// newTestCA returns a fresh signer to act as a certificate authority, distinct
+// from the fixed test key.
+func newTestCA(t *testing.T) ssh.Signer {
+	t.Helper()
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer
+}
+
+func signTestCert(t *testing.T, ca ssh.Signer, key ssh.PublicKey, typ uint32, principals []string, validFor time.Duration) *ssh.Certificate {
+	t.Helper()
+	cert := &ssh.Certificate{
+		Key:             key,
+		CertType:        typ,
+		KeyId:           "test-cert",
+		ValidPrincipals: principals,
+		ValidAfter:      uint64(time.Now().Add(-time.Hour).Unix()),
+		ValidBefore:     uint64(time.Now().Add(validFor).Unix()),
+	}
+	if err := cert.SignCert(rand.Reader, ca); err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+// certServerConfig accepts user certificates from userCA and plain userKey, and
+// presents hostKey (a certificate signer or a plain one).
+func certServerConfig(userCA, userKey ssh.PublicKey, hostKey ssh.Signer) *ssh.ServerConfig {
+	checker := &ssh.CertChecker{
+		IsUserAuthority: func(auth ssh.PublicKey) bool {
+			return string(auth.Marshal()) == string(userCA.Marshal())
+		},
+		UserKeyFallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if string(key.Marshal()) == string(userKey.Marshal()) {
+				return nil, nil
+			}
+			return nil, errors.New("bad key")
+		},
+	}
+	cfg := &ssh.ServerConfig{PublicKeyCallback: checker.Authenticate}
+	cfg.AddHostKey(hostKey)
+	return cfg
+}
+
+func TestDialWithUserAndHostCertificates(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	if err := os.MkdirAll(filepath.Join(home, ".ssh"), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	khPath := filepath.Join(home, ".ssh", "known_hosts")
+	if err := os.WriteFile(khPath, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	userCA := newTestCA(t)
+	hostCA := newTestCA(t)
+	userSigner := mustTestSigner(t)
+	hostSigner := mustTestSigner(t)
+
+	keyPath := filepath.Join(home, "id_rsa")
+	if err := os.WriteFile(keyPath, []byte(testSSHPrivateKey), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	userCert := signTestCert(t, userCA, userSigner.PublicKey(), ssh.UserCert, []string{testSSHUser}, time.Hour)
+	if err := os.WriteFile(keyPath+"-cert.pub", ssh.MarshalAuthorizedKey(userCert), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	hostCert := signTestCert(t, hostCA, hostSigner.PublicKey(), ssh.HostCert, []string{"127.0.0.1"}, time.Hour)
+	hostCertSigner, err := ssh.NewCertSigner(hostCert, hostSigner)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	addr := startTestSSHServer(t, certServerConfig(userCA.PublicKey(), userSigner.PublicKey(), hostCertSigner), nil)
+	port := addr.Port
+	host := model.Host{
+		Host: addr.IP.String(),
+		Port: port,
+		User: testSSHUser,
+		Auth: model.AuthConfig{Method: model.AuthKey, KeyPath: keyPath},
+	}
+	caLine := string(ssh.MarshalAuthorizedKey(hostCA.PublicKey()))
+
+	t.Run("team CA", func(t *testing.T) {
+		client, info, err := dial(context.Background(), host, nil, DialOptions{HostCAs: []string{caLine}})
+		if err != nil {
+			t.Fatalf("dial: %v", err)
+		}
+		_ = client.Close()
+		if info.UserCert == nil || info.UserCert.Principals[0] != testSSHUser || info.UserCert.ValidBefore == 0 {
+			t.Fatalf("unexpected user cert info: %+v", info.UserCert)
+		}
+		if info.HostCert == nil || info.HostCert.Authority != ssh.FingerprintSHA256(hostCA.PublicKey()) {
+			t.Fatalf("unexpected host cert info: %+v", info.HostCert)
+		}
+	})
+
+	t.Run("untrusted CA falls back to plain key", func(t *testing.T) {
+		_, _, err := dial(context.Background(), host, nil, DialOptions{})
+		var unk ErrUnknownHostKey
+		if !errors.As(err, &unk) {
+			t.Fatalf("expected unknown host key, got %v", err)
+		}
+		if unk.Fingerprint != ssh.FingerprintSHA256(hostSigner.PublicKey()) {
+			t.Fatalf("expected the certified key's fingerprint, got %s", unk.Fingerprint)
+		}
+	})
+
+	t.Run("known_hosts cert-authority", func(t *testing.T) {
+		line := "@cert-authority [127.0.0.1]:" + strconv.Itoa(port) + " " + caLine
+		if err := os.WriteFile(khPath, []byte(line), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { _ = os.WriteFile(khPath, nil, 0o600) })
+
+		client, info, err := dial(context.Background(), host, nil, DialOptions{})
+		if err != nil {
+			t.Fatalf("dial: %v", err)
+		}
+		_ = client.Close()
+		if info.HostCert == nil {
+			t.Fatal("expected host cert info")
+		}
+	})
+
+	t.Run("expired certificate", func(t *testing.T) {
+		expired := signTestCert(t, userCA, userSigner.PublicKey(), ssh.UserCert, []string{testSSHUser}, -time.Minute)
+		certPath := filepath.Join(home, "expired-cert.pub")
+		if err := os.WriteFile(certPath, ssh.MarshalAuthorizedKey(expired), 0o600); err != nil {
+			t.Fatal(err)
+		}
+
+		// An explicitly configured expired certificate is an error.
+		h := host
+		h.Auth.CertPath = certPath
+		if _, _, err := dial(context.Background(), h, nil, DialOptions{HostCAs: []string{caLine}}); err == nil {
+			t.Fatal("expected expired certificate error")
+		}
+
+		// A default one is skipped and the plain key is used.
+		if err := os.WriteFile(keyPath+"-cert.pub", ssh.MarshalAuthorizedKey(expired), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		client, info, err := dial(context.Background(), host, nil, DialOptions{HostCAs: []string{caLine}})
+		if err != nil {
+			t.Fatalf("dial: %v", err)
+		}
+		_ = client.Close()
+		if info.UserCert != nil {
+			t.Fatalf("expected plain key auth, got %+v", info.UserCert)
+		}
+	})
+}
+
+func TestTeamHostCAs(t *testing.T) {
+	cfg := model.AppConfig{
+		Teams: []model.Team{{ID: "t1", HostCAs: []string{"ca-1"}}},
+		Networks: []model.Network{
+			{ID: 1, TeamID: "t1", Hosts: []model.Host{{ID: 1}}},
+			{ID: 2, Hosts: []model.Host{{ID: 2}, {ID: 3, TeamID: "t1"}}},
+		},
+	}
+	for id, want := range map[int]int{1: 1, 2: 0, 3: 1} {
+		h := model.Host{ID: id}
+		for _, n := range cfg.Networks {
+			for _, nh := range n.Hosts {
+				if nh.ID == id {
+					h = nh
+				}
+			}
+		}
+		if got := len(TeamHostCAs(cfg, h)); got != want {
+			t.Fatalf("host %d: got %d CAs, want %d", id, got, want)
+		}
+	}
+}
@@ -0,0 +1,245 @@
+package sshclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/ankouros/pterminal/internal/model"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestResolveJumpChain(t *testing.T) {
+	cfg := model.AppConfig{
+		Networks: []model.Network{
+			{
+				ID: 1,
+				Hosts: []model.Host{
+					{ID: 1, UID: "outer", Name: "outer"},
+					{ID: 2, UID: "inner", Name: "inner", JumpHosts: []string{"outer"}},
+					{ID: 3, UID: "target", Name: "target", JumpHosts: []string{"inner"}},
+					{ID: 4, UID: "loop-a", Name: "loop-a", JumpHosts: []string{"loop-b"}},
+					{ID: 5, UID: "loop-b", Name: "loop-b", JumpHosts: []string{"loop-a"}},
+				},
+			},
+		},
+	}
+
+	chain, err := ResolveJumpChain(cfg, cfg.Networks[0].Hosts[2])
+	if err != nil {
+		t.Fatalf("resolve: %v", err)
+	}
+	if len(chain) != 2 || chain[0].UID != "outer" || chain[1].UID != "inner" {
+		t.Fatalf("unexpected chain: %+v", chain)
+	}
+
+	if _, err := ResolveJumpChain(cfg, cfg.Networks[0].Hosts[3]); err == nil {
+		t.Fatal("expected loop error")
+	}
+
+	missing := model.Host{UID: "x", JumpHosts: []string{"nope"}}
+	if _, err := ResolveJumpChain(cfg, missing); err == nil {
+		t.Fatal("expected missing jump host error")
+	}
+}
+
+func TestDialClientThroughJumpHost(t *testing.T) {
+	signer := mustTestSigner(t)
+
+	newServerConfig := func(password string) *ssh.ServerConfig {
+		cfg := &ssh.ServerConfig{
+			PasswordCallback: func(conn ssh.ConnMetadata, pw []byte) (*ssh.Permissions, error) {
+				if string(pw) != password {
+					return nil, errors.New("bad password")
+				}
+				return nil, nil
+			},
+		}
+		cfg.AddHostKey(signer)
+		return cfg
+	}
+
+	targetAddr := startTestSSHServer(t, newServerConfig("target-pw"), nil)
+	var tunnels atomic.Int32
+	bastionAddr := startTestSSHServer(t, newServerConfig("bastion-pw"), &testSSHHandlers{tunnels: &tunnels})
+
+	bastion := model.Host{
+		Name:    "bastion",
+		Host:    bastionAddr.IP.String(),
+		Port:    bastionAddr.Port,
+		User:    testSSHUser,
+		Auth:    model.AuthConfig{Method: model.AuthPassword},
+		HostKey: model.HostKeyConfig{Mode: model.HostKeyInsecure},
+	}
+	target := model.Host{
+		Name:    "target",
+		Host:    targetAddr.IP.String(),
+		Port:    targetAddr.Port,
+		User:    testSSHUser,
+		Auth:    model.AuthConfig{Method: model.AuthPassword},
+		HostKey: model.HostKeyConfig{Mode: model.HostKeyInsecure},
+	}
+
+	opts := DialOptions{Jumps: []Hop{{
+		Host:             bastion,
+		PasswordProvider: func() (string, error) { return "bastion-pw", nil },
+	}}}
+	client, _, err := DialClient(context.Background(), target, func() (string, error) {
+		return "target-pw", nil
+	}, opts)
+	if err != nil {
+		t.Fatalf("dial through jump host: %v", err)
+	}
+	defer client.Close()
+
+	if got := tunnels.Load(); got != 1 {
+		t.Fatalf("expected 1 direct-tcpip tunnel through bastion, got %d", got)
+	}
+	if !strings.Contains(string(client.ServerVersion()), "SSH-2.0") {
+		t.Fatalf("unexpected server version %q", client.ServerVersion())
+	}
+
+	// A bad bastion password must fail on the bastion hop, not the target.
+	opts.Jumps[0].PasswordProvider = func() (string, error) { return "wrong", nil }
+	_, _, err = DialClient(context.Background(), target, func() (string, error) {
+		return "target-pw", nil
+	}, opts)
+	if err == nil || !strings.Contains(err.Error(), `jump host "bastion"`) {
+		t.Fatalf("expected bastion auth error, got %v", err)
+	}
+}
+
+// testSSHHandlers customizes startTestSSHServer; nil fields keep the defaults.
+type testSSHHandlers struct {
+	// tunnels, when set, allows direct-tcpip channels and counts each one.
+	tunnels *atomic.Int32
+	// global serves the connection's global requests instead of discarding
+	// them.
+	global func(conn ssh.Conn, reqs <-chan *ssh.Request)
+	// session serves accepted session channels instead of serveTestSession.
+	session func(conn ssh.Conn, ch ssh.Channel, reqs <-chan *ssh.Request)
+}
+
+// startTestSSHServer runs an SSH server that accepts sessions and whatever h
+// adds; h may be nil.
+func startTestSSHServer(t *testing.T, cfg *ssh.ServerConfig, h *testSSHHandlers) *net.TCPAddr {
+	t.Helper()
+	if h == nil {
+		h = &testSSHHandlers{}
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() { _ = ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go serveTestSSHConn(conn, cfg, h)
+		}
+	}()
+
+	return ln.Addr().(*net.TCPAddr)
+}
+
+func serveTestSSHConn(conn net.Conn, cfg *ssh.ServerConfig, h *testSSHHandlers) {
+	defer conn.Close()
+
+	srvConn, chans, reqs, err := ssh.NewServerConn(conn, cfg)
+	if err != nil {
+		return
+	}
+	defer srvConn.Close()
+	if h.global != nil {
+		go h.global(srvConn, reqs)
+	} else {
+		go ssh.DiscardRequests(reqs)
+	}
+
+	for newCh := range chans {
+		switch newCh.ChannelType() {
+		case "direct-tcpip":
+			if h.tunnels == nil {
+				_ = newCh.Reject(ssh.Prohibited, "no tunnels")
+				continue
+			}
+			var payload struct {
+				Host       string
+				Port       uint32
+				OriginHost string
+				OriginPort uint32
+			}
+			if err := ssh.Unmarshal(newCh.ExtraData(), &payload); err != nil {
+				_ = newCh.Reject(ssh.ConnectionFailed, err.Error())
+				continue
+			}
+			upstream, err := net.Dial("tcp", net.JoinHostPort(payload.Host, fmt.Sprint(payload.Port)))
+			if err != nil {
+				_ = newCh.Reject(ssh.ConnectionFailed, err.Error())
+				continue
+			}
+			ch, chReqs, err := newCh.Accept()
+			if err != nil {
+				_ = upstream.Close()
+				continue
+			}
+			h.tunnels.Add(1)
+			go ssh.DiscardRequests(chReqs)
+			go func() {
+				defer ch.Close()
+				defer upstream.Close()
+				go func() { _, _ = io.Copy(upstream, ch) }()
+				_, _ = io.Copy(ch, upstream)
+			}()
+		case "session":
+			ch, chReqs, err := newCh.Accept()
+			if err != nil {
+				continue
+			}
+			if h.session != nil {
+				go h.session(srvConn, ch, chReqs)
+			} else {
+				go serveTestSession(ch, chReqs)
+			}
+		default:
+			_ = newCh.Reject(ssh.UnknownChannelType, "unsupported")
+		}
+	}
+}
+
+// serveTestSession answers pty/shell/exec requests; exec echoes the command
+// back on stdout and exits 0.
+func serveTestSession(ch ssh.Channel, reqs <-chan *ssh.Request) {
+	defer ch.Close()
+	for req := range reqs {
+		switch req.Type {
+		case "pty-req", "shell", "window-change", "env":
+			if req.WantReply {
+				_ = req.Reply(true, nil)
+			}
+		case "exec":
+			var payload struct{ Command string }
+			_ = ssh.Unmarshal(req.Payload, &payload)
+			if req.WantReply {
+				_ = req.Reply(true, nil)
+			}
+			_, _ = io.WriteString(ch, payload.Command)
+			_, _ = ch.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{0}))
+			return
+		default:
+			if req.WantReply {
+				_ = req.Reply(false, nil)
+			}
+		}
+	}
+}
@@ -14,7 +14,7 @@ func TestAuthMethodKeyboardInteractive(t *testing.T) {
 	}
 
 	t.Run("no provider", func(t *testing.T) {
-		if _, _, err := authMethod(host, nil); err == nil {
+		if _, _, err := authMethod(host, nil, nil); err == nil {
 			t.Fatal("expected error when password provider is missing")
 		}
 	})
@@ -24,7 +24,7 @@ func TestAuthMethodKeyboardInteractive(t *testing.T) {
 		auth, _, err := authMethod(host, func() (string, error) {
 			called++
 			return "secret", nil
-		})
+		}, nil)
 		if err != nil {
 			t.Fatalf("unexpected error: %v", err)
 		}
@@ -223,18 +223,6 @@
     return script;
   }
 
-  async function waitForConnected(hostId, tabId, timeoutMs = 10000) {
-    const started = Date.now();
-    while (Date.now() - started < timeoutMs) {
-      try {
-        const state = await rpc({ type: "state", hostId, tabId });
-        if (state?.state === "connected") return true;
-      } catch {}
-      await sleep(400);
-    }
-    return false;
-  }
-
   async function runSamakiaVerify(host) {
     const template = getSamakiaVerifyTemplate(host);
     if (!template) {
@@ -245,17 +233,36 @@
     const script = ensureSamakiaVerifyScript(host);
     if (!script) return;
 
-    if (activeHostId !== host.id || !activeTermTabId || activeState !== "connected") {
-      connectHost(host);
-      const tabId = activeTermTabId || 1;
-      const connected = await waitForConnected(host.id, tabId);
-      if (!connected) {
-        notifyWarn("Connect to the host before running verification.");
-        return;
+    const payload = { type: "script_run", hostIds: [host.id], hostId: host.id };
+    if (script.id) {
+      payload.scriptId = script.id;
+    } else {
+      payload.name = script.name;
+      payload.command = script.command;
+    }
+    // An unlocked vault or the keyring may already hold the password; the
+    // run reports "password required" otherwise.
+    const vaultOpen = storesOpen();
+    if (needsPasswordPrompt(host.auth?.method)) {
+      let pw = getRuntimePassword(host.id);
+      if (!pw && !vaultOpen) {
+        pw = await promptDialog(`Password for ${host.user}@${host.host}:`, "", {
+          okText: "Use password",
+          type: "password",
+        });
+        if (!pw) return;
+        setRuntimePassword(host.id, pw);
       }
+      if (pw) payload.passwordB64 = b64enc(pw);
     }
 
-    runScript(script);
+    try {
+      const res = await rpc(payload);
+      notifyInfo(`Verifying ${host.name}…`);
+      watchScriptRun(res.runId);
+    } catch (e) {
+      notifyError(e.detail || e.error || "Verification failed to start");
+    }
   }
 
   let toastSeq = 0;
@@ -384,19 +391,30 @@
 
   let samakiaImportSettingsResolver = null;
 
+  // The same dialog drives ssh_config imports: opts.path shows the file row
+  // and hides UID matching, which ssh_config entries cannot provide.
   function samakiaImportSettingsDialog(
     defaultName = "Samakia Inventory",
-    defaultMode = "hostname"
+    defaultMode = "hostname",
+    opts = {}
   ) {
     return new Promise((resolve) => {
       samakiaImportSettingsResolver = resolve;
       const input = el("samakia-import-network-input");
       const select = el("samakia-import-match-mode");
+      const withPath = typeof opts.path === "string";
+      el("samakia-import-settings-title").textContent =
+        opts.title || "Samakia import";
+      el("samakia-import-path-row").classList.toggle("hidden", !withPath);
+      el("samakia-import-path-input").value = withPath ? opts.path : "";
+      el("samakia-import-match-uid").hidden = withPath;
+      el("samakia-import-uid-warn").classList.toggle("hidden", withPath);
       if (input) {
         input.value = defaultName || "Samakia Inventory";
       }
       if (select) {
-        select.value = defaultMode || "hostname";
+        select.value =
+          withPath && defaultMode === "uid" ? "hostname" : defaultMode || "hostname";
       }
       el("samakia-import-settings-modal").classList.remove("hidden");
       setTimeout(() => input?.focus?.(), 0);
@@ -421,6 +439,10 @@
   function openSamakiaImportSummary(summary, importPath) {
     samakiaImportSummary = summary || null;
     samakiaImportPath = importPath || "";
+    el("samakia-import-title").textContent =
+      summary?.source === "ssh_config"
+        ? "SSH config import summary"
+        : "Samakia import summary";
     el("samakia-import-network").textContent =
       summary?.networkName || "Samakia Inventory";
     el("samakia-import-source").textContent = summary?.source || "unknown";
@@ -565,6 +587,37 @@
     })();
   };
 
+  // Forwarded-agent signing requests (hosts with agent forwarding set to
+  // "confirm") are asked one at a time; unanswered ones are denied by the
+  // backend after payload.timeoutSec.
+  let agentConfirmQueue = Promise.resolve();
+  window.__confirmAgentSign = (payload) => {
+    agentConfirmQueue = agentConfirmQueue.then(async () => {
+      const key = payload?.comment ? `${payload.comment} (${payload.fingerprint})` : payload?.fingerprint;
+      const allow = await confirmDialog(
+        `${payload?.host || "A remote host"} wants to sign with your SSH agent key ${key}. Allow?`,
+        { okText: "Allow", cancelText: "Deny" }
+      );
+      await rpc({ type: "agent_confirm", requestId: payload?.requestId, allow }).catch(() => {});
+    });
+  };
+
+  // Control socket "open": show a host tab and connect it.
+  window.__openHostTab = (hostId, tabId) => {
+    const host = findHostById(hostId);
+    if (!host) return;
+    const net = config?.networks?.find((n) => (n.hosts || []).some((h) => h.id === hostId));
+    if (net && net.id !== activeNetworkId) {
+      activeNetworkId = net.id;
+      renderNetworks();
+    }
+    const state = ensureHostTerminalState(hostId);
+    ensureTabMeta(state, tabId);
+    state.activeTabId = tabId;
+    setActiveTab("terminal");
+    connectHostTab(host, tabId);
+  };
+
   function formatUpdateProgress() {
     const downloaded = Number(updateInfo.downloaded) || 0;
     const total = Number(updateInfo.total) || 0;
@@ -910,6 +963,23 @@
     return (team?.members || []).some((m) => normalizeEmail(m.email) === email);
   }
 
+  // isDeviceInTeam reports whether this device is listed under the user's
+  // membership, which is what lets it receive the team key.
+  function isDeviceInTeam(team) {
+    const fp = teamPresence.fingerprint || "";
+    const email = userEmail();
+    if (!fp || !email) return false;
+    return (team?.members || []).some(
+      (m) => normalizeEmail(m.email) === email && (m.devices || []).includes(fp)
+    );
+  }
+
+  function addMemberDevice(member, fp) {
+    if (!fp) return;
+    member.devices = member.devices || [];
+    if (!member.devices.includes(fp)) member.devices.push(fp);
+  }
+
   function teamRole(team, email) {
     const norm = normalizeEmail(email);
     if (!norm) return "";
@@ -1340,12 +1410,17 @@
     for (const id of state.tabOrder) {
       const btn = document.createElement("div");
       btn.className = "term-tab" + (id === state.activeTabId ? " active" : "");
+      const bc = broadcastMemberOf(activeHostId, id);
+      if (bc) {
+        btn.classList.add("broadcast");
+        btn.classList.toggle("paused", !!bc.member.paused);
+      }
       btn.setAttribute("role", "tab");
       btn.setAttribute("aria-selected", id === state.activeTabId ? "true" : "false");
       btn.title = "Double-click to rename";
 
       const label = document.createElement("span");
-      label.textContent = state.tabNames.get(id) || `Tab ${id}`;
+      label.textContent = (bc ? "⇉ " : "") + (state.tabNames.get(id) || `Tab ${id}`);
 
       btn.appendChild(label);
 
@@ -1389,7 +1464,9 @@
     const state = ensureHostTerminalState(hostId);
     if (state.tabOrder.length <= 1) return;
 
-    rpc({ type: "disconnect", hostId, tabId }).catch(() => {});
+    rpc({ type: "disconnect", hostId, tabId })
+      .then(refreshBroadcast)
+      .catch(() => {});
 
     const entry = state.tabs.get(tabId);
     if (entry) {
@@ -1604,6 +1681,666 @@
     setActiveTab(remembered || "terminal");
   }
 
+  /* ===================== Port Forwards ===================== */
+
+  let forwardsHostId = null;
+  let forwardsTimer = null;
+
+  function describeForward(f) {
+    const flag = f.kind === "remote" ? "-R" : f.kind === "dynamic" ? "-D" : "-L";
+    const target = f.kind === "dynamic" ? "SOCKS5" : f.target;
+    return `${flag} ${f.bind} → ${target}`;
+  }
+
+  function renderForwards(list) {
+    const container = el("forwards-list");
+    if (!container) return;
+    container.innerHTML = "";
+    if (!list.length) {
+      const empty = document.createElement("div");
+      empty.className = "help";
+      empty.textContent = "No forwards configured for this host.";
+      container.appendChild(empty);
+      return;
+    }
+
+    list.forEach((f) => {
+      const item = document.createElement("div");
+      item.className = "forward-item";
+
+      const info = document.createElement("div");
+      const rule = document.createElement("div");
+      rule.className = "forward-rule";
+      rule.textContent = describeForward(f);
+      const meta = document.createElement("div");
+      meta.className = "forward-meta";
+      if (f.state === "error") {
+        meta.classList.add("error");
+        meta.textContent = `error: ${f.error || "failed"}`;
+      } else {
+        meta.textContent = `${f.state} · ↓ ${formatBytes(f.bytesIn)} · ↑ ${formatBytes(
+          f.bytesOut
+        )} · ${f.active} open / ${f.total} total`;
+      }
+      info.appendChild(rule);
+      info.appendChild(meta);
+
+      const actions = document.createElement("div");
+      actions.className = "forward-actions";
+      const running = f.state === "active" || f.state === "waiting";
+      const toggle = document.createElement("button");
+      toggle.className = "btn small secondary";
+      toggle.textContent = running ? "Stop" : "Start";
+      toggle.onclick = () => {
+        rpc({
+          type: running ? "forward_stop" : "forward_start",
+          hostId: forwardsHostId,
+          forwardId: f.id,
+        })
+          .then(refreshForwards)
+          .catch((e) => notifyError(e.detail || e.error || "Forward failed"));
+      };
+      const remove = document.createElement("button");
+      remove.className = "btn small secondary";
+      remove.textContent = "Remove";
+      remove.onclick = () => removeForward(f.id);
+      actions.appendChild(toggle);
+      actions.appendChild(remove);
+
+      item.appendChild(info);
+      item.appendChild(actions);
+      container.appendChild(item);
+    });
+  }
+
+  function refreshForwards() {
+    if (!forwardsHostId) return Promise.resolve();
+    return rpc({ type: "forward_list", hostId: forwardsHostId })
+      .then((res) => renderForwards(res.forwards || []))
+      .catch(() => {});
+  }
+
+  function applyForwardKindVisibility() {
+    const kind = el("forward-kind")?.value || "local";
+    el("forward-target-row")?.classList.toggle("hidden", kind === "dynamic");
+  }
+
+  function openForwardsModal(host) {
+    if (!host) return;
+    if ((host.driver || "ssh") !== "ssh") {
+      notifyWarn("Port forwarding is only available for SSH hosts.");
+      return;
+    }
+    forwardsHostId = host.id;
+    el("forwards-title").textContent = `Port forwards · ${host.name}`;
+    el("forward-kind").value = "local";
+    el("forward-bind-port").value = "";
+    el("forward-target-host").value = "localhost";
+    el("forward-target-port").value = "";
+    el("forward-autostart").checked = false;
+    applyForwardKindVisibility();
+    el("forwards-modal").classList.remove("hidden");
+    refreshForwards();
+    clearInterval(forwardsTimer);
+    forwardsTimer = setInterval(refreshForwards, 1000);
+  }
+
+  function closeForwardsModal() {
+    clearInterval(forwardsTimer);
+    forwardsTimer = null;
+    forwardsHostId = null;
+    el("forwards-modal")?.classList.add("hidden");
+  }
+
+  function addForward() {
+    const host = findHostById(forwardsHostId);
+    if (!host) return;
+    const kind = el("forward-kind").value || "local";
+    const bindPort = Number(el("forward-bind-port").value);
+    const targetHost = el("forward-target-host").value.trim();
+    const targetPort = Number(el("forward-target-port").value);
+    if (!(bindPort > 0 && bindPort <= 65535)) {
+      notifyWarn("Enter a bind port between 1 and 65535.");
+      return;
+    }
+    if (kind !== "dynamic" && (!targetHost || !(targetPort > 0 && targetPort <= 65535))) {
+      notifyWarn("Enter a target host and port.");
+      return;
+    }
+    host.forwards = host.forwards || [];
+    host.forwards.push({
+      id: newLocalId(),
+      kind,
+      bindPort,
+      targetHost: kind === "dynamic" ? "" : targetHost,
+      targetPort: kind === "dynamic" ? 0 : targetPort,
+      autoStart: !!el("forward-autostart").checked,
+    });
+    el("forward-bind-port").value = "";
+    el("forward-target-port").value = "";
+    saveConfig();
+    setTimeout(refreshForwards, 300);
+  }
+
+  function removeForward(id) {
+    const host = findHostById(forwardsHostId);
+    if (!host) return;
+    rpc({ type: "forward_stop", hostId: host.id, forwardId: id }).catch(() => {});
+    host.forwards = (host.forwards || []).filter((f) => f.id !== id);
+    saveConfig();
+    setTimeout(refreshForwards, 300);
+  }
+
+  /* ===================== Broadcast ===================== */
+
+  let broadcastGroups = [];
+  let broadcastTimer = null;
+
+  function broadcastMemberOf(hostId, tabId) {
+    for (const g of broadcastGroups) {
+      const m = (g.members || []).find((x) => x.hostId === hostId && x.tabId === tabId);
+      if (m) return { group: g, member: m };
+    }
+    return null;
+  }
+
+  function broadcastTabLabel(hostId, tabId) {
+    const host = findHostById(hostId);
+    const tabName = hostTerminals.get(hostId)?.tabNames?.get(tabId) || `Tab ${tabId}`;
+    return `${host?.name || `Host ${hostId}`} · ${tabName}`;
+  }
+
+  function applyBroadcastGroups(groups) {
+    broadcastGroups = Array.isArray(groups) ? groups : [];
+    renderTerminalTabBar();
+    if (!el("broadcast-modal").classList.contains("hidden")) renderBroadcast();
+  }
+
+  function refreshBroadcast() {
+    return rpc({ type: "broadcast_list" })
+      .then((res) => applyBroadcastGroups(res.groups))
+      .catch(() => {});
+  }
+
+  function broadcastRpc(req) {
+    return rpc(req)
+      .then((res) => applyBroadcastGroups(res.groups))
+      .catch((e) => notifyError(e.detail || e.error || "Broadcast failed"));
+  }
+
+  function renderBroadcast() {
+    const container = el("broadcast-list");
+    container.innerHTML = "";
+    if (!broadcastGroups.length) {
+      const empty = document.createElement("div");
+      empty.className = "help";
+      empty.textContent = "No broadcast groups. Add the current tab to start one.";
+      container.appendChild(empty);
+    }
+
+    broadcastGroups.forEach((g) => {
+      const title = document.createElement("div");
+      title.className = "help";
+      title.textContent = `${g.name || g.id} · ${g.members.length} member(s)`;
+      container.appendChild(title);
+
+      g.members.forEach((m) => {
+        const item = document.createElement("div");
+        item.className = "broadcast-item";
+
+        const info = document.createElement("div");
+        const name = document.createElement("div");
+        name.className = "broadcast-name";
+        name.textContent = broadcastTabLabel(m.hostId, m.tabId);
+        const meta = document.createElement("div");
+        meta.className = "broadcast-meta";
+        if (m.stalled) {
+          meta.classList.add("error");
+          meta.textContent = "paused: not keeping up";
+        } else {
+          meta.textContent = m.paused ? "paused" : "receiving";
+        }
+        info.appendChild(name);
+        info.appendChild(meta);
+
+        const actions = document.createElement("div");
+        actions.className = "broadcast-actions";
+        const pause = document.createElement("button");
+        pause.className = "btn small secondary";
+        pause.textContent = m.paused ? "Resume" : "Pause";
+        pause.onclick = () =>
+          broadcastRpc({
+            type: "broadcast_pause",
+            hostId: m.hostId,
+            tabId: m.tabId,
+            paused: !m.paused,
+          });
+        const remove = document.createElement("button");
+        remove.className = "btn small secondary";
+        remove.textContent = "Remove";
+        remove.onclick = () =>
+          broadcastRpc({ type: "broadcast_leave", hostId: m.hostId, tabId: m.tabId });
+        actions.appendChild(pause);
+        actions.appendChild(remove);
+
+        item.appendChild(info);
+        item.appendChild(actions);
+        container.appendChild(item);
+      });
+    });
+
+    const select = el("broadcast-group");
+    const prev = select.value;
+    select.innerHTML = "";
+    broadcastGroups.forEach((g) => {
+      const opt = document.createElement("option");
+      opt.value = g.id;
+      opt.textContent = g.name || g.id;
+      select.appendChild(opt);
+    });
+    const fresh = document.createElement("option");
+    fresh.value = "";
+    fresh.textContent = "New group";
+    select.appendChild(fresh);
+    select.value = [...select.options].some((o) => o.value === prev) ? prev : select.options[0].value;
+    el("broadcast-name").disabled = select.value !== "";
+  }
+
+  function openBroadcastModal() {
+    el("broadcast-modal").classList.remove("hidden");
+    renderBroadcast();
+    refreshBroadcast();
+    clearInterval(broadcastTimer);
+    broadcastTimer = setInterval(refreshBroadcast, 1500);
+  }
+
+  function closeBroadcastModal() {
+    clearInterval(broadcastTimer);
+    broadcastTimer = null;
+    el("broadcast-modal")?.classList.add("hidden");
+  }
+
+  function addTabToBroadcast() {
+    if (!activeHostId || !activeTermTabId) return;
+    broadcastRpc({
+      type: "broadcast_join",
+      groupId: el("broadcast-group").value || "",
+      name: el("broadcast-name").value.trim(),
+      hostId: activeHostId,
+      tabId: activeTermTabId,
+    }).then(() => {
+      el("broadcast-name").value = "";
+    });
+  }
+
+  /* ===================== Recordings ===================== */
+
+  let activeRecording = false;
+
+  function formatDuration(seconds) {
+    const s = Math.max(0, Math.round(Number(seconds) || 0));
+    const h = Math.floor(s / 3600);
+    const m = Math.floor((s % 3600) / 60);
+    const pad = (n) => String(n).padStart(2, "0");
+    return h ? `${h}:${pad(m)}:${pad(s % 60)}` : `${m}:${pad(s % 60)}`;
+  }
+
+  function renderRecordingButton() {
+    const btn = el("btn-rec-tab");
+    if (!btn) return;
+    btn.classList.toggle("recording", activeRecording);
+    btn.textContent = activeRecording ? "■ Stop rec" : "● Rec";
+  }
+
+  function toggleTabRecording() {
+    if (!activeHostId || !activeTermTabId) return;
+    const enabled = !activeRecording;
+    rpc({
+      type: "recording_set",
+      hostId: activeHostId,
+      tabId: activeTermTabId,
+      enabled,
+    })
+      .then((r) => {
+        activeRecording = enabled && !!r.path;
+        renderRecordingButton();
+        if (enabled && r.path) notifyInfo(`Recording to:\n${r.path}`);
+        if (!enabled) notifyInfo("Recording stopped.");
+      })
+      .catch((e) => notifyError(e.detail || e.error || "Recording failed"));
+  }
+
+  function renderRecordings(list) {
+    const container = el("recordings-list");
+    if (!container) return;
+    container.innerHTML = "";
+    if (!list.length) {
+      const empty = document.createElement("div");
+      empty.className = "help";
+      empty.textContent = "No recordings yet.";
+      container.appendChild(empty);
+      return;
+    }
+
+    list.forEach((r) => {
+      const item = document.createElement("div");
+      item.className = "recording-item";
+
+      const info = document.createElement("div");
+      const name = document.createElement("div");
+      name.className = "recording-name";
+      name.textContent = r.title || r.name;
+      const meta = document.createElement("div");
+      meta.className = "recording-meta";
+      meta.textContent = [
+        formatTime(r.timestamp || r.modTime),
+        formatDuration(r.duration),
+        formatBytes(r.size),
+        r.name,
+      ]
+        .filter(Boolean)
+        .join(" · ");
+      info.appendChild(name);
+      info.appendChild(meta);
+
+      const actions = document.createElement("div");
+      actions.className = "recording-actions";
+      const exp = document.createElement("button");
+      exp.className = "btn small secondary";
+      exp.textContent = "Export";
+      exp.onclick = () =>
+        rpc({ type: "recording_export", name: r.name })
+          .then((res) => notifySuccess(`Recording exported to:\n${res.path}`))
+          .catch((e) => notifyError(e.detail || e.error || "Export failed"));
+      actions.appendChild(exp);
+
+      item.appendChild(info);
+      item.appendChild(actions);
+      container.appendChild(item);
+    });
+  }
+
+  function openRecordingsModal() {
+    el("recordings-modal").classList.remove("hidden");
+    rpc({ type: "recording_list" })
+      .then((res) => {
+        el("recordings-dir").textContent = res.dir || "";
+        renderRecordings(res.recordings || []);
+      })
+      .catch((e) => notifyError(e.detail || e.error || "Could not list recordings"));
+  }
+
+  function closeRecordingsModal() {
+    el("recordings-modal")?.classList.add("hidden");
+  }
+
+  /* ===================== Password vault ===================== */
+
+  let vaultState = null; // { exists, locked, autoLockMin, entries }
+  let keyringState = null; // { enabled, available }
+
+  // storesOpen reports whether a saved password can be looked up without asking.
+  function storesOpen() {
+    const vaultOpen = !!vaultState?.exists && !vaultState.locked;
+    return vaultOpen || (!!keyringState?.enabled && !!keyringState.available);
+  }
+
+  function renderVaultButton() {
+    const btn = el("btn-vault");
+    if (!btn) return;
+    const unlocked = !!vaultState?.exists && !vaultState.locked;
+    btn.classList.toggle("unlocked", unlocked);
+    btn.textContent = !vaultState?.exists ? "Vault" : unlocked ? "Vault: open" : "Vault: locked";
+  }
+
+  function renderVaultModal() {
+    const st = vaultState || {};
+    const unlocked = st.exists && !st.locked;
+    let status = "No vault yet. Choose a master passphrase to create one.";
+    if (st.exists && st.locked) status = "Locked. Enter the master passphrase to unlock.";
+    if (unlocked) {
+      status = `Unlocked · ${st.entries || 0} saved password${st.entries === 1 ? "" : "s"}`;
+    }
+    el("vault-status").textContent = status;
+    const kr = keyringState || {};
+    el("keyring-enabled").checked = !!kr.enabled;
+    el("keyring-status").textContent = !kr.available
+      ? "No Secret Service found on the session bus."
+      : kr.enabled
+        ? "Passwords are saved to the keyring first; the vault is used when the keyring has none."
+        : "";
+    el("vault-passphrase-row").classList.toggle("hidden", !!unlocked);
+    el("vault-confirm-row").classList.toggle("hidden", !!st.exists);
+    el("vault-auto-lock-row").classList.toggle("hidden", !unlocked);
+    el("vault-auto-lock").value = String(st.autoLockMin ?? 15);
+    el("vault-reset").classList.toggle("hidden", !st.exists);
+    el("vault-lock").classList.toggle("hidden", !unlocked);
+    el("vault-submit").classList.toggle("hidden", !!unlocked);
+    el("vault-submit").textContent = st.exists ? "Unlock" : "Create";
+  }
+
+  function applyVaultState(res) {
+    vaultState = res?.vault || null;
+    keyringState = res?.keyring || null;
+    renderVaultButton();
+    if (!el("vault-modal")?.classList.contains("hidden")) renderVaultModal();
+  }
+
+  function refreshVault() {
+    return rpc({ type: "vault_status" })
+      .then(applyVaultState)
+      .catch(() => {});
+  }
+
+  function openVaultModal() {
+    el("vault-passphrase").value = "";
+    el("vault-confirm").value = "";
+    el("vault-modal").classList.remove("hidden");
+    renderVaultModal();
+    refreshVault().then(() => {
+      if (!vaultState?.exists || vaultState.locked) el("vault-passphrase").focus();
+    });
+  }
+
+  function closeVaultModal() {
+    el("vault-modal")?.classList.add("hidden");
+    el("vault-passphrase").value = "";
+    el("vault-confirm").value = "";
+  }
+
+  async function submitVault() {
+    const passphrase = el("vault-passphrase").value;
+    if (!passphrase) {
+      notifyWarn("Enter the master passphrase.");
+      return;
+    }
+    const create = !vaultState?.exists;
+    if (create && passphrase !== el("vault-confirm").value) {
+      notifyWarn("The passphrases do not match.");
+      return;
+    }
+    try {
+      const res = await rpc({
+        type: create ? "vault_create" : "vault_unlock",
+        passphraseB64: b64enc(passphrase),
+      });
+      el("vault-passphrase").value = "";
+      el("vault-confirm").value = "";
+      applyVaultState(res);
+      notifySuccess(create ? "Vault created and unlocked." : "Vault unlocked.");
+    } catch (e) {
+      if (e.error === "bad_passphrase") {
+        notifyError("Wrong passphrase.");
+      } else {
+        notifyError(e.detail || e.error || "Vault operation failed");
+      }
+    }
+  }
+
+  function lockVault() {
+    rpc({ type: "vault_lock" })
+      .then(applyVaultState)
+      .catch((e) => notifyError(e.detail || e.error || "Could not lock the vault"));
+  }
+
+  function setVaultAutoLock() {
+    const minutes = Math.max(0, Math.min(1440, Number(el("vault-auto-lock").value) || 0));
+    rpc({ type: "vault_auto_lock", minutes })
+      .then(applyVaultState)
+      .catch((e) => notifyError(e.detail || e.error || "Could not set auto-lock"));
+  }
+
+  async function resetVault() {
+    const ok = await confirmDialog(
+      "Delete the vault and every password saved in it? This cannot be undone.",
+      { okText: "Delete vault", cancelText: "Cancel" }
+    );
+    if (!ok) return;
+    rpc({ type: "vault_reset" })
+      .then((res) => {
+        applyVaultState(res);
+        notifyInfo("Vault deleted.");
+      })
+      .catch((e) => notifyError(e.detail || e.error || "Could not delete the vault"));
+  }
+
+  function setKeyringEnabled(enabled) {
+    config.credentials = { ...(config.credentials || {}), secretService: !!enabled };
+    Promise.resolve(saveConfig()).then(refreshVault);
+  }
+
+  function forgetHostPasswords(host) {
+    rpc({ type: "secrets_forget", hostId: host.id })
+      .then((res) => {
+        setRuntimePassword(host.id, "");
+        setRuntimeSftpPassword(host.id, "");
+        applyVaultState(res);
+        notifyInfo(`Saved passwords for ${host.name} removed.`);
+      })
+      .catch((e) => notifyError(e.detail || e.error || "Could not forget passwords"));
+  }
+
+  /* ===================== SSH agent ===================== */
+
+  function renderAgentKeys(res) {
+    const container = el("agent-keys");
+    if (!container) return;
+    container.innerHTML = "";
+    const keys = res?.keys || [];
+    if (!keys.length) {
+      const empty = document.createElement("div");
+      empty.className = "help";
+      empty.textContent = "No keys loaded.";
+      container.appendChild(empty);
+    }
+    keys.forEach((k) => {
+      const item = document.createElement("div");
+      item.className = "agent-key";
+
+      const info = document.createElement("div");
+      const name = document.createElement("div");
+      name.className = "agent-key-name";
+      name.textContent = k.comment || k.type;
+      const meta = document.createElement("div");
+      meta.className = "agent-key-meta mono";
+      meta.textContent = [k.type, k.fingerprint, k.expiresAt ? `until ${formatTime(k.expiresAt)}` : ""]
+        .filter(Boolean)
+        .join(" · ");
+      info.appendChild(name);
+      info.appendChild(meta);
+
+      const actions = document.createElement("div");
+      actions.className = "recording-actions";
+      const remove = document.createElement("button");
+      remove.className = "btn small secondary danger";
+      remove.textContent = "Remove";
+      remove.onclick = () =>
+        rpc({ type: "agent_remove", fingerprint: k.fingerprint })
+          .then(renderAgentKeys)
+          .catch((e) => notifyError(e.detail || e.error || "Could not remove the key"));
+      actions.appendChild(remove);
+
+      item.appendChild(info);
+      item.appendChild(actions);
+      container.appendChild(item);
+    });
+    el("agent-remove-all").classList.toggle("hidden", !keys.length);
+
+    const settings = config?.agent || {};
+    el("agent-add-unlocked").checked = !!settings.addUnlockedKeys;
+    el("agent-default-lifetime").value = String(settings.lifetimeMin || 0);
+    el("agent-socket").checked = !!settings.socket;
+    const status = el("agent-socket-status");
+    status.classList.toggle("error", !!res?.socketError);
+    status.textContent = res?.socketError
+      ? `Socket unavailable: ${res.socketError}`
+      : res?.socket
+        ? `SSH_AUTH_SOCK=${res.socket}`
+        : "";
+  }
+
+  function refreshAgent() {
+    return rpc({ type: "agent_keys" })
+      .then(renderAgentKeys)
+      .catch((e) => notifyError(e.detail || e.error || "Could not list agent keys"));
+  }
+
+  function openAgentModal() {
+    el("agent-modal").classList.remove("hidden");
+    refreshAgent();
+  }
+
+  function closeAgentModal() {
+    el("agent-modal")?.classList.add("hidden");
+  }
+
+  async function addAgentKey(passphrase = "") {
+    const path = el("agent-key-path").value.trim();
+    if (!path) {
+      notifyWarn("Enter the path of a private key file.");
+      return;
+    }
+    const minutes = Math.max(0, Number(el("agent-key-lifetime").value) || 0);
+    try {
+      const res = await rpc({ type: "agent_add", path, minutes, passphraseB64: b64enc(passphrase) });
+      el("agent-key-path").value = "";
+      renderAgentKeys(res);
+      notifySuccess("Key added to the agent.");
+    } catch (e) {
+      if (e.error === "passphrase_required" && !passphrase) {
+        const pass = await promptDialog(`Passphrase for ${path}:`, "", {
+          okText: "Add key",
+          type: "password",
+        });
+        if (pass) addAgentKey(pass);
+        return;
+      }
+      notifyError(e.detail || e.error || "Could not add the key");
+    }
+  }
+
+  async function removeAllAgentKeys() {
+    const ok = await confirmDialog("Remove every key from the built-in agent?", {
+      okText: "Remove all",
+      cancelText: "Cancel",
+    });
+    if (!ok) return;
+    rpc({ type: "agent_remove" })
+      .then(renderAgentKeys)
+      .catch((e) => notifyError(e.detail || e.error || "Could not remove the keys"));
+  }
+
+  function saveAgentSettings() {
+    const agent = {
+      addUnlockedKeys: el("agent-add-unlocked").checked || undefined,
+      lifetimeMin: Math.max(0, Number(el("agent-default-lifetime").value) || 0) || undefined,
+      socket: el("agent-socket").checked || undefined,
+      socketPath: config.agent?.socketPath || undefined,
+    };
+    config.agent = Object.values(agent).some(Boolean) ? agent : undefined;
+    Promise.resolve(saveConfig()).then(refreshAgent);
+  }
+
   /* ===================== SFTP File Manager ===================== */
 
   function formatBytes(n) {
@@ -1632,9 +2369,14 @@
     );
   }
 
-  function showTrustDialogAsync(hostId, hostPort, fingerprint) {
+  function showTrustDialogAsync(hostId, hostPort, fingerprint, mismatch = false) {
     el("trust-host").textContent = hostPort;
     el("trust-fingerprint").textContent = fingerprint;
+    el("trust-title").textContent = mismatch ? "SSH Host Key Changed" : "Trust SSH Host Key?";
+    el("trust-accept").textContent = mismatch ? "Replace key" : "Trust";
+    el("trust-warn").textContent = mismatch
+      ? "This host presented a different key than the one recorded for it. Replace the key only if you know why it changed; the old key will be rejected from now on and the change is logged."
+      : "Only trust this host key if you expected this server. If the key changed unexpectedly, it could indicate an attack.";
 
     const modal = el("trust-modal");
     modal.classList.remove("hidden");
@@ -1653,7 +2395,8 @@
 
       el("trust-accept").onclick = () => {
         rpc({ type: "trust_host", hostId })
-          .then(() => {
+          .then((res) => {
+            if (res?.replaced) replaceSharedPins(hostId, res.fingerprint);
             modal.classList.add("hidden");
             cleanup();
             resolve();
@@ -1667,6 +2410,109 @@
     });
   }
 
+  // A replaced key supersedes the pins shared with the team, or the host
+  // would keep rejecting it.
+  function replaceSharedPins(hostId, fingerprint) {
+    const host = findHostById(hostId);
+    if (!host?.hostKey?.pins?.length || !fingerprint) return;
+    host.hostKey.pins = [fingerprint];
+    saveConfig().catch(() => {});
+  }
+
+  let hostKeysTarget = null;
+  let hostKeysPinned = [];
+
+  function renderHostKeys(res) {
+    const host = findHostById(hostKeysTarget?.id) || hostKeysTarget;
+    const container = el("hostkeys-keys");
+    container.innerHTML = "";
+    const keys = res?.record?.keys || [];
+    if (!keys.length) {
+      const empty = document.createElement("div");
+      empty.className = "help";
+      empty.textContent = "No keys recorded yet. Keys are recorded on the next connect.";
+      container.appendChild(empty);
+    }
+    keys.forEach((k) => {
+      const item = document.createElement("div");
+      item.className = "agent-key" + (k.replacedAt ? " replaced" : "");
+
+      const info = document.createElement("div");
+      const name = document.createElement("div");
+      name.className = "agent-key-name";
+      const badges = [k.pinned ? "pinned" : "", k.replacedAt ? `replaced ${formatTime(k.replacedAt)}` : ""];
+      name.textContent = [k.type, ...badges].filter(Boolean).join(" · ");
+      const meta = document.createElement("div");
+      meta.className = "agent-key-meta mono";
+      meta.textContent = `${k.fingerprint} · first seen ${formatTime(k.firstSeen)} · last seen ${formatTime(k.lastSeen)}`;
+      info.appendChild(name);
+      info.appendChild(meta);
+
+      const actions = document.createElement("div");
+      actions.className = "recording-actions";
+      if (!k.replacedAt) {
+        const pin = document.createElement("button");
+        pin.className = "btn small secondary";
+        pin.textContent = k.pinned ? "Unpin" : "Pin";
+        pin.onclick = () =>
+          rpc({ type: "hostkeys_pin", hostId: host.id, fingerprint: k.fingerprint, enabled: !k.pinned })
+            .then((next) => {
+              renderHostKeys(next);
+              if (host.hostKey?.pins?.length) shareHostPins(next.pinned || []);
+            })
+            .catch((e) => notifyError(e.detail || e.error || "Could not update the pin"));
+        actions.appendChild(pin);
+      }
+
+      item.appendChild(info);
+      item.appendChild(actions);
+      container.appendChild(item);
+    });
+
+    const audit = el("hostkeys-audit");
+    audit.innerHTML = "";
+    const events = (res?.record?.audit || []).slice().reverse();
+    if (!events.length) audit.textContent = "No changes yet.";
+    events.forEach((ev) => {
+      const line = document.createElement("div");
+      const prev = ev.previous?.length ? ` (was ${ev.previous.join(", ")})` : "";
+      const by = ev.by ? ` by ${ev.by}` : "";
+      line.textContent = `${formatTime(ev.at)} ${ev.action} ${ev.fingerprint}${prev}${by}`;
+      audit.appendChild(line);
+    });
+
+    const team = host.scope === "team";
+    el("hostkeys-share-row").classList.toggle("hidden", !team);
+    el("hostkeys-share").checked = !!host.hostKey?.pins?.length;
+    el("hostkeys-share").disabled = !team || (!(res?.pinned || []).length && !host.hostKey?.pins?.length);
+    hostKeysPinned = res?.pinned || [];
+  }
+
+  // shareHostPins publishes the pins in the host config, which syncs to the
+  // team like any other host change. An empty list stops sharing.
+  function shareHostPins(pins) {
+    const host = hostKeysTarget && findHostById(hostKeysTarget.id);
+    if (!host) return;
+    host.hostKey = { ...(host.hostKey || {}), pins: pins.length ? pins.slice() : undefined };
+    saveConfig().catch((e) => notifyError(e.detail || e.error || "Could not save the host"));
+  }
+
+  function openHostKeysModal(host) {
+    hostKeysTarget = host;
+    el("hostkeys-title").textContent = `Host keys · ${host.name || host.host}`;
+    rpc({ type: "hostkeys_get", hostId: host.id })
+      .then((res) => {
+        renderHostKeys(res);
+        el("hostkeys-modal").classList.remove("hidden");
+      })
+      .catch((e) => notifyError(e.detail || e.error || "Could not load host keys"));
+  }
+
+  function closeHostKeysModal() {
+    el("hostkeys-modal")?.classList.add("hidden");
+    hostKeysTarget = null;
+  }
+
   async function sftpRpc(hostId, req) {
     const host = findHostById(hostId);
     if (!host) throw { error: "host_not_found" };
@@ -1692,7 +2538,7 @@
     } catch (err) {
       // Host key trust
       if (err.error === "unknown_host_key" || err.error === "host_key_mismatch") {
-        await showTrustDialogAsync(hostId, err.hostPort, err.fingerprint);
+        await showTrustDialogAsync(hostId, err.hostPort, err.fingerprint, err.error === "host_key_mismatch");
         return await tryOnce(currentConnSecret(), currentSftpSecret());
       }
 
@@ -1750,7 +2596,8 @@
         <button class="btn small secondary" data-action="refresh" title="Refresh">Refresh</button>
         <button class="btn small secondary" data-action="mkdir" title="New folder">New Folder</button>
         <button class="btn small secondary" data-action="upload" title="Upload (or drag & drop)">Upload</button>
-        <button class="btn small secondary" data-action="download" title="Download to ~/Downloads">Download</button>
+        <button class="btn small secondary" data-action="upload-folder" title="Upload a folder">Upload Folder</button>
+        <button class="btn small secondary" data-action="download" title="Download the selected file or folder">Download</button>
         <button class="btn small secondary" data-action="rename" title="Rename">Rename</button>
         <button class="btn small" data-action="delete" style="color: #ff6b7d; border-color: rgba(255, 107, 125, 0.4)" title="Delete">
           Delete
@@ -1798,7 +2645,10 @@
         if (action === "up") navigateUp(hostId).catch(() => {});
         if (action === "mkdir") createFolder(hostId).catch(() => {});
         if (action === "upload") entry.fileInput.click();
-        if (action === "download") downloadSelected(hostId).catch(() => {});
+        if (action === "upload-folder")
+          uploadFolder(hostId).catch((e) => notifyError(e.detail || e.error || "Upload failed"));
+        if (action === "download")
+          downloadSelected(hostId).catch((e) => notifyError(e.detail || e.error || "Download failed"));
         if (action === "delete") deleteSelected(hostId).catch(() => {});
         if (action === "rename") renameSelected(hostId).catch(() => {});
       });
@@ -2037,8 +2887,14 @@
   async function downloadSelected(hostId) {
     const entry = ensureFilePane(hostId);
     if (!entry.selectedPath) return;
-    const r = await sftpRpc(hostId, { type: "sftp_download", path: entry.selectedPath });
-    if (r.localPath) notifySuccess(`Downloaded to:\n${r.localPath}`);
+    await startTransfer(hostId, { direction: "download", source: entry.selectedPath });
+  }
+
+  async function uploadFolder(hostId) {
+    const entry = ensureFilePane(hostId);
+    const res = await rpc({ type: "local_dir_pick", title: "Upload folder" });
+    if (res.canceled || !res.path) return;
+    await startTransfer(hostId, { direction: "upload", source: res.path, destDir: entry.cwd });
   }
 
   function abToB64(buf) {
@@ -2057,12 +2913,16 @@
       type: "sftp_upload_begin",
       dir: entry.cwd,
       name: file.name,
+      size: file.size,
+      modTime: file.lastModified || 0,
     });
     const uploadId = begin.uploadId;
     if (!uploadId) throw new Error("uploadId missing");
 
+    // An interrupted upload of the same file continues where it stopped.
     const chunkSize = 256 * 1024;
-    let offset = 0;
+    let offset = begin.offset || 0;
+    if (offset) notifyInfo(`Resuming ${file.name} after ${formatBytes(offset)}`);
 
     while (offset < file.size) {
       const slice = file.slice(offset, offset + chunkSize);
@@ -2075,7 +2935,11 @@
       offset += slice.size;
     }
 
-    await sftpRpc(hostId, { type: "sftp_upload_end", uploadId });
+    const res = await sftpRpc(hostId, { type: "sftp_upload_end", uploadId }).catch((e) => {
+      if (e.error === "checksum_mismatch") notifyError(`${file.name} was corrupted in transit; upload it again`);
+      throw e;
+    });
+    if (!res?.verify) notifyInfo(`${file.name} uploaded; the server could not verify its checksum`);
   }
 
   async function uploadFiles(hostId, files) {
@@ -2086,9 +2950,150 @@
     await refreshFiles(hostId);
   }
 
+  /* ===================== Transfers ===================== */
+
+  const transferState = new Map(); // id -> transfer, kept up to date by __transferProgress
+
+  const transferFinished = (t) => ["done", "failed", "canceled"].includes(t?.status);
+
+  async function startTransfer(hostId, req) {
+    const res = await sftpRpc(hostId, { type: "transfer_start", ...req });
+    const verb = req.direction === "upload" ? "Upload" : "Download";
+    notifyInfo(`${verb} queued:\n${req.source}`);
+    return res.transferId;
+  }
+
+  function updateTransfersButton() {
+    const active = [...transferState.values()].filter((t) => !transferFinished(t)).length;
+    el("btn-transfers").textContent = active ? `Transfers (${active})` : "Transfers";
+  }
+
+  function renderTransfers() {
+    const modal = el("transfers-modal");
+    if (!modal || modal.classList.contains("hidden")) return;
+    const container = el("transfers-list");
+    container.innerHTML = "";
+    const list = [...transferState.values()].sort((a, b) => b.createdAt - a.createdAt);
+    if (!list.length) {
+      const empty = document.createElement("div");
+      empty.className = "help";
+      empty.textContent = "No transfers yet.";
+      container.appendChild(empty);
+      return;
+    }
+    list.forEach((t) => {
+      const item = document.createElement("div");
+      item.className = "recording-item";
+
+      const info = document.createElement("div");
+      const name = document.createElement("div");
+      name.className = "recording-name";
+      const host = findHostById(t.hostId);
+      name.textContent = `${t.direction === "upload" ? "↑" : "↓"} ${t.source}${host ? ` (${host.name})` : ""}`;
+      const meta = document.createElement("div");
+      meta.className = "recording-meta transfer-meta";
+      meta.classList.toggle("error", t.status === "failed");
+      meta.textContent = [
+        t.status,
+        `${t.filesDone}/${t.files} files`,
+        `${formatBytes(t.bytesDone)} of ${formatBytes(t.bytes)}`,
+        t.filesSkipped ? `${t.filesSkipped} skipped` : "",
+        t.filesResumed ? `${t.filesResumed} resumed` : "",
+        t.filesUnverified ? `${t.filesUnverified} unverified` : "",
+        t.current ? t.current.path : "",
+        t.error || "",
+      ]
+        .filter(Boolean)
+        .join(" · ");
+      meta.title = (t.errors || []).map((e) => `${e.path}: ${e.error}`).join("\n");
+      const bar = document.createElement("div");
+      bar.className = "transfer-progress";
+      const fill = document.createElement("div");
+      fill.style.width = `${t.bytes ? Math.round((100 * t.bytesDone) / t.bytes) : transferFinished(t) ? 100 : 0}%`;
+      bar.appendChild(fill);
+      info.appendChild(name);
+      info.appendChild(meta);
+      if (!transferFinished(t)) info.appendChild(bar);
+
+      const actions = document.createElement("div");
+      actions.className = "recording-actions";
+      const action = (label, type) => {
+        const btn = document.createElement("button");
+        btn.className = "btn small secondary";
+        btn.textContent = label;
+        btn.onclick = () =>
+          rpc({ type, transferId: t.id }).catch((e) =>
+            notifyError(e.detail || e.error || "Could not update the transfer")
+          );
+        actions.appendChild(btn);
+      };
+      if (t.status === "paused") action("Resume", "transfer_resume");
+      else if (!transferFinished(t)) action("Pause", "transfer_pause");
+      if (!transferFinished(t)) action("Cancel", "transfer_cancel");
+
+      item.appendChild(info);
+      item.appendChild(actions);
+      container.appendChild(item);
+    });
+  }
+
+  // Transfer progress pushed by the backend.
+  window.__transferProgress = (t) => {
+    const prev = transferState.get(t.id);
+    transferState.set(t.id, t);
+    updateTransfersButton();
+    renderTransfers();
+    if (!transferFinished(t) || transferFinished(prev)) return;
+    const verb = t.direction === "upload" ? "Upload" : "Download";
+    if (t.status === "done") notifySuccess(`${verb} finished:\n${t.dest}`);
+    else if (t.status === "failed") notifyError(`${verb} failed: ${t.error || "unknown error"}`);
+    if (t.direction === "upload" && t.hostId === activeHostId && hostFiles.has(t.hostId)) {
+      refreshFiles(t.hostId).catch(() => {});
+    }
+  };
+
+  function openTransfersModal() {
+    const settings = config?.transfers || {};
+    el("transfers-download-dir").value = settings.downloadDir || "";
+    el("transfers-conflict").value = settings.conflict || "rename";
+    el("transfers-concurrency").value = settings.concurrency ? String(settings.concurrency) : "";
+    el("transfers-modal").classList.remove("hidden");
+    rpc({ type: "transfer_list" })
+      .then((res) => {
+        (res.transfers || []).forEach((t) => transferState.set(t.id, t));
+        updateTransfersButton();
+        renderTransfers();
+      })
+      .catch((e) => notifyError(e.detail || e.error || "Could not list transfers"));
+  }
+
+  function closeTransfersModal() {
+    el("transfers-modal")?.classList.add("hidden");
+  }
+
+  function saveTransferSettings() {
+    const conflict = el("transfers-conflict").value;
+    const transfers = {
+      downloadDir: el("transfers-download-dir").value.trim() || undefined,
+      concurrency: Math.min(8, Math.max(0, Number(el("transfers-concurrency").value) || 0)) || undefined,
+      conflict: conflict !== "rename" ? conflict : undefined,
+    };
+    config.transfers = Object.values(transfers).some(Boolean) ? transfers : undefined;
+    saveConfig();
+  }
+
   /* ===================== SFTP File Editor ===================== */
 
-  let fileEditState = null; // { hostId, path, original, saving }
+  let fileEditState = null; // { hostId, path, original, version, saving }
+
+  function saveEditorSettings() {
+    const editor = {
+      atomicSave: el("file-edit-atomic").checked || undefined,
+      backup: el("file-edit-backup").checked || undefined,
+    };
+    config.editor = Object.values(editor).some(Boolean) ? editor : undefined;
+    saveConfig();
+  }
 
   function setupFileEditorModal() {
     const modal = el("file-edit-modal");
@@ -2121,23 +3126,41 @@
     }
 
     btnCancel.onclick = () => close();
+    el("file-edit-atomic").addEventListener("change", saveEditorSettings);
+    el("file-edit-backup").addEventListener("change", saveEditorSettings);
 
     btnSave.onclick = async () => {
       if (!fileEditState || fileEditState.saving) return;
-      const { hostId, path } = fileEditState;
+      const { hostId, path, version } = fileEditState;
       const text = textarea.value || "";
 
       fileEditState.saving = true;
       btnSave.disabled = true;
       btnSave.textContent = "Saving…";
 
-      sftpRpc(hostId, { type: "sftp_write", path, dataB64: b64enc(text) })
-        .then(async () => {
+      const write = (force) =>
+        sftpRpc(hostId, { type: "sftp_write", path, dataB64: b64enc(text), version, force });
+
+      write(false)
+        .catch(async (e) => {
+          // Someone else changed the file since it was opened.
+          if (e.error !== "remote_changed") throw e;
+          const overwrite = await confirmDialog(
+            `${path} changed on the server since you opened it.\nOverwrite their changes?`,
+            { okText: "Overwrite", danger: true }
+          );
+          if (!overwrite) throw { error: "remote_changed", canceled: true };
+          return write(true);
+        })
+        .then(async (res) => {
           fileEditState.original = text;
+          fileEditState.version = res?.version || "";
+          if (res?.backup) notifyInfo(`Saved; previous version kept as ${res.backup}`);
           await close();
           refreshFiles(hostId).catch(() => {});
         })
         .catch((e) => {
+          if (e.canceled) return;
           notifyError(e.detail || e.error || "Save failed");
         })
         .finally(() => {
@@ -2178,18 +3201,72 @@
 
     el("file-edit-path").textContent = remotePath;
     el("file-edit-text").value = text;
+    el("file-edit-atomic").checked = !!config.editor?.atomicSave;
+    el("file-edit-backup").checked = !!config.editor?.backup;
     el("file-edit-modal").classList.remove("hidden");
     el("file-edit-text").focus();
 
-    fileEditState = { hostId, path: remotePath, original: text, saving: false };
+    fileEditState = { hostId, path: remotePath, original: text, version: r.version || "", saving: false };
   }
 
   /* ===================== Status ===================== */
 
+  function formatCertExpiry(validBefore) {
+    if (!validBefore) return "never expires";
+    const secs = validBefore - Math.floor(Date.now() / 1000);
+    if (secs <= 0) return "expired";
+    if (secs < 3600) return `expires in ${Math.ceil(secs / 60)}m`;
+    if (secs < 86400) return `expires in ${Math.round(secs / 3600)}h`;
+    return `expires in ${Math.round(secs / 86400)}d`;
+  }
+
+  // Short status suffix and a detailed tooltip for SSH certificates in use.
+  function certStatus(certs) {
+    if (!certs) return { text: "", title: "" };
+    const parts = [];
+    const lines = [];
+    if (certs.userCert) {
+      const c = certs.userCert;
+      parts.push(`cert ${formatCertExpiry(c.validBefore)}`);
+      lines.push(
+        `User certificate ${c.keyId || ""}`.trim(),
+        `  principals: ${(c.principals || []).join(", ") || "any"}`,
+        `  valid until: ${c.validBefore ? formatTime(c.validBefore) : "forever"}`,
+        `  CA: ${c.authority}`
+      );
+    }
+    if (certs.hostCert) {
+      const c = certs.hostCert;
+      parts.push("host cert");
+      lines.push(
+        `Host certificate ${c.keyId || ""}`.trim(),
+        `  principals: ${(c.principals || []).join(", ") || "any"}`,
+        `  valid until: ${c.validBefore ? formatTime(c.validBefore) : "forever"}`,
+        `  CA: ${c.authority}`
+      );
+    }
+    return { text: parts.length ? ` · ${parts.join(" · ")}` : "", title: lines.join("\n") };
+  }
+
+  function connectionHealth(state) {
+    if (state?.keepalivesMissed) {
+      return ` · no keepalive reply (${state.keepalivesMissed} missed)`;
+    }
+    if (state?.rttMs) {
+      return ` · ${state.rttMs < 10 ? state.rttMs.toFixed(1) : Math.round(state.rttMs)} ms`;
+    }
+    return "";
+  }
+
   function updateStatus(state) {
+    activeRecording = !!state?.recording;
+    renderRecordingButton();
+
     const status = el("status");
     const text = status.querySelector(".status-text");
     const attempts = status.querySelector(".status-attempts");
+    const certs = certStatus(state?.state === "connected" ? state.certs : null);
+    status.title = certs.title || "Connection status";
 
     status.classList.remove(
       "status-connected",
@@ -2210,7 +3287,7 @@
       activeState = "connected";
       status.classList.add("status-connected");
       text.textContent = "connected";
-      attempts.textContent = "";
+      attempts.textContent = connectionHealth(state) + certs.text;
       updateTerminalActions();
       return;
     }
@@ -2303,7 +3380,7 @@
           trustPrompted.add(hostId);
           const host = findHostById(hostId);
           if (host) {
-            showTrustDialogAsync(hostId, s.hostPort, s.fingerprint)
+            showTrustDialogAsync(hostId, s.hostPort, s.fingerprint, s.errCode === "host_key_mismatch")
               .then(() => {
                 trustPrompted.delete(hostId);
                 connectHost(host);
@@ -2480,6 +3557,8 @@
       samakiaRunBtn.classList.remove("hidden");
     }
 
+    el("host-menu-forget").classList.toggle("hidden", !storesOpen());
+
     showHostMenuAt(e.clientX, e.clientY);
 
     // Update connect button state asynchronously (avoid menu-open lag).
@@ -2508,7 +3587,7 @@
 
     el("file-menu-open").disabled = !hasSel || !isDir;
     el("file-menu-edit").disabled = !hasSel || isDir;
-    el("file-menu-download").disabled = !hasSel || isDir;
+    el("file-menu-download").disabled = !hasSel;
     el("file-menu-rename").disabled = !hasSel;
     el("file-menu-delete").disabled = !hasSel;
     el("file-menu-copy-path").disabled = !hasSel;
@@ -2726,70 +3805,301 @@
     if (isPersonalView()) {
       return script.scope !== "team" && !script.teamId;
     }
-    return script.scope === "team" && script.teamId === activeTeamId;
+    return script.scope === "team" && script.teamId === activeTeamId;
+  }
+
+  function renderScripts() {
+    if (!config) return;
+    const container = el("scripts");
+    if (!container) return;
+    container.innerHTML = "";
+    const frag = document.createDocumentFragment();
+
+    const scripts = (config?.scripts || []).filter(isScriptVisible);
+    scripts.forEach((script) => {
+      const item = document.createElement("div");
+      item.className = "script-item";
+
+      const name = document.createElement("div");
+      name.className = "script-name";
+      name.textContent = script.name || "Untitled script";
+
+      const meta = document.createElement("div");
+      meta.className = "script-meta";
+      meta.textContent = script.description || script.command || "";
+
+      const actions = document.createElement("div");
+      actions.className = "script-actions";
+
+      const runBtn = document.createElement("button");
+      runBtn.className = "btn small secondary";
+      runBtn.textContent = "Run";
+      runBtn.onclick = (e) => {
+        e.stopPropagation();
+        runScript(script);
+      };
+
+      const runOnBtn = document.createElement("button");
+      runOnBtn.className = "btn small secondary";
+      runOnBtn.textContent = "Run on…";
+      runOnBtn.title = "Run on selected hosts and collect results";
+      runOnBtn.onclick = (e) => {
+        e.stopPropagation();
+        openScriptRunDialog(script);
+      };
+
+      const editBtn = document.createElement("button");
+      editBtn.className = "btn small secondary";
+      editBtn.textContent = "Edit";
+      editBtn.onclick = (e) => {
+        e.stopPropagation();
+        openScriptEditor("edit", script);
+      };
+
+      actions.appendChild(runBtn);
+      actions.appendChild(runOnBtn);
+      actions.appendChild(editBtn);
+
+      item.appendChild(name);
+      if (meta.textContent) item.appendChild(meta);
+      item.appendChild(actions);
+
+      item.ondblclick = () => openScriptEditor("edit", script);
+
+      frag.appendChild(item);
+    });
+
+    container.appendChild(frag);
+  }
+
+  function runScript(script) {
+    if (!script?.command) return;
+    if (!activeHostId || !activeTermTabId) {
+      notifyWarn("Select a host before running a script.");
+      return;
+    }
+    queueInput(script.command + "\r");
+  }
+
+  /* ===================== Script runs ===================== */
+
+  let scriptRunTarget = null;
+  let scriptRunsSelected = "";
+  let scriptRunsTimer = null;
+  const scriptRunsExpanded = new Set();
+
+  function openScriptRunDialog(script) {
+    if (!script?.command) return;
+    const net = (config?.networks || []).find((n) => n.id === activeNetworkId);
+    const hosts = visibleHosts(net).filter((h) => !h.driver || h.driver === "ssh");
+    if (!hosts.length) {
+      notifyWarn("The active network has no SSH hosts.");
+      return;
+    }
+    scriptRunTarget = script;
+    el("script-run-title").textContent = `Run “${script.name || "script"}” on hosts`;
+    el("script-run-command").textContent = script.command;
+
+    const list = el("script-run-hosts");
+    list.innerHTML = "";
+    hosts.forEach((h) => {
+      const label = document.createElement("label");
+      label.className = "script-run-host";
+      const cb = document.createElement("input");
+      cb.type = "checkbox";
+      cb.checked = true;
+      cb.value = String(h.id);
+      const text = document.createElement("span");
+      text.textContent = `${h.name} (${h.user}@${h.host})`;
+      label.appendChild(cb);
+      label.appendChild(text);
+      list.appendChild(label);
+    });
+    el("script-run-modal").classList.remove("hidden");
+  }
+
+  function closeScriptRunDialog() {
+    el("script-run-modal")?.classList.add("hidden");
+    scriptRunTarget = null;
+  }
+
+  function setScriptRunHostsChecked(checked) {
+    el("script-run-hosts")
+      .querySelectorAll("input[type=checkbox]")
+      .forEach((cb) => (cb.checked = checked));
+  }
+
+  async function startScriptRun() {
+    const script = scriptRunTarget;
+    if (!script) return;
+    const hostIds = Array.from(
+      el("script-run-hosts").querySelectorAll("input[type=checkbox]:checked")
+    ).map((cb) => Number(cb.value));
+    if (!hostIds.length) {
+      notifyWarn("Select at least one host.");
+      return;
+    }
+    const payload = {
+      type: "script_run",
+      hostIds,
+      concurrency: Number(el("script-run-concurrency").value) || 0,
+    };
+    if (script.id) {
+      payload.scriptId = script.id;
+    } else {
+      payload.name = script.name;
+      payload.command = script.command;
+    }
+    try {
+      const res = await rpc(payload);
+      closeScriptRunDialog();
+      watchScriptRun(res.runId);
+      openScriptRunsModal(res.runId);
+    } catch (e) {
+      notifyError(e.detail || e.error || "Could not start script run");
+    }
+  }
+
+  function scriptRunSummary(run) {
+    const running = (run.results || []).filter(
+      (r) => r.status === "queued" || r.status === "running"
+    ).length;
+    if (!run.done) {
+      return `${run.scriptName || "script"}: ${running} of ${(run.results || []).length} hosts pending`;
+    }
+    return `${run.scriptName || "script"}: ${run.passed} passed, ${run.failed} failed`;
+  }
+
+  // Notifies once the run finishes.
+  async function watchScriptRun(runId) {
+    for (;;) {
+      await sleep(1000);
+      let run;
+      try {
+        run = (await rpc({ type: "script_run_get", runId })).run;
+      } catch {
+        return;
+      }
+      if (!run?.done) continue;
+      if (run.failed) {
+        notifyError(scriptRunSummary(run));
+      } else {
+        notifySuccess(scriptRunSummary(run));
+      }
+      return;
+    }
   }
 
-  function renderScripts() {
-    if (!config) return;
-    const container = el("scripts");
-    if (!container) return;
+  function resultOutput(text, cls) {
+    const pre = document.createElement("pre");
+    pre.className = `script-result-output ${cls}`;
+    pre.textContent = text;
+    return pre;
+  }
+
+  function renderScriptRun(run) {
+    const container = el("script-runs-results");
     container.innerHTML = "";
-    const frag = document.createDocumentFragment();
+    el("script-runs-summary").textContent = run ? `${scriptRunSummary(run)}\n$ ${run.command}` : "";
+    el("script-runs-stop").classList.toggle("hidden", !run || run.done);
+    if (!run) return;
 
-    const scripts = (config?.scripts || []).filter(isScriptVisible);
-    scripts.forEach((script) => {
+    (run.results || []).forEach((r) => {
       const item = document.createElement("div");
-      item.className = "script-item";
+      item.className = "script-result";
 
+      const head = document.createElement("div");
+      head.className = "script-result-head";
+      const status = document.createElement("div");
+      status.className = `script-result-status ${r.status}`;
+      status.textContent = r.status;
       const name = document.createElement("div");
-      name.className = "script-name";
-      name.textContent = script.name || "Untitled script";
-
+      name.textContent = r.hostName || r.address || `host ${r.hostId}`;
       const meta = document.createElement("div");
-      meta.className = "script-meta";
-      meta.textContent = script.description || script.command || "";
-
-      const actions = document.createElement("div");
-      actions.className = "script-actions";
-
-      const runBtn = document.createElement("button");
-      runBtn.className = "btn small secondary";
-      runBtn.textContent = "Run";
-      runBtn.onclick = (e) => {
-        e.stopPropagation();
-        runScript(script);
-      };
-
-      const editBtn = document.createElement("button");
-      editBtn.className = "btn small secondary";
-      editBtn.textContent = "Edit";
-      editBtn.onclick = (e) => {
-        e.stopPropagation();
-        openScriptEditor("edit", script);
+      meta.className = "script-result-meta";
+      const parts = [];
+      if (r.status === "passed" || r.status === "failed") parts.push(`exit ${r.exitCode}`);
+      if (r.durationMs) parts.push(`${(r.durationMs / 1000).toFixed(1)}s`);
+      meta.textContent = parts.join(" · ");
+      head.appendChild(status);
+      head.appendChild(name);
+      head.appendChild(meta);
+      item.appendChild(head);
+
+      const key = `${run.id}:${r.hostId}`;
+      if (scriptRunsExpanded.has(key)) {
+        const err = r.error === "password_required"
+          ? "password required (connect to the host once to cache it)"
+          : r.error;
+        if (err) item.appendChild(resultOutput(err, "stderr"));
+        if (r.stdout) item.appendChild(resultOutput(r.stdout, "stdout"));
+        if (r.stderr) item.appendChild(resultOutput(r.stderr, "stderr"));
+        if (r.truncated) item.appendChild(resultOutput("(output truncated)", ""));
+        if (!err && !r.stdout && !r.stderr) item.appendChild(resultOutput("(no output)", ""));
+      }
+      item.onclick = (e) => {
+        if (e.target.closest("pre")) return;
+        if (scriptRunsExpanded.has(key)) {
+          scriptRunsExpanded.delete(key);
+        } else {
+          scriptRunsExpanded.add(key);
+        }
+        renderScriptRun(run);
       };
+      container.appendChild(item);
+    });
+  }
 
-      actions.appendChild(runBtn);
-      actions.appendChild(editBtn);
-
-      item.appendChild(name);
-      if (meta.textContent) item.appendChild(meta);
-      item.appendChild(actions);
+  async function refreshScriptRuns() {
+    clearTimeout(scriptRunsTimer);
+    scriptRunsTimer = null;
+    const modal = el("script-runs-modal");
+    if (!modal || modal.classList.contains("hidden")) return;
 
-      item.ondblclick = () => openScriptEditor("edit", script);
+    let runs = [];
+    try {
+      runs = (await rpc({ type: "script_runs" })).runs || [];
+    } catch (e) {
+      notifyError(e.detail || e.error || "Could not load script runs");
+      return;
+    }
 
-      frag.appendChild(item);
+    const sel = el("script-runs-select");
+    sel.innerHTML = "";
+    if (!runs.length) {
+      const opt = document.createElement("option");
+      opt.value = "";
+      opt.textContent = "No script runs yet";
+      sel.appendChild(opt);
+      renderScriptRun(null);
+      return;
+    }
+    runs.forEach((run) => {
+      const opt = document.createElement("option");
+      opt.value = run.id;
+      opt.textContent = `${formatTime(Math.floor(run.startedAt / 1000))} · ${scriptRunSummary(run)}`;
+      sel.appendChild(opt);
     });
+    if (!runs.some((r) => r.id === scriptRunsSelected)) scriptRunsSelected = runs[0].id;
+    sel.value = scriptRunsSelected;
 
-    container.appendChild(frag);
+    const run = runs.find((r) => r.id === scriptRunsSelected);
+    renderScriptRun(run);
+    if (run && !run.done) {
+      scriptRunsTimer = setTimeout(refreshScriptRuns, 1000);
+    }
   }
 
-  function runScript(script) {
-    if (!script?.command) return;
-    if (!activeHostId || !activeTermTabId) {
-      notifyWarn("Select a host before running a script.");
-      return;
-    }
-    queueInput(script.command + "\r");
+  function openScriptRunsModal(runId = "") {
+    if (runId) scriptRunsSelected = runId;
+    el("script-runs-modal").classList.remove("hidden");
+    refreshScriptRuns();
+  }
+
+  function closeScriptRunsModal() {
+    el("script-runs-modal")?.classList.add("hidden");
+    clearTimeout(scriptRunsTimer);
+    scriptRunsTimer = null;
   }
 
   /* ===================== Connection ===================== */
@@ -2840,6 +4150,7 @@
     if (!activeHostId || !activeTermTabId) return;
     try {
       await rpc({ type: "disconnect", hostId: activeHostId, tabId: activeTermTabId });
+      refreshBroadcast();
       activeState = "disconnected";
       updateStatus({ state: "disconnected" });
       const { entry } = getTerminalEntry(activeHostId, activeTermTabId);
@@ -2889,6 +4200,39 @@
     select.value = selectedId || "";
   }
 
+  function fillJumpHostSelect(select, target) {
+    if (!select) return;
+    select.innerHTML = "";
+    const none = document.createElement("option");
+    none.value = "";
+    none.textContent = "None (direct)";
+    select.appendChild(none);
+
+    const selected = (target?.jumpHosts || [])[0] || "";
+    let found = false;
+    (config?.networks || [])
+      .filter((n) => !n.deleted)
+      .forEach((n) => {
+        (n.hosts || [])
+          .filter((h) => !h.deleted && h.uid && (h.driver || "ssh") === "ssh")
+          .filter((h) => !target || h.uid !== target.uid)
+          .forEach((h) => {
+            const opt = document.createElement("option");
+            opt.value = h.uid;
+            opt.textContent = `${n.name} / ${h.name}`;
+            select.appendChild(opt);
+            if (h.uid === selected) found = true;
+          });
+      });
+    if (selected && !found) {
+      const opt = document.createElement("option");
+      opt.value = selected;
+      opt.textContent = `Unknown host (${selected.slice(0, 8)})`;
+      select.appendChild(opt);
+    }
+    select.value = selected;
+  }
+
   function renderNetworkCopyTeams(target) {
     const row = el("net-copy-row");
     const list = el("net-copy-teams");
@@ -2999,6 +4343,7 @@
       fillTeamSelect(el("net-team"), teamId, true);
       applyNetworkScopeVisibility();
       renderNetworkCopyTeams(target);
+      el("net-ssh-export-row").classList.toggle("hidden", mode !== "edit");
       validateEditor();
       el("editor-modal").classList.remove("hidden");
       return;
@@ -3029,6 +4374,20 @@
 
     // Auth method
     el("host-auth").value = auth.method || "password";
+    el("host-key-path").value = auth.keyPath || "";
+    el("host-cert-path").value = auth.certPath || "";
+    el("host-forward-agent").value = target?.forwardAgent || "";
+    fillJumpHostSelect(el("host-jump"), target);
+
+    // Keepalive
+    const keepalive = target?.keepalive;
+    el("host-keepalive-interval").value = keepalive?.disabled ? "0" : keepalive?.intervalSec || "";
+    el("host-keepalive-missed").value = keepalive?.maxMissed || "";
+
+    // Recording
+    el("host-record").checked = !!target?.recording?.enabled;
+    el("host-record-input").checked = !!target?.recording?.input;
+    el("host-record-resize").checked = !!target?.recording?.resize;
 
     // Password field (ssh + telecom), memory-only
     el("host-password").value = target?.id ? getRuntimePassword(target.id) : "";
@@ -3059,9 +4418,16 @@
   }
 
   el("host-auth").addEventListener("change", () => {
+    applyHostAuthVisibility();
     validateEditor();
   });
 
+  function applyHostAuthVisibility() {
+    const isSSH = (el("host-driver")?.value || "ssh") === "ssh";
+    el("host-key-row")?.classList.toggle("hidden", !isSSH || el("host-auth").value !== "key");
+    el("host-agent-fwd-row")?.classList.toggle("hidden", !isSSH || el("host-auth").value !== "agent");
+  }
+
   function applyNetworkScopeVisibility() {
     const scope = el("net-scope")?.value || "private";
     el("net-team-row")?.classList.toggle("hidden", scope !== "team");
@@ -3083,6 +4449,7 @@
     document.querySelectorAll("#editor-form [data-driver]").forEach((n) => {
       n.classList.toggle("hidden", n.dataset.driver !== driver);
     });
+    applyHostAuthVisibility();
     applySFTPVisibility();
     validateEditor();
   }
@@ -3246,6 +4613,14 @@
       const hostScope = el("host-scope")?.value || "private";
       const hostTeamId = hostScope === "team" ? el("host-team").value : "";
       const hostRole = el("host-role")?.value || "generic";
+      const jumpHost = el("host-jump")?.value || "";
+      // Keep hand-edited multi-hop chains when the first hop is unchanged.
+      const prevJumps = editorMode === "edit" ? editorTarget?.jumpHosts || [] : [];
+      const jumpHosts = !jumpHost
+        ? undefined
+        : prevJumps[0] === jumpHost
+        ? prevJumps
+        : [jumpHost];
 
       const hostId = editorMode === "create" ? nextHostId() : editorTarget.id;
       if (
@@ -3263,6 +4638,18 @@
         setRuntimeSftpPassword(hostId, "");
       }
 
+      const keepaliveInterval = el("host-keepalive-interval").value.trim();
+      const keepaliveMissed = Number(el("host-keepalive-missed").value) || 0;
+      let keepalive;
+      if (keepaliveInterval === "0") {
+        keepalive = { disabled: true };
+      } else if (keepaliveInterval || keepaliveMissed) {
+        keepalive = {
+          intervalSec: Number(keepaliveInterval) || undefined,
+          maxMissed: keepaliveMissed || undefined,
+        };
+      }
+
       const data = {
         name: el("host-name").value.trim(),
         host: el("host-host").value.trim(),
@@ -3270,10 +4657,25 @@
         port: Number(el("host-port").value),
         role: hostRole === "generic" ? "" : hostRole,
         driver,
+        jumpHosts: driver === "ssh" ? jumpHosts : undefined,
+        keepalive: driver === "ssh" ? keepalive : undefined,
+        forwardAgent:
+          driver === "ssh" && authMethod === "agent"
+            ? el("host-forward-agent").value || undefined
+            : undefined,
+        recording: el("host-record").checked
+          ? {
+              enabled: true,
+              input: el("host-record-input").checked,
+              resize: el("host-record-resize").checked,
+            }
+          : undefined,
         scope: hostScope,
         teamId: hostTeamId,
         auth: {
           method: authMethod,
+          keyPath: authMethod === "key" ? el("host-key-path").value.trim() : "",
+          certPath: authMethod === "key" ? el("host-cert-path").value.trim() : "",
           password: "",
         },
         sftpEnabled: sftpEnabled,
@@ -3483,6 +4885,8 @@
     }
     refreshTeamRepoPaths();
     refreshTeamPresence();
+    refreshSyncDevices();
+    renderSyncSettings();
     renderTeamsModal();
     renderProfileSection();
     if (!teamsPresenceTimer) {
@@ -3506,12 +4910,169 @@
         teamPresence = res || { peers: [], user: null };
         if (teamsModalOpen) {
           renderTeamsModal();
+          renderSyncPeers();
           refreshTeamRepoPaths();
         }
       })
       .catch(() => {});
   }
 
+  function renderSyncSettings() {
+    const settings = config?.sync || {};
+    el("sync-port").value = settings.port ? String(settings.port) : "";
+    el("sync-peers").value = (settings.peers || []).join("\n");
+    el("sync-mdns").checked = !!settings.mdns;
+  }
+
+  function saveSyncSettings() {
+    const port = Number(el("sync-port").value) || 0;
+    if (port < 0 || port > 65535) {
+      notifyWarn("Sync port must be between 1 and 65535");
+      return;
+    }
+    const peers = el("sync-peers")
+      .value.split("\n")
+      .map((line) => line.trim())
+      .filter(Boolean);
+    const bad = peers.find((p) => !/^(\[[^\]]+\]|[^:\s]+):\d{1,5}$/.test(p));
+    if (bad) {
+      notifyWarn(`Static peer "${bad}" is not host:port`);
+      return;
+    }
+    const sync = {
+      port: port || undefined,
+      peers: peers.length ? peers : undefined,
+      mdns: el("sync-mdns").checked || undefined,
+    };
+    config.sync = Object.values(sync).some(Boolean) ? sync : undefined;
+    saveConfig();
+  }
+
+  function renderSyncPeers() {
+    const container = el("teams-peers-list");
+    container.innerHTML = "";
+    const now = Date.now() / 1000;
+    const peers = (teamPresence.peers || []).filter((p) => now - (p.lastSeen || 0) < 20);
+    if (!peers.length) {
+      const empty = document.createElement("div");
+      empty.className = "help";
+      empty.textContent = "No peers online.";
+      container.appendChild(empty);
+      return;
+    }
+    peers.forEach((p) => {
+      const item = document.createElement("div");
+      item.className = "recording-item";
+      const info = document.createElement("div");
+      const name = document.createElement("div");
+      name.className = "recording-name";
+      name.textContent = [p.name || p.email || p.deviceId, p.host ? `(${p.host})` : ""].filter(Boolean).join(" ");
+      const meta = document.createElement("div");
+      meta.className = "recording-meta mono";
+      meta.textContent = [p.addr && p.tcpPort ? `${p.addr}:${p.tcpPort}` : p.addr, p.source].filter(Boolean).join(" · ");
+      info.appendChild(name);
+      info.appendChild(meta);
+      item.appendChild(info);
+      container.appendChild(item);
+    });
+  }
+
+  function refreshSyncDevices() {
+    rpc({ type: "p2p_devices" })
+      .then(renderSyncDevices)
+      .catch(() => {});
+    rpc({ type: "p2p_rejections" })
+      .then(renderSyncRejections)
+      .catch(() => {});
+  }
+
+  function renderSyncRejections(res) {
+    const container = el("teams-rejections-list");
+    container.innerHTML = "";
+    const list = res?.rejections || [];
+    if (!list.length) {
+      const empty = document.createElement("div");
+      empty.className = "help";
+      empty.textContent = "No rejected changes.";
+      container.appendChild(empty);
+      return;
+    }
+    list.forEach((r) => {
+      const item = document.createElement("div");
+      item.className = "recording-item";
+      const info = document.createElement("div");
+      const name = document.createElement("div");
+      name.className = "recording-name";
+      const team = getTeamById(r.teamId || "");
+      name.textContent = `${r.kind} "${r.name || r.id}"${team ? ` in ${team.name}` : ""}: ${r.reason}`;
+      const meta = document.createElement("div");
+      meta.className = "recording-meta mono";
+      meta.textContent = [`from ${r.peer || "unknown"}`, r.signer ? `signed by ${r.signer}` : "", formatTime(r.at)]
+        .filter(Boolean)
+        .join(" · ");
+      info.appendChild(name);
+      info.appendChild(meta);
+      item.appendChild(info);
+      container.appendChild(item);
+    });
+  }
+
+  window.__syncRejected = (r) => {
+    notifyWarn(`Sync rejected ${r.kind} "${r.name || r.id}": ${r.reason}`);
+    if (teamsModalOpen) refreshSyncDevices();
+  };
+
+  function renderSyncDevices(res) {
+    const container = el("teams-devices-list");
+    container.innerHTML = "";
+    const devices = res?.devices || [];
+    if (!devices.length) {
+      const empty = document.createElement("div");
+      empty.className = "help";
+      empty.textContent = "No devices pinned yet.";
+      container.appendChild(empty);
+      return;
+    }
+    devices.forEach((d) => {
+      const item = document.createElement("div");
+      item.className = "recording-item";
+
+      const info = document.createElement("div");
+      const name = document.createElement("div");
+      name.className = "recording-name";
+      name.textContent = [d.name || d.email || d.deviceId, d.host ? `(${d.host})` : "", d.blocked ? "— blocked" : ""]
+        .filter(Boolean)
+        .join(" ");
+      const meta = document.createElement("div");
+      meta.className = "recording-meta mono";
+      meta.textContent = `${d.fingerprint} · last seen ${new Date(d.lastSeen * 1000).toLocaleString()}`;
+      info.appendChild(name);
+      info.appendChild(meta);
+
+      const actions = document.createElement("div");
+      actions.className = "recording-actions";
+      const action = (label, req, confirmText) => {
+        const btn = document.createElement("button");
+        btn.className = "btn small secondary";
+        btn.textContent = label;
+        btn.onclick = async () => {
+          if (confirmText && !(await confirmDialog(confirmText, { okText: label, danger: true }))) return;
+          rpc({ ...req, fingerprint: d.fingerprint })
+            .then(renderSyncDevices)
+            .catch((e) => notifyError(e.detail || e.error || "Could not update the device"));
+        };
+        actions.appendChild(btn);
+      };
+      if (d.blocked) action("Unblock", { type: "p2p_device_block", blocked: false });
+      else action("Block", { type: "p2p_device_block", blocked: true }, `Stop syncing with ${name.textContent}?`);
+      action("Forget", { type: "p2p_device_forget" }, `Forget ${name.textContent}? It can enroll again with the shared secret.`);
+
+      item.appendChild(info);
+      item.appendChild(actions);
+      container.appendChild(item);
+    });
+  }
+
   function refreshTeamRepoPaths() {
     rpc({ type: "team_repo_paths" })
       .then((res) => {
@@ -3573,12 +5134,22 @@
     const team = getTeamById(activeTeamDetailId || "");
     const isAdmin = !!team && isTeamAdmin(team);
     const isMember = !!team && isUserInTeam(team);
+    const deviceListed = !!team && isDeviceInTeam(team);
     const currentEmail = userEmail();
     el("team-name").value = team?.name || "";
     el("team-name-display").textContent = team?.name || "";
     el("team-id").textContent = team?.id || "";
     const repoPath = (team?.id && teamRepoPaths[team.id]) || "";
     el("team-repo-path").textContent = repoPath || "Not available";
+    const keyGen = (team?.id && teamPresence.teamKeys?.[team.id]) || 0;
+    el("team-key-status").textContent = keyGen
+      ? `Generation ${keyGen}`
+      : deviceListed
+        ? "Waiting for a team admin's device"
+        : "Not available on this device";
+
+    el("team-host-cas").value = (team?.hostCAs || []).join("\n");
+    el("team-host-cas").readOnly = !isAdmin;
 
     el("team-name").disabled = !isAdmin;
     el("team-name").readOnly = !isAdmin;
@@ -3605,7 +5176,21 @@
       requestBtn.disabled = true;
     }
 
-    if (team && !isMember) {
+    if (team && isMember && !deviceListed) {
+      const req = findTeamRequest(team, currentEmail);
+      const pending = req?.status === "pending" && req.deviceKey === teamPresence.fingerprint;
+      if (requestStatus) {
+        requestStatus.textContent = pending
+          ? "Device request pending"
+          : "This device cannot read the team's data yet.";
+      }
+      if (requestBtn) {
+        requestBtn.textContent = isAdmin ? "Add this device" : "Request access";
+        requestBtn.disabled = pending || !teamPresence.fingerprint;
+      }
+      requestRow?.classList.remove("hidden");
+    } else if (team && !isMember) {
+      if (requestBtn) requestBtn.textContent = "Request access";
       const req = findTeamRequest(team, currentEmail);
       const status = req?.status || "";
       let statusText = "";
@@ -3669,7 +5254,7 @@
           label.textContent = req.name || req.email || "Unknown";
           const meta = document.createElement("div");
           meta.className = "team-member-status";
-          meta.textContent = req.email || "";
+          meta.textContent = [req.email, req.deviceKey].filter(Boolean).join(" · ");
           info.appendChild(label);
           info.appendChild(meta);
 
@@ -3689,13 +5274,14 @@
               target.resolvedBy = currentEmail || "";
             }
             team.members = team.members || [];
-            if (!team.members.some((m) => normalizeEmail(m.email) === normalizeEmail(req.email))) {
-              team.members.push({
-                email: req.email,
-                name: req.name || "",
-                role: "user",
-              });
+            let member = team.members.find(
+              (m) => normalizeEmail(m.email) === normalizeEmail(req.email)
+            );
+            if (!member) {
+              member = { email: req.email, name: req.name || "", role: "user" };
+              team.members.push(member);
             }
+            addMemberDevice(member, req.deviceKey);
             saveConfig();
           };
 
@@ -3729,10 +5315,11 @@
       const row = document.createElement("div");
       row.className = "team-member-row";
       const status = isMemberActive(member.email) ? "active" : "offline";
+      const devices = (member.devices || []).length;
       const info = document.createElement("div");
       info.innerHTML = `
         <div>${esc(member.name || member.email || "Unknown")}</div>
-        <div class="team-member-status ${status}">${status}</div>
+        <div class="team-member-status ${status}">${status} · ${devices} device${devices === 1 ? "" : "s"}</div>
       `;
       row.appendChild(info);
 
@@ -3813,6 +5400,8 @@
     el("team-delete").classList.toggle("hidden", !isAdmin);
     el("team-copy-path").disabled = !repoPath;
 
+    el("team-bundle-export").classList.toggle("hidden", !deviceListed || !keyGen);
+
     const leaveBtn = el("team-leave");
     if (leaveBtn) {
       const isMemberBtn = !!team && isMember;
@@ -3831,6 +5420,7 @@
 
     el("profile-name-text").textContent = name || "—";
     el("profile-email-text").textContent = email || "—";
+    el("profile-device-key").textContent = teamPresence.fingerprint || "—";
 
     el("profile-display")?.classList.toggle("hidden", !showDisplay);
     el("profile-form")?.classList.toggle("hidden", showDisplay);
@@ -3848,6 +5438,7 @@
   }
 
   el("teams-close").onclick = closeTeamsModal;
+  ["sync-port", "sync-peers", "sync-mdns"].forEach((id) => el(id).addEventListener("change", saveSyncSettings));
   el("btn-teams").onclick = openTeamsModal;
   el("team-copy-path").onclick = () => {
     const path = el("team-repo-path").textContent || "";
@@ -3894,7 +5485,9 @@
     const members = [];
     const email = userEmail();
     if (email) {
-      members.push({ email, name: config?.user?.name || "", role: "admin" });
+      const admin = { email, name: config?.user?.name || "", role: "admin" };
+      addMemberDevice(admin, teamPresence.fingerprint);
+      members.push(admin);
     }
     config.teams = config.teams || [];
     config.teams.push({ id: "", name: name.trim(), members });
@@ -3905,7 +5498,19 @@
   el("team-save").onclick = () => {
     const team = getTeamById(activeTeamDetailId || "");
     if (!team || !isTeamAdmin(team)) return;
+    const cas = el("team-host-cas")
+      .value.split("\n")
+      .map((line) => line.trim())
+      .filter(Boolean);
+    const bad = cas.find(
+      (line) => !/^(@cert-authority\s+\S+\s+)?(ssh-|ecdsa-|sk-)\S+\s+[A-Za-z0-9+/=]+/.test(line)
+    );
+    if (bad) {
+      notifyWarn(`Not a public key: ${bad.slice(0, 40)}`);
+      return;
+    }
     team.name = el("team-name").value.trim();
+    team.hostCAs = cas.length ? cas : undefined;
     saveConfig();
   };
 
@@ -3975,14 +5580,64 @@
     saveConfig();
   };
 
+  el("team-bundle-export").onclick = async () => {
+    const team = getTeamById(activeTeamDetailId || "");
+    if (!team) return;
+    const passphrase = await promptDialog(
+      `Passphrase for the "${team.name || "team"}" bundle.\nWith one, devices that never synced with the team can import it; leave empty for devices that already hold the team key.`,
+      "",
+      { type: "password", okText: "Export" }
+    );
+    if (passphrase === null) return;
+    rpc({ type: "team_bundle_export", teamId: team.id, passphrase })
+      .then((r) => notifySuccess(`Team bundle exported to:\n${r.path}`))
+      .catch((e) => notifyError(e.detail || e.error || "Could not export the team bundle"));
+  };
+
+  el("btn-team-bundle-import").onclick = async () => {
+    const picked = await rpc({ type: "team_bundle_pick" }).catch(() => null);
+    if (!picked || picked.canceled) return;
+    let passphrase = "";
+    for (;;) {
+      try {
+        const r = await rpc({ type: "team_bundle_import", path: picked.path, passphrase });
+        const b = r.bundle || {};
+        activeTeamDetailId = b.teamId || activeTeamDetailId;
+        notifySuccess(
+          `Imported team "${b.teamName || b.teamId}"${b.from ? ` from ${b.from}` : ""}: ${b.files || 0} file(s) updated`
+        );
+        refreshTeamPresence();
+        refreshSyncDevices();
+        return;
+      } catch (e) {
+        if (e.error !== "passphrase_required" || passphrase) {
+          notifyError(e.detail || e.error || "Could not import the team bundle");
+          return;
+        }
+        passphrase = await promptDialog("This device needs the team key. Bundle passphrase:", "", {
+          type: "password",
+          okText: "Import",
+        });
+        if (!passphrase) return;
+      }
+    }
+  };
+
   el("team-request-access").onclick = () => {
     const team = getTeamById(activeTeamDetailId || "");
-    if (!team || isUserInTeam(team)) return;
+    if (!team || isDeviceInTeam(team)) return;
     const email = userEmail();
     if (!isValidEmail(email)) {
       notifyWarn("Set a valid email in your profile before requesting access.");
       return;
     }
+    const deviceKey = teamPresence.fingerprint || "";
+    if (isTeamAdmin(team)) {
+      const member = (team.members || []).find((m) => normalizeEmail(m.email) === email);
+      addMemberDevice(member, deviceKey);
+      saveConfig();
+      return;
+    }
     team.requests = team.requests || [];
     const existing = findTeamRequest(team, email);
     const now = Math.floor(Date.now() / 1000);
@@ -3995,6 +5650,7 @@
       existing.resolvedAt = 0;
       existing.resolvedBy = "";
       existing.name = config?.user?.name || existing.name || "";
+      existing.deviceKey = deviceKey;
     } else {
       team.requests.push({
         id: newLocalId(),
@@ -4002,6 +5658,7 @@
         name: config?.user?.name || "",
         status: "pending",
         requestedAt: now,
+        deviceKey,
       });
     }
     saveConfig();
@@ -4052,7 +5709,7 @@
         if (host?.sftp) host.sftp.password = "";
       });
     });
-    rpc({ type: "config_save", config: sanitized })
+    return rpc({ type: "config_save", config: sanitized })
       .then(loadConfig)
       .catch((e) => notifyError(e.detail || e.error || "Failed to save config"));
   }
@@ -4166,6 +5823,8 @@
 
     el("btn-disconnect").disabled = !hasHost || !hasTab;
     el("btn-new-term-tab").disabled = !hasHost || !isTerminalTab;
+    el("btn-broadcast").disabled = !isTerminalTab;
+    el("btn-rec-tab").disabled = !hasHost || !hasTab || !isConnected || !isTerminalTab;
     el("btn-copy").disabled = !hasTerm || !isTerminalTab;
     el("btn-clear").disabled = !hasTerm || !isTerminalTab;
     el("btn-paste").disabled = !hasTerm || !isConnected || !isTerminalTab;
@@ -4316,6 +5975,64 @@
       }
     };
 
+    el("btn-ssh-config-import").onclick = async () => {
+      const settings = await samakiaImportSettingsDialog(
+        "SSH Config",
+        lastSamakiaImportMatchMode,
+        { title: "SSH config import", path: "~/.ssh/config" }
+      );
+      if (!settings) return;
+      const networkName = String(settings.networkName || "").trim();
+      if (!networkName) {
+        notifyWarn("Network name is required for import.");
+        return;
+      }
+      const matchMode = String(settings.matchMode || "hostname").trim();
+
+      const ok = await confirmDialog(
+        [
+          "Import will update hosts previously imported from ssh_config and remove missing ones in the target network.",
+          `Match mode: ${formatSamakiaMatchMode(matchMode)}`,
+          "",
+          "Continue?",
+        ].join("\n"),
+        { okText: "Import", danger: true }
+      );
+      if (!ok) return;
+
+      try {
+        const r = await rpc({
+          type: "ssh_config_import",
+          path: String(settings.path || "").trim(),
+          networkName,
+          matchMode,
+        });
+
+        config = normalizeConfig(r.config);
+        renderTeamSelect();
+        renderNetworks();
+        renderHosts();
+        renderScripts();
+
+        openSamakiaImportSummary(r.summary, r.importPath || "");
+      } catch (e) {
+        notifyError(e.detail || e.error || "SSH config import failed");
+      }
+    };
+
+    el("net-ssh-export").onclick = async () => {
+      if (editorType !== "network" || !editorTarget) return;
+      try {
+        const r = await rpc({
+          type: "ssh_config_export",
+          networkId: editorTarget.id,
+        });
+        notifySuccess(`ssh_config exported to:\n${r.path}`);
+      } catch (e) {
+        notifyError(e.detail || e.error || "ssh_config export failed");
+      }
+    };
+
     const samakiaImportSettingsModal = el("samakia-import-settings-modal");
     samakiaImportSettingsModal?.addEventListener("click", (e) => {
       if (e.target === samakiaImportSettingsModal) {
@@ -4330,6 +6047,7 @@
       closeSamakiaImportSettings({
         networkName: networkInput?.value || "",
         matchMode: matchSelect?.value || "hostname",
+        path: el("samakia-import-path-input")?.value || "",
       });
     };
     el("samakia-import-network-input").addEventListener("keydown", (e) => {
@@ -4544,6 +6262,120 @@
       hideHostMenu();
       if (h) runSamakiaVerify(h);
     };
+    el("host-menu-forwards").onclick = () => {
+      const h = hostMenuTarget;
+      hideHostMenu();
+      if (h) openForwardsModal(h);
+    };
+    el("host-menu-forget").onclick = () => {
+      const h = hostMenuTarget;
+      hideHostMenu();
+      if (h) forgetHostPasswords(h);
+    };
+    el("host-menu-hostkeys").onclick = () => {
+      const h = hostMenuTarget;
+      hideHostMenu();
+      if (h) openHostKeysModal(h);
+    };
+    el("hostkeys-close").onclick = () => closeHostKeysModal();
+    el("hostkeys-share").addEventListener("change", () => {
+      if (!hostKeysTarget) return;
+      shareHostPins(el("hostkeys-share").checked ? hostKeysPinned : []);
+    });
+    el("forward-kind").addEventListener("change", applyForwardKindVisibility);
+    el("forward-add").onclick = () => addForward();
+    el("forwards-close").onclick = () => closeForwardsModal();
+    el("btn-rec-tab").onclick = () => toggleTabRecording();
+    el("btn-broadcast").onclick = () => openBroadcastModal();
+    el("broadcast-close").onclick = () => closeBroadcastModal();
+    el("broadcast-add").onclick = () => addTabToBroadcast();
+    el("broadcast-group").addEventListener("change", () => {
+      el("broadcast-name").disabled = el("broadcast-group").value !== "";
+    });
+    const broadcastModal = el("broadcast-modal");
+    broadcastModal?.addEventListener("click", (e) => {
+      if (e.target === broadcastModal) closeBroadcastModal();
+    });
+    el("btn-script-runs").onclick = () => openScriptRunsModal();
+    el("script-runs-close").onclick = () => closeScriptRunsModal();
+    el("script-runs-select").addEventListener("change", () => {
+      scriptRunsSelected = el("script-runs-select").value;
+      refreshScriptRuns();
+    });
+    el("script-runs-stop").onclick = () =>
+      rpc({ type: "script_run_cancel", runId: scriptRunsSelected })
+        .then(() => refreshScriptRuns())
+        .catch((e) => notifyError(e.detail || e.error || "Cancel failed"));
+    const scriptRunsModal = el("script-runs-modal");
+    scriptRunsModal?.addEventListener("click", (e) => {
+      if (e.target === scriptRunsModal) closeScriptRunsModal();
+    });
+    el("script-run-cancel").onclick = () => closeScriptRunDialog();
+    el("script-run-start").onclick = () => startScriptRun();
+    el("script-run-all").onclick = () => setScriptRunHostsChecked(true);
+    el("script-run-none").onclick = () => setScriptRunHostsChecked(false);
+    const scriptRunModal = el("script-run-modal");
+    scriptRunModal?.addEventListener("click", (e) => {
+      if (e.target === scriptRunModal) closeScriptRunDialog();
+    });
+    el("btn-vault").onclick = () => openVaultModal();
+    el("vault-close").onclick = () => closeVaultModal();
+    el("vault-submit").onclick = () => submitVault();
+    el("vault-lock").onclick = () => lockVault();
+    el("vault-reset").onclick = () => resetVault();
+    el("vault-auto-lock").addEventListener("change", setVaultAutoLock);
+    el("keyring-enabled").addEventListener("change", (e) => setKeyringEnabled(e.target.checked));
+    el("vault-passphrase").addEventListener("keydown", (e) => {
+      if (e.key === "Enter" && vaultState?.exists) submitVault();
+    });
+    el("vault-confirm").addEventListener("keydown", (e) => {
+      if (e.key === "Enter") submitVault();
+    });
+    const vaultModal = el("vault-modal");
+    vaultModal?.addEventListener("click", (e) => {
+      if (e.target === vaultModal) closeVaultModal();
+    });
+    el("btn-recordings").onclick = () => openRecordingsModal();
+    el("btn-agent").onclick = () => openAgentModal();
+    el("agent-close").onclick = () => closeAgentModal();
+    el("agent-add").onclick = () => addAgentKey();
+    el("agent-remove-all").onclick = () => removeAllAgentKeys();
+    el("agent-key-path").addEventListener("keydown", (e) => {
+      if (e.key === "Enter") addAgentKey();
+    });
+    ["agent-add-unlocked", "agent-default-lifetime", "agent-socket"].forEach((id) =>
+      el(id).addEventListener("change", saveAgentSettings)
+    );
+    const agentModal = el("agent-modal");
+    agentModal?.addEventListener("click", (e) => {
+      if (e.target === agentModal) closeAgentModal();
+    });
+    el("recordings-close").onclick = () => closeRecordingsModal();
+    el("btn-transfers").onclick = () => openTransfersModal();
+    el("transfers-close").onclick = () => closeTransfersModal();
+    el("transfers-download-browse").onclick = () =>
+      rpc({ type: "local_dir_pick", title: "Download folder" })
+        .then((res) => {
+          if (res.canceled || !res.path) return;
+          el("transfers-download-dir").value = res.path;
+          saveTransferSettings();
+        })
+        .catch((e) => notifyError(e.detail || e.error || "Could not pick a folder"));
+    ["transfers-download-dir", "transfers-conflict", "transfers-concurrency"].forEach((id) =>
+      el(id).addEventListener("change", saveTransferSettings)
+    );
+    const transfersModal = el("transfers-modal");
+    transfersModal?.addEventListener("click", (e) => {
+      if (e.target === transfersModal) closeTransfersModal();
+    });
+    const recordingsModal = el("recordings-modal");
+    recordingsModal?.addEventListener("click", (e) => {
+      if (e.target === recordingsModal) closeRecordingsModal();
+    });
+    const forwardsModal = el("forwards-modal");
+    forwardsModal?.addEventListener("click", (e) => {
+      if (e.target === forwardsModal) closeForwardsModal();
+    });
     el("host-menu-duplicate").onclick = () => {
       const h = hostMenuTarget;
       hideHostMenu();
@@ -4575,7 +6407,7 @@
     el("file-menu-download").onclick = () => {
       const t = fileMenuTarget;
       hideFileMenu();
-      if (!t?.hostId || !t.path || t.isDir) return;
+      if (!t?.hostId || !t.path) return;
       const entry = ensureFilePane(t.hostId);
       entry.selectedPath = t.path;
       downloadSelected(t.hostId).catch((e) =>
@@ -4589,6 +6421,13 @@
       if (!hostId) return;
       ensureFilePane(hostId).fileInput.click();
     };
+    el("file-menu-upload-folder").onclick = () => {
+      const t = fileMenuTarget;
+      hideFileMenu();
+      const hostId = t?.hostId || activeHostId;
+      if (!hostId) return;
+      uploadFolder(hostId).catch((e) => notifyError(e.detail || e.error || "Upload failed"));
+    };
     el("file-menu-mkdir").onclick = () => {
       const t = fileMenuTarget;
       hideFileMenu();
@@ -4686,5 +6525,8 @@
     loadConfig();
     updateTerminalActions();
     refreshUpdateStatus();
+    refreshVault();
+    // The vault locks itself after the idle timeout.
+    setInterval(refreshVault, 30000);
   });
 })();
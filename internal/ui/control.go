@@ -0,0 +1,119 @@
+package ui
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/ankouros/pterminal/internal/control"
+	"github.com/ankouros/pterminal/internal/rpc"
+	"github.com/ankouros/pterminal/internal/session"
+)
+
+// controlTimeout bounds how long a socket request waits for the UI thread.
+const controlTimeout = 60 * time.Second
+
+func (w *Window) startControl() {
+	w.control = control.NewServer(w.serveControl)
+	w.mgr.SetStateListener(func(hostID, tabID int, state session.SessionState, err error) {
+		ev := control.Event{Type: control.EventState, HostID: hostID, TabID: tabID, State: state.String()}
+		if err != nil {
+			ev.Error = err.Error()
+		}
+		w.control.Publish(ev)
+	})
+	_ = w.control.Configure(w.mgr.Config().Control)
+}
+
+// publishOutput forwards a chunk of terminal output to socket subscribers.
+func (w *Window) publishOutput(hostID, tabID int, chunk []byte) {
+	if w.control == nil || !w.control.Subscribed(control.EventOutput) {
+		return
+	}
+	w.control.Publish(control.Event{
+		Type:    control.EventOutput,
+		HostID:  hostID,
+		TabID:   tabID,
+		DataB64: base64.StdEncoding.EncodeToString(chunk),
+	})
+}
+
+// serveControl runs a socket request through the UI's registry, on the UI
+// thread like calls from the WebView.
+func (w *Window) serveControl(method string, params json.RawMessage) (any, error) {
+	if method == "open" {
+		var req controlOpenRequest
+		if len(params) > 0 {
+			if err := json.Unmarshal(params, &req); err != nil {
+				return nil, &control.Error{Code: control.CodeInvalidParams, Message: err.Error()}
+			}
+		}
+		return w.controlOpen(req)
+	}
+	if !w.registry.Has(method) {
+		return nil, &control.Error{Code: control.CodeMethodNotFound, Message: "unknown method " + method}
+	}
+	// Only exposed methods are served: trusting host keys, managing sync
+	// devices and team bundles, approving agent signatures and the rest of
+	// what is not exposed stay with the person at the window.
+	if !w.registry.Exposed(method) {
+		return nil, &control.Error{Code: control.CodeDenied, Message: "not available over the control socket"}
+	}
+
+	var resp any
+	var err error
+	if uiErr := w.onUIThread(func() { resp, err = w.registry.Call(context.Background(), method, params) }); uiErr != nil {
+		return nil, uiErr
+	}
+	if err != nil {
+		e := rpc.AsError(err)
+		ce := &control.Error{Code: control.CodeAppError, Message: e.Code}
+		if len(e.Data) > 0 {
+			ce.Data = e.Data
+		}
+		return nil, ce
+	}
+	return resp, nil
+}
+
+// controlOpenRequest shows a host tab (default 1).
+type controlOpenRequest struct {
+	HostID int `json:"hostId"`
+	TabID  int `json:"tabId"`
+}
+
+// controlOpen shows a host tab in the window and connects it, as if the
+// user had clicked it.
+func (w *Window) controlOpen(req controlOpenRequest) (any, error) {
+	if req.TabID <= 0 {
+		req.TabID = 1
+	}
+	if _, ok := w.svc.HostByID(req.HostID); !ok {
+		return nil, &control.Error{Code: control.CodeAppError, Message: "unknown_host"}
+	}
+	js := fmt.Sprintf("window.__openHostTab && window.__openHostTab(%d, %d);", req.HostID, req.TabID)
+	if err := w.onUIThread(func() { w.wv.Eval(js) }); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+// onUIThread runs fn on the UI thread and waits for it.
+func (w *Window) onUIThread(fn func()) error {
+	if w.closed.Load() {
+		return fmt.Errorf("window closed")
+	}
+	done := make(chan struct{})
+	w.wv.Dispatch(func() {
+		fn()
+		close(done)
+	})
+	select {
+	case <-done:
+		return nil
+	case <-time.After(controlTimeout):
+		return fmt.Errorf("timed out waiting for the UI")
+	}
+}
@@ -66,6 +66,30 @@ static char* pterminal_pick_json(void* parent, const char* title) {
   gtk_widget_destroy(dialog);
   return filename; // must be freed by g_free()
 }
+
+static char* pterminal_pick_folder(void* parent, const char* title) {
+  GtkWindow* w = (GtkWindow*)parent;
+  GtkWidget* dialog = gtk_file_chooser_dialog_new(
+    title,
+    w,
+    GTK_FILE_CHOOSER_ACTION_SELECT_FOLDER,
+    "_Cancel", GTK_RESPONSE_CANCEL,
+    "_Select", GTK_RESPONSE_ACCEPT,
+    NULL
+  );
+
+  gtk_file_chooser_set_local_only(GTK_FILE_CHOOSER(dialog), TRUE);
+  gtk_file_chooser_set_select_multiple(GTK_FILE_CHOOSER(dialog), FALSE);
+  gtk_file_chooser_set_current_folder(GTK_FILE_CHOOSER(dialog), g_get_home_dir());
+
+  char* filename = NULL;
+  if (gtk_dialog_run(GTK_DIALOG(dialog)) == GTK_RESPONSE_ACCEPT) {
+    filename = gtk_file_chooser_get_filename(GTK_FILE_CHOOSER(dialog));
+  }
+
+  gtk_widget_destroy(dialog);
+  return filename; // must be freed by g_free()
+}
 */
 import "C"
 
@@ -120,3 +144,38 @@ func (w *Window) pickSamakiaInventoryPath() string {
 	defer C.g_free(C.gpointer(p))
 	return C.GoString(p)
 }
+
+func (w *Window) pickTeamBundlePath() string {
+	if w == nil || w.wv == nil {
+		return ""
+	}
+
+	title := C.CString("Import team bundle (.json)")
+	defer C.free(unsafe.Pointer(title))
+
+	p := C.pterminal_pick_json(w.wv.Window(), title)
+	if p == nil {
+		return ""
+	}
+	defer C.g_free(C.gpointer(p))
+	return C.GoString(p)
+}
+
+func (w *Window) pickLocalDir(title string) string {
+	if w == nil || w.wv == nil {
+		return ""
+	}
+	if title == "" {
+		title = "Select folder"
+	}
+
+	ctitle := C.CString(title)
+	defer C.free(unsafe.Pointer(ctitle))
+
+	p := C.pterminal_pick_folder(w.wv.Window(), ctitle)
+	if p == nil {
+		return ""
+	}
+	defer C.g_free(C.gpointer(p))
+	return C.GoString(p)
+}
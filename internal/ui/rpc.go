@@ -0,0 +1,243 @@
+package ui
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/ankouros/pterminal/internal/buildinfo"
+	"github.com/ankouros/pterminal/internal/control"
+	"github.com/ankouros/pterminal/internal/model"
+	"github.com/ankouros/pterminal/internal/rpc"
+	"github.com/ankouros/pterminal/internal/service"
+	"github.com/ankouros/pterminal/internal/sftpclient"
+	"github.com/ankouros/pterminal/internal/terminal"
+)
+
+// slowRPC is when a call is logged as slow; the UI thread waits for it.
+const slowRPC = 2 * time.Second
+
+// ImportPickRequest imports a file picked in a dialog.
+type ImportPickRequest struct {
+	NetworkName string `json:"networkName"`
+	MatchMode   string `json:"matchMode"`
+}
+
+// PickResponse is a picked file, or Canceled.
+type PickResponse struct {
+	Path     string `json:"path,omitempty"`
+	Canceled bool   `json:"canceled,omitempty"`
+}
+
+// DirPickRequest asks for a local directory.
+type DirPickRequest struct {
+	Title string `json:"title"`
+}
+
+// AgentConfirmRequest answers a forwarded-agent signing request.
+type AgentConfirmRequest struct {
+	RequestID int64 `json:"requestId"`
+	Allow     bool  `json:"allow"`
+}
+
+// UpdateRequest asks for the update state; Force checks first.
+type UpdateRequest struct {
+	Force bool `json:"force"`
+}
+
+// UpdateResponse is the update state.
+type UpdateResponse struct {
+	Update rpcResp `json:"update"`
+}
+
+// AboutResponse describes the build.
+type AboutResponse struct {
+	Text   string  `json:"text"`
+	About  rpcResp `json:"about"`
+	Update rpcResp `json:"update"`
+}
+
+// newRegistry builds the methods served to the UI: the service's plus those
+// that need the window (file pickers, agent confirmation, updates).
+func (w *Window) newRegistry() *rpc.Registry {
+	r := rpc.NewRegistry()
+	r.Use(rpc.Recover(log.Printf), rpc.Logging(log.Printf, slowRPC))
+	w.svc.Register(r)
+
+	rpc.Register(r, "config_import_pick", func(context.Context, struct{}) (any, error) {
+		path := w.pickConfigImportPath()
+		if path == "" {
+			return PickResponse{Canceled: true}, nil
+		}
+		resp, err := w.svc.ImportConfig(path)
+		if err != nil {
+			return nil, err
+		}
+		w.activeHostID.Store(0)
+		w.activeTabID.Store(0)
+		w.attachedMu.Lock()
+		w.attached = make(map[attachKey]terminal.Session)
+		w.attachedMu.Unlock()
+		return resp, nil
+	})
+	rpc.Register(r, "samakia_inventory_import_pick", func(_ context.Context, req ImportPickRequest) (any, error) {
+		path := w.pickSamakiaInventoryPath()
+		if path == "" {
+			return PickResponse{Canceled: true}, nil
+		}
+		return w.svc.ImportSamakiaInventory(path, req.NetworkName, req.MatchMode)
+	})
+	rpc.Register(r, "team_bundle_pick", func(context.Context, struct{}) (PickResponse, error) {
+		path := w.pickTeamBundlePath()
+		return PickResponse{Path: path, Canceled: path == ""}, nil
+	})
+	rpc.Register(r, "local_dir_pick", func(_ context.Context, req DirPickRequest) (PickResponse, error) {
+		path := w.pickLocalDir(req.Title)
+		return PickResponse{Path: path, Canceled: path == ""}, nil
+	})
+	rpc.Register(r, "telecom_pick", func(context.Context, struct{}) (PickResponse, error) {
+		// Bind handlers are invoked on the UI thread in this build; dispatching
+		// back to the UI thread and waiting would deadlock.
+		return PickResponse{Path: w.pickTelecomExecutablePath()}, nil
+	})
+
+	rpc.Register(r, "agent_confirm", func(_ context.Context, req AgentConfirmRequest) (rpc.Empty, error) {
+		w.agentMu.Lock()
+		ch := w.agentAsks[req.RequestID]
+		delete(w.agentAsks, req.RequestID)
+		w.agentMu.Unlock()
+		if ch == nil {
+			return rpc.Empty{}, rpc.Fail("no_pending_request", nil)
+		}
+		ch <- req.Allow
+		return rpc.Empty{}, nil
+	})
+
+	rpc.Register(r, "about", func(context.Context, struct{}) (AboutResponse, error) {
+		return AboutResponse{
+			Text: "pTerminal – SSH Terminal Manager",
+			About: rpcResp{
+				"full":      buildinfo.String(),
+				"version":   buildinfo.Version,
+				"gitCommit": buildinfo.GitCommit,
+				"buildTime": buildinfo.BuildTime,
+			},
+			Update: w.updatePayload(),
+		}, nil
+	})
+	rpc.Register(r, "update_status", func(_ context.Context, req UpdateRequest) (UpdateResponse, error) {
+		if req.Force {
+			w.triggerUpdateCheck(true)
+		}
+		return UpdateResponse{Update: w.updatePayload()}, nil
+	})
+	rpc.Register(r, "update_check", func(context.Context, struct{}) (UpdateResponse, error) {
+		w.triggerUpdateCheck(true)
+		return UpdateResponse{Update: w.updatePayload()}, nil
+	})
+	rpc.Register(r, "update_install", func(context.Context, struct{}) (UpdateResponse, error) {
+		go w.runUpdateInstall()
+		return UpdateResponse{Update: w.updatePayload()}, nil
+	})
+	rpc.Register(r, "app_restart", func(context.Context, struct{}) (rpc.Empty, error) {
+		go w.requestRestart()
+		return rpc.Empty{}, nil
+	})
+	rpc.Register(r, "app_quit", func(context.Context, struct{}) (rpc.Empty, error) {
+		go w.requestQuit()
+		return rpc.Empty{}, nil
+	})
+	// Pickers, agent confirmations, updates and restarts stay with the
+	// window.
+	r.Expose("about", "update_status", "update_check", "app_quit")
+	return r
+}
+
+// serviceHooks connect the service's sessions to the terminal views.
+func (w *Window) serviceHooks() service.Hooks {
+	return service.Hooks{
+		Attach: func(hostID, tabID int, sess terminal.Session) {
+			w.ensureAttached(hostID, tabID, sess)
+			if int(w.activeHostID.Load()) == hostID && int(w.activeTabID.Load()) == tabID {
+				w.kickFlush()
+			}
+		},
+		Selected: func(hostID, tabID int, sess terminal.Session) {
+			w.activeHostID.Store(int64(hostID))
+			w.activeTabID.Store(int64(tabID))
+			if sess != nil {
+				w.ensureAttached(hostID, tabID, sess)
+				_ = w.flushHost(hostID, tabID)
+			} else {
+				// Show restored scrollback while the connection is set up.
+				w.kickFlush()
+			}
+		},
+		Detached: func(hostID, tabID int) {
+			w.attachedMu.Lock()
+			delete(w.attached, attachKey{hostID: hostID, tabID: tabID})
+			w.attachedMu.Unlock()
+		},
+		ConfigApplied: func(cfg model.AppConfig) {
+			if w.control != nil {
+				_ = w.control.Configure(cfg.Control)
+			}
+		},
+		Transfer: w.pushTransfer,
+	}
+}
+
+// pushTransfer reports transfer progress to the page and socket subscribers.
+func (w *Window) pushTransfer(t sftpclient.Transfer) {
+	if w.control != nil && w.control.Subscribed(control.EventTransfer) {
+		w.control.Publish(control.Event{Type: control.EventTransfer, HostID: t.HostID, Transfer: t})
+	}
+	if w.wv == nil || w.closed.Load() {
+		return
+	}
+	b, err := json.Marshal(t)
+	if err != nil {
+		return
+	}
+	w.wv.Dispatch(func() {
+		w.wv.Eval(fmt.Sprintf("window.__transferProgress && window.__transferProgress(%s);", string(b)))
+	})
+}
+
+// handleRPC is the WebView binding: payload is the request object with the
+// method in "type". It runs on the UI thread.
+func (w *Window) handleRPC(payload string) string {
+	var head struct {
+		Type string `json:"type"`
+	}
+	if err := json.Unmarshal([]byte(payload), &head); err != nil {
+		return reply(nil, rpc.Fail(rpc.CodeBadRequest, nil))
+	}
+	return reply(w.registry.Call(context.Background(), head.Type, json.RawMessage(payload)))
+}
+
+// reply encodes a call's outcome the way the UI expects: the response's
+// fields plus "ok", and "error" (the code) on failure.
+func reply(resp any, err error) string {
+	if err != nil {
+		e := rpc.AsError(err)
+		out := make(map[string]any, len(e.Data)+2)
+		for k, v := range e.Data {
+			out[k] = v
+		}
+		out["ok"] = false
+		out["error"] = e.Code
+		b, _ := json.Marshal(out)
+		return string(b)
+	}
+	b, merr := json.Marshal(resp)
+	if merr != nil || len(b) < 2 || b[0] != '{' {
+		return reply(nil, rpc.Fail(rpc.CodeInternal, nil))
+	}
+	if string(b) == "{}" {
+		return `{"ok":true}`
+	}
+	return `{"ok":true,` + string(b[1:])
+}
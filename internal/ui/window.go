@@ -25,16 +25,22 @@ import (
 
 	"github.com/ankouros/pterminal/internal/buildinfo"
 	"github.com/ankouros/pterminal/internal/config"
+	"github.com/ankouros/pterminal/internal/control"
+	"github.com/ankouros/pterminal/internal/credstore"
+	"github.com/ankouros/pterminal/internal/hostkeys"
 	"github.com/ankouros/pterminal/internal/model"
 	"github.com/ankouros/pterminal/internal/p2p"
+	"github.com/ankouros/pterminal/internal/rpc"
+	"github.com/ankouros/pterminal/internal/scriptrun"
+	"github.com/ankouros/pterminal/internal/service"
 	"github.com/ankouros/pterminal/internal/session"
 	"github.com/ankouros/pterminal/internal/sftpclient"
+	"github.com/ankouros/pterminal/internal/sshagent"
 	"github.com/ankouros/pterminal/internal/sshclient"
-	"github.com/ankouros/pterminal/internal/teamrepo"
 	"github.com/ankouros/pterminal/internal/terminal"
 	"github.com/ankouros/pterminal/internal/update"
+	"github.com/ankouros/pterminal/internal/vault"
 	webview "github.com/webview/webview_go"
-	"golang.org/x/crypto/ssh"
 )
 
 //go:embed assets/*
@@ -163,13 +169,23 @@ type Window struct {
 	sftp *sftpclient.Manager
 	p2p  *p2p.Service
 
-	// pending host-key trust data
-	pendingTrust map[int]pendingKey
+	// svc implements the RPC methods; registry serves them (see rpc.go).
+	svc      *service.Service
+	registry *rpc.Registry
 
-	// per-host password cache (memory only)
-	pwMu    sync.RWMutex
-	pwCache map[int]string
-	sftpPw  map[int]string
+	// keyring is the desktop Secret Service; used when enabled in the config.
+	keyring *credstore.SecretService
+
+	// agent is the built-in SSH agent (see internal/sshagent).
+	agent *sshagent.Agent
+
+	// control is the opt-in JSON-RPC socket (see control.go).
+	control *control.Server
+
+	// agent signing requests waiting for the user (confirm-mode forwarding)
+	agentMu   sync.Mutex
+	agentSeq  int64
+	agentAsks map[int64]chan bool
 
 	activeHostID atomic.Int64
 	activeTabID  atomic.Int64
@@ -180,10 +196,6 @@ type Window struct {
 	flushCancel context.CancelFunc
 	flushCh     chan struct{}
 
-	ioCancel context.CancelFunc
-	inputCh  chan inputMsg
-	resizeCh chan resizeMsg
-
 	windowHidden atomic.Bool
 	closed       atomic.Bool
 	exePath      string
@@ -192,13 +204,6 @@ type Window struct {
 	updateMu sync.Mutex
 }
 
-type pendingKey struct {
-	kind        string
-	hostPort    string
-	fingerprint string
-	key         ssh.PublicKey
-}
-
 type updateState struct {
 	LatestTag    string
 	ReleaseURL   string
@@ -291,102 +296,13 @@ func selectUpdateAsset(goos string, assets []update.Asset) (update.Asset, bool)
 	return update.Asset{}, false
 }
 
-type inputMsg struct {
-	hostID  int
-	tabID   int
-	dataB64 string
-}
-
-type resizeMsg struct {
-	hostID int
-	tabID  int
-	cols   int
-	rows   int
-}
-
 type attachKey struct {
 	hostID int
 	tabID  int
 }
 
-type rpcReq struct {
-	Type string `json:"type"`
-
-	HostID int `json:"hostId,omitempty"`
-	TabID  int `json:"tabId,omitempty"`
-	Cols   int `json:"cols,omitempty"`
-	Rows   int `json:"rows,omitempty"`
-
-	Force bool `json:"force,omitempty"`
-
-	DataB64         string `json:"dataB64,omitempty"`
-	PasswordB64     string `json:"passwordB64,omitempty"`
-	SftpPasswordB64 string `json:"sftpPasswordB64,omitempty"`
-	Config          any    `json:"config,omitempty"`
-
-	Path string `json:"path,omitempty"`
-	From string `json:"from,omitempty"`
-	To   string `json:"to,omitempty"`
-	Dir  string `json:"dir,omitempty"`
-	Name string `json:"name,omitempty"`
-
-	NetworkName string `json:"networkName,omitempty"`
-	MatchMode   string `json:"matchMode,omitempty"`
-	Summary     any    `json:"summary,omitempty"`
-	Format      string `json:"format,omitempty"`
-
-	UploadID string `json:"uploadId,omitempty"`
-}
-
 type rpcResp map[string]any
 
-func (w *Window) getCachedPassword(hostID int) string {
-	w.pwMu.RLock()
-	defer w.pwMu.RUnlock()
-	return w.pwCache[hostID]
-}
-
-func (w *Window) setCachedPassword(hostID int, pw string) {
-	w.pwMu.Lock()
-	w.pwCache[hostID] = pw
-	w.pwMu.Unlock()
-}
-
-func (w *Window) getCachedSFTPPassword(hostID int) string {
-	w.pwMu.RLock()
-	defer w.pwMu.RUnlock()
-	return w.sftpPw[hostID]
-}
-
-func (w *Window) setCachedSFTPPassword(hostID int, pw string) {
-	w.pwMu.Lock()
-	if pw == "" {
-		delete(w.sftpPw, hostID)
-	} else {
-		w.sftpPw[hostID] = pw
-	}
-	w.pwMu.Unlock()
-}
-
-func ok(extra rpcResp) string {
-	if extra == nil {
-		extra = rpcResp{}
-	}
-	extra["ok"] = true
-	b, _ := json.Marshal(extra)
-	return string(b)
-}
-
-func fail(code string, extra rpcResp) string {
-	if extra == nil {
-		extra = rpcResp{}
-	}
-	extra["ok"] = false
-	extra["error"] = code
-	b, _ := json.Marshal(extra)
-	return string(b)
-}
-
 func NewWindow(mgr *session.Manager, p2pSvc *p2p.Service) (*Window, error) {
 	wv, err := newWebViewWithFallback()
 	if err != nil {
@@ -394,18 +310,43 @@ func NewWindow(mgr *session.Manager, p2pSvc *p2p.Service) (*Window, error) {
 	}
 
 	w := &Window{
-		wv:           wv,
-		mgr:          mgr,
-		sftp:         sftpclient.NewManager(mgr.Config()),
-		p2p:          p2pSvc,
-		pendingTrust: make(map[int]pendingKey),
-		pwCache:      make(map[int]string),
-		sftpPw:       make(map[int]string),
-		attached:     make(map[attachKey]terminal.Session),
-		flushCh:      make(chan struct{}, 1),
-		inputCh:      make(chan inputMsg, 16384),
-		resizeCh:     make(chan resizeMsg, 256),
+		wv:        wv,
+		mgr:       mgr,
+		sftp:      sftpclient.NewManager(mgr.Config()),
+		p2p:       p2pSvc,
+		attached:  make(map[attachKey]terminal.Session),
+		flushCh:   make(chan struct{}, 1),
+		agentAsks: make(map[int64]chan bool),
+	}
+	deps := service.Deps{
+		Manager: mgr,
+		SFTP:    w.sftp,
+		P2P:     p2pSvc,
+		Hooks:   w.serviceHooks(),
+	}
+	runsPath, _ := config.ScriptRunsPath()
+	deps.Scripts = scriptrun.NewRunner(mgr.Config(), runsPath)
+	w.keyring = credstore.NewSecretService()
+	deps.Keyring = w.keyring
+	mgr.SetAgentConfirm(w.confirmAgentSign)
+	if path, err := config.HostKeysPath(); err == nil {
+		if s, err := hostkeys.Open(path); err == nil {
+			deps.HostKeys = s
+			sshclient.SetHostKeyStore(s)
+		}
+	}
+	w.agent = sshagent.New()
+	_ = w.agent.Configure(mgr.Config().Agent)
+	sshclient.SetLocalAgent(w.agent)
+	deps.Agent = w.agent
+	if vaultPath, err := config.VaultPath(); err == nil {
+		if v, err := vault.Open(vaultPath); err == nil {
+			deps.Vault = v
+		}
 	}
+	w.svc = service.New(deps)
+	w.registry = w.newRegistry()
+	w.startControl()
 	if exe, err := os.Executable(); err == nil {
 		w.exePath = exe
 	} else {
@@ -416,638 +357,11 @@ func NewWindow(mgr *session.Manager, p2pSvc *p2p.Service) (*Window, error) {
 	w.wv.SetSize(1200, 800, webview.HintNone)
 	w.wv.Dispatch(func() { w.setNativeIcon() })
 
-	w.wv.Bind("rpc", func(payload string) string {
-		var req rpcReq
-		if err := json.Unmarshal([]byte(payload), &req); err != nil {
-			return fail("bad_request", nil)
-		}
-
-		// Opportunistic password cache (used by SSH + SFTP ops). JS can supply
-		// PasswordB64 to avoid prompting repeatedly.
-		if req.HostID != 0 && req.PasswordB64 != "" {
-			if pw, err := b64dec(req.PasswordB64); err == nil && pw != "" {
-				w.setCachedPassword(req.HostID, pw)
-			}
-		}
-		if req.HostID != 0 && req.SftpPasswordB64 != "" {
-			if pw, err := b64dec(req.SftpPasswordB64); err == nil && pw != "" {
-				w.setCachedSFTPPassword(req.HostID, pw)
-				w.sftp.SetCustomPassword(req.HostID, pw)
-			}
-		}
-
-		switch req.Type {
-
-		case "config_get":
-			cfg, _, err := config.EnsureConfig()
-			if err != nil {
-				return fail("config_load_failed", nil)
-			}
-			w.mgr.SetConfig(cfg)
-			w.sftp.SetConfig(cfg)
-			if w.p2p != nil {
-				w.p2p.SetConfig(cfg)
-			}
-			return ok(rpcResp{"config": cfg})
-
-		case "config_save":
-			raw, _ := json.Marshal(req.Config)
-			current := w.mgr.Config()
-			var incoming model.AppConfig
-			if err := json.Unmarshal(raw, &incoming); err != nil {
-				return fail("config_save_failed", nil)
-			}
-			updated, _ := p2p.ApplyLocalEdits(current, incoming)
-			_ = config.StripSecrets(&updated)
-			if err := config.Save(updated); err != nil {
-				return fail("config_save_failed", nil)
-			}
-			w.mgr.SetConfig(updated)
-			w.sftp.SetConfig(updated)
-			if w.p2p != nil {
-				w.p2p.SetConfig(updated)
-				w.p2p.SyncNow()
-			}
-			return ok(rpcResp{"config": updated})
-
-		case "config_export":
-			path, err := config.ExportToDownloads()
-			if err != nil {
-				return fail("export_failed", nil)
-			}
-			return ok(rpcResp{"path": path})
-
-		case "config_import_pick":
-			path := w.pickConfigImportPath()
-			if path == "" {
-				return ok(rpcResp{"canceled": true})
-			}
-
-			cfg, backup, err := config.ImportFromFile(path)
-			if err != nil {
-				return fail("import_failed", rpcResp{"detail": err.Error()})
-			}
-
-			// Imported config might invalidate existing sessions/IDs; disconnect all.
-			w.mgr.DisconnectAll()
-			w.sftp.DisconnectAll()
-
-			w.pendingTrust = make(map[int]pendingKey)
-			w.pwMu.Lock()
-			w.pwCache = make(map[int]string)
-			w.sftpPw = make(map[int]string)
-			w.pwMu.Unlock()
-			w.activeHostID.Store(0)
-			w.activeTabID.Store(0)
-			w.attachedMu.Lock()
-			w.attached = make(map[attachKey]terminal.Session)
-			w.attachedMu.Unlock()
-
-			w.mgr.SetConfig(cfg)
-			w.sftp.SetConfig(cfg)
-			if w.p2p != nil {
-				w.p2p.SetConfig(cfg)
-				w.p2p.SyncNow()
-			}
-			return ok(rpcResp{
-				"config":       cfg,
-				"importPath":   path,
-				"backupPath":   backup,
-				"disconnected": true,
-			})
-
-		case "samakia_inventory_import_pick":
-			path := w.pickSamakiaInventoryPath()
-			if path == "" {
-				return ok(rpcResp{"canceled": true})
-			}
-
-			current := w.mgr.Config()
-			updated, summary, err := config.ImportSamakiaInventory(current, path, req.NetworkName, req.MatchMode)
-			if err != nil {
-				return fail("import_failed", rpcResp{"detail": err.Error()})
-			}
-
-			if err := config.Save(updated); err != nil {
-				return fail("config_save_failed", rpcResp{"detail": err.Error()})
-			}
-
-			w.mgr.SetConfig(updated)
-			w.sftp.SetConfig(updated)
-			if w.p2p != nil {
-				w.p2p.SetConfig(updated)
-				w.p2p.SyncNow()
-			}
-
-			return ok(rpcResp{
-				"config":     updated,
-				"importPath": path,
-				"summary":    summary,
-			})
-
-		case "samakia_import_report":
-			raw, _ := json.Marshal(req.Summary)
-			var summary config.SamakiaImportSummary
-			if err := json.Unmarshal(raw, &summary); err != nil {
-				return fail("report_failed", rpcResp{"detail": "invalid summary payload"})
-			}
-
-			format := strings.ToLower(strings.TrimSpace(req.Format))
-			var path string
-			var err error
-			if format == "csv" {
-				path, err = config.ExportSamakiaImportReportCSV(summary, req.Path)
-			} else if format == "md" || format == "markdown" {
-				path, err = config.ExportSamakiaImportReportMarkdown(summary, req.Path)
-			} else {
-				path, err = config.ExportSamakiaImportReport(summary, req.Path)
-			}
-			if err != nil {
-				return fail("report_failed", rpcResp{"detail": err.Error()})
-			}
-			return ok(rpcResp{"path": path})
-
-		case "sftp_ls":
-			ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
-			defer cancel()
-
-			entries, cwd, err := w.sftp.List(ctx, req.HostID, req.Path, func(hostID int) (string, error) {
-				if pw := w.getCachedSFTPPassword(hostID); pw != "" {
-					return pw, nil
-				}
-				if pw := w.getCachedPassword(hostID); pw != "" {
-					return pw, nil
-				}
-				return "", errors.New("password_required")
-			})
-			if err != nil {
-				var unk sshclient.ErrUnknownHostKey
-				if errors.As(err, &unk) {
-					w.pendingTrust[req.HostID] = pendingKey{
-						kind:        "unknown_host_key",
-						hostPort:    unk.HostPort,
-						fingerprint: unk.Fingerprint,
-						key:         unk.Key,
-					}
-					return fail("unknown_host_key", rpcResp{
-						"hostPort":    unk.HostPort,
-						"fingerprint": unk.Fingerprint,
-					})
-				}
-				var mismatch sshclient.ErrHostKeyMismatch
-				if errors.As(err, &mismatch) {
-					w.pendingTrust[req.HostID] = pendingKey{
-						kind:        "host_key_mismatch",
-						hostPort:    mismatch.HostPort,
-						fingerprint: mismatch.Fingerprint,
-						key:         mismatch.Key,
-					}
-					return fail("host_key_mismatch", rpcResp{
-						"hostPort":    mismatch.HostPort,
-						"fingerprint": mismatch.Fingerprint,
-					})
-				}
-				if errors.Is(err, sshclient.ErrPassphraseRequired) {
-					return fail("passphrase_required", nil)
-				}
-				if err.Error() == "password_required" {
-					return fail("password_required", nil)
-				}
-				return fail("sftp_failed", rpcResp{"detail": err.Error()})
-			}
-			return ok(rpcResp{"cwd": cwd, "entries": entries})
-
-		case "sftp_mkdir":
-			ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
-			defer cancel()
-			if err := w.sftp.MkdirAll(ctx, req.HostID, req.Path, func(hostID int) (string, error) {
-				if pw := w.getCachedSFTPPassword(hostID); pw != "" {
-					return pw, nil
-				}
-				if pw := w.getCachedPassword(hostID); pw != "" {
-					return pw, nil
-				}
-				return "", errors.New("password_required")
-			}); err != nil {
-				if errors.Is(err, sshclient.ErrPassphraseRequired) {
-					return fail("passphrase_required", nil)
-				}
-				return fail("sftp_failed", rpcResp{"detail": err.Error()})
-			}
-			return ok(nil)
-
-		case "sftp_rm":
-			ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
-			defer cancel()
-			if err := w.sftp.Remove(ctx, req.HostID, req.Path, func(hostID int) (string, error) {
-				if pw := w.getCachedSFTPPassword(hostID); pw != "" {
-					return pw, nil
-				}
-				if pw := w.getCachedPassword(hostID); pw != "" {
-					return pw, nil
-				}
-				return "", errors.New("password_required")
-			}); err != nil {
-				if errors.Is(err, sshclient.ErrPassphraseRequired) {
-					return fail("passphrase_required", nil)
-				}
-				return fail("sftp_failed", rpcResp{"detail": err.Error()})
-			}
-			return ok(nil)
-
-		case "sftp_mv":
-			ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
-			defer cancel()
-			if err := w.sftp.Rename(ctx, req.HostID, req.From, req.To, func(hostID int) (string, error) {
-				if pw := w.getCachedSFTPPassword(hostID); pw != "" {
-					return pw, nil
-				}
-				if pw := w.getCachedPassword(hostID); pw != "" {
-					return pw, nil
-				}
-				return "", errors.New("password_required")
-			}); err != nil {
-				if errors.Is(err, sshclient.ErrPassphraseRequired) {
-					return fail("passphrase_required", nil)
-				}
-				return fail("sftp_failed", rpcResp{"detail": err.Error()})
-			}
-			return ok(nil)
-
-		case "sftp_download":
-			ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
-			defer cancel()
-			out, err := w.sftp.DownloadToDownloads(ctx, req.HostID, req.Path, func(hostID int) (string, error) {
-				if pw := w.getCachedSFTPPassword(hostID); pw != "" {
-					return pw, nil
-				}
-				if pw := w.getCachedPassword(hostID); pw != "" {
-					return pw, nil
-				}
-				return "", errors.New("password_required")
-			})
-			if err != nil {
-				if errors.Is(err, sshclient.ErrPassphraseRequired) {
-					return fail("passphrase_required", nil)
-				}
-				return fail("sftp_failed", rpcResp{"detail": err.Error()})
-			}
-			return ok(rpcResp{"localPath": out})
-
-		case "sftp_upload_begin":
-			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-			defer cancel()
-			id, err := w.sftp.BeginUpload(ctx, req.HostID, req.Dir, req.Name, func(hostID int) (string, error) {
-				if pw := w.getCachedSFTPPassword(hostID); pw != "" {
-					return pw, nil
-				}
-				if pw := w.getCachedPassword(hostID); pw != "" {
-					return pw, nil
-				}
-				return "", errors.New("password_required")
-			})
-			if err != nil {
-				if errors.Is(err, sshclient.ErrPassphraseRequired) {
-					return fail("passphrase_required", nil)
-				}
-				return fail("sftp_failed", rpcResp{"detail": err.Error()})
-			}
-			return ok(rpcResp{"uploadId": id})
-
-		case "sftp_upload_chunk":
-			if req.UploadID == "" || req.DataB64 == "" {
-				return fail("bad_request", nil)
-			}
-			data, err := base64.StdEncoding.DecodeString(req.DataB64)
-			if err != nil {
-				return fail("bad_request", nil)
-			}
-			if err := w.sftp.UploadChunk(req.UploadID, data); err != nil {
-				return fail("sftp_failed", rpcResp{"detail": err.Error()})
-			}
-			return ok(nil)
-
-		case "sftp_upload_end":
-			if req.UploadID == "" {
-				return fail("bad_request", nil)
-			}
-			if err := w.sftp.EndUpload(req.UploadID); err != nil {
-				return fail("sftp_failed", rpcResp{"detail": err.Error()})
-			}
-			return ok(nil)
-
-		case "sftp_read":
-			if req.HostID == 0 || req.Path == "" {
-				return fail("bad_request", nil)
-			}
-			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-			defer cancel()
-			const max = 2 * 1024 * 1024
-			b, err := w.sftp.ReadFile(ctx, req.HostID, req.Path, max, func(hostID int) (string, error) {
-				if pw := w.getCachedSFTPPassword(hostID); pw != "" {
-					return pw, nil
-				}
-				if pw := w.getCachedPassword(hostID); pw != "" {
-					return pw, nil
-				}
-				return "", errors.New("password_required")
-			})
-			if err != nil {
-				if errors.Is(err, sshclient.ErrPassphraseRequired) {
-					return fail("passphrase_required", nil)
-				}
-				return fail("sftp_failed", rpcResp{"detail": err.Error()})
-			}
-			return ok(rpcResp{"dataB64": base64.StdEncoding.EncodeToString(b)})
-
-		case "sftp_write":
-			if req.HostID == 0 || req.Path == "" || req.DataB64 == "" {
-				return fail("bad_request", nil)
-			}
-			data, err := base64.StdEncoding.DecodeString(req.DataB64)
-			if err != nil {
-				return fail("bad_request", nil)
-			}
-			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-			defer cancel()
-			if err := w.sftp.WriteFile(ctx, req.HostID, req.Path, data, func(hostID int) (string, error) {
-				if pw := w.getCachedSFTPPassword(hostID); pw != "" {
-					return pw, nil
-				}
-				if pw := w.getCachedPassword(hostID); pw != "" {
-					return pw, nil
-				}
-				return "", errors.New("password_required")
-			}); err != nil {
-				if errors.Is(err, sshclient.ErrPassphraseRequired) {
-					return fail("passphrase_required", nil)
-				}
-				return fail("sftp_failed", rpcResp{"detail": err.Error()})
-			}
-			return ok(nil)
-
-		case "select":
-			hostID := req.HostID
-			if hostID == 0 {
-				return fail("bad_request", nil)
-			}
-			tabID := req.TabID
-			if tabID <= 0 {
-				tabID = 1
-			}
-
-			// Cache password if provided (from config or prompt)
-			if req.PasswordB64 != "" {
-				if pw, err := b64dec(req.PasswordB64); err == nil && pw != "" {
-					// never overwrite a valid cached password with empty data
-					w.setCachedPassword(hostID, pw)
-				}
-			}
-
-			// Start connecting asynchronously to avoid blocking the WebView UI thread.
-			sess, alreadyConnected, err := w.mgr.StartConnectAsync(hostID, tabID, req.Cols, req.Rows, func(id int) (string, error) {
-				if pw := w.getCachedPassword(id); pw != "" {
-					return pw, nil
-				}
-				// only request password if NONE was ever provided
-				return "", errors.New("password_required")
-			}, func(sess terminal.Session, err error) {
-				if err != nil {
-					var unk sshclient.ErrUnknownHostKey
-					if errors.As(err, &unk) {
-						w.wv.Dispatch(func() {
-							w.pendingTrust[hostID] = pendingKey{
-								kind:        "unknown_host_key",
-								hostPort:    unk.HostPort,
-								fingerprint: unk.Fingerprint,
-								key:         unk.Key,
-							}
-						})
-						return
-					}
-
-					var mismatch sshclient.ErrHostKeyMismatch
-					if errors.As(err, &mismatch) {
-						w.wv.Dispatch(func() {
-							w.pendingTrust[hostID] = pendingKey{
-								kind:        "host_key_mismatch",
-								hostPort:    mismatch.HostPort,
-								fingerprint: mismatch.Fingerprint,
-								key:         mismatch.Key,
-							}
-						})
-						return
-					}
-					return
-				}
-
-				if sess != nil {
-					w.ensureAttached(hostID, tabID, sess)
-					if int(w.activeHostID.Load()) == hostID && int(w.activeTabID.Load()) == tabID {
-						w.kickFlush()
-					}
-				}
-			})
-			if err != nil {
-				return fail("connect_failed", rpcResp{"detail": err.Error()})
-			}
-
-			w.activeHostID.Store(int64(hostID))
-			w.activeTabID.Store(int64(tabID))
-			if alreadyConnected && sess != nil {
-				w.ensureAttached(hostID, tabID, sess)
-				_ = w.flushHost(hostID, tabID)
-			}
-
-			return ok(rpcResp{"started": true})
-
-		case "trust_host":
-			p := w.pendingTrust[req.HostID]
-			if p.key == nil {
-				return fail("no_pending_trust", nil)
-			}
-			if err := sshclient.TrustHostKey(p.hostPort, p.key); err != nil {
-				return fail("trust_failed", nil)
-			}
-			delete(w.pendingTrust, req.HostID)
-			return ok(nil)
-
-		case "input":
-			if req.HostID == 0 || req.DataB64 == "" {
-				return fail("bad_input", nil)
-			}
-			// Bind handlers are invoked on the UI thread in this build; never block
-			// the UI thread on network/PTY writes. Enqueue to a worker instead.
-			w.inputCh <- inputMsg{hostID: req.HostID, tabID: req.TabID, dataB64: req.DataB64}
-			return ok(nil)
-
-		case "resize":
-			// Also avoid blocking the UI thread on SSH window-change requests.
-			if req.HostID != 0 && req.Cols > 0 && req.Rows > 0 {
-				select {
-				case w.resizeCh <- resizeMsg{hostID: req.HostID, tabID: req.TabID, cols: req.Cols, rows: req.Rows}:
-				default:
-					// Best effort; a later resize will win.
-				}
-			}
-			return ok(nil)
-
-		case "state":
-			info := w.mgr.SessionInfoTab(req.HostID, req.TabID)
-			state := "disconnected"
-			if info.State == session.StateConnected {
-				state = "connected"
-			} else if info.State == session.StateReconnecting {
-				state = "reconnecting"
-			}
-
-			resp := rpcResp{
-				"hostId":    req.HostID,
-				"tabId":     req.TabID,
-				"state":     state,
-				"attempts":  info.Attempts,
-				"detail":    info.LastErr,
-				"connected": info.State == session.StateConnected,
-			}
-
-			// Host key trust hints (also used for reconnect failures)
-			if p := w.pendingTrust[req.HostID]; p.key != nil {
-				resp["errCode"] = p.kind
-				resp["hostPort"] = p.hostPort
-				resp["fingerprint"] = p.fingerprint
-				return ok(resp)
-			}
-
-			if info.Err != nil {
-				var unk sshclient.ErrUnknownHostKey
-				if errors.As(info.Err, &unk) {
-					w.pendingTrust[req.HostID] = pendingKey{
-						kind:        "unknown_host_key",
-						hostPort:    unk.HostPort,
-						fingerprint: unk.Fingerprint,
-						key:         unk.Key,
-					}
-					resp["errCode"] = "unknown_host_key"
-					resp["hostPort"] = unk.HostPort
-					resp["fingerprint"] = unk.Fingerprint
-				}
-
-				var mismatch sshclient.ErrHostKeyMismatch
-				if errors.As(info.Err, &mismatch) {
-					w.pendingTrust[req.HostID] = pendingKey{
-						kind:        "host_key_mismatch",
-						hostPort:    mismatch.HostPort,
-						fingerprint: mismatch.Fingerprint,
-						key:         mismatch.Key,
-					}
-					resp["errCode"] = "host_key_mismatch"
-					resp["hostPort"] = mismatch.HostPort
-					resp["fingerprint"] = mismatch.Fingerprint
-				}
-
-				var missingKey *sshclient.ErrKeyNotFound
-				if errors.As(info.Err, &missingKey) {
-					resp["errCode"] = "key_not_found"
-					resp["keyPath"] = missingKey.Requested
-					resp["keyChecked"] = missingKey.Checked
-				}
-
-				if info.LastErr == "password_required" {
-					resp["errCode"] = "password_required"
-				}
-				if errors.Is(info.Err, sshclient.ErrPassphraseRequired) {
-					resp["errCode"] = "passphrase_required"
-				}
-			}
-
-			return ok(resp)
-
-		case "disconnect":
-			if req.HostID == 0 {
-				return fail("bad_request", nil)
-			}
-			if err := w.mgr.DisconnectTab(req.HostID, req.TabID); err != nil {
-				return fail("disconnect_failed", rpcResp{"detail": err.Error()})
-			}
-			w.sftp.Disconnect(req.HostID)
-			w.attachedMu.Lock()
-			delete(w.attached, attachKey{hostID: req.HostID, tabID: req.TabID})
-			w.attachedMu.Unlock()
-			return ok(nil)
-
-		case "telecom_pick":
-			// Bind handlers are invoked on the UI thread in this build; dispatching
-			// back to the UI thread and waiting would deadlock.
-			return ok(rpcResp{"path": w.pickTelecomExecutablePath()})
-
-		case "about":
-			aboutPayload := rpcResp{
-				"full":      buildinfo.String(),
-				"version":   buildinfo.Version,
-				"gitCommit": buildinfo.GitCommit,
-				"buildTime": buildinfo.BuildTime,
-			}
-			updatePayload := w.updatePayload()
-			return ok(rpcResp{
-				"text":   "pTerminal – SSH Terminal Manager",
-				"about":  aboutPayload,
-				"update": updatePayload,
-			})
-
-		case "teams_presence":
-			if w.p2p == nil {
-				return ok(rpcResp{"peers": []p2p.PeerInfo{}, "user": w.mgr.Config().User})
-			}
-			presence := w.p2p.Presence()
-			return ok(rpcResp{"peers": presence.Peers, "user": presence.User})
-
-		case "team_repo_paths":
-			cfg := w.mgr.Config()
-			p, err := config.ConfigPath()
-			if err != nil {
-				return fail("config_load_failed", nil)
-			}
-			base := filepath.Dir(p)
-			paths := map[string]string{}
-			for _, t := range cfg.Teams {
-				if t.ID == "" {
-					continue
-				}
-				paths[t.ID] = teamrepo.TeamDir(base, t.ID)
-			}
-			return ok(rpcResp{"paths": paths})
-
-		case "update_status":
-			if req.Force {
-				w.triggerUpdateCheck(true)
-			}
-			return ok(rpcResp{"update": w.updatePayload()})
-
-		case "update_check":
-			w.triggerUpdateCheck(true)
-			return ok(rpcResp{"update": w.updatePayload()})
-
-		case "update_install":
-			go w.runUpdateInstall()
-			return ok(rpcResp{"update": w.updatePayload()})
-
-		case "app_restart":
-			go w.requestRestart()
-			return ok(nil)
-
-		case "app_quit":
-			go w.requestQuit()
-			return ok(nil)
-
-		default:
-			return fail("unknown_rpc", nil)
-		}
-	})
+	w.wv.Bind("rpc", w.handleRPC)
 
 	html, _ := w.buildInlinedHTML()
 	w.wv.SetHtml(html)
 
-	w.startIOLoops()
 	w.startPTYFlushLoop()
 	if softwareRenderEnabled() {
 		w.wv.Dispatch(func() {
@@ -1060,11 +374,7 @@ func NewWindow(mgr *session.Manager, p2pSvc *p2p.Service) (*Window, error) {
 }
 
 func (w *Window) ApplyConfig(cfg model.AppConfig) {
-	w.mgr.SetConfig(cfg)
-	w.sftp.SetConfig(cfg)
-	if w.p2p != nil {
-		w.p2p.SetConfig(cfg)
-	}
+	w.svc.ApplyConfig(cfg)
 
 	sw := softwareRenderEnabled()
 	b, err := json.Marshal(cfg)
@@ -1081,42 +391,22 @@ func (w *Window) ApplyConfig(cfg model.AppConfig) {
 	})
 }
 
-func (w *Window) startIOLoops() {
-	ctx, cancel := context.WithCancel(context.Background())
-	w.ioCancel = cancel
-
-	go func() {
-		for {
-			select {
-			case <-ctx.Done():
-				return
-			case msg, ok := <-w.inputCh:
-				if !ok {
-					return
-				}
-				_ = w.mgr.WriteTab(msg.hostID, msg.tabID, msg.dataB64)
-			}
-		}
-	}()
-
-	go func() {
-		for {
-			select {
-			case <-ctx.Done():
-				return
-			case msg, ok := <-w.resizeCh:
-				if !ok {
-					return
-				}
-				_ = w.mgr.ResizeTab(msg.hostID, msg.tabID, msg.cols, msg.rows)
-			}
-		}
-	}()
+// NotifySyncRejected tells the page about a LAN sync change that was not
+// merged.
+func (w *Window) NotifySyncRejected(r p2p.Rejection) {
+	b, err := json.Marshal(r)
+	if err != nil || w.closed.Load() {
+		return
+	}
+	w.wv.Dispatch(func() {
+		w.wv.Eval(fmt.Sprintf("window.__syncRejected && window.__syncRejected(%s);", b))
+	})
 }
 
 func (w *Window) attachOutput(hostID, tabID int, sess terminal.Session) {
 	for chunk := range sess.Output() {
 		w.mgr.BufferOutputTab(hostID, tabID, chunk)
+		w.publishOutput(hostID, tabID, chunk)
 		if int(w.activeHostID.Load()) == hostID && int(w.activeTabID.Load()) == tabID {
 			w.kickFlush()
 		}
@@ -1498,6 +788,50 @@ func (w *Window) notifyUpdateError(msg string) {
 	w.runJSNotify("notifyError", msg)
 }
 
+// agentStatus lists the built-in agent's keys and socket for the UI.
+// agentConfirmTimeout bounds how long a forwarded-agent signing request waits
+// for an answer before it is denied.
+const agentConfirmTimeout = time.Minute
+
+// confirmAgentSign asks the UI whether a remote host may sign with the
+// forwarded agent. It runs on the SSH connection's goroutine and blocks until
+// the user answers or the request times out.
+func (w *Window) confirmAgentSign(req sshclient.AgentSignRequest) bool {
+	if w.wv == nil || w.closed.Load() {
+		return false
+	}
+	ch := make(chan bool, 1)
+	w.agentMu.Lock()
+	w.agentSeq++
+	id := w.agentSeq
+	w.agentAsks[id] = ch
+	w.agentMu.Unlock()
+	defer func() {
+		w.agentMu.Lock()
+		delete(w.agentAsks, id)
+		w.agentMu.Unlock()
+	}()
+
+	b, _ := json.Marshal(rpcResp{
+		"requestId":   id,
+		"hostId":      req.Host.ID,
+		"host":        req.Host.Name,
+		"fingerprint": req.Fingerprint,
+		"comment":     req.Comment,
+		"timeoutSec":  int(agentConfirmTimeout / time.Second),
+	})
+	w.wv.Dispatch(func() {
+		w.wv.Eval(fmt.Sprintf("window.__confirmAgentSign && window.__confirmAgentSign(%s);", string(b)))
+	})
+
+	select {
+	case allow := <-ch:
+		return allow
+	case <-time.After(agentConfirmTimeout):
+		return false
+	}
+}
+
 func (w *Window) runJSNotify(fn, msg string) {
 	if w.wv == nil || w.closed.Load() {
 		return
@@ -1676,14 +1010,8 @@ func (w *Window) Close() {
 	if w.flushCancel != nil {
 		w.flushCancel()
 	}
-	if w.ioCancel != nil {
-		w.ioCancel()
-	}
-	if w.inputCh != nil {
-		close(w.inputCh)
-	}
-	if w.resizeCh != nil {
-		close(w.resizeCh)
+	if w.svc != nil {
+		w.svc.Close()
 	}
 	if w.mgr != nil {
 		w.mgr.DisconnectAll()
@@ -1691,11 +1019,16 @@ func (w *Window) Close() {
 	if w.sftp != nil {
 		w.sftp.DisconnectAll()
 	}
+	if w.keyring != nil {
+		_ = w.keyring.Close()
+	}
+	if w.agent != nil {
+		sshclient.SetLocalAgent(nil)
+		_ = w.agent.Close()
+	}
+	if w.control != nil {
+		_ = w.control.Close()
+	}
 	trayCleanup()
 	w.wv.Destroy()
 }
-
-func b64dec(b string) (string, error) {
-	d, err := base64.StdEncoding.DecodeString(b)
-	return string(d), err
-}
@@ -0,0 +1,447 @@
+package vault
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/argon2"
+)
+
+/*
+Secret vault
+
+An opt-in file holding host passwords and key passphrases, encrypted with AES-256-GCM under a key
+derived from a master passphrase with Argon2id. The file keeps the KDF
+parameters, salt and auto-lock setting in the clear and everything else in
+the sealed payload. Unlocking keeps the key in memory until Lock, or until
+the vault has not been used for the auto-lock timeout.
+
+The vault is a separate file next to the config; it never enters the config,
+P2P sync or exports. It is one of the credstore backends; secrets are keyed
+by credstore kind and host UID.
+*/
+
+const (
+	// Version 2 also authenticates the auto-lock setting; version 1 files
+	// are upgraded on unlock.
+	fileVersion = 2
+
+	DefaultAutoLock = 15 * time.Minute
+
+	keyLen = 32
+)
+
+var (
+	ErrLocked        = errors.New("vault is locked")
+	ErrNotFound      = errors.New("vault does not exist")
+	ErrExists        = errors.New("vault already exists")
+	ErrBadPassphrase = errors.New("wrong passphrase")
+)
+
+type kdfParams struct {
+	Name    string `json:"name"`
+	Salt    []byte `json:"salt"`
+	Time    uint32 `json:"time"`
+	Memory  uint32 `json:"memory"` // KiB
+	Threads uint8  `json:"threads"`
+}
+
+type file struct {
+	Version     int       `json:"version"`
+	KDF         kdfParams `json:"kdf"`
+	AutoLockSec int       `json:"autoLockSec,omitempty"` // 0 = default, -1 = never
+	Nonce       []byte    `json:"nonce"`
+	Sealed      []byte    `json:"sealed"`
+}
+
+// payload is the decrypted content: kind -> host UID -> secret.
+type payload struct {
+	Secrets map[string]map[string]string `json:"secrets"`
+}
+
+// Status is the vault state shown in the UI.
+type Status struct {
+	Exists      bool `json:"exists"`
+	Locked      bool `json:"locked"`
+	AutoLockMin int  `json:"autoLockMin"` // 0 = never
+	Entries     int  `json:"entries"`
+}
+
+type Vault struct {
+	path string
+
+	mu       sync.Mutex
+	hdr      *file // nil when no vault file exists
+	key      []byte
+	data     payload
+	autoLock time.Duration
+	timer    *time.Timer
+}
+
+// Open reads the vault header at path. A missing file is not an error; the
+// vault then reports Exists false until Create.
+func Open(path string) (*Vault, error) {
+	v := &Vault{path: path, autoLock: DefaultAutoLock}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return v, nil
+		}
+		return nil, err
+	}
+	var f file
+	if err := json.Unmarshal(b, &f); err != nil {
+		return nil, fmt.Errorf("invalid vault file: %w", err)
+	}
+	if f.Version < 1 || f.Version > fileVersion || f.KDF.Name != "argon2id" {
+		return nil, fmt.Errorf("unsupported vault version %d (%s)", f.Version, f.KDF.Name)
+	}
+	if err := f.KDF.check(); err != nil {
+		return nil, err
+	}
+	v.hdr = &f
+	v.autoLock = autoLockFrom(f.AutoLockSec)
+	return v, nil
+}
+
+func autoLockFrom(sec int) time.Duration {
+	switch {
+	case sec < 0:
+		return 0
+	case sec == 0:
+		return DefaultAutoLock
+	default:
+		return time.Duration(sec) * time.Second
+	}
+}
+
+// ValidKDFParams reports whether Argon2id parameters (memory in KiB) read from
+// a file are sane: a tampered file could otherwise stall the app or exhaust
+// memory while deriving the key, or make it trivial to guess.
+func ValidKDFParams(salt []byte, time, memory uint32, threads uint8) bool {
+	return len(salt) >= 16 && time >= 1 && time <= 10 && memory >= 8*1024 && memory <= 1024*1024 &&
+		threads >= 1 && threads <= 16
+}
+
+func (p kdfParams) check() error {
+	if !ValidKDFParams(p.Salt, p.Time, p.Memory, p.Threads) {
+		return errors.New("invalid vault key derivation parameters")
+	}
+	return nil
+}
+
+func deriveKey(passphrase string, p kdfParams) []byte {
+	return argon2.IDKey([]byte(passphrase), p.Salt, p.Time, p.Memory, p.Threads, keyLen)
+}
+
+func (v *Vault) Name() string { return "vault" }
+
+// Available reports whether the vault exists and is unlocked.
+func (v *Vault) Available() bool {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.hdr != nil && v.key != nil
+}
+
+// Status reports whether the vault exists and is unlocked.
+func (v *Vault) Status() Status {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	st := Status{
+		Exists:      v.hdr != nil,
+		Locked:      v.key == nil,
+		AutoLockMin: int(v.autoLock / time.Minute),
+	}
+	for _, m := range v.data.Secrets {
+		st.Entries += len(m)
+	}
+	return st
+}
+
+// Create writes a new, empty vault protected by passphrase and leaves it unlocked.
+func (v *Vault) Create(passphrase string) error {
+	if passphrase == "" {
+		return errors.New("passphrase is required")
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.hdr != nil {
+		return ErrExists
+	}
+
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return err
+	}
+	hdr := &file{
+		Version: fileVersion,
+		KDF:     kdfParams{Name: "argon2id", Salt: salt, Time: 3, Memory: 64 * 1024, Threads: 4},
+	}
+	v.hdr = hdr
+	v.key = deriveKey(passphrase, hdr.KDF)
+	v.data = payload{Secrets: map[string]map[string]string{}}
+	if err := v.saveLocked(); err != nil {
+		v.hdr, v.key = nil, nil
+		return err
+	}
+	v.touchLocked()
+	return nil
+}
+
+// Unlock derives the key from passphrase and decrypts the vault.
+func (v *Vault) Unlock(passphrase string) error {
+	v.mu.Lock()
+	hdr := v.hdr
+	v.mu.Unlock()
+	if hdr == nil {
+		return ErrNotFound
+	}
+
+	// Key derivation is slow on purpose; do not hold the lock meanwhile.
+	if err := hdr.KDF.check(); err != nil {
+		return err
+	}
+	key := deriveKey(passphrase, hdr.KDF)
+	data, err := open(key, hdr)
+	if err != nil {
+		return err
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.key = key
+	v.data = data
+	if v.hdr.Version < fileVersion {
+		v.hdr.Version = fileVersion
+		if err := v.saveLocked(); err != nil {
+			return err
+		}
+	}
+	v.touchLocked()
+	return nil
+}
+
+func open(key []byte, hdr *file) (payload, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return payload{}, err
+	}
+	plain, err := aead.Open(nil, hdr.Nonce, hdr.Sealed, additionalData(hdr))
+	if err != nil {
+		return payload{}, ErrBadPassphrase
+	}
+	var p payload
+	if err := json.Unmarshal(plain, &p); err != nil {
+		return payload{}, fmt.Errorf("invalid vault payload: %w", err)
+	}
+	if p.Secrets == nil {
+		p.Secrets = map[string]map[string]string{}
+	}
+	return p, nil
+}
+
+// Lock forgets the key and the decrypted secrets.
+func (v *Vault) Lock() {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.lockLocked()
+}
+
+func (v *Vault) lockLocked() {
+	for i := range v.key {
+		v.key[i] = 0
+	}
+	v.key = nil
+	v.data = payload{}
+	if v.timer != nil {
+		v.timer.Stop()
+		v.timer = nil
+	}
+}
+
+// SetAutoLock sets the idle time after which the vault locks itself; 0 never
+// locks automatically. The setting is stored in the vault file and sealed
+// with it, so the vault must be unlocked.
+func (v *Vault) SetAutoLock(d time.Duration) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.hdr == nil {
+		return ErrNotFound
+	}
+	if v.key == nil {
+		return ErrLocked
+	}
+	v.autoLock = d
+	if d <= 0 {
+		v.hdr.AutoLockSec = -1
+	} else {
+		v.hdr.AutoLockSec = int(d / time.Second)
+	}
+	v.touchLocked()
+	return v.saveLocked()
+}
+
+// Get returns the secret of kind for a host UID. ok is false when the vault
+// is locked or has no such entry.
+func (v *Vault) Get(kind, hostUID string) (string, bool) {
+	if hostUID == "" {
+		return "", false
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.key == nil {
+		return "", false
+	}
+	s, ok := v.data.Secrets[kind][hostUID]
+	if ok {
+		v.touchLocked()
+	}
+	return s, ok
+}
+
+// Set stores (or, for an empty secret, removes) a host secret.
+func (v *Vault) Set(kind, hostUID, secret string) error {
+	if hostUID == "" {
+		return errors.New("host uid is required")
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.key == nil {
+		return ErrLocked
+	}
+	m := v.data.Secrets[kind]
+	if secret == "" {
+		if _, ok := m[hostUID]; !ok {
+			return nil
+		}
+		delete(m, hostUID)
+	} else {
+		if m[hostUID] == secret {
+			return nil
+		}
+		if m == nil {
+			m = map[string]string{}
+			v.data.Secrets[kind] = m
+		}
+		m[hostUID] = secret
+	}
+	v.touchLocked()
+	return v.saveLocked()
+}
+
+// Forget removes every secret stored for a host.
+func (v *Vault) Forget(hostUID string) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.key == nil {
+		return ErrLocked
+	}
+	changed := false
+	for _, m := range v.data.Secrets {
+		if _, ok := m[hostUID]; ok {
+			delete(m, hostUID)
+			changed = true
+		}
+	}
+	if !changed {
+		return nil
+	}
+	return v.saveLocked()
+}
+
+// Destroy deletes the vault file, e.g. after a forgotten passphrase.
+func (v *Vault) Destroy() error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.lockLocked()
+	v.hdr = nil
+	v.autoLock = DefaultAutoLock
+	if err := os.Remove(v.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// touchLocked restarts the auto-lock timer.
+func (v *Vault) touchLocked() {
+	if v.timer != nil {
+		v.timer.Stop()
+		v.timer = nil
+	}
+	if v.autoLock <= 0 {
+		return
+	}
+	var t *time.Timer
+	t = time.AfterFunc(v.autoLock, func() {
+		v.mu.Lock()
+		defer v.mu.Unlock()
+		if v.timer == t {
+			v.lockLocked()
+		}
+	})
+	v.timer = t
+}
+
+// saveLocked seals the payload with a fresh nonce and writes the file.
+func (v *Vault) saveLocked() error {
+	aead, err := newAEAD(v.key)
+	if err != nil {
+		return err
+	}
+	plain, err := json.Marshal(v.data)
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	v.hdr.Nonce = nonce
+	v.hdr.Sealed = aead.Seal(nil, nonce, plain, additionalData(v.hdr))
+	return v.writeLocked()
+}
+
+func (v *Vault) writeLocked() error {
+	b, err := json.MarshalIndent(v.hdr, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(v.path), 0o700); err != nil {
+		return err
+	}
+	tmp := v.path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, v.path); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// additionalData binds the sealed payload to the KDF parameters and the
+// auto-lock setting, so they cannot be changed without breaking decryption.
+func additionalData(hdr *file) []byte {
+	b, _ := json.Marshal(hdr.KDF)
+	if hdr.Version >= 2 {
+		b = fmt.Appendf(b, "\x00%d", hdr.AutoLockSec)
+	}
+	return b
+}
@@ -0,0 +1,146 @@
+package vault
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ankouros/pterminal/internal/credstore"
+)
+
+var _ credstore.Store = (*Vault)(nil)
+
+func TestVaultRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "vault.json")
+	v, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st := v.Status(); st.Exists || !st.Locked {
+		t.Fatalf("unexpected status before create: %+v", st)
+	}
+	if err := v.Create("correct horse"); err != nil {
+		t.Fatal(err)
+	}
+	if err := v.Set(credstore.KindPassword, "host-a", "s3cret-pw"); err != nil {
+		t.Fatal(err)
+	}
+	if err := v.Set(credstore.KindSFTP, "host-a", "sftp-pw"); err != nil {
+		t.Fatal(err)
+	}
+
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(raw, []byte("s3cret-pw")) || bytes.Contains(raw, []byte("host-a")) {
+		t.Fatal("vault file contains plaintext")
+	}
+	fi, _ := os.Stat(path)
+	if fi.Mode().Perm() != 0o600 {
+		t.Fatalf("unexpected mode %v", fi.Mode())
+	}
+
+	// Next start: locked until unlocked with the right passphrase.
+	again, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := again.Get(credstore.KindPassword, "host-a"); ok {
+		t.Fatal("locked vault returned a secret")
+	}
+	if err := again.Set(credstore.KindPassword, "host-b", "x"); !errors.Is(err, ErrLocked) {
+		t.Fatalf("expected ErrLocked, got %v", err)
+	}
+	if err := again.Unlock("wrong"); !errors.Is(err, ErrBadPassphrase) {
+		t.Fatalf("expected ErrBadPassphrase, got %v", err)
+	}
+	if err := again.Unlock("correct horse"); err != nil {
+		t.Fatal(err)
+	}
+	if pw, ok := again.Get(credstore.KindPassword, "host-a"); !ok || pw != "s3cret-pw" {
+		t.Fatalf("unexpected secret %q %v", pw, ok)
+	}
+	if st := again.Status(); st.Entries != 2 || st.Locked {
+		t.Fatalf("unexpected status: %+v", st)
+	}
+
+	if err := again.Forget("host-a"); err != nil {
+		t.Fatal(err)
+	}
+	again.Lock()
+	if err := again.Unlock("correct horse"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := again.Get(credstore.KindSFTP, "host-a"); ok {
+		t.Fatal("forgotten secret still present")
+	}
+
+	if err := again.Destroy(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("vault file not removed: %v", err)
+	}
+}
+
+func TestVaultAutoLock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "vault.json")
+	v, _ := Open(path)
+	if err := v.Create("pw"); err != nil {
+		t.Fatal(err)
+	}
+	if err := v.SetAutoLock(50 * time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for !v.Status().Locked {
+		if time.Now().After(deadline) {
+			t.Fatal("vault did not lock itself")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// The timeout is kept in the file.
+	again, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := again.SetAutoLock(0); !errors.Is(err, ErrLocked) {
+		t.Fatalf("auto-lock changed while locked: %v", err)
+	}
+	if err := again.Unlock("pw"); err != nil {
+		t.Fatal(err)
+	}
+	if err := again.SetAutoLock(0); err != nil {
+		t.Fatal(err)
+	}
+	again, _ = Open(path)
+	if st := again.Status(); st.AutoLockMin != 0 || again.autoLock != 0 {
+		t.Fatalf("auto-lock off not persisted: %+v", st)
+	}
+
+	// The setting is sealed with the payload: editing it breaks unlocking.
+	raw, _ := os.ReadFile(path)
+	raw = bytes.Replace(raw, []byte(`"autoLockSec": -1`), []byte(`"autoLockSec": 60`), 1)
+	if err := os.WriteFile(path, raw, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	again, _ = Open(path)
+	if err := again.Unlock("pw"); err == nil {
+		t.Fatal("tampered auto-lock setting accepted")
+	}
+
+	// So are the KDF parameters, which are also bounded before use.
+	raw = bytes.Replace(raw, []byte(`"time": 3`), []byte(`"time": 4000000000`), 1)
+	if err := os.WriteFile(path, raw, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Open(path); err == nil {
+		t.Fatal("unbounded KDF parameters accepted")
+	}
+}